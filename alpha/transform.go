@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/rhysd/gocaml/ast"
 	"github.com/rhysd/loc"
+	"strings"
 )
 
 // Alpha transform.
@@ -33,6 +34,8 @@ type transformer struct {
 	current *mapping
 	count   uint
 	err     error
+	// Parameters which are not in scope yet while visiting a default value of optional parameter
+	pending []ast.Param
 }
 
 func newTransformer() *transformer {
@@ -40,6 +43,7 @@ func newTransformer() *transformer {
 		current: newMapping(nil),
 		count:   0,
 		err:     nil,
+		pending: nil,
 	}
 }
 
@@ -52,10 +56,115 @@ func (t *transformer) newID(n string) string {
 	return fmt.Sprintf("%s$t%d", n, t.count)
 }
 
-func (t *transformer) register(s *ast.Symbol) {
+// DisplayNameOf returns the name written in source for the identifier generated by alpha
+// transform. It returns false when the identifier was not derived from a user-defined symbol
+// (e.g. temporary variables introduced by K-normalization).
+//
+// Identifiers in functions specialized by closure.Specialize are suffixed with the specialized
+// functions (e.g. 'x$t1[f$t2]' is displayed as 'x[f]' and 'x$t1[f$t2,g$t3]' as 'x[f,g]'), and
+// lambda 'lambda.line3.col9$t4' is displayed as 'lambda:3:9'.
+func DisplayNameOf(id string) (string, bool) {
+	base, specs, ok := splitSpecializedName(id)
+	if !ok {
+		return "", false
+	}
+
+	idx := strings.LastIndex(base, "$t")
+	if idx <= 0 || idx+2 == len(base) {
+		return "", false
+	}
+	for _, c := range base[idx+2:] {
+		if c < '0' || '9' < c {
+			return "", false
+		}
+	}
+	name := lambdaDisplayName(base[:idx])
+
+	for _, s := range specs {
+		funs := splitTopLevelCommas(s)
+		for i, f := range funs {
+			if n, ok := DisplayNameOf(f); ok {
+				funs[i] = n
+			}
+		}
+		name = fmt.Sprintf("%s[%s]", name, strings.Join(funs, ","))
+	}
+	return name, true
+}
+
+// Splits the identifier such as 'f$t1[g$t2][h$t3]' into 'f$t1' and its specializations 'g$t2' and
+// 'h$t3'.
+func splitSpecializedName(id string) (string, []string, bool) {
+	idx := strings.IndexByte(id, '[')
+	if idx < 0 {
+		return id, nil, true
+	}
+	base, rest := id[:idx], id[idx:]
+	specs := []string{}
+	for rest != "" {
+		if rest[0] != '[' {
+			return "", nil, false
+		}
+		depth := 0
+		end := -1
+		for i, c := range rest {
+			if c == '[' {
+				depth++
+			} else if c == ']' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			return "", nil, false
+		}
+		specs = append(specs, rest[1:end])
+		rest = rest[end+1:]
+	}
+	return base, specs, true
+}
+
+// Splits specialization 'g$t2,h$t3' of function specialized with multiple functions. Commas in
+// nested brackets are not separators.
+func splitTopLevelCommas(s string) []string {
+	names := []string{}
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				names = append(names, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(names, s[start:])
+}
+
+// Name of lambda 'lambda.line{N}.col{M}' is displayed as 'lambda:{N}:{M}'.
+func lambdaDisplayName(name string) string {
+	var line, col int
+	if n, err := fmt.Sscanf(name, "lambda.line%d.col%d", &line, &col); err != nil || n != 2 || fmt.Sprintf("lambda.line%d.col%d", line, col) != name {
+		return name
+	}
+	return fmt.Sprintf("lambda:%d:%d", line, col)
+}
+
+func (t *transformer) register(node ast.Expr, s *ast.Symbol) {
 	if s.IsIgnored() {
 		return
 	}
+	if s.IsQualified() {
+		t.err = loc.ErrorfIn(node.Pos(), node.End(), "Cannot define '%s' because qualified name is reserved for module members", s.DisplayName)
+		return
+	}
 	s.Name = t.newID(s.DisplayName)
 	t.current.add(s.DisplayName, s)
 }
@@ -74,7 +183,7 @@ func (t *transformer) Visit(node ast.Expr) ast.Visitor {
 		// At first, transform value bound to the variable
 		ast.Visit(t, n.Bound)
 		t.nest()
-		t.register(n.Symbol)
+		t.register(n, n.Symbol)
 		ast.Visit(t, n.Body)
 		t.pop()
 		return nil
@@ -84,10 +193,17 @@ func (t *transformer) Visit(node ast.Expr) ast.Visitor {
 			return nil
 		}
 		t.nest()
-		t.register(n.Func.Symbol)
+		t.register(n, n.Func.Symbol)
 		t.nest()
-		for _, p := range n.Func.Params {
-			t.register(p.Ident)
+		saved := t.pending
+		for i, p := range n.Func.Params {
+			// Default value can refer only preceding parameters
+			if p.Default != nil {
+				t.pending = n.Func.Params[i:]
+				ast.Visit(t, p.Default)
+				t.pending = saved
+			}
+			t.register(n, p.Ident)
 		}
 		ast.Visit(t, n.Func.Body)
 		t.pop() // Pop parameters scope
@@ -102,7 +218,7 @@ func (t *transformer) Visit(node ast.Expr) ast.Visitor {
 		}
 		t.nest()
 		for _, e := range n.Symbols {
-			t.register(e)
+			t.register(n, e)
 		}
 		ast.Visit(t, n.Body)
 		t.pop()
@@ -110,7 +226,7 @@ func (t *transformer) Visit(node ast.Expr) ast.Visitor {
 	case *ast.Match:
 		ast.Visit(t, n.Target)
 		t.nest()
-		t.register(n.SomeIdent)
+		t.register(n, n.SomeIdent)
 		ast.Visit(t, n.IfSome)
 		t.pop()
 		ast.Visit(t, n.IfNone)
@@ -124,6 +240,12 @@ func (t *transformer) Visit(node ast.Expr) ast.Visitor {
 		}
 		mapped, ok := t.current.resolve(n.Symbol.DisplayName)
 		if !ok {
+			for _, p := range t.pending {
+				if p.Ident.DisplayName == n.Symbol.DisplayName {
+					t.err = loc.ErrorfIn(n.Pos(), n.End(), "Default value of optional parameter cannot refer to parameter '%s' which is not defined yet", n.Symbol.DisplayName)
+					return nil
+				}
+			}
 			// External symbol is ignored because name should be identical.
 			return nil
 		}
@@ -1,6 +1,7 @@
 package alpha
 
 import (
+	"fmt"
 	"github.com/rhysd/gocaml/ast"
 	"github.com/rhysd/gocaml/token"
 	"github.com/rhysd/loc"
@@ -192,9 +193,9 @@ func TestLetRec(t *testing.T) {
 		&ast.FuncDef{
 			ast.NewSymbol("f"),
 			[]ast.Param{
-				{ast.NewSymbol("a"), nil},
-				{ast.NewSymbol("b"), nil},
-				{ast.NewSymbol("c"), nil},
+				{ast.NewSymbol("a"), nil, "", false, nil},
+				{ast.NewSymbol("b"), nil, "", false, nil},
+				{ast.NewSymbol("c"), nil, "", false, nil},
 			},
 			ref2,
 			nil,
@@ -243,9 +244,9 @@ func TestRecursiveFunc(t *testing.T) {
 		&ast.FuncDef{
 			ast.NewSymbol("f"),
 			[]ast.Param{
-				{ast.NewSymbol("a"), nil},
-				{ast.NewSymbol("b"), nil},
-				{ast.NewSymbol("c"), nil},
+				{ast.NewSymbol("a"), nil, "", false, nil},
+				{ast.NewSymbol("b"), nil, "", false, nil},
+				{ast.NewSymbol("c"), nil, "", false, nil},
 			},
 			ref,
 			nil,
@@ -283,7 +284,7 @@ func TestFuncAndParamHaveSameName(t *testing.T) {
 		&ast.FuncDef{
 			ast.NewSymbol("f"),
 			[]ast.Param{
-				{ast.NewSymbol("f"), nil},
+				{ast.NewSymbol("f"), nil, "", false, nil},
 			},
 			ref,
 			nil,
@@ -320,9 +321,9 @@ func TestParamDuplicate(t *testing.T) {
 		&ast.FuncDef{
 			ast.NewSymbol("f"),
 			[]ast.Param{
-				{ast.NewSymbol("a"), nil},
-				{ast.NewSymbol("b"), nil},
-				{ast.NewSymbol("b"), nil},
+				{ast.NewSymbol("a"), nil, "", false, nil},
+				{ast.NewSymbol("b"), nil, "", false, nil},
+				{ast.NewSymbol("b"), nil, "", false, nil},
 			},
 			&ast.Int{tok, 42},
 			nil,
@@ -335,6 +336,80 @@ func TestParamDuplicate(t *testing.T) {
 	}
 }
 
+func TestDefaultValueReferToParam(t *testing.T) {
+	tok := &token.Token{
+		Start: loc.Pos{},
+		End:   loc.Pos{},
+	}
+	ref := &ast.VarRef{
+		tok,
+		ast.NewSymbol("x"),
+	}
+	root := &ast.LetRec{
+		tok,
+		&ast.FuncDef{
+			ast.NewSymbol("f"),
+			[]ast.Param{
+				{ast.NewSymbol("x"), nil, "", false, nil},
+				{ast.NewSymbol("y"), nil, "y", true, ref},
+			},
+			&ast.Int{tok, 42},
+			nil,
+		},
+		&ast.Int{tok, 42},
+	}
+
+	if err := Transform(root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Func.Params[0].Ident != ref.Symbol {
+		t.Fatalf("Ref in default value should be resolved to preceding parameter but actually %s", ref.Symbol.Name)
+	}
+}
+
+func TestDefaultValueReferToUndefinedParam(t *testing.T) {
+	tok := &token.Token{
+		Start: loc.Pos{},
+		End:   loc.Pos{},
+	}
+	for _, tc := range []struct {
+		what string
+		ref  string
+	}{
+		{"itself", "x"},     // let rec f ?(x = x) y = ...
+		{"succeeding", "y"}, // let rec f ?(x = y) y = ...
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			ref := &ast.VarRef{
+				tok,
+				ast.NewSymbol(tc.ref),
+			}
+			root := &ast.LetRec{
+				tok,
+				&ast.FuncDef{
+					ast.NewSymbol("f"),
+					[]ast.Param{
+						{ast.NewSymbol("x"), nil, "x", true, ref},
+						{ast.NewSymbol("y"), nil, "", false, nil},
+					},
+					&ast.Int{tok, 42},
+					nil,
+				},
+				&ast.Int{tok, 42},
+			}
+
+			err := Transform(root)
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			msg := fmt.Sprintf("cannot refer to parameter '%s' which is not defined yet", tc.ref)
+			if !strings.Contains(err.Error(), msg) {
+				t.Fatal("Unexpected error message:", err)
+			}
+		})
+	}
+}
+
 func TestExternalSymbol(t *testing.T) {
 	tok := &token.Token{
 		Start: loc.Pos{},
@@ -371,3 +446,55 @@ func TestUnderscoreName(t *testing.T) {
 		t.Fatal("Unexpected error for '_' variable reference:", err)
 	}
 }
+
+func TestDefineQualifiedName(t *testing.T) {
+	tok := &token.Token{
+		Start: loc.Pos{},
+		End:   loc.Pos{},
+	}
+	root := &ast.Let{
+		tok,
+		ast.NewSymbol("String.trim"),
+		&ast.Int{tok, 42},
+		&ast.Int{tok, 42},
+		nil,
+	}
+	err := Transform(root)
+	if err == nil {
+		t.Fatal("Error was expected")
+	}
+	if !strings.Contains(err.Error(), "Cannot define 'String.trim' because qualified name is reserved for module members") {
+		t.Fatal("Unexpected error for defining qualified name:", err)
+	}
+}
+
+func TestDisplayNameOf(t *testing.T) {
+	for _, tc := range []struct {
+		id       string
+		expected string
+		ok       bool
+	}{
+		{"x$t1", "x", true},
+		{"foo_bar'$t42", "foo_bar'", true},
+		{"$k3", "", false},
+		{"$unused1", "", false},
+		{"x$t", "", false},
+		{"f$t1[g$t2]", "f[g]", true},
+		{"x$t3[f$t1[g$t2]]", "x[f[g]]", true},
+		{"x$t3[f$t1][g$t2]", "x[f][g]", true},
+		{"compose$t1[inc$t5,dbl$t7]", "compose[inc,dbl]", true},
+		{"x$t4[inc$t5,$k2]", "x[inc,$k2]", true},
+		{"apply$t1[$k4]", "apply[$k4]", true},
+		{"$k3[f$t1]", "", false},
+		{"f$t1[g$t2", "", false},
+		{"lambda.line3.col9$t4", "lambda:3:9", true},
+		{"lambda.line1.col5$t2[g$t1]", "lambda:1:5[g]", true},
+		{"lambda.foo$t2", "lambda.foo", true},
+		{"print_int", "", false},
+	} {
+		name, ok := DisplayNameOf(tc.id)
+		if ok != tc.ok || name != tc.expected {
+			t.Errorf("DisplayNameOf(%q) should be (%q, %v) but actually (%q, %v)", tc.id, tc.expected, tc.ok, name, ok)
+		}
+	}
+}
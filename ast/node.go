@@ -6,6 +6,8 @@ import (
 	"github.com/rhysd/gocaml/token"
 	"github.com/rhysd/loc"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Type t =
@@ -75,9 +77,21 @@ func (s *Symbol) IsIgnored() bool {
 	return strings.HasPrefix(s.Name, "$unused")
 }
 
+// IsQualified returns true when the symbol is a qualified name of module member like 'String.trim'.
+func (s *Symbol) IsQualified() bool {
+	r, _ := utf8.DecodeRuneInString(s.DisplayName)
+	return unicode.IsUpper(r) && strings.ContainsRune(s.DisplayName, '.')
+}
+
 type Param struct {
 	Ident *Symbol
-	Type  Expr
+	Type  Expr // Maybe nil
+	// Label of the parameter like ~foo or ?foo. Empty when the parameter is not labeled
+	Label string
+	// True when the parameter is optional (?foo). Its type is wrapped in option type
+	Optional bool
+	// Default value of optional parameter like ?(foo = 42). Maybe nil
+	Default Expr
 }
 
 type FuncDef struct {
@@ -122,6 +136,11 @@ type (
 		Value string
 	}
 
+	Char struct {
+		Token *token.Token
+		Value byte
+	}
+
 	Not struct {
 		OpToken *token.Token
 		Child   Expr
@@ -152,6 +171,35 @@ type (
 		Left, Right Expr
 	}
 
+	Lnot struct {
+		OpToken *token.Token
+		Child   Expr
+	}
+
+	Land struct {
+		Left, Right Expr
+	}
+
+	Lor struct {
+		Left, Right Expr
+	}
+
+	Lxor struct {
+		Left, Right Expr
+	}
+
+	Lsl struct {
+		Left, Right Expr
+	}
+
+	Lsr struct {
+		Left, Right Expr
+	}
+
+	Asr struct {
+		Left, Right Expr
+	}
+
 	FNeg struct {
 		MinusToken *token.Token
 		Child      Expr
@@ -233,6 +281,15 @@ type (
 		Args   []Expr
 	}
 
+	// LabeledArg is an argument with label like ~foo:e or ?foo:e. It only appears in arguments of
+	// Apply node. Type inference reorders arguments by their labels and removes this node.
+	LabeledArg struct {
+		StartToken *token.Token
+		Label      string
+		Optional   bool
+		Child      Expr
+	}
+
 	Tuple struct {
 		Elems []Expr
 	}
@@ -254,6 +311,11 @@ type (
 		Target     Expr
 	}
 
+	StringGet struct {
+		StringToken   *token.Token
+		String, Index Expr
+	}
+
 	Get struct {
 		Array, Index Expr
 	}
@@ -343,6 +405,13 @@ func (e *String) End() loc.Pos {
 	return e.Token.End
 }
 
+func (e *Char) Pos() loc.Pos {
+	return e.Token.Start
+}
+func (e *Char) End() loc.Pos {
+	return e.Token.End
+}
+
 func (e *Not) Pos() loc.Pos {
 	return e.OpToken.Start
 }
@@ -392,6 +461,55 @@ func (e *Mod) End() loc.Pos {
 	return e.Right.End()
 }
 
+func (e *Lnot) Pos() loc.Pos {
+	return e.OpToken.Start
+}
+func (e *Lnot) End() loc.Pos {
+	return e.Child.End()
+}
+
+func (e *Land) Pos() loc.Pos {
+	return e.Left.Pos()
+}
+func (e *Land) End() loc.Pos {
+	return e.Right.End()
+}
+
+func (e *Lor) Pos() loc.Pos {
+	return e.Left.Pos()
+}
+func (e *Lor) End() loc.Pos {
+	return e.Right.End()
+}
+
+func (e *Lxor) Pos() loc.Pos {
+	return e.Left.Pos()
+}
+func (e *Lxor) End() loc.Pos {
+	return e.Right.End()
+}
+
+func (e *Lsl) Pos() loc.Pos {
+	return e.Left.Pos()
+}
+func (e *Lsl) End() loc.Pos {
+	return e.Right.End()
+}
+
+func (e *Lsr) Pos() loc.Pos {
+	return e.Left.Pos()
+}
+func (e *Lsr) End() loc.Pos {
+	return e.Right.End()
+}
+
+func (e *Asr) Pos() loc.Pos {
+	return e.Left.Pos()
+}
+func (e *Asr) End() loc.Pos {
+	return e.Right.End()
+}
+
 func (e *FNeg) Pos() loc.Pos {
 	return e.MinusToken.Start
 }
@@ -521,6 +639,13 @@ func (e *Apply) End() loc.Pos {
 	return e.Args[len(e.Args)-1].End()
 }
 
+func (e *LabeledArg) Pos() loc.Pos {
+	return e.StartToken.Start
+}
+func (e *LabeledArg) End() loc.Pos {
+	return e.Child.End()
+}
+
 func (e *Tuple) Pos() loc.Pos {
 	return e.Elems[0].Pos()
 }
@@ -549,6 +674,13 @@ func (e *ArraySize) End() loc.Pos {
 	return e.Target.End()
 }
 
+func (e *StringGet) Pos() loc.Pos {
+	return e.StringToken.Start
+}
+func (e *StringGet) End() loc.Pos {
+	return e.Index.End()
+}
+
 func (e *Get) Pos() loc.Pos {
 	return e.Array.Pos()
 }
@@ -634,6 +766,7 @@ func (e *Bool) Name() string      { return "Bool" }
 func (e *Int) Name() string       { return "Int" }
 func (e *Float) Name() string     { return "Float" }
 func (e *String) Name() string    { return fmt.Sprintf("String (%s)", e.Token.Value()) }
+func (e *Char) Name() string      { return fmt.Sprintf("Char (%s)", e.Token.Value()) }
 func (e *Not) Name() string       { return "Not" }
 func (e *Neg) Name() string       { return "Neg" }
 func (e *Add) Name() string       { return "Add" }
@@ -641,6 +774,13 @@ func (e *Sub) Name() string       { return "Sub" }
 func (e *Mul) Name() string       { return "Mul" }
 func (e *Div) Name() string       { return "Div" }
 func (e *Mod) Name() string       { return "Mod" }
+func (e *Lnot) Name() string      { return "Lnot" }
+func (e *Land) Name() string      { return "Land" }
+func (e *Lor) Name() string       { return "Lor" }
+func (e *Lxor) Name() string      { return "Lxor" }
+func (e *Lsl) Name() string       { return "Lsl" }
+func (e *Lsr) Name() string       { return "Lsr" }
+func (e *Asr) Name() string       { return "Asr" }
 func (e *FNeg) Name() string      { return "FNeg" }
 func (e *FAdd) Name() string      { return "FAdd" }
 func (e *FSub) Name() string      { return "FSub" }
@@ -657,14 +797,30 @@ func (e *Or) Name() string        { return "Or" }
 func (e *If) Name() string        { return "If" }
 func (e *Let) Name() string       { return fmt.Sprintf("Let (%s)", e.Symbol.DisplayName) }
 func (e *VarRef) Name() string    { return fmt.Sprintf("VarRef (%s)", e.Symbol.DisplayName) }
+func paramName(p Param) string {
+	switch {
+	case p.Optional:
+		return "?" + p.Ident.DisplayName
+	case p.Label != "":
+		return "~" + p.Ident.DisplayName
+	default:
+		return p.Ident.DisplayName
+	}
+}
 func (e *LetRec) Name() string {
-	params := e.Func.Params[0].Ident.DisplayName
+	params := paramName(e.Func.Params[0])
 	for _, p := range e.Func.Params[1:] {
-		params = fmt.Sprintf("%s, %s", params, p.Ident.DisplayName)
+		params = fmt.Sprintf("%s, %s", params, paramName(p))
 	}
 	return fmt.Sprintf("LetRec (fun %s %s)", e.Func.Symbol.DisplayName, params)
 }
 func (e *Apply) Name() string { return "Apply" }
+func (e *LabeledArg) Name() string {
+	if e.Optional {
+		return fmt.Sprintf("LabeledArg (?%s)", e.Label)
+	}
+	return fmt.Sprintf("LabeledArg (~%s)", e.Label)
+}
 func (e *Tuple) Name() string { return "Tuple" }
 func (e *LetTuple) Name() string {
 	vars := e.Symbols[0].DisplayName
@@ -675,6 +831,7 @@ func (e *LetTuple) Name() string {
 }
 func (e *ArrayCreate) Name() string { return "ArrayCreate" }
 func (e *ArraySize) Name() string   { return "ArraySize" }
+func (e *StringGet) Name() string   { return "StringGet" }
 func (e *Get) Name() string         { return "Get" }
 func (e *Put) Name() string         { return "Put" }
 func (e *Match) Name() string       { return fmt.Sprintf("Match (%s)", e.SomeIdent.DisplayName) }
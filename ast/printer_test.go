@@ -148,6 +148,9 @@ func TestPrintAST(t *testing.T) {
 								nil,
 								"unit",
 							},
+							"",
+							false,
+							nil,
 						},
 					},
 					&VarRef{tok, NewSymbol("a")},
@@ -34,6 +34,26 @@ func Visit(v Visitor, e Expr) {
 	case *Mod:
 		Visit(v, n.Left)
 		Visit(v, n.Right)
+	case *Lnot:
+		Visit(v, n.Child)
+	case *Land:
+		Visit(v, n.Left)
+		Visit(v, n.Right)
+	case *Lor:
+		Visit(v, n.Left)
+		Visit(v, n.Right)
+	case *Lxor:
+		Visit(v, n.Left)
+		Visit(v, n.Right)
+	case *Lsl:
+		Visit(v, n.Left)
+		Visit(v, n.Right)
+	case *Lsr:
+		Visit(v, n.Left)
+		Visit(v, n.Right)
+	case *Asr:
+		Visit(v, n.Left)
+		Visit(v, n.Right)
 	case *FNeg:
 		Visit(v, n.Child)
 	case *FAdd:
@@ -87,6 +107,9 @@ func Visit(v Visitor, e Expr) {
 			if p.Type != nil {
 				Visit(v, p.Type)
 			}
+			if p.Default != nil {
+				Visit(v, p.Default)
+			}
 		}
 		if n.Func.RetType != nil {
 			Visit(v, n.Func.RetType)
@@ -98,6 +121,8 @@ func Visit(v Visitor, e Expr) {
 		for _, e := range n.Args {
 			Visit(v, e)
 		}
+	case *LabeledArg:
+		Visit(v, n.Child)
 	case *Tuple:
 		for _, e := range n.Elems {
 			Visit(v, e)
@@ -113,6 +138,9 @@ func Visit(v Visitor, e Expr) {
 		Visit(v, n.Elem)
 	case *ArraySize:
 		Visit(v, n.Target)
+	case *StringGet:
+		Visit(v, n.String)
+		Visit(v, n.Index)
 	case *Get:
 		Visit(v, n.Array)
 		Visit(v, n.Index)
@@ -77,6 +77,9 @@ func (fvg *freeVarsGatherer) exploreInsn(insn *gcil.Insn) {
 		fvg.add(val.Rhs)
 	case *gcil.ArrLen:
 		fvg.add(val.Array)
+	case *gcil.StrLoad:
+		fvg.add(val.From)
+		fvg.add(val.Index)
 	case *gcil.Some:
 		fvg.add(val.Elem)
 	case *gcil.IsSome:
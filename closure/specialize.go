@@ -0,0 +1,445 @@
+package closure
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/gcil"
+	"github.com/rhysd/gocaml/typing"
+	"strings"
+)
+
+// Maximum number of specialized functions for one function. It prevents code size from exploding
+// when a higher-order function is called with many different functions.
+const maxSpecializations = 8
+
+type specKey struct {
+	callee string
+	args   string // Known functions passed to parameters. Unknown arguments are empty
+}
+
+// Known function passed as argument at the parameter index
+type knownArg struct {
+	index int
+	fun   string
+}
+
+// Closure specialization (a.k.a. defunctionalization for known functions).
+//
+// When a function parameter is only called in the body and a known function is passed as the
+// argument at a call site, the callee is cloned for the arguments and the parameters are renamed to
+// the names of the argument functions. All known function arguments at the call site are
+// specialized by one clone (e.g. 'f[h,g]'). In the cloned body, calling the parameter becomes a call to
+// the known function. Code generator emits a direct call for it (captures are still passed through
+// the closure value), so LLVM can inline the function.
+//
+// e.g.
+//
+//	f = fun g,x
+//	  appcls g x
+//	h = makecls () h
+//	app f h,y
+//
+// is converted to
+//
+//	f[h] = fun h,x[h]
+//	  appcls h x[h]   ; direct call to function 'h'
+//	h = makecls () h
+//	app f[h] h,y
+//
+// Other identifiers defined in the clone are also renamed with the suffix (e.g. 'x' -> 'x[h]') to
+// keep each identifier defined only once in the program. When 'f = fun g1,g2,x' is called with known
+// functions 'h1' and 'h2' as 'app f h1,h2,y', the clone is 'f[h1,h2] = fun h1,h2,x[h1,h2]'.
+type specializer struct {
+	prog    *gcil.Program
+	env     *typing.Env
+	specs   map[specKey]string
+	counts  map[string]int
+	visited map[string]struct{}
+	queue   []*gcil.Block
+}
+
+func specializedName(callee string, known []knownArg) string {
+	funs := make([]string, 0, len(known))
+	for _, k := range known {
+		funs = append(funs, k.fun)
+	}
+	return fmt.Sprintf("%s[%s]", callee, strings.Join(funs, ","))
+}
+
+// OriginalName returns the name of the original function which the specialized function was cloned
+// from. Names of other functions are returned as-is.
+func OriginalName(name string) string {
+	if i := strings.IndexByte(name, '['); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// Returns true when the identifier is a closure value of toplevel function. Thanks to alpha
+// transform, the name of closure value is always the same as the function's name.
+func (spec *specializer) isKnownClosure(ident string) bool {
+	if _, ok := spec.prog.Toplevel[ident]; !ok {
+		return false
+	}
+	_, ok := spec.prog.Closures[ident]
+	return ok
+}
+
+func (spec *specializer) canSpecialize(callee string, index int, arg string) bool {
+	if callee == arg || !spec.isKnownClosure(arg) {
+		return false
+	}
+	if _, ok := spec.prog.Closures[callee]; ok {
+		// Closure call site needs the closure value of callee. Specialized function cannot have it.
+		return false
+	}
+	f, ok := spec.prog.Toplevel[callee]
+	if !ok || len(f.Val.Params) <= index {
+		return false
+	}
+	param := f.Val.Params[index]
+	used := usedNames(f.Val.Body)
+	if _, ok := used[arg]; ok {
+		// Renaming the parameter would conflict with other use of the function
+		return false
+	}
+	for _, p := range f.Val.Params {
+		if p == arg {
+			return false
+		}
+	}
+	return isCalledInBlock(f.Val.Body, param)
+}
+
+func (spec *specializer) specialize(callee string, known []knownArg) string {
+	orig := spec.prog.Toplevel[callee]
+	args := make([]string, len(orig.Val.Params))
+	for _, k := range known {
+		args[k.index] = k.fun
+	}
+	key := specKey{callee, strings.Join(args, ",")}
+	if name, ok := spec.specs[key]; ok {
+		return name
+	}
+	if spec.counts[callee] >= maxSpecializations {
+		return ""
+	}
+	name := specializedName(callee, known)
+	if _, ok := spec.prog.Toplevel[name]; ok {
+		// The same function was already specialized with the arguments at other parameters
+		return ""
+	}
+	spec.counts[callee]++
+
+	// Identifiers defined in the clone are renamed so that each identifier is defined only once in
+	// the program. Names of toplevel functions are not renamed because they are closure values of
+	// the functions.
+	renames := map[string]string{}
+	for _, k := range known {
+		renames[orig.Val.Params[k.index]] = k.fun
+	}
+	for _, n := range append(definedNames(orig.Val.Body), orig.Val.Params...) {
+		if _, ok := renames[n]; ok {
+			continue
+		}
+		if _, ok := spec.prog.Toplevel[n]; ok {
+			continue
+		}
+		renamed := specializedName(n, known)
+		renames[n] = renamed
+		spec.env.Table[renamed] = spec.env.Table[n]
+	}
+	rename := func(n string) string {
+		if r, ok := renames[n]; ok {
+			return r
+		}
+		return n
+	}
+
+	params := make([]string, 0, len(orig.Val.Params))
+	for _, p := range orig.Val.Params {
+		params = append(params, rename(p))
+	}
+	body := copyBlock(orig.Val.Body, fmt.Sprintf("body (%s)", name), rename)
+	spec.prog.Toplevel.Add(name, &gcil.Fun{params, body, orig.Val.IsRecursive}, orig.Pos)
+	spec.env.Table[name] = spec.env.Table[callee]
+	spec.specs[key] = name
+	spec.queue = append(spec.queue, body)
+	return name
+}
+
+func (spec *specializer) app(app *gcil.App) {
+	if app.Kind != gcil.DIRECT_CALL {
+		return
+	}
+	known := []knownArg{}
+	seen := map[string]struct{}{}
+	for i, a := range app.Args {
+		if _, ok := seen[a]; ok {
+			// The same function cannot be the name of two parameters
+			continue
+		}
+		if spec.canSpecialize(app.Callee, i, a) {
+			known = append(known, knownArg{i, a})
+			seen[a] = struct{}{}
+		}
+	}
+	if len(known) == 0 {
+		return
+	}
+	if name := spec.specialize(app.Callee, known); name != "" {
+		spec.visited[app.Callee] = struct{}{}
+		app.Callee = name
+	}
+}
+
+func (spec *specializer) block(block *gcil.Block) {
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		switch val := i.Val.(type) {
+		case *gcil.App:
+			spec.app(val)
+		case *gcil.If:
+			spec.block(val.Then)
+			spec.block(val.Else)
+		}
+	}
+}
+
+// Removes the functions which were specialized and are no longer referred from anywhere.
+// Recursive call in its own body is not counted as a reference.
+func (spec *specializer) removeUnused() {
+	used := map[string]nameSet{"": usedNames(spec.prog.Entry)}
+	for n, f := range spec.prog.Toplevel {
+		used[n] = usedNames(f.Val.Body)
+	}
+	for removed := true; removed; {
+		removed = false
+		for n := range spec.visited {
+			if _, ok := used[n]; !ok || isReferred(n, used) {
+				continue
+			}
+			delete(spec.prog.Toplevel, n)
+			delete(used, n)
+			removed = true
+		}
+	}
+}
+
+func isReferred(name string, used map[string]nameSet) bool {
+	for user, names := range used {
+		if user == name {
+			continue
+		}
+		if _, ok := names[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Specialize does closure specialization for higher-order functions called with known functions.
+// It must be applied to the closure-transformed program. Specialized functions are added to
+// toplevel of the program and their types are registered to the type environment.
+func Specialize(prog *gcil.Program, env *typing.Env) {
+	spec := &specializer{
+		prog,
+		env,
+		map[specKey]string{},
+		map[string]int{},
+		map[string]struct{}{},
+		[]*gcil.Block{prog.Entry},
+	}
+	// Functions are visited in order of their names because which functions are specialized depends
+	// on the order when the number of specializations reaches the limit.
+	for _, n := range prog.Toplevel.SortedNames() {
+		spec.queue = append(spec.queue, prog.Toplevel[n].Val.Body)
+	}
+	for len(spec.queue) > 0 {
+		b := spec.queue[0]
+		spec.queue = spec.queue[1:]
+		spec.block(b)
+	}
+	spec.removeUnused()
+}
+
+// Returns true when the function parameter is called in the block or passed to other function
+// which may be specialized further.
+func isCalledInBlock(block *gcil.Block, name string) bool {
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		switch val := i.Val.(type) {
+		case *gcil.App:
+			if val.Kind == gcil.CLOSURE_CALL && val.Callee == name {
+				return true
+			}
+			if val.Kind == gcil.DIRECT_CALL {
+				for _, a := range val.Args {
+					if a == name {
+						return true
+					}
+				}
+			}
+		case *gcil.If:
+			if isCalledInBlock(val.Then, name) || isCalledInBlock(val.Else, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type nameCollector struct {
+	names nameSet
+}
+
+func (c *nameCollector) add(names ...string) {
+	for _, n := range names {
+		c.names[n] = struct{}{}
+	}
+}
+
+func (c *nameCollector) block(block *gcil.Block) {
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		c.add(i.Ident)
+		switch val := i.Val.(type) {
+		case *gcil.Unary:
+			c.add(val.Child)
+		case *gcil.Binary:
+			c.add(val.Lhs, val.Rhs)
+		case *gcil.Ref:
+			c.add(val.Ident)
+		case *gcil.If:
+			c.add(val.Cond)
+			c.block(val.Then)
+			c.block(val.Else)
+		case *gcil.App:
+			c.add(val.Callee)
+			c.add(val.Args...)
+		case *gcil.Tuple:
+			c.add(val.Elems...)
+		case *gcil.Array:
+			c.add(val.Size, val.Elem)
+		case *gcil.TplLoad:
+			c.add(val.From)
+		case *gcil.ArrLoad:
+			c.add(val.From, val.Index)
+		case *gcil.ArrStore:
+			c.add(val.To, val.Index, val.Rhs)
+		case *gcil.ArrLen:
+			c.add(val.Array)
+		case *gcil.StrLoad:
+			c.add(val.From, val.Index)
+		case *gcil.Some:
+			c.add(val.Elem)
+		case *gcil.IsSome:
+			c.add(val.OptVal)
+		case *gcil.DerefSome:
+			c.add(val.SomeVal)
+		case *gcil.MakeCls:
+			c.add(val.Fun)
+			c.add(val.Vars...)
+		}
+	}
+}
+
+// Collects all names defined or used in the block
+func usedNames(block *gcil.Block) nameSet {
+	c := &nameCollector{nameSet{}}
+	c.block(block)
+	return c.names
+}
+
+func renameAll(names []string, rename func(string) string) []string {
+	renamed := make([]string, 0, len(names))
+	for _, n := range names {
+		renamed = append(renamed, rename(n))
+	}
+	return renamed
+}
+
+func copyVal(val gcil.Val, rename func(string) string) gcil.Val {
+	switch val := val.(type) {
+	case *gcil.Unit:
+		return gcil.UnitVal
+	case *gcil.Bool:
+		return &gcil.Bool{val.Const}
+	case *gcil.Int:
+		return &gcil.Int{val.Const}
+	case *gcil.Float:
+		return &gcil.Float{val.Const}
+	case *gcil.String:
+		return &gcil.String{val.Const}
+	case *gcil.Char:
+		return &gcil.Char{val.Const}
+	case *gcil.Unary:
+		return &gcil.Unary{val.Op, rename(val.Child)}
+	case *gcil.Binary:
+		return &gcil.Binary{val.Op, rename(val.Lhs), rename(val.Rhs)}
+	case *gcil.Ref:
+		return &gcil.Ref{rename(val.Ident)}
+	case *gcil.If:
+		return &gcil.If{
+			rename(val.Cond),
+			copyBlock(val.Then, val.Then.Name, rename),
+			copyBlock(val.Else, val.Else.Name, rename),
+		}
+	case *gcil.App:
+		return &gcil.App{rename(val.Callee), renameAll(val.Args, rename), val.Kind}
+	case *gcil.Tuple:
+		return &gcil.Tuple{renameAll(val.Elems, rename), val.OnStack}
+	case *gcil.Array:
+		return &gcil.Array{rename(val.Size), rename(val.Elem)}
+	case *gcil.TplLoad:
+		return &gcil.TplLoad{rename(val.From), val.Index}
+	case *gcil.ArrLoad:
+		return &gcil.ArrLoad{rename(val.From), rename(val.Index)}
+	case *gcil.ArrStore:
+		return &gcil.ArrStore{rename(val.To), rename(val.Index), rename(val.Rhs)}
+	case *gcil.ArrLen:
+		return &gcil.ArrLen{rename(val.Array)}
+	case *gcil.StrLoad:
+		return &gcil.StrLoad{rename(val.From), rename(val.Index)}
+	case *gcil.Some:
+		return &gcil.Some{rename(val.Elem)}
+	case *gcil.None:
+		return gcil.NoneVal
+	case *gcil.IsSome:
+		return &gcil.IsSome{rename(val.OptVal)}
+	case *gcil.DerefSome:
+		return &gcil.DerefSome{rename(val.SomeVal)}
+	case *gcil.XRef:
+		return &gcil.XRef{val.Ident}
+	case *gcil.MakeCls:
+		return &gcil.MakeCls{renameAll(val.Vars, rename), val.Fun, val.OnStack}
+	case *gcil.Fun:
+		panic("unreachable because IR was closure-transformed")
+	default:
+		panic(fmt.Sprintf("Unknown value to copy: %v", val))
+	}
+}
+
+// Makes a deep copy of the block. Identifiers defined or used in the block are renamed with
+// 'rename'.
+func copyBlock(block *gcil.Block, name string, rename func(string) string) *gcil.Block {
+	insns := []*gcil.Insn{}
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		insns = append(insns, gcil.NewInsn(rename(i.Ident), copyVal(i.Val, rename), i.Pos))
+	}
+	return gcil.NewBlockFromArray(name, insns)
+}
+
+// Collects identifiers defined by instructions in the block including nested blocks
+func definedNames(block *gcil.Block) []string {
+	names := []string{}
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		names = append(names, i.Ident)
+		if val, ok := i.Val.(*gcil.If); ok {
+			names = append(names, definedNames(val.Then)...)
+			names = append(names, definedNames(val.Else)...)
+		}
+	}
+	return names
+}
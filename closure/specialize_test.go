@@ -0,0 +1,232 @@
+package closure
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rhysd/gocaml/alpha"
+	"github.com/rhysd/gocaml/gcil"
+	"github.com/rhysd/gocaml/lexer"
+	"github.com/rhysd/gocaml/parser"
+	"github.com/rhysd/gocaml/typing"
+	"github.com/rhysd/loc"
+	"strings"
+	"testing"
+)
+
+func testSpecialize(t *testing.T, code string) (*gcil.Program, *typing.Env) {
+	s := loc.NewDummySource(code)
+	l := lexer.NewLexer(s)
+	go l.Lex()
+	ast, err := parser.Parse(l.Tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = alpha.Transform(ast.Root); err != nil {
+		t.Fatal(err)
+	}
+	env, err := typing.TypeInferernce(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, err := gcil.FromAST(ast.Root, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcil.ElimRefs(ir, env)
+	prog := Transform(ir)
+	Specialize(prog, env)
+	return prog, env
+}
+
+func TestSpecialize(t *testing.T) {
+	cases := []struct {
+		what       string
+		code       string
+		toplevel   []string
+		entry      []string
+		notContain []string
+	}{
+		{
+			what: "higher-order function called with known function",
+			code: "let rec apply f x = f x in let rec inc x = x + 1 in println_int (apply inc 41)",
+			toplevel: []string{
+				"apply$t1[inc$t4] = fun inc$t4,x$t3[inc$t4] ; type=(int -> int) -> int -> int",
+				"$k3[inc$t4] = appcls inc$t4 x$t3[inc$t4] ; type=int",
+			},
+			entry: []string{
+				"inc$t4 = makecls () inc$t4",
+				"app apply$t1[inc$t4] inc$t4,$k",
+			},
+			notContain: []string{
+				"apply$t1 = fun",
+			},
+		},
+		{
+			what: "specialized per argument function",
+			code: "let rec apply f x = f x in let rec inc x = x + 1 in let rec dec x = x - 1 in println_int (apply inc (apply dec 0))",
+			toplevel: []string{
+				"apply$t1[inc$t4] = fun inc$t4,x$t3[inc$t4] ;",
+				"apply$t1[dec$t6] = fun dec$t6,x$t3[dec$t6] ;",
+			},
+			entry: []string{
+				"app apply$t1[inc$t4] inc$t4,",
+				"app apply$t1[dec$t6] dec$t6,",
+			},
+		},
+		{
+			what: "known closure passed to higher-order function",
+			code: "let n = 10 in let rec apply f x = f x in let rec add x = x + n in println_int (apply add 1)",
+			toplevel: []string{
+				"apply$t2[add$t5] = fun add$t5,x$t4[add$t5] ;",
+			},
+			entry: []string{
+				"add$t5 = makecls (n$t1) add$t5",
+				"app apply$t2[add$t5] add$t5,",
+			},
+		},
+		{
+			what: "recursive higher-order function",
+			code: "let rec iter f arr i = if i < Array.length arr then (f arr.(i); iter f arr (i + 1)) else () in let rec p x = println_int x in iter p (Array.make 3 1) 0",
+			toplevel: []string{
+				"iter$t1[p$t5] = recfun p$t5,arr$t3[p$t5],i$t4[p$t5]",
+				"appcls p$t5 $k",
+				"app iter$t1[p$t5] p$t5,arr$t3[p$t5],",
+			},
+			notContain: []string{
+				"app iter$t1 ",
+			},
+		},
+		{
+			what: "identifiers in specialized function are renamed",
+			code: "let rec apply f x = let y = f x in y + 1 in let rec inc x = x + 1 in let rec dec x = x - 1 in println_int (apply inc (apply dec 0))",
+			toplevel: []string{
+				"y$t4[inc$t5] = appcls inc$t5 x$t3[inc$t5] ; type=int",
+				"y$t4[dec$t7] = appcls dec$t7 x$t3[dec$t7] ; type=int",
+			},
+			notContain: []string{
+				"y$t4 = ",
+			},
+		},
+		{
+			what: "multiple function parameters",
+			code: "let rec compose f g x = f (g x) in let rec inc x = x + 1 in let rec dbl x = x * 2 in println_int (compose inc dbl 3)",
+			toplevel: []string{
+				"compose$t1[inc$t5,dbl$t7] = fun inc$t5,dbl$t7,x$t4[inc$t5,dbl$t7] ;",
+				"appcls dbl$t7 x$t4[inc$t5,dbl$t7] ;",
+				"appcls inc$t5",
+			},
+			entry: []string{
+				"app compose$t1[inc$t5,dbl$t7] inc$t5,dbl$t7,",
+			},
+			notContain: []string{
+				"compose$t1[inc$t5] ",
+				"compose$t1 = ",
+			},
+		},
+		{
+			what: "the same function passed to multiple parameters",
+			code: "let rec compose f g x = f (g x) in let rec inc x = x + 1 in println_int (compose inc inc 3)",
+			toplevel: []string{
+				"compose$t1[inc$t5] = fun inc$t5,g$t3[inc$t5],x$t4[inc$t5] ;",
+				"appcls g$t3[inc$t5] x$t4[inc$t5] ;",
+			},
+			entry: []string{
+				"app compose$t1[inc$t5] inc$t5,inc$t5,",
+			},
+		},
+		{
+			what: "function called via closure is not specialized",
+			code: "let n = 1 in let rec apply f x = f x + n in let rec inc x = x + 1 in println_int (apply inc 41)",
+			toplevel: []string{
+				"apply$t2 = fun f$t3,x$t4",
+			},
+			notContain: []string{
+				"apply$t2[",
+			},
+		},
+		{
+			what: "parameter which is not called is not specialized",
+			code: "let rec id f = f in let rec inc x = x + 1 in println_int ((id inc) 1)",
+			toplevel: []string{
+				"id$t1 = fun f$t2",
+			},
+			notContain: []string{
+				"id$t1[",
+			},
+		},
+		{
+			what: "function parameter passed to other higher-order function",
+			code: "let rec apply f x = f x in let rec twice g = apply g (apply g 1) in let rec inc x = x + 1 in println_int (twice inc)",
+			toplevel: []string{
+				"twice$t4[inc$t6] = fun inc$t6",
+				"app apply$t1[inc$t6] inc$t6,",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			prog, env := testSpecialize(t, tc.code)
+
+			var buf bytes.Buffer
+			prog.PrintToplevels(&buf, env)
+			toplevel := buf.String()
+			for _, expected := range tc.toplevel {
+				if !strings.Contains(toplevel, expected) {
+					t.Errorf("Expected '%s' in toplevels. Output:\n%s", expected, toplevel)
+				}
+			}
+
+			buf.Reset()
+			prog.Entry.Println(&buf, env)
+			entry := buf.String()
+			for _, expected := range tc.entry {
+				if !strings.Contains(entry, expected) {
+					t.Errorf("Expected '%s' in entry. Output:\n%s", expected, entry)
+				}
+			}
+
+			for _, unexpected := range tc.notContain {
+				if strings.Contains(toplevel, unexpected) || strings.Contains(entry, unexpected) {
+					t.Errorf("Unexpected '%s' in output:\n%s\n%s", unexpected, toplevel, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestSpecializationLimitIsDeterministic(t *testing.T) {
+	// 'ap' is called with 10 different known functions from 10 different functions. Only some of
+	// them are specialized due to the limit. Which ones are specialized must not depend on order
+	// of iterating maps.
+	var code bytes.Buffer
+	code.WriteString("let rec ap g x = g x in\n")
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&code, "let rec f%d x = x + %d in\n", i, i)
+		fmt.Fprintf(&code, "let rec c%d x = ap f%d x in\n", i, i)
+	}
+	code.WriteString("println_int (c0 0")
+	for i := 1; i < 10; i++ {
+		fmt.Fprintf(&code, " + c%d 0", i)
+	}
+	code.WriteString(")\n")
+
+	var first string
+	for i := 0; i < 12; i++ {
+		prog, env := testSpecialize(t, code.String())
+		var buf bytes.Buffer
+		prog.PrintToplevels(&buf, env)
+		out := buf.String()
+		if i == 0 {
+			first = out
+			// Functions are visited in order of their names. So calls in c0~c7 are specialized.
+			if !strings.Contains(out, "ap$t1[f0$t4] = fun") || !strings.Contains(out, "ap$t1[f7$t32] = fun") || strings.Contains(out, "ap$t1[f8$t36]") {
+				t.Fatalf("Unexpected specializations:\n%s", out)
+			}
+			continue
+		}
+		if out != first {
+			t.Fatalf("Specialization result changed between runs.\nFirst:\n%s\nNow:\n%s", first, out)
+		}
+	}
+}
@@ -135,7 +135,7 @@ func (trans *transformWithKFO) insn(insn *gcil.Insn) {
 				delete(trans.knownFuns, insn.Ident)
 			}
 			// If the function is referred from somewhere, we need to  make a closure.
-			replaced = &gcil.MakeCls{vars, insn.Ident}
+			replaced = &gcil.MakeCls{vars, insn.Ident, false}
 		}
 		trans.replacedFuns[insn] = replaced
 	case *gcil.If:
@@ -5,6 +5,7 @@ import (
 	"github.com/rhysd/gocaml/gcil"
 	"github.com/rhysd/gocaml/typing"
 	"llvm.org/llvm/bindings/go/llvm"
+	"strings"
 )
 
 func getOpCmpPredicate(op gcil.OperatorKind) (llvm.IntPredicate, llvm.FloatPredicate, string) {
@@ -27,6 +28,21 @@ func getOpCmpPredicate(op gcil.OperatorKind) (llvm.IntPredicate, llvm.FloatPredi
 	}
 }
 
+func unsignedPredicate(pred llvm.IntPredicate) llvm.IntPredicate {
+	switch pred {
+	case llvm.IntSLT:
+		return llvm.IntULT
+	case llvm.IntSLE:
+		return llvm.IntULE
+	case llvm.IntSGT:
+		return llvm.IntUGT
+	case llvm.IntSGE:
+		return llvm.IntUGE
+	default:
+		return pred
+	}
+}
+
 type blockBuilder struct {
 	*moduleBuilder
 	registers   map[string]llvm.Value
@@ -108,7 +124,7 @@ func (b *blockBuilder) buildEq(ty typing.Type, bin *gcil.Binary, lhs, rhs llvm.V
 			i = 0
 		}
 		return llvm.ConstInt(b.typeBuilder.boolT, i, false /*sign extend*/)
-	case *typing.Bool, *typing.Int:
+	case *typing.Bool, *typing.Int, *typing.Char:
 		return b.builder.CreateICmp(icmp, lhs, rhs, name)
 	case *typing.Float:
 		return b.builder.CreateFCmp(fcmp, lhs, rhs, name)
@@ -161,12 +177,20 @@ func (b *blockBuilder) buildLess(val *gcil.Binary, lhs, rhs llvm.Value) llvm.Val
 		return b.builder.CreateICmp(ipred, lhs, rhs, name)
 	case *typing.Float:
 		return b.builder.CreateFCmp(fpred, lhs, rhs, name)
+	case *typing.Char:
+		// Characters are compared as unsigned bytes
+		return b.builder.CreateICmp(unsignedPredicate(ipred), lhs, rhs, name)
 	default:
 		panic(fmt.Sprintf("Invalid type for '%s' operator: %s", name, lty.String()))
 	}
 }
 
 func (b *blockBuilder) buildEqOption(ty *typing.Option, bin *gcil.Binary, lhs, rhs llvm.Value) llvm.Value {
+	switch ty.Elem.(type) {
+	case *typing.Int, *typing.Bool, *typing.Float, *typing.Char:
+		return b.buildEqFlatOption(ty, bin, lhs, rhs)
+	}
+
 	tyVal := b.typeBuilder.buildOption(ty)
 	lhsIsSome := b.buildIsSome(lhs, tyVal, ty)
 	rhsIsSome := b.buildIsSome(rhs, tyVal, ty)
@@ -203,27 +227,38 @@ func (b *blockBuilder) buildEqOption(ty *typing.Option, bin *gcil.Binary, lhs, r
 	return phi
 }
 
+// Compares scalar option values without branches. Since payload of unboxed option value is
+// always initialized (zero for 'None'), it is safe to compare payloads even if they are 'None'.
+//
+//	lhs = rhs  : lhs.tag = rhs.tag && (not lhs.tag || lhs.value = rhs.value)
+//	lhs <> rhs : lhs.tag <> rhs.tag || (lhs.tag && lhs.value <> rhs.value)
+func (b *blockBuilder) buildEqFlatOption(ty *typing.Option, bin *gcil.Binary, lhs, rhs llvm.Value) llvm.Value {
+	tyVal := b.typeBuilder.buildOption(ty)
+	lhsIsSome := b.buildIsSome(lhs, tyVal, ty)
+	rhsIsSome := b.buildIsSome(rhs, tyVal, ty)
+	elemCmp := b.buildEq(ty.Elem, bin, b.buildDerefSome(lhs, ty), b.buildDerefSome(rhs, ty))
+
+	if bin.Op == gcil.NEQ {
+		tagCmp := b.builder.CreateICmp(llvm.IntNE, lhsIsSome, rhsIsSome, "")
+		both := b.builder.CreateAnd(lhsIsSome, elemCmp, "")
+		return b.builder.CreateOr(tagCmp, both, "neq.opt")
+	}
+
+	tagCmp := b.builder.CreateICmp(llvm.IntEQ, lhsIsSome, rhsIsSome, "")
+	both := b.builder.CreateOr(b.builder.CreateNot(lhsIsSome, ""), elemCmp, "")
+	return b.builder.CreateAnd(tagCmp, both, "eql.opt")
+}
+
 func (b *blockBuilder) buildIsSome(optVal llvm.Value, tyVal llvm.Type, ty *typing.Option) llvm.Value {
 	switch ty.Elem.(type) {
-	case *typing.Int, *typing.Bool, *typing.Float:
-		one := llvm.ConstInt(tyVal, 1, false /*signed*/)
-		// Extract flag value
-		flag := b.builder.CreateAnd(optVal, one, "")
-		// flag == 1 means that it contains a value
-		return b.builder.CreateICmp(llvm.IntEQ, flag, one, "issome")
+	case *typing.Int, *typing.Bool, *typing.Float, *typing.Char, *typing.Option, *typing.Unit:
+		// First field of unboxed option value is a tag
+		return b.builder.CreateExtractValue(optVal, 0, "issome")
 	case *typing.String, *typing.Fun, *typing.Array:
 		ptr := b.builder.CreateExtractValue(optVal, 0, "")
 		return b.builder.CreateNot(b.builder.CreateIsNull(ptr, ""), "issome")
-	case *typing.Tuple:
+	case *typing.Tuple, *typing.Opaque, *typing.Hashtbl:
 		return b.builder.CreateNot(b.builder.CreateIsNull(optVal, ""), "issome")
-	case *typing.Option, *typing.Unit:
-		flag := b.builder.CreateExtractValue(optVal, 0, "")
-		return b.builder.CreateICmp(
-			llvm.IntEQ,
-			flag,
-			llvm.ConstInt(b.typeBuilder.boolT, 1, false /*signed*/),
-			"issome",
-		)
 	default:
 		panic("unreachable")
 	}
@@ -231,29 +266,44 @@ func (b *blockBuilder) buildIsSome(optVal llvm.Value, tyVal llvm.Type, ty *typin
 
 func (b *blockBuilder) buildDerefSome(optVal llvm.Value, ty *typing.Option) llvm.Value {
 	switch ty.Elem.(type) {
-	case *typing.Int:
-		// shift 1 bit to squash a flag
-		one := llvm.ConstInt(llvm.IntType(65), 1, false /*signed*/)
-		v := b.builder.CreateLShr(optVal, one, "")
-		// Truncate to the same size bits
-		return b.builder.CreateTrunc(v, b.typeBuilder.intT, "derefsome")
-	case *typing.Float:
-		// shift 1 bit to squash a flag
-		one := llvm.ConstInt(llvm.IntType(65), 1, false /*signed*/)
-		v := b.builder.CreateLShr(optVal, one, "")
-		// Truncate to the same size bits
-		v = b.builder.CreateTrunc(v, llvm.IntType(64), "")
-		return b.builder.CreateBitCast(v, b.typeBuilder.convertGCIL(ty.Elem), "derefsome")
-	case *typing.Bool:
-		// shift 1 bit to squash a flag
-		one := llvm.ConstInt(llvm.IntType(2), 1, false /*signed*/)
-		v := b.builder.CreateLShr(optVal, one, "")
-		// Truncate to the same size bits
-		return b.builder.CreateTrunc(v, b.typeBuilder.boolT, "derefsome")
-	case *typing.String, *typing.Fun, *typing.Array, *typing.Tuple:
-		return optVal
-	case *typing.Option, *typing.Unit:
+	case *typing.Int, *typing.Bool, *typing.Float, *typing.Char, *typing.Option, *typing.Unit:
+		// Second field of unboxed option value is a payload
 		return b.builder.CreateExtractValue(optVal, 1, "derefsome")
+	case *typing.String, *typing.Fun, *typing.Array, *typing.Tuple, *typing.Opaque, *typing.Hashtbl:
+		return optVal
+	default:
+		panic("unreachable")
+	}
+}
+
+func (b *blockBuilder) buildSome(elemVal llvm.Value, ty *typing.Option) llvm.Value {
+	switch ty.Elem.(type) {
+	case *typing.Int, *typing.Bool, *typing.Float, *typing.Char, *typing.Option, *typing.Unit:
+		v := llvm.Undef(b.typeBuilder.buildOption(ty))
+		v = b.builder.CreateInsertValue(v, llvm.ConstInt(b.typeBuilder.boolT, 1, false), 0, "some.flag")
+		v = b.builder.CreateInsertValue(v, elemVal, 1, "some.elem")
+		return v
+	case *typing.String, *typing.Fun, *typing.Array, *typing.Tuple, *typing.Opaque, *typing.Hashtbl:
+		// They use NULL pointer for 'None' value. So nothing to do to make 'Some' value.
+		return elemVal
+	default:
+		panic("unreachable")
+	}
+}
+
+func (b *blockBuilder) buildNone(ty *typing.Option) llvm.Value {
+	tyVal := b.typeBuilder.buildOption(ty)
+	switch ty.Elem.(type) {
+	case *typing.Int, *typing.Bool, *typing.Float, *typing.Char, *typing.Option, *typing.Unit:
+		// Both tag and payload are zero
+		return llvm.ConstNull(tyVal)
+	case *typing.String, *typing.Fun, *typing.Array:
+		v := llvm.Undef(tyVal)
+		null := llvm.ConstPointerNull(tyVal.StructElementTypes()[0])
+		v = b.builder.CreateInsertValue(v, null, 0, "none.flag")
+		return v
+	case *typing.Tuple, *typing.Opaque, *typing.Hashtbl:
+		return llvm.ConstPointerNull(tyVal)
 	default:
 		panic("unreachable")
 	}
@@ -273,6 +323,8 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 		return llvm.ConstInt(b.typeBuilder.intT, uint64(val.Const), true /*sign extend*/)
 	case *gcil.Float:
 		return llvm.ConstFloat(b.typeBuilder.floatT, val.Const)
+	case *gcil.Char:
+		return llvm.ConstInt(b.typeBuilder.charT, uint64(val.Const), false /*sign extend*/)
 	case *gcil.String:
 		strVal := b.buildAlloca(b.typeBuilder.stringT, "")
 
@@ -294,6 +346,8 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 			return b.builder.CreateFNeg(child, "fneg")
 		case gcil.NOT:
 			return b.builder.CreateNot(child, "not")
+		case gcil.LNOT:
+			return b.builder.CreateNot(child, "lnot")
 		default:
 			panic("unreachable")
 		}
@@ -311,6 +365,18 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 			return b.builder.CreateSDiv(lhs, rhs, "div")
 		case gcil.MOD:
 			return b.builder.CreateSRem(lhs, rhs, "mod")
+		case gcil.LAND:
+			return b.builder.CreateAnd(lhs, rhs, "land")
+		case gcil.LOR:
+			return b.builder.CreateOr(lhs, rhs, "lor")
+		case gcil.LXOR:
+			return b.builder.CreateXor(lhs, rhs, "lxor")
+		case gcil.LSL:
+			return b.builder.CreateShl(lhs, rhs, "lsl")
+		case gcil.LSR:
+			return b.builder.CreateLShr(lhs, rhs, "lsr")
+		case gcil.ASR:
+			return b.builder.CreateAShr(lhs, rhs, "asr")
 		case gcil.FADD:
 			return b.builder.CreateFAdd(lhs, rhs, "fadd")
 		case gcil.FSUB:
@@ -347,12 +413,18 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 		b.builder.CreateCondBr(cond, thenBlock, elseBlock)
 
 		b.builder.SetInsertPointAtEnd(thenBlock)
+		if b.coverage != nil {
+			b.buildCoverageCount(b.coverage.branches[val.Then])
+		}
 		thenVal := b.buildBlock(val.Then)
 		b.builder.CreateBr(endBlock)
 		thenLastBlock := b.builder.GetInsertBlock()
 
 		elseBlock.MoveAfter(thenLastBlock)
 		b.builder.SetInsertPointAtEnd(elseBlock)
+		if b.coverage != nil {
+			b.buildCoverageCount(b.coverage.branches[val.Else])
+		}
 		elseVal := b.buildBlock(val.Else)
 		b.builder.CreateBr(endBlock)
 		elseLastBlock := b.builder.GetInsertBlock()
@@ -365,6 +437,15 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 	case *gcil.Fun:
 		panic("unreachable because IR was closure-transformed")
 	case *gcil.App:
+		if val.Kind == gcil.EXTERNAL_CALL && strings.HasPrefix(val.Callee, "Hashtbl.") {
+			return b.buildHashtblCall(ident, val)
+		}
+		if val.Kind == gcil.EXTERNAL_CALL && val.Callee == "exit" {
+			code := b.builder.CreateTrunc(b.resolve(val.Args[0]), b.context.Int32Type(), "")
+			b.builder.CreateCall(b.globalTable["exit"], []llvm.Value{code}, "")
+			// Returned value is never used because exit() does not return
+			return llvm.Undef(b.typeBuilder.convertGCIL(b.typeOf(ident)))
+		}
 		argsLen := len(val.Args)
 		if val.Kind == gcil.CLOSURE_CALL {
 			argsLen++
@@ -413,7 +494,13 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 		ptrTy := b.typeBuilder.convertGCIL(b.typeOf(ident))
 		allocTy := ptrTy.ElementType()
 
-		ptr := b.buildMalloc(allocTy, ident)
+		var ptr llvm.Value
+		if val.OnStack {
+			// Escape analysis proved that the tuple is never used after returning from the function
+			ptr = b.buildAlloca(allocTy, ident)
+		} else {
+			ptr = b.buildMalloc(allocTy, ident)
+		}
 		for i, e := range val.Elems {
 			v := b.resolve(e)
 			p := b.builder.CreateStructGEP(ptr, i, fmt.Sprintf("%s.%d", ident, i))
@@ -491,6 +578,12 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 	case *gcil.ArrLen:
 		fromVal := b.resolve(val.Array)
 		return b.builder.CreateExtractValue(fromVal, 1, "arrsize")
+	case *gcil.StrLoad:
+		fromVal := b.resolve(val.From)
+		idxVal := b.resolve(val.Index)
+		charsPtr := b.builder.CreateExtractValue(fromVal, 0, "")
+		charPtr := b.builder.CreateInBoundsGEP(charsPtr, []llvm.Value{idxVal}, "")
+		return b.builder.CreateLoad(charPtr, "strload")
 	case *gcil.XRef:
 		ty, ok := b.env.Externals[val.Ident]
 		if !ok {
@@ -539,7 +632,14 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 		}
 		b.builder.CreateStore(funPtr, b.builder.CreateStructGEP(closureVal, 0, ""))
 
-		capturesVal := b.buildMalloc(capturesTy, fmt.Sprintf("captures.%s", val.Fun))
+		var capturesVal llvm.Value
+		capturesName := fmt.Sprintf("captures.%s", val.Fun)
+		if val.OnStack {
+			// Escape analysis proved that the closure is never used after returning from the function
+			capturesVal = b.buildAlloca(capturesTy, capturesName)
+		} else {
+			capturesVal = b.buildMalloc(capturesTy, capturesName)
+		}
 		for i, v := range val.Vars {
 			ptr := b.builder.CreateStructGEP(capturesVal, i, "")
 			freevar := b.resolve(v)
@@ -560,58 +660,13 @@ func (b *blockBuilder) buildVal(ident string, val gcil.Val) llvm.Value {
 		if !ok {
 			panic("Type of Some is not an option type: " + b.typeOf(ident).String())
 		}
-
-		switch ty.Elem.(type) {
-		case *typing.Int, *typing.Bool:
-			tyVal := b.typeBuilder.buildOption(ty)
-			// Extend 1 bit for flag
-			extended := b.builder.CreateZExt(elemVal, tyVal, "")
-			// Lowest bit is a flag. So shift left by 1 bit
-			shifted := b.builder.CreateShl(extended, llvm.ConstInt(tyVal, 1, false /*signed*/), "")
-			// Set flag to 1
-			return b.builder.CreateOr(shifted, llvm.ConstInt(tyVal, 1, false /*signed*/), "")
-		case *typing.Float:
-			// Similar to Int or Bool cases, but bitcast is required
-			tyVal := b.typeBuilder.buildOption(ty)
-			casted := b.builder.CreateBitCast(elemVal, llvm.Int64Type(), "")
-			extended := b.builder.CreateZExt(casted, tyVal, "")
-			shifted := b.builder.CreateShl(extended, llvm.ConstInt(tyVal, 1, false /*signed*/), "")
-			return b.builder.CreateOr(shifted, llvm.ConstInt(tyVal, 1, false /*signed*/), "")
-		case *typing.String, *typing.Fun, *typing.Array, *typing.Tuple:
-			// They use NULL pointer for 'None' value. So nothing to do to make 'Some' value.
-			return elemVal
-		case *typing.Option, *typing.Unit:
-			v := llvm.Undef(b.typeBuilder.buildOption(ty))
-			v = b.builder.CreateInsertValue(v, llvm.ConstInt(b.typeBuilder.boolT, 1, false), 0, "some.flag")
-			v = b.builder.CreateInsertValue(v, elemVal, 1, "some.elem")
-			return v
-		default:
-			panic("unreachable")
-		}
+		return b.buildSome(elemVal, ty)
 	case *gcil.None:
 		ty, ok := b.typeOf(ident).(*typing.Option)
 		if !ok {
 			panic("Type of None is not an option type: " + b.typeOf(ident).String())
 		}
-
-		tyVal := b.typeBuilder.buildOption(ty)
-		switch ty.Elem.(type) {
-		case *typing.Int, *typing.Bool, *typing.Float:
-			return llvm.ConstInt(tyVal, 0, false)
-		case *typing.String, *typing.Fun, *typing.Array:
-			v := llvm.Undef(tyVal)
-			null := llvm.ConstPointerNull(tyVal.StructElementTypes()[0])
-			v = b.builder.CreateInsertValue(v, null, 0, "none.flag")
-			return v
-		case *typing.Tuple:
-			return llvm.ConstPointerNull(tyVal)
-		case *typing.Option, *typing.Unit:
-			v := llvm.Undef(b.typeBuilder.buildOption(ty))
-			v = b.builder.CreateInsertValue(v, llvm.ConstInt(b.typeBuilder.boolT, 0, false), 0, "none.flag")
-			return v
-		default:
-			panic("unreachable")
-		}
+		return b.buildNone(ty)
 	case *gcil.IsSome:
 		optVal := b.resolve(val.OptVal)
 		ty, ok := b.typeOf(val.OptVal).(*typing.Option)
@@ -639,10 +694,21 @@ func (b *blockBuilder) buildInsn(insn *gcil.Insn) llvm.Value {
 	}
 	v := b.buildVal(insn.Ident, insn.Val)
 	b.registers[insn.Ident] = v
+	if b.debug != nil {
+		if _, ok := insn.Val.(*gcil.MakeCls); ok {
+			b.debug.enterLexicalBlock(insn.Pos)
+		}
+		b.debug.declareLocal(b.builder, insn.Ident, b.typeOf(insn.Ident), v, insn.Pos)
+	}
 	return v
 }
 
 func (b *blockBuilder) buildBlock(block *gcil.Block) llvm.Value {
+	if b.debug != nil {
+		// Lexical blocks opened in the block are closed at the end of the block
+		scope := b.debug.scope
+		defer func() { b.debug.scope = scope }()
+	}
 	i := block.Top.Next
 	for {
 		v := b.buildInsn(i)
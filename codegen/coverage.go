@@ -0,0 +1,139 @@
+package codegen
+
+import (
+	"github.com/rhysd/gocaml/closure"
+	"github.com/rhysd/gocaml/coverage"
+	"github.com/rhysd/gocaml/gcil"
+	"github.com/rhysd/loc"
+	"llvm.org/llvm/bindings/go/llvm"
+	"path/filepath"
+	"strings"
+)
+
+// Coverage instrumentation. Counters are put in a global array and incremented at the entry of each
+// toplevel function and at the beginning of each branch of 'if'. The runtime dumps the counters with
+// the descriptors of their points to the profile file on exit. Functions specialized by
+// closure.Specialize share counters with their original functions because they are the same code
+// in source.
+type coverageBuilder struct {
+	source   string
+	points   []coverage.Point
+	indices  map[coverage.Point]int // Indices of counters for points
+	funcs    map[string]int         // Indices of counters for function entries
+	branches map[*gcil.Block]int    // Indices of counters for branches of 'if'
+	counters llvm.Value
+}
+
+func newCoverageBuilder(src *loc.Source) *coverageBuilder {
+	path := src.Path
+	if src.Exists {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	return &coverageBuilder{path, nil, map[coverage.Point]int{}, map[string]int{}, map[*gcil.Block]int{}, llvm.Value{}}
+}
+
+// Adds the point and returns the index of its counter. When the same point was already added, its
+// counter is shared.
+func (c *coverageBuilder) add(p coverage.Point) int {
+	if i, ok := c.indices[p]; ok {
+		return i
+	}
+	c.points = append(c.points, p)
+	i := len(c.points) - 1
+	c.indices[p] = i
+	return i
+}
+
+// Position of branch is the position of the first instruction in the branch. When the instruction
+// has no position, it falls back to the position of 'if'.
+func (c *coverageBuilder) branch(kind coverage.PointKind, block *gcil.Block, ifPos loc.Pos) {
+	pos := block.Top.Next.Pos
+	if pos.Line == 0 {
+		pos = ifPos
+	}
+	c.branches[block] = c.add(coverage.Point{kind, pos.Line, pos.Column, ""})
+}
+
+func (c *coverageBuilder) collectBlock(block *gcil.Block) {
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		if val, ok := i.Val.(*gcil.If); ok {
+			c.branch(coverage.THEN, val.Then, i.Pos)
+			c.collectBlock(val.Then)
+			c.branch(coverage.ELSE, val.Else, i.Pos)
+			c.collectBlock(val.Else)
+		}
+	}
+}
+
+// Collects all points of counters in the program. Toplevel functions are visited in order of their
+// names to make indices of counters deterministic. Specialized functions are counted as their
+// original functions.
+func (c *coverageBuilder) collect(prog *gcil.Program) {
+	for _, n := range prog.Toplevel.SortedNames() {
+		f := prog.Toplevel[n]
+		c.funcs[n] = c.add(coverage.Point{coverage.FUNC, f.Pos.Line, f.Pos.Column, closure.OriginalName(n)})
+		c.collectBlock(f.Val.Body)
+	}
+	c.collectBlock(prog.Entry)
+}
+
+func (b *moduleBuilder) buildConstCString(s, name string) llvm.Value {
+	init := b.context.ConstString(s, true /*add null*/)
+	v := llvm.AddGlobal(b.module, init.Type(), name)
+	v.SetInitializer(init)
+	v.SetGlobalConstant(true)
+	v.SetLinkage(llvm.PrivateLinkage)
+	zero := llvm.ConstInt(b.context.Int32Type(), 0, false)
+	return llvm.ConstInBoundsGEP(v, []llvm.Value{zero, zero})
+}
+
+// Declares the global array of counters and the runtime function to register them.
+func (b *moduleBuilder) buildCoverageDecls(prog *gcil.Program) {
+	b.coverage.collect(prog)
+
+	i64T := b.context.Int64Type()
+	arrT := llvm.ArrayType(i64T, len(b.coverage.points))
+	v := llvm.AddGlobal(b.module, arrT, "__gocaml_coverage_counters")
+	v.SetInitializer(llvm.ConstNull(arrT))
+	v.SetLinkage(llvm.PrivateLinkage)
+	b.coverage.counters = v
+
+	charPtrT := llvm.PointerType(b.context.Int8Type(), 0 /*address space*/)
+	params := []llvm.Type{charPtrT, charPtrT, llvm.PointerType(i64T, 0 /*address space*/), i64T}
+	t := llvm.FunctionType(b.typeBuilder.voidT, params, false /*varargs*/)
+	f := llvm.AddFunction(b.module, "__gocaml_coverage_init", t)
+	f.SetLinkage(llvm.ExternalLinkage)
+	f.AddFunctionAttr(b.attributes["nounwind"])
+	b.globalTable["__gocaml_coverage_init"] = f
+}
+
+// Registers counters to the runtime at the beginning of main. Descriptors of points are passed as
+// one string separated by newlines.
+func (b *moduleBuilder) buildCoverageInit() {
+	descs := make([]string, 0, len(b.coverage.points))
+	for _, p := range b.coverage.points {
+		descs = append(descs, p.String())
+	}
+	i64T := b.context.Int64Type()
+	zero := llvm.ConstInt(i64T, 0, false)
+	args := []llvm.Value{
+		b.buildConstCString(b.coverage.source, "__gocaml_coverage_source"),
+		b.buildConstCString(strings.Join(descs, "\n"), "__gocaml_coverage_points"),
+		llvm.ConstInBoundsGEP(b.coverage.counters, []llvm.Value{zero, zero}),
+		llvm.ConstInt(i64T, uint64(len(b.coverage.points)), false),
+	}
+	b.builder.CreateCall(b.globalTable["__gocaml_coverage_init"], args, "")
+}
+
+// Increments the counter at the current insertion point.
+func (b *moduleBuilder) buildCoverageCount(index int) {
+	i64T := b.context.Int64Type()
+	indices := []llvm.Value{llvm.ConstInt(i64T, 0, false), llvm.ConstInt(i64T, uint64(index), false)}
+	ptr := llvm.ConstInBoundsGEP(b.coverage.counters, indices)
+	count := b.builder.CreateLoad(ptr, "coverage.count")
+	inc := b.builder.CreateAdd(count, llvm.ConstInt(i64T, 1, false), "coverage.inc")
+	b.builder.CreateStore(inc, ptr)
+}
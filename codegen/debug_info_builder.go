@@ -1,6 +1,8 @@
 package codegen
 
 import (
+	"fmt"
+	"github.com/rhysd/gocaml/alpha"
 	"github.com/rhysd/gocaml/typing"
 	"github.com/rhysd/loc"
 	"llvm.org/llvm/bindings/go/llvm"
@@ -108,18 +110,21 @@ func newDebugInfoBuilder(module llvm.Module, file *loc.Source, tb *typeBuilder,
 		Name:        "captures",
 	})
 
+	// Note:
+	// Names of members are referred by pretty printers for debuggers. Please update them in
+	// pretty_printers.go and runtime/gocaml_lldb.py when changing.
 	d.stringInfo = d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
 		Name:        "string",
 		File:        d.file,
 		SizeInBits:  d.sizes.stringSize.allocInBits,
 		AlignInBits: d.sizes.stringSize.alignInBits,
 		Elements: []llvm.Metadata{
-			d.pointerOf(d.builder.CreateBasicType(llvm.DIBasicType{
+			d.memberInfo("chars", d.pointerOf(d.builder.CreateBasicType(llvm.DIBasicType{
 				Name:       "char",
 				SizeInBits: target.TypeSizeInBits(tb.context.Int8Type()),
-				Encoding:   llvm.DW_ATE_signed,
-			}), "chars"),
-			d.basicTypeInfo(typing.IntType, llvm.DW_ATE_signed),
+				Encoding:   llvm.DW_ATE_signed_char,
+			}), ""), tb.stringT, 0),
+			d.memberInfo("size", d.basicTypeInfo(typing.IntType, llvm.DW_ATE_signed), tb.stringT, 1),
 		},
 	})
 
@@ -134,7 +139,21 @@ func (d *debugInfoBuilder) basicTypeInfo(ty typing.Type, enc llvm.DwarfTypeEncod
 	})
 }
 
+// Describes the index-th element of the LLVM struct type as a named member.
+func (d *debugInfoBuilder) memberInfo(name string, info llvm.Metadata, structTy llvm.Type, index int) llvm.Metadata {
+	elemTy := structTy.StructElementTypes()[index]
+	return d.builder.CreateMemberType(d.file, llvm.DIMemberType{
+		Name:         name,
+		File:         d.file,
+		SizeInBits:   d.sizes.data.TypeSizeInBits(elemTy),
+		AlignInBits:  uint32(d.sizes.data.ABITypeAlignment(elemTy) * 8),
+		OffsetInBits: d.sizes.data.ElementOffset(structTy, index) * 8,
+		Type:         info,
+	})
+}
+
 func (d *debugInfoBuilder) closureTypeInfo(ty *typing.Fun) llvm.Metadata {
+	structTy := d.typeBuilder.buildClosure(ty)
 	funPtr := d.pointerOf(d.funcTypeInfo(ty, true), "")
 	size := d.sizes.sizeOf(ty)
 	return d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
@@ -142,7 +161,10 @@ func (d *debugInfoBuilder) closureTypeInfo(ty *typing.Fun) llvm.Metadata {
 		File:        d.file,
 		SizeInBits:  size.allocInBits,
 		AlignInBits: size.alignInBits,
-		Elements:    []llvm.Metadata{funPtr, d.voidPtrInfo},
+		Elements: []llvm.Metadata{
+			d.memberInfo("fun", funPtr, structTy, 0),
+			d.memberInfo("captures", d.voidPtrInfo, structTy, 1),
+		},
 	})
 }
 
@@ -185,8 +207,12 @@ func (d *debugInfoBuilder) typeInfo(ty typing.Type) llvm.Metadata {
 		return d.basicTypeInfo(ty, llvm.DW_ATE_boolean)
 	case *typing.Float:
 		return d.basicTypeInfo(ty, llvm.DW_ATE_float)
+	case *typing.Char:
+		return d.basicTypeInfo(ty, llvm.DW_ATE_unsigned_char)
 	case *typing.String:
 		return d.stringInfo
+	case *typing.Opaque, *typing.Hashtbl:
+		return d.voidPtrInfo
 	case *typing.Unit:
 		size := d.sizes.sizeOf(ty)
 		return d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
@@ -200,7 +226,11 @@ func (d *debugInfoBuilder) typeInfo(ty typing.Type) llvm.Metadata {
 		return d.closureTypeInfo(ty)
 	case *typing.Array:
 		size := d.sizes.sizeOf(ty)
-		elems := []llvm.Metadata{d.pointerOf(d.typeInfo(ty.Elem), ""), d.basicTypeInfo(typing.IntType, llvm.DW_ATE_signed)}
+		structTy := d.typeBuilder.convertGCIL(ty)
+		elems := []llvm.Metadata{
+			d.memberInfo("buf", d.pointerOf(d.typeInfo(ty.Elem), ""), structTy, 0),
+			d.memberInfo("size", d.basicTypeInfo(typing.IntType, llvm.DW_ATE_signed), structTy, 1),
+		}
 		return d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
 			Name:        ty.String(),
 			File:        d.file,
@@ -210,9 +240,10 @@ func (d *debugInfoBuilder) typeInfo(ty typing.Type) llvm.Metadata {
 		})
 	case *typing.Tuple:
 		size := d.sizes.sizeOf(ty)
+		structTy := d.typeBuilder.convertGCIL(ty).ElementType()
 		elems := make([]llvm.Metadata, 0, len(ty.Elems))
-		for _, e := range ty.Elems {
-			elems = append(elems, d.typeInfo(e))
+		for i, e := range ty.Elems {
+			elems = append(elems, d.memberInfo(fmt.Sprintf("_%d", i), d.typeInfo(e), structTy, i))
 		}
 		name := ty.String()
 		allocated := d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
@@ -224,33 +255,38 @@ func (d *debugInfoBuilder) typeInfo(ty typing.Type) llvm.Metadata {
 		})
 		return d.pointerOf(allocated, name)
 	case *typing.Option:
-		switch ty := ty.Elem.(type) {
-		case *typing.Int, *typing.Bool, *typing.Float:
-			return d.basicTypeInfo(ty, llvm.DW_ATE_unsigned)
-		case *typing.String, *typing.Fun, *typing.Array, *typing.Tuple:
-			return d.typeInfo(ty)
-		case *typing.Option, *typing.Unit:
-			size := d.sizes.sizeOf(ty)
-			elems := []llvm.Metadata{
-				d.basicTypeInfo(ty, llvm.DW_ATE_boolean),
-				d.typeInfo(ty),
-			}
-			name := ty.String()
-			return d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
-				Name:        name,
-				File:        d.file,
-				SizeInBits:  size.allocInBits,
-				AlignInBits: size.alignInBits,
-				Elements:    elems,
-			})
-		default:
-			panic("unreachable")
-		}
+		return d.optionTypeInfo(ty)
 	default:
 		panic("cannot handle debug info for type " + ty.String())
 	}
 }
 
+// Unboxed option value is described as a struct which has 'tag' and 'value' members.
+// Options of pointer-like values are described as the same as their payloads because 'None'
+// is represented with NULL.
+func (d *debugInfoBuilder) optionTypeInfo(ty *typing.Option) llvm.Metadata {
+	switch elem := ty.Elem.(type) {
+	case *typing.String, *typing.Fun, *typing.Array, *typing.Tuple, *typing.Opaque, *typing.Hashtbl:
+		return d.typeInfo(elem)
+	case *typing.Int, *typing.Bool, *typing.Float, *typing.Char, *typing.Option, *typing.Unit:
+		size := d.sizes.sizeOf(ty)
+		structTy := d.typeBuilder.buildOption(ty)
+		elems := []llvm.Metadata{
+			d.memberInfo("tag", d.basicTypeInfo(typing.BoolType, llvm.DW_ATE_boolean), structTy, 0),
+			d.memberInfo("value", d.typeInfo(elem), structTy, 1),
+		}
+		return d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
+			Name:        ty.String(),
+			File:        d.file,
+			SizeInBits:  size.allocInBits,
+			AlignInBits: size.alignInBits,
+			Elements:    elems,
+		})
+	default:
+		panic("unreachable")
+	}
+}
+
 func (d *debugInfoBuilder) setMainFuncInfo(mainfun llvm.Value, line int) {
 	voidInfo := d.builder.CreateBasicType(llvm.DIBasicType{Name: "void"})
 	info := d.builder.CreateSubroutineType(llvm.DISubroutineType{d.file, []llvm.Metadata{voidInfo}})
@@ -284,6 +320,79 @@ func (d *debugInfoBuilder) setFuncInfo(funptr llvm.Value, ty *typing.Fun, line i
 	d.scope = meta
 }
 
+// Closure captures are described as a struct whose members are named after the captured
+// variables. It is used for the type of the first parameter of closure function.
+func (d *debugInfoBuilder) capturesTypeInfo(name string, captures []string, types []typing.Type, capturesTy llvm.Type) llvm.Metadata {
+	members := make([]llvm.Metadata, 0, len(captures))
+	for i, c := range captures {
+		if n, ok := alpha.DisplayNameOf(c); ok {
+			c = n
+		}
+		members = append(members, d.memberInfo(c, d.typeInfo(types[i]), capturesTy, i))
+	}
+	captured := d.builder.CreateStructType(d.compileUnit, llvm.DIStructType{
+		Name:        name + ".captures",
+		File:        d.file,
+		SizeInBits:  d.sizes.data.TypeSizeInBits(capturesTy),
+		AlignInBits: uint32(d.sizes.data.ABITypeAlignment(capturesTy) * 8),
+		Elements:    members,
+	})
+	return d.pointerOf(captured, "")
+}
+
+// Nested 'let rec' opens a new lexical block. Since GCIL is flattened by K-normalization, the
+// block lasts until the end of the GCIL block which contains the 'let rec'.
+func (d *debugInfoBuilder) enterLexicalBlock(pos loc.Pos) {
+	d.scope = d.builder.CreateLexicalBlock(d.scope, llvm.DILexicalBlock{
+		File:   d.file,
+		Line:   pos.Line,
+		Column: pos.Column,
+	})
+}
+
+// Emits llvm.dbg.value for the variable at the end of current block. Variables are SSA values
+// in GoCaml, so llvm.dbg.declare with stack slot is not necessary.
+func (d *debugInfoBuilder) insertValue(b llvm.Builder, variable llvm.Metadata, val llvm.Value, pos loc.Pos) {
+	d.setLocation(b, pos)
+	expr := d.builder.CreateExpression(nil)
+	call := d.builder.InsertValueAtEnd(val, variable, expr, 0 /*offset*/, b.GetInsertBlock())
+	b.SetInstDebugLocation(call)
+}
+
+// Declares a variable bound by 'let' expression. Identifiers which are not from user-defined
+// symbols (temporary variables introduced by K-normalization) are ignored.
+func (d *debugInfoBuilder) declareLocal(b llvm.Builder, ident string, ty typing.Type, val llvm.Value, pos loc.Pos) {
+	name, ok := alpha.DisplayNameOf(ident)
+	if !ok {
+		return
+	}
+	if _, ok := ty.(*typing.Unit); ok {
+		return
+	}
+	variable := d.builder.CreateAutoVariable(d.scope, llvm.DIAutoVariable{
+		Name: name,
+		File: d.file,
+		Line: pos.Line,
+		Type: d.typeInfo(ty),
+	})
+	d.insertValue(b, variable, val, pos)
+}
+
+// Declares a parameter of function. argNo is 1-based index of the parameter in LLVM function.
+func (d *debugInfoBuilder) declareParam(b llvm.Builder, name string, info llvm.Metadata, val llvm.Value, argNo int, pos loc.Pos) {
+	if n, ok := alpha.DisplayNameOf(name); ok {
+		name = n
+	}
+	variable := d.builder.CreateParameterVariable(d.scope, llvm.DIParameterVariable{
+		Name:  name,
+		File:  d.file,
+		Line:  pos.Line,
+		Type:  info,
+		ArgNo: argNo,
+	})
+	d.insertValue(b, variable, val, pos)
+}
+
 func (d *debugInfoBuilder) setLocation(b llvm.Builder, pos loc.Pos) {
 	scope := d.scope
 	if scope.C == nil {
@@ -46,6 +46,12 @@ type EmitOptions struct {
 	LinkerFlags string
 	// Generate debug information or not. If true, debug information will be added and you can debug the generated executable with debugger like an LLDB.
 	DebugInfo bool
+	// Instrument counters for code coverage or not. If true, the generated executable dumps how many times each function and each branch were executed to the profile file on exit.
+	Coverage bool
+	// Emit frame pointers and readable symbol names of functions or not. If true, sampling profilers such as perf can walk call stacks and show functions by the names written in source.
+	Profiling bool
+	// Instrument call profiling or not. If true, the generated executable counts calls and cumulative time of each toplevel function and prints them as a table on exit.
+	ProfileCalls bool
 }
 
 // Emitter object to emit LLVM IR, object file, assembly or executable.
@@ -107,6 +113,18 @@ func (emitter *Emitter) RunOptimizationPasses() {
 	modPasses.Run(emitter.Module)
 }
 
+// Returns the number of functions defined in the module. Declarations of external functions are
+// not counted.
+func (emitter *Emitter) NumFunctions() int {
+	n := 0
+	for f := emitter.Module.FirstFunction(); !f.IsNil(); f = llvm.NextFunction(f) {
+		if !f.IsDeclaration() {
+			n++
+		}
+	}
+	return n
+}
+
 // Returns LLVM IR as string.
 func (emitter *Emitter) EmitLLVMIR() string {
 	return emitter.Module.String()
@@ -3,6 +3,7 @@ package codegen
 import (
 	"github.com/rhysd/gocaml/alpha"
 	"github.com/rhysd/gocaml/closure"
+	"github.com/rhysd/gocaml/escape"
 	"github.com/rhysd/gocaml/gcil"
 	"github.com/rhysd/gocaml/lexer"
 	"github.com/rhysd/gocaml/parser"
@@ -15,27 +16,40 @@ import (
 )
 
 func testCreateEmitter(code string, optimize OptLevel, debug bool) (e *Emitter, err error) {
-	s := loc.NewDummySource(code)
+	return testCreateEmitterWithOptions(code, EmitOptions{optimize, "", "", debug, false, false, false})
+}
+
+func testCompileProgram(s *loc.Source) (*gcil.Program, *typing.Env, error) {
 	l := lexer.NewLexer(s)
 	go l.Lex()
 	ast, err := parser.Parse(l.Tokens)
 	if err != nil {
-		return
+		return nil, nil, err
 	}
 	if err = alpha.Transform(ast.Root); err != nil {
-		return
+		return nil, nil, err
 	}
 	env, err := typing.TypeInferernce(ast)
 	if err != nil {
-		return
+		return nil, nil, err
 	}
 	ir, err := gcil.FromAST(ast.Root, env)
 	if err != nil {
-		return
+		return nil, nil, err
 	}
 	gcil.ElimRefs(ir, env)
 	prog := closure.Transform(ir)
-	opts := EmitOptions{optimize, "", "", debug}
+	closure.Specialize(prog, env)
+	escape.Analyze(prog)
+	return prog, env, nil
+}
+
+func testCreateEmitterWithOptions(code string, opts EmitOptions) (e *Emitter, err error) {
+	s := loc.NewDummySource(code)
+	prog, env, err := testCompileProgram(s)
+	if err != nil {
+		return
+	}
 	e, err = NewEmitter(prog, env, s, opts)
 	if err != nil {
 		return
@@ -139,6 +153,90 @@ func TestEmitLLVMIRWithDebugInfo(t *testing.T) {
 	}
 }
 
+func TestDebugInfoOfVariables(t *testing.T) {
+	code := `
+	let rec f x =
+		let y = x * 2 in
+		let rec g z = z + y in
+		g x
+	in
+	let a = f 21 in
+	println_int a
+	`
+	e, err := testCreateEmitter(code, OptimizeNone, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	expects := []string{
+		"call void @llvm.dbg.value(",
+		`!DILocalVariable(name: "x", arg: 1`,
+		`!DILocalVariable(name: "z", arg: 2`,
+		`!DILocalVariable(name: "closure", arg: 1`,
+		`!DILocalVariable(name: "y"`,
+		`!DILocalVariable(name: "a"`,
+		`!DIDerivedType(tag: DW_TAG_member, name: "y"`,
+		"!DILexicalBlock(",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(ir, expect) {
+			t.Errorf("IR does not contain '%s': %s", expect, ir)
+		}
+	}
+	for _, unexpected := range []string{`name: "y$t`, `name: "$k`} {
+		if strings.Contains(ir, unexpected) {
+			t.Errorf("Alpha-renamed or temporary name '%s' should not be in debug info: %s", unexpected, ir)
+		}
+	}
+}
+
+func TestEmbedGDBPrettyPrinters(t *testing.T) {
+	code := "let t = (1, \"foo\") in let a = Array.make 3 (Some 1.0) in println_int (Array.length a)"
+	e, err := testCreateEmitter(code, OptimizeDefault, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	expects := []string{
+		`section ".debug_gdb_scripts"`,
+		`@llvm.used = appending global`,
+		`\04gocaml-pretty-printers\0A`,
+		`!DIDerivedType(tag: DW_TAG_member, name: "chars"`,
+		`!DIDerivedType(tag: DW_TAG_member, name: "buf"`,
+		`!DIDerivedType(tag: DW_TAG_member, name: "_1"`,
+	}
+	for _, expect := range expects {
+		if !strings.Contains(ir, expect) {
+			t.Errorf("IR does not contain '%s': %s", expect, ir)
+		}
+	}
+
+	e, err = testCreateEmitter(code, OptimizeDefault, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir = e.EmitLLVMIR()
+	if strings.Contains(ir, ".debug_gdb_scripts") {
+		t.Errorf("Pretty printers should not be embedded without debug information: %s", ir)
+	}
+}
+
+func TestGDBScriptsSectionSupport(t *testing.T) {
+	for triple, expected := range map[string]bool{
+		"x86_64-unknown-linux-gnu": true,
+		"aarch64-unknown-freebsd":  true,
+		"x86_64-apple-darwin17.0":  false,
+		"x86_64-pc-windows-msvc":   false,
+	} {
+		if actual := supportsGDBScriptsSection(triple); actual != expected {
+			t.Errorf("Support of .debug_gdb_scripts for '%s' should be %v but actually %v", triple, expected, actual)
+		}
+	}
+}
+
 func TestEmitOptimizedAggressive(t *testing.T) {
 	e, err := testCreateEmitter("let rec f x = x + x in println_int (f 42)", OptimizeAggressive, false)
 	if err != nil {
@@ -170,6 +268,43 @@ func TestEmitIRContainingExternalSymbols(t *testing.T) {
 	}
 }
 
+func TestRenamedExternalSymbols(t *testing.T) {
+	e, err := testCreateEmitter(`output_string stdout "foo"; output_string stderr (String.trim " bar ")`, OptimizeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	expects := []string{
+		"@gocaml_stdout = external global i8*",
+		"@gocaml_stderr = external global i8*",
+		"@String_trim(",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(ir, expect) {
+			t.Errorf("IR does not contain renamed external symbol '%s': %s", expect, ir)
+		}
+	}
+}
+
+func TestExitNeverReturns(t *testing.T) {
+	e, err := testCreateEmitter(`let rec f x = if x < 0 then exit 1 else x in println_int (f 42)`, OptimizeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	expects := []string{
+		"declare void @exit(i32)",
+		"call void @exit(i32",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(ir, expect) {
+			t.Errorf("IR does not contain '%s': %s", expect, ir)
+		}
+	}
+}
+
 func TestDisposeEmitter(t *testing.T) {
 	e, err := testCreateEmitter("x; y; f (x + y); g (x < y)", OptimizeDefault, true)
 	if err != nil {
@@ -185,3 +320,236 @@ func TestDisposeEmitter(t *testing.T) {
 	// Do not crash when it's called twice
 	e.Dispose()
 }
+
+func TestUnboxedOptionValues(t *testing.T) {
+	code := `
+	let rec f x = if x < 0.0 then None else Some x in
+	let rec g i = if i = 0 then None else Some (i = 1) in
+	match f 3.14 with
+		| Some v -> println_float v
+		| None -> println_bool ((g 1) = (Some true))
+	`
+	e, err := testCreateEmitter(code, OptimizeNone, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	expects := []string{
+		"{ i1, double }",
+		"{ i1, i1 }",
+		`name: "tag"`,
+		`name: "value"`,
+	}
+	for _, expect := range expects {
+		if !strings.Contains(ir, expect) {
+			t.Errorf("IR does not contain '%s': %s", expect, ir)
+		}
+	}
+	// Payloads are not packed into wider integers with a flag bit (e.g. i65 for float and i2 for
+	// bool). So no shift, truncation or bitcast is needed to make or extract them
+	unexpects := []string{
+		"i65",
+		"i2 ",
+		"bitcast double",
+		"lshr",
+	}
+	for _, unexpect := range unexpects {
+		if strings.Contains(ir, unexpect) {
+			t.Errorf("IR should not contain '%s': %s", unexpect, ir)
+		}
+	}
+}
+
+func TestMathIntrinsics(t *testing.T) {
+	code := `
+	let x = sqrt (abs_float (pow 2.0 3.0)) in
+	println_bool (is_nan (floor x +. ceil x +. infinity +. nan))
+	`
+	e, err := testCreateEmitter(code, OptimizeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	expects := []string{
+		"call double @llvm.sqrt.f64(double",
+		"call double @llvm.fabs.f64(double",
+		"call double @llvm.pow.f64(double",
+		"call double @llvm.floor.f64(double",
+		"call double @llvm.ceil.f64(double",
+		"fcmp uno double",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(ir, expect) {
+			t.Errorf("IR does not contain '%s': %s", expect, ir)
+		}
+	}
+	for _, unexpected := range []string{"@sqrt(", "@infinity = external", "@nan = external"} {
+		if strings.Contains(ir, unexpected) {
+			t.Errorf("Math built-in should not be external symbol '%s': %s", unexpected, ir)
+		}
+	}
+}
+
+func TestCoverageInstrumentation(t *testing.T) {
+	code := `
+let rec f x = if x < 0 then -x else x in
+let rec g x = x + 1 in
+println_int (f 42)
+`
+	e, err := testCreateEmitterWithOptions(code, EmitOptions{OptimizeNone, "", "", false, true, false, false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	for _, expected := range []string{
+		"@__gocaml_coverage_counters = private global [4 x i64] zeroinitializer",
+		`c"<dummy>\00"`,
+		`c"func 2:1 f$t1\0Athen 2:29\0Aelse 2:37\0Afunc 3:1 g$t3\00"`,
+		"call void @__gocaml_coverage_init(",
+		"coverage.inc",
+	} {
+		if !strings.Contains(ir, expected) {
+			t.Errorf("IR should contain '%s' but it does not: %s", expected, ir)
+		}
+	}
+
+	e, err = testCreateEmitter(code, OptimizeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	if ir := e.EmitLLVMIR(); strings.Contains(ir, "coverage") {
+		t.Fatalf("Counters should not be instrumented without coverage option: %s", ir)
+	}
+}
+
+func TestCoverageOfSpecializedFunction(t *testing.T) {
+	code := `
+let rec apply f x = if x < 0 then f (-x) else f x in
+let rec inc x = x + 1 in
+let rec dec x = x - 1 in
+println_int (apply inc 1);
+println_int (apply dec 2)
+`
+	prog, _, err := testCompileProgram(loc.NewDummySource(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := prog.Toplevel["apply$t1[inc$t4]"]; !ok {
+		t.Fatalf("'apply' should be specialized with 'inc': %v", prog.Toplevel.SortedNames())
+	}
+
+	c := newCoverageBuilder(loc.NewDummySource(code))
+	c.collect(prog)
+	actual := []string{}
+	for _, p := range c.points {
+		actual = append(actual, p.String())
+	}
+	expected := []string{
+		"func 2:1 apply$t1",
+		"then 2:38",
+		"else 2:47",
+		"func 4:1 dec$t6",
+		"func 3:1 inc$t4",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Specialized functions should share counters with original. Want %v but got %v", expected, actual)
+	}
+	for n := range prog.Toplevel {
+		if strings.HasPrefix(n, "apply$t1") && c.funcs[n] != 0 {
+			t.Errorf("Counter of '%s' should be the counter of 'apply$t1' but got %d", n, c.funcs[n])
+		}
+	}
+}
+
+func TestDemangleFuncName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{"f$t1", "f"},
+		{"fib$t12", "fib"},
+		{"lambda.line3.col9$t4", "lambda:3:9"},
+		{"apply$t3[f$t1]", "apply[f]"},
+		{"lambda.line1.col5$t2[g$t1]", "lambda:1:5[g]"},
+		{"$k8", "$k8"},
+		{"foo", "foo"},
+	} {
+		if actual := demangleFuncName(tc.name); actual != tc.expected {
+			t.Errorf("Demangled name of '%s' should be '%s' but got '%s'", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestProfilingSymbols(t *testing.T) {
+	code := `
+let rec f x = x + 1 in
+let rec g x =
+  let rec f y = y * 2 in
+  f x in
+let h = fun x -> x - 1 in
+println_int (f (g (h 1)))
+`
+	e, err := testCreateEmitterWithOptions(code, EmitOptions{OptimizeNone, "", "", false, false, true, false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	for _, expected := range []string{
+		`define internal i64 @"f:2:1"(`,
+		`define internal i64 @"f:4:3"(`,
+		`define internal i64 @g(`,
+		`@"lambda:6:9"(`,
+		`"no-frame-pointer-elim"="true"`,
+	} {
+		if !strings.Contains(ir, expected) {
+			t.Errorf("IR should contain '%s' but it does not: %s", expected, ir)
+		}
+	}
+	for _, mangled := range []string{"@f$t", "@g$t", "@lambda.line"} {
+		if strings.Contains(ir, mangled) {
+			t.Errorf("Symbol mangled by alpha transform '%s' should not remain: %s", mangled, ir)
+		}
+	}
+
+	e, err = testCreateEmitter(code, OptimizeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir = e.EmitLLVMIR()
+	if !strings.Contains(ir, "define private i64 @f$t1(") || strings.Contains(ir, "no-frame-pointer-elim") {
+		t.Fatalf("Symbols should not be changed without profiling option: %s", ir)
+	}
+}
+
+func TestCallProfileInstrumentation(t *testing.T) {
+	code := `
+let rec f x = x + 1 in
+let rec g x = f x in
+println_int (g 42)
+`
+	e, err := testCreateEmitterWithOptions(code, EmitOptions{OptimizeNone, "", "", false, false, false, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	ir := e.EmitLLVMIR()
+	for _, expected := range []string{
+		`c"f\0Ag\00"`,
+		"call void @__gocaml_profile_init(",
+		"%profile.start = call i64 @__gocaml_profile_enter(i64 0)",
+		"call void @__gocaml_profile_leave(i64 0, i64 %profile.start)",
+		"call i64 @__gocaml_profile_enter(i64 1)",
+		// Symbols are not changed without profiling option
+		"define private i64 @f$t1(",
+	} {
+		if !strings.Contains(ir, expected) {
+			t.Errorf("IR should contain '%s' but it does not: %s", expected, ir)
+		}
+	}
+}
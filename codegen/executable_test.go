@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/rhysd/gocaml/alpha"
 	"github.com/rhysd/gocaml/closure"
+	"github.com/rhysd/gocaml/coverage"
+	"github.com/rhysd/gocaml/escape"
 	"github.com/rhysd/gocaml/gcil"
 	"github.com/rhysd/gocaml/lexer"
 	"github.com/rhysd/gocaml/parser"
@@ -14,6 +16,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -78,8 +81,10 @@ func TestExecutable(t *testing.T) {
 			}
 			gcil.ElimRefs(ir, env)
 			prog := closure.Transform(ir)
+			closure.Specialize(prog, env)
+			escape.Analyze(prog)
 
-			opts := EmitOptions{OptimizeDefault, "", "", true}
+			opts := EmitOptions{OptimizeDefault, "", "", true, false, false, false}
 			emitter, err := NewEmitter(prog, env, s, opts)
 			if err != nil {
 				t.Fatal(err)
@@ -115,6 +120,185 @@ func TestExecutable(t *testing.T) {
 	}
 }
 
+func TestCoverageProfile(t *testing.T) {
+	code := `let rec absolute x = if x < 0 then -x else x in
+let rec unused x = x + 1 in
+println_int (absolute (-1));
+println_int (absolute (-2));
+println_int (absolute 3)`
+	e, err := testCreateEmitterWithOptions(code, EmitOptions{OptimizeDefault, "", "", false, true, false, false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	outfile, err := filepath.Abs("test.coverage.a.out")
+	if err != nil {
+		panic(err)
+	}
+	if err := e.EmitExecutable(outfile); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outfile)
+
+	profile, err := filepath.Abs("test.coverage.cover")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(profile)
+	// Run twice to check that counts of runs are accumulated
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command(outfile)
+		cmd.Env = append(os.Environ(), "GOCAML_COVERAGE_FILE="+profile)
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	prof, err := coverage.ParseProfile(f, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prof.Files) != 1 || prof.Files[0].Source != "<dummy>" {
+		t.Fatalf("Unexpected files in profile: %v", prof.Files)
+	}
+
+	actual := []string{}
+	for _, c := range prof.Files[0].Counters {
+		actual = append(actual, fmt.Sprintf("%s %d", c.Point.String(), c.Count))
+	}
+	expected := []string{
+		"func 1:1 absolute$t1 6",
+		"then 1:36 4",
+		"else 1:44 2",
+		"func 2:1 unused$t3 0",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected counters. Want %v but got %v", expected, actual)
+	}
+}
+
+func TestCallProfile(t *testing.T) {
+	code := `let rec fib n = if n < 2 then n else fib (n - 1) + fib (n - 2) in
+let rec unused x = x + 1 in
+println_int (fib 10)`
+	e, err := testCreateEmitterWithOptions(code, EmitOptions{OptimizeDefault, "", "", false, false, true, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Dispose()
+	outfile, err := filepath.Abs("test.profile.a.out")
+	if err != nil {
+		panic(err)
+	}
+	if err := e.EmitExecutable(outfile); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outfile)
+
+	profile, err := filepath.Abs("test.profile.txt")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(profile)
+	cmd := exec.Command(outfile)
+	cmd.Env = append(os.Environ(), "GOCAML_PROFILE_FILE="+profile)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "55\n" {
+		t.Fatalf("Unexpected output: %q", out)
+	}
+
+	table, err := ioutil.ReadFile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(table)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Table should have a header and one row for 'fib' but got:\n%s", table)
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "calls total (ms) per call (us) function" {
+		t.Fatalf("Unexpected header: %s", lines[0])
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) != 4 || fields[0] != "177" || fields[3] != "fib" {
+		t.Fatalf("Unexpected row for 'fib': %s", lines[1])
+	}
+}
+
+func TestRuntimeFailureExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		what   string
+		code   string
+		status int
+		stderr string
+	}{
+		{
+			"stack overflow",
+			`println_str "before"; let rec f n = let r = f (n + 1) in println_int r; r in println_int (f 0)`,
+			4, // EXIT_INVALID_MEMORY_ACCESS in runtime
+			"Runtime error: Invalid memory access\n",
+		},
+		{
+			"division by zero",
+			`println_str "before"; println_int (42 / (Array.length argv - 1))`,
+			3, // EXIT_ARITHMETIC_ERROR in runtime
+			"Runtime error: Arithmetic error (e.g. division by zero)\n",
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			e, err := testCreateEmitterWithOptions(tc.code, EmitOptions{OptimizeDefault, "", "", false, true, false, false})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Dispose()
+			outfile, err := filepath.Abs("test.failure.a.out")
+			if err != nil {
+				panic(err)
+			}
+			if err := e.EmitExecutable(outfile); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outfile)
+			profile, err := filepath.Abs("test.failure.cover")
+			if err != nil {
+				panic(err)
+			}
+			defer os.Remove(profile)
+
+			var stdout, stderr strings.Builder
+			cmd := exec.Command(outfile)
+			cmd.Env = append(os.Environ(), "GOCAML_COVERAGE_FILE="+profile)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			err = cmd.Run()
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok {
+				t.Fatalf("Executable should exit with failure but got %v", err)
+			}
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); !ok || status.Signaled() || status.ExitStatus() != tc.status {
+				t.Fatalf("Exit code should be %d but got %v", tc.status, exitErr)
+			}
+			if out := stdout.String(); out != "before\n" {
+				t.Fatalf("Output before the failure should be flushed but got %q", out)
+			}
+			if msg := stderr.String(); msg != tc.stderr {
+				t.Fatalf("Unexpected error message: %q", msg)
+			}
+			// Coverage profile is not dumped on runtime failure
+			if _, err := os.Stat(profile); err == nil {
+				t.Fatalf("Coverage profile should not be written on runtime failure")
+			}
+		})
+	}
+}
+
 func BenchmarkExecutableCreation(b *testing.B) {
 	inputs, err := filepath.Glob("testdata/*.ml")
 	if err != nil {
@@ -157,8 +341,10 @@ func BenchmarkExecutableCreation(b *testing.B) {
 		}
 		gcil.ElimRefs(ir, env)
 		prog := closure.Transform(ir)
+		closure.Specialize(prog, env)
+		escape.Analyze(prog)
 
-		opts := EmitOptions{OptimizeDefault, "", "", true}
+		opts := EmitOptions{OptimizeDefault, "", "", true, false, false, false}
 		emitter, err := NewEmitter(prog, env, source, opts)
 		if err != nil {
 			b.Fatal(err)
@@ -190,3 +376,30 @@ func BenchmarkExecutableCreation(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkOptionValues(b *testing.B) {
+	code, err := ioutil.ReadFile(filepath.FromSlash("testdata/bench/float_option.ml"))
+	if err != nil {
+		panic(err)
+	}
+	e, err := testCreateEmitter(string(code), OptimizeDefault, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer e.Dispose()
+	outfile, err := filepath.Abs("test.float_option.a.out")
+	if err != nil {
+		panic(err)
+	}
+	if err := e.EmitExecutable(outfile); err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(outfile)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := exec.Command(outfile).Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
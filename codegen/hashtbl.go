@@ -0,0 +1,249 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/gcil"
+	"github.com/rhysd/gocaml/typing"
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// Hash tables are implemented in runtime. Runtime does not know types of keys and values. So they
+// are boxed and passed as 'i8*'. Hash function and equality function for the key type are
+// generated by compiler and passed to runtime on creating a hash table.
+//
+//   hash function : i64 (i8* key)
+//   equality      : i64 (i8* lhs, i8* rhs)
+//   iter callback : void (i8* closure, i8* key, i8* value)
+
+func (b *moduleBuilder) hashtblHashFunT() llvm.Type {
+	return llvm.FunctionType(b.typeBuilder.intT, []llvm.Type{b.typeBuilder.voidPtrT}, false /*varargs*/)
+}
+
+func (b *moduleBuilder) hashtblEqFunT() llvm.Type {
+	ptr := b.typeBuilder.voidPtrT
+	return llvm.FunctionType(b.typeBuilder.intT, []llvm.Type{ptr, ptr}, false /*varargs*/)
+}
+
+func (b *moduleBuilder) hashtblIterFunT() llvm.Type {
+	ptr := b.typeBuilder.voidPtrT
+	return llvm.FunctionType(b.typeBuilder.voidT, []llvm.Type{ptr, ptr, ptr}, false /*varargs*/)
+}
+
+func (b *moduleBuilder) buildHashtblFuncDecls() {
+	ptr := b.typeBuilder.voidPtrT
+	void := b.typeBuilder.voidT
+	decls := []struct {
+		name   string
+		ret    llvm.Type
+		params []llvm.Type
+	}{
+		{"__hashtbl_create", ptr, []llvm.Type{
+			b.typeBuilder.intT,
+			llvm.PointerType(b.hashtblHashFunT(), 0 /*address space*/),
+			llvm.PointerType(b.hashtblEqFunT(), 0 /*address space*/),
+		}},
+		{"__hashtbl_add", void, []llvm.Type{ptr, ptr, ptr}},
+		{"__hashtbl_replace", void, []llvm.Type{ptr, ptr, ptr}},
+		{"__hashtbl_find", ptr, []llvm.Type{ptr, ptr}},
+		{"__hashtbl_remove", void, []llvm.Type{ptr, ptr}},
+		{"__hashtbl_mem", b.typeBuilder.boolT, []llvm.Type{ptr, ptr}},
+		{"__hashtbl_length", b.typeBuilder.intT, []llvm.Type{ptr}},
+		{"__hashtbl_iter", void, []llvm.Type{
+			ptr,
+			llvm.PointerType(b.hashtblIterFunT(), 0 /*address space*/),
+			ptr,
+		}},
+	}
+	for _, d := range decls {
+		t := llvm.FunctionType(d.ret, d.params, false /*varargs*/)
+		v := llvm.AddFunction(b.module, d.name, t)
+		v.SetLinkage(llvm.ExternalLinkage)
+		b.globalTable[d.name] = v
+	}
+}
+
+// Builds private helper function for hash table. 'build' emits its body with a new block builder.
+// Insertion point and debug location of the builder are restored after building the helper.
+func (b *moduleBuilder) buildHashtblHelper(name string, ty llvm.Type, build func(*blockBuilder, llvm.Value)) llvm.Value {
+	if f, ok := b.funcTable[name]; ok {
+		return f
+	}
+
+	f := llvm.AddFunction(b.module, name, ty)
+	f.SetLinkage(llvm.PrivateLinkage)
+	f.AddFunctionAttr(b.attributes["nounwind"])
+	b.funcTable[name] = f
+
+	saved := b.builder.GetInsertBlock()
+	savedLoc := b.builder.GetCurrentDebugLocation()
+	if b.debug != nil {
+		b.debug.clearLocation(b.builder)
+	}
+
+	allocaBlock := b.context.AddBasicBlock(f, "entry")
+	body := b.context.AddBasicBlock(f, "body")
+	b.builder.SetInsertPointAtEnd(body)
+	build(newBlockBuilder(b, allocaBlock), f)
+	b.builder.SetInsertPointAtEnd(allocaBlock)
+	b.builder.CreateBr(body)
+
+	b.builder.SetInsertPointAtEnd(saved)
+	if b.debug != nil {
+		b.builder.SetCurrentDebugLocation(savedLoc.Line, savedLoc.Col, savedLoc.Scope, savedLoc.InlinedAt)
+	}
+	return f
+}
+
+func (b *blockBuilder) callRuntime(name string, args ...llvm.Value) llvm.Value {
+	f, ok := b.globalTable[name]
+	if !ok {
+		panic("Runtime function not found: " + name)
+	}
+	return b.builder.CreateCall(f, args, "")
+}
+
+// Builds hash value of the key. Hash values of tuple elements are combined.
+func (b *blockBuilder) buildHash(ty typing.Type, val llvm.Value) llvm.Value {
+	switch ty := ty.(type) {
+	case *typing.Unit:
+		return llvm.ConstInt(b.typeBuilder.intT, 0, false /*sign extend*/)
+	case *typing.Bool, *typing.Char:
+		return b.callRuntime("__hash_int", b.builder.CreateZExt(val, b.typeBuilder.intT, ""))
+	case *typing.Int:
+		return b.callRuntime("__hash_int", val)
+	case *typing.Float:
+		return b.callRuntime("__hash_float", val)
+	case *typing.String:
+		return b.callRuntime("__hash_str", val)
+	case *typing.Tuple:
+		hash := llvm.ConstInt(b.typeBuilder.intT, 0, false /*sign extend*/)
+		for i, elemTy := range ty.Elems {
+			elem := b.builder.CreateLoad(b.builder.CreateStructGEP(val, i, ""), "")
+			hash = b.callRuntime("__hash_combine", hash, b.buildHash(elemTy, elem))
+		}
+		return hash
+	default:
+		panic("Cannot hash value of type " + ty.String())
+	}
+}
+
+// Boxes the value to pass it to runtime. Boxes for keys only used for lookup can be allocated on
+// stack because runtime never stores them.
+func (b *blockBuilder) buildBox(ident string, onStack bool) llvm.Value {
+	t := b.typeBuilder.convertGCIL(b.typeOf(ident))
+	var ptr llvm.Value
+	if onStack {
+		ptr = b.buildAlloca(t, "box")
+	} else {
+		ptr = b.buildMalloc(t, "box")
+	}
+	b.builder.CreateStore(b.resolve(ident), ptr)
+	return b.builder.CreateBitCast(ptr, b.typeBuilder.voidPtrT, "")
+}
+
+func (b *blockBuilder) buildUnbox(ptr llvm.Value, ty typing.Type) llvm.Value {
+	t := llvm.PointerType(b.typeBuilder.convertGCIL(ty), 0 /*address space*/)
+	return b.builder.CreateLoad(b.builder.CreateBitCast(ptr, t, ""), "unbox")
+}
+
+func (b *blockBuilder) buildHashtblHashFun(key typing.Type) llvm.Value {
+	name := fmt.Sprintf("hashtbl.hash.%s", key.String())
+	return b.buildHashtblHelper(name, b.hashtblHashFunT(), func(builder *blockBuilder, f llvm.Value) {
+		v := builder.buildUnbox(f.Param(0), key)
+		builder.builder.CreateRet(builder.buildHash(key, v))
+	})
+}
+
+// Keys are compared structurally as the same as '=' operator.
+func (b *blockBuilder) buildHashtblEqFun(key typing.Type) llvm.Value {
+	name := fmt.Sprintf("hashtbl.eq.%s", key.String())
+	return b.buildHashtblHelper(name, b.hashtblEqFunT(), func(builder *blockBuilder, f llvm.Value) {
+		l := builder.buildUnbox(f.Param(0), key)
+		r := builder.buildUnbox(f.Param(1), key)
+		eq := builder.buildEq(key, &gcil.Binary{gcil.EQ, "", ""}, l, r)
+		builder.builder.CreateRet(builder.builder.CreateZExt(eq, builder.typeBuilder.intT, ""))
+	})
+}
+
+// Callback for iteration unboxes the key and the value, then calls the closure with them.
+func (b *blockBuilder) buildHashtblIterFun(ty *typing.Fun) llvm.Value {
+	name := fmt.Sprintf("hashtbl.iter.%s", ty.String())
+	return b.buildHashtblHelper(name, b.hashtblIterFunT(), func(builder *blockBuilder, f llvm.Value) {
+		clsTy := llvm.PointerType(builder.typeBuilder.buildClosure(ty), 0 /*address space*/)
+		cls := builder.builder.CreateLoad(builder.builder.CreateBitCast(f.Param(0), clsTy, ""), "closure")
+		funPtr := builder.builder.CreateExtractValue(cls, 0, "funptr")
+		capturesPtr := builder.builder.CreateExtractValue(cls, 1, "capturesptr")
+		key := builder.buildUnbox(f.Param(1), ty.Params[0])
+		val := builder.buildUnbox(f.Param(2), ty.Params[1])
+		builder.builder.CreateCall(funPtr, []llvm.Value{capturesPtr, key, val}, "")
+		builder.builder.CreateRetVoid()
+	})
+}
+
+// Lowers the call of Hashtbl module function to runtime function call.
+func (b *blockBuilder) buildHashtblCall(ident string, app *gcil.App) llvm.Value {
+	args := app.Args
+	switch app.Callee {
+	case "Hashtbl.create":
+		tbl, ok := b.typeOf(ident).(*typing.Hashtbl)
+		if !ok {
+			panic("Result of Hashtbl.create is not a hash table: " + b.typeOf(ident).String())
+		}
+		hash := b.buildHashtblHashFun(tbl.Key)
+		eq := b.buildHashtblEqFun(tbl.Key)
+		return b.callRuntime("__hashtbl_create", b.resolve(args[0]), hash, eq)
+	case "Hashtbl.add", "Hashtbl.replace":
+		key := b.buildBox(args[1], false)
+		val := b.buildBox(args[2], false)
+		name := "__hashtbl_add"
+		if app.Callee == "Hashtbl.replace" {
+			name = "__hashtbl_replace"
+		}
+		b.callRuntime(name, b.resolve(args[0]), key, val)
+		return b.unitVal
+	case "Hashtbl.find":
+		ty, ok := b.typeOf(ident).(*typing.Option)
+		if !ok {
+			panic("Result of Hashtbl.find is not an option: " + b.typeOf(ident).String())
+		}
+		found := b.callRuntime("__hashtbl_find", b.resolve(args[0]), b.buildBox(args[1], true))
+
+		parent := b.builder.GetInsertBlock().Parent()
+		someBlk := llvm.AddBasicBlock(parent, "find.some")
+		noneBlk := llvm.AddBasicBlock(parent, "find.none")
+		endBlk := llvm.AddBasicBlock(parent, "find.end")
+		b.builder.CreateCondBr(b.builder.CreateIsNull(found, ""), noneBlk, someBlk)
+
+		b.builder.SetInsertPointAtEnd(someBlk)
+		someVal := b.buildSome(b.buildUnbox(found, ty.Elem), ty)
+		b.builder.CreateBr(endBlk)
+
+		b.builder.SetInsertPointAtEnd(noneBlk)
+		noneVal := b.buildNone(ty)
+		b.builder.CreateBr(endBlk)
+
+		b.builder.SetInsertPointAtEnd(endBlk)
+		phi := b.builder.CreatePHI(b.typeBuilder.buildOption(ty), "find.merge")
+		phi.AddIncoming([]llvm.Value{someVal, noneVal}, []llvm.BasicBlock{someBlk, noneBlk})
+		return phi
+	case "Hashtbl.remove":
+		b.callRuntime("__hashtbl_remove", b.resolve(args[0]), b.buildBox(args[1], true))
+		return b.unitVal
+	case "Hashtbl.mem":
+		return b.callRuntime("__hashtbl_mem", b.resolve(args[0]), b.buildBox(args[1], true))
+	case "Hashtbl.length":
+		return b.callRuntime("__hashtbl_length", b.resolve(args[0]))
+	case "Hashtbl.iter":
+		ty, ok := b.typeOf(args[0]).(*typing.Fun)
+		if !ok {
+			panic("Callback of Hashtbl.iter is not a function: " + b.typeOf(args[0]).String())
+		}
+		iter := b.buildHashtblIterFun(ty)
+		// Runtime calls the callback while iterating. So the closure can be put on stack.
+		cls := b.buildBox(args[0], true)
+		b.callRuntime("__hashtbl_iter", b.resolve(args[1]), iter, cls)
+		return b.unitVal
+	default:
+		panic("Unknown function of Hashtbl module: " + app.Callee)
+	}
+}
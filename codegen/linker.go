@@ -23,7 +23,8 @@ func gopaths() []string {
 	return filepath.SplitList(s)
 }
 
-func detectRuntimePath() (string, error) {
+// RuntimePath returns the path to the runtime library linked to executables.
+func RuntimePath() (string, error) {
 	// XXX:
 	// Need to investigate solid way to get runtime library path
 
@@ -79,7 +80,7 @@ func (lnk *linker) cmdFailed(args []string, msg string) error {
 func (lnk *linker) link(executable string, objFiles []string) error {
 	// TODO: Consider Windows environment
 
-	runtimePath, err := detectRuntimePath()
+	runtimePath, err := RuntimePath()
 	if err != nil {
 		return err
 	}
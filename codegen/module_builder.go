@@ -6,6 +6,8 @@ import (
 	"github.com/rhysd/gocaml/typing"
 	"github.com/rhysd/loc"
 	"llvm.org/llvm/bindings/go/llvm"
+	"math"
+	"strings"
 )
 
 type moduleBuilder struct {
@@ -21,6 +23,10 @@ type moduleBuilder struct {
 	globalTable map[string]llvm.Value
 	funcTable   map[string]llvm.Value
 	closures    gcil.Closures
+	coverage    *coverageBuilder
+	profiling   bool
+	symbols     map[string]string
+	profiler    *callProfiler
 }
 
 func createAttributeTable(ctx llvm.Context) map[string]llvm.Attribute {
@@ -34,6 +40,7 @@ func createAttributeTable(ctx llvm.Context) map[string]llvm.Attribute {
 		"ssp",
 		"uwtable",
 		"alwaysinline",
+		"zeroext",
 	} {
 		kind := llvm.AttributeKindID(attr)
 		attrs[attr] = ctx.CreateEnumAttribute(kind, 0)
@@ -45,6 +52,7 @@ func createAttributeTable(ctx llvm.Context) map[string]llvm.Attribute {
 		value string
 	}{
 		{"disable-tail-calls", "false"},
+		{"no-frame-pointer-elim", "true"},
 	} {
 		attrs[attr.kind] = ctx.CreateStringAttribute(attr.kind, attr.value)
 	}
@@ -102,6 +110,16 @@ func newModuleBuilder(env *typing.Env, file *loc.Source, opts EmitOptions) (*mod
 		}
 	}
 
+	var coverage *coverageBuilder = nil
+	if opts.Coverage {
+		coverage = newCoverageBuilder(file)
+	}
+
+	var profiler *callProfiler = nil
+	if opts.ProfileCalls {
+		profiler = newCallProfiler()
+	}
+
 	// Note:
 	// We create registers table for each blocks because closure transform
 	// breaks alpha-transformed identifiers. But all identifiers are identical
@@ -119,6 +137,10 @@ func newModuleBuilder(env *typing.Env, file *loc.Source, opts EmitOptions) (*mod
 		nil,
 		nil,
 		nil,
+		coverage,
+		opts.Profiling,
+		nil,
+		profiler,
 	}, nil
 }
 
@@ -152,6 +174,7 @@ func (b *moduleBuilder) buildExternalClosureWrapper(funName string, ty *typing.F
 	val.AddFunctionAttr(b.attributes["ssp"])
 	val.AddFunctionAttr(b.attributes["uwtable"])
 	val.AddFunctionAttr(b.attributes["disable-tail-calls"])
+	b.keepFramePointer(val)
 	b.funcTable[name] = val
 
 	extFunVal, ok := b.globalTable[funName]
@@ -179,20 +202,110 @@ func (b *moduleBuilder) buildExternalClosureWrapper(funName string, ty *typing.F
 	return val
 }
 
+// Built-in symbols whose names are already defined in C standard library are renamed in runtime.
+var renamedExternals = map[string]string{
+	"stdin":  "gocaml_stdin",
+	"stdout": "gocaml_stdout",
+	"stderr": "gocaml_stderr",
+	"getenv": "gocaml_getenv",
+}
+
+// Returns the symbol name of external value in object file. Module member like 'String.trim' is
+// defined as 'String_trim' in runtime because '.' cannot be contained in C identifiers.
+func externalSymbol(name string) string {
+	if sym, ok := renamedExternals[name]; ok {
+		return sym
+	}
+	return strings.Replace(name, ".", "_", -1)
+}
+
+// Math functions are lowered to LLVM intrinsics instead of runtime functions so that optimizer can
+// constant-fold and vectorize them.
+var mathIntrinsics = map[string]string{
+	"sqrt":      "llvm.sqrt.f64",
+	"sin":       "llvm.sin.f64",
+	"cos":       "llvm.cos.f64",
+	"exp":       "llvm.exp.f64",
+	"log":       "llvm.log.f64",
+	"pow":       "llvm.pow.f64",
+	"floor":     "llvm.floor.f64",
+	"ceil":      "llvm.ceil.f64",
+	"abs_float": "llvm.fabs.f64",
+}
+
+// Builds math built-ins which are not defined in runtime. Returns false when the name is not a math
+// built-in.
+func (b *moduleBuilder) buildMathDecl(name string, ty typing.Type) bool {
+	if intrinsic, ok := mathIntrinsics[name]; ok {
+		funTy, ok := ty.(*typing.Fun)
+		if !ok {
+			panic("Type of math function is not a function: " + ty.String())
+		}
+		b.globalTable[name] = llvm.AddFunction(b.module, intrinsic, b.typeBuilder.buildExternalFun(funTy))
+		return true
+	}
+
+	switch name {
+	case "infinity", "nan":
+		f := math.Inf(1)
+		if name == "nan" {
+			f = math.NaN()
+		}
+		v := llvm.AddGlobal(b.module, b.typeBuilder.floatT, name)
+		v.SetLinkage(llvm.PrivateLinkage)
+		v.SetGlobalConstant(true)
+		v.SetUnnamedAddr(true)
+		v.SetInitializer(llvm.ConstFloat(b.typeBuilder.floatT, f))
+		b.globalTable[name] = v
+	case "is_nan":
+		// NaN is the only value which is unordered with itself
+		t := llvm.FunctionType(b.typeBuilder.boolT, []llvm.Type{b.typeBuilder.floatT}, false /*varargs*/)
+		f := llvm.AddFunction(b.module, name, t)
+		f.SetLinkage(llvm.PrivateLinkage)
+		f.AddFunctionAttr(b.attributes["alwaysinline"])
+		f.AddFunctionAttr(b.attributes["nounwind"])
+		b.globalTable[name] = f
+
+		// Note:
+		// Insertion point need not to be restored because external declarations are built before
+		// any function body.
+		body := b.context.AddBasicBlock(f, "entry")
+		b.builder.SetInsertPointAtEnd(body)
+		p := f.Param(0)
+		b.builder.CreateRet(b.builder.CreateFCmp(llvm.FloatUNO, p, p, ""))
+	default:
+		return false
+	}
+	return true
+}
+
 func (b *moduleBuilder) buildExternalDecl(name string, from typing.Type) {
+	if b.buildMathDecl(name, from) {
+		return
+	}
+	sym := externalSymbol(name)
 	switch ty := from.(type) {
 	case *typing.Var:
 		panic("unreachable") // because type variables are dereferenced at type analysis
 	case *typing.Fun:
 		// Make a declaration for the external symbol function
 		tyVal := b.typeBuilder.buildExternalFun(ty)
-		val := llvm.AddFunction(b.module, name, tyVal)
+		val := llvm.AddFunction(b.module, sym, tyVal)
 		val.SetLinkage(llvm.ExternalLinkage)
 		val.AddFunctionAttr(b.attributes["disable-tail-calls"])
+		// Characters are passed as zero-extended 'unsigned char' values in C ABI
+		if _, ok := ty.Ret.(*typing.Char); ok {
+			val.AddAttributeAtIndex(0, b.attributes["zeroext"])
+		}
+		for i, p := range ty.Params {
+			if _, ok := p.(*typing.Char); ok {
+				val.AddAttributeAtIndex(i+1, b.attributes["zeroext"])
+			}
+		}
 		b.globalTable[name] = val
 	default:
 		t := b.typeBuilder.convertGCIL(from)
-		v := llvm.AddGlobal(b.module, t, name)
+		v := llvm.AddGlobal(b.module, t, sym)
 		v.SetLinkage(llvm.ExternalLinkage)
 		b.globalTable[name] = v
 	}
@@ -212,7 +325,7 @@ func (b *moduleBuilder) buildFuncDecl(insn gcil.FunInsn) {
 	}
 
 	t := b.typeBuilder.buildFun(ty, !isClosure)
-	v := llvm.AddFunction(b.module, name, t)
+	v := llvm.AddFunction(b.module, b.symbolName(name), t)
 
 	index := 0
 	if isClosure {
@@ -226,13 +339,20 @@ func (b *moduleBuilder) buildFuncDecl(insn gcil.FunInsn) {
 	}
 
 	// Currently GoCaml does not have modules. So all functions are private.
-	v.SetLinkage(llvm.PrivateLinkage)
+	// Private symbols are not put in symbol table of object file. Internal linkage is used instead
+	// when profiling so that profilers can know names of functions.
+	if b.profiling {
+		v.SetLinkage(llvm.InternalLinkage)
+	} else {
+		v.SetLinkage(llvm.PrivateLinkage)
+	}
 
 	v.AddFunctionAttr(b.attributes["inlinehint"])
 	v.AddFunctionAttr(b.attributes["nounwind"])
 	v.AddFunctionAttr(b.attributes["ssp"])
 	v.AddFunctionAttr(b.attributes["uwtable"])
 	v.AddFunctionAttr(b.attributes["disable-tail-calls"])
+	b.keepFramePointer(v)
 
 	b.funcTable[name] = v
 }
@@ -267,6 +387,14 @@ func (b *moduleBuilder) buildFunBody(insn gcil.FunInsn) {
 			panic("Type for function definition not found: " + name)
 		}
 		b.debug.setFuncInfo(funVal, ty, insn.Pos.Line, isClosure)
+		for i, p := range fun.Params {
+			argNo := i + 1
+			if isClosure {
+				argNo++
+			}
+			info := b.debug.typeInfo(ty.Params[i])
+			b.debug.declareParam(b.builder, p, info, blockBuilder.registers[p], argNo, insn.Pos)
+		}
 	}
 
 	// Expose captures of closure
@@ -279,6 +407,17 @@ func (b *moduleBuilder) buildFunBody(insn gcil.FunInsn) {
 				exposed := b.builder.CreateLoad(ptr, fmt.Sprintf("%s.capture.%s", name, n))
 				blockBuilder.registers[n] = exposed
 			}
+			if b.debug != nil {
+				types := make([]typing.Type, 0, len(closure))
+				for _, n := range closure {
+					types = append(types, blockBuilder.typeOf(n))
+				}
+				info := b.debug.capturesTypeInfo(name, closure, types, capturesTy.ElementType())
+				b.debug.declareParam(b.builder, "closure", info, closureVal, 1, insn.Pos)
+				for i, n := range closure {
+					b.debug.declareLocal(b.builder, n, types[i], blockBuilder.registers[n], insn.Pos)
+				}
+			}
 		}
 		if fun.IsRecursive {
 			// When the closure itself is used in its body, it needs to prepare the closure object
@@ -296,7 +435,19 @@ func (b *moduleBuilder) buildFunBody(insn gcil.FunInsn) {
 		}
 	}
 
+	if b.coverage != nil {
+		b.buildCoverageCount(b.coverage.funcs[name])
+	}
+
+	var profileStart llvm.Value
+	if b.profiler != nil {
+		profileStart = b.buildProfileEnter(name)
+	}
+
 	lastVal := blockBuilder.buildBlock(fun.Body)
+	if b.profiler != nil {
+		b.buildProfileLeave(name, profileStart)
+	}
 	b.builder.CreateRet(lastVal)
 	if b.debug != nil {
 		b.debug.clearLocation(b.builder)
@@ -324,6 +475,7 @@ func (b *moduleBuilder) buildMain(entry *gcil.Block) {
 	funVal.AddFunctionAttr(b.attributes["ssp"])
 	funVal.AddFunctionAttr(b.attributes["uwtable"])
 	funVal.AddFunctionAttr(b.attributes["disable-tail-calls"])
+	b.keepFramePointer(funVal)
 
 	if b.debug != nil {
 		pos := entry.Top.Next.Pos
@@ -333,6 +485,12 @@ func (b *moduleBuilder) buildMain(entry *gcil.Block) {
 	allocaBlock := b.context.AddBasicBlock(funVal, "entry")
 	start := b.context.AddBasicBlock(funVal, "start")
 	b.builder.SetInsertPointAtEnd(start)
+	if b.coverage != nil {
+		b.buildCoverageInit()
+	}
+	if b.profiler != nil {
+		b.buildProfileInit()
+	}
 	builder := newBlockBuilder(b, allocaBlock)
 	builder.buildBlock(entry)
 
@@ -362,6 +520,16 @@ func (b *moduleBuilder) buildLibgcFuncDecls() {
 	b.globalTable["GC_malloc"] = v
 }
 
+// 'exit' built-in is lowered to the call of exit() in C standard library directly.
+func (b *moduleBuilder) buildExitFuncDecl() {
+	t := llvm.FunctionType(b.typeBuilder.voidT, []llvm.Type{b.context.Int32Type()}, false /*varargs*/)
+	v := llvm.AddFunction(b.module, "exit", t)
+	v.SetLinkage(llvm.ExternalLinkage)
+	v.AddFunctionAttr(b.attributes["noreturn"])
+	v.AddFunctionAttr(b.attributes["nounwind"])
+	b.globalTable["exit"] = v
+}
+
 func (b *moduleBuilder) build(prog *gcil.Program) error {
 	// Note:
 	// Currently global variables are external symbols only.
@@ -371,9 +539,21 @@ func (b *moduleBuilder) build(prog *gcil.Program) error {
 	b.funcTable = make(map[string]llvm.Value, len(prog.Toplevel)+len(b.env.Externals))
 
 	b.buildLibgcFuncDecls()
+	b.buildHashtblFuncDecls()
+	b.buildExitFuncDecl()
+	if b.coverage != nil {
+		b.buildCoverageDecls(prog)
+	}
 	for name, ty := range b.env.Externals {
 		b.buildExternalDecl(name, ty)
 	}
+	if b.profiling || b.profiler != nil {
+		// Note: Built after external declarations to avoid conflicts with their symbols
+		b.buildSymbolNames(prog.Toplevel)
+	}
+	if b.profiler != nil {
+		b.buildProfileDecls(prog)
+	}
 
 	b.closures = prog.Closures
 	for _, fun := range prog.Toplevel {
@@ -386,6 +566,7 @@ func (b *moduleBuilder) build(prog *gcil.Program) error {
 
 	b.buildMain(prog.Entry)
 	if b.debug != nil {
+		b.buildGDBScriptsSection()
 		b.debug.finalize()
 	}
 
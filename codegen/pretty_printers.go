@@ -0,0 +1,199 @@
+package codegen
+
+import (
+	"llvm.org/llvm/bindings/go/llvm"
+	"strings"
+)
+
+// Pretty printers for GDB. The script is embedded in '.debug_gdb_scripts' section of the
+// executable and GDB loads it automatically (when the path is allowed by 'auto-load safe-path').
+// It renders GoCaml values in OCaml-like syntax. Values are recognized by the names of struct
+// members emitted by debugInfoBuilder.
+//
+// Formatters for LLDB are put in runtime/gocaml_lldb.py since LLDB cannot load scripts from
+// executables.
+const gdbPrettyPrinters = `import gdb
+import re
+
+
+def gocaml_display_name(name):
+    # Remove suffixes added by alpha transform and closure wrappers (e.g. 'f$t1' -> 'f')
+    return re.sub(r'\$t\d+|\$closure$', '', name)
+
+
+def gocaml_fields(ty):
+    ty = ty.strip_typedefs()
+    if ty.code != gdb.TYPE_CODE_STRUCT:
+        return []
+    return [f.name for f in ty.fields()]
+
+
+def gocaml_format(val):
+    # Nested values are also rendered by the pretty printers
+    s = str(val)
+    if ' ' in s and s[0] not in '([{"<':
+        return '(' + s + ')'
+    return s
+
+
+def gocaml_limit():
+    limit = gdb.parameter('print elements')
+    if limit is None or limit <= 0:
+        return None
+    return limit
+
+
+class GoCamlUnitPrinter(object):
+    def __init__(self, val):
+        self.val = val
+
+    def to_string(self):
+        return '()'
+
+
+class GoCamlStringPrinter(object):
+    def __init__(self, val):
+        self.val = val
+
+    def to_string(self):
+        chars = self.val['chars']
+        if int(chars) == 0:
+            return 'None'
+        size = int(self.val['size'])
+        s = chars.string('utf-8', 'replace', size)
+        s = s.replace('\\', '\\\\').replace('"', '\\"').replace('\n', '\\n').replace('\t', '\\t')
+        return '"' + s + '"'
+
+
+class GoCamlArrayPrinter(object):
+    def __init__(self, val):
+        self.val = val
+
+    def to_string(self):
+        buf = self.val['buf']
+        if int(buf) == 0:
+            return 'None'
+        size = int(self.val['size'])
+        limit = gocaml_limit()
+        count = size if limit is None else min(size, limit)
+        elems = [gocaml_format(buf[i]) for i in range(count)]
+        if count < size:
+            elems.append('...')
+        return '[|' + '; '.join(elems) + '|]'
+
+
+class GoCamlOptionPrinter(object):
+    def __init__(self, val):
+        self.val = val
+
+    def to_string(self):
+        if not bool(self.val['tag']):
+            return 'None'
+        return 'Some ' + gocaml_format(self.val['value'])
+
+
+class GoCamlTuplePrinter(object):
+    def __init__(self, val):
+        self.val = val
+
+    def to_string(self):
+        if int(self.val) == 0:
+            return 'None'
+        tpl = self.val.dereference()
+        return '(' + ', '.join(str(tpl[f]) for f in gocaml_fields(tpl.type)) + ')'
+
+
+class GoCamlClosurePrinter(object):
+    def __init__(self, val):
+        self.val = val
+
+    def function_block(self, addr):
+        try:
+            block = gdb.block_for_pc(addr)
+        except RuntimeError:
+            return None
+        while block is not None and block.function is None:
+            block = block.superblock
+        return block
+
+    def to_string(self):
+        addr = int(self.val['fun'])
+        if addr == 0:
+            return 'None'
+        block = self.function_block(addr)
+        if block is None:
+            return '<fun>'
+        name = gocaml_display_name(block.function.name)
+        for sym in block:
+            if sym.is_argument and sym.name == 'closure':
+                env = self.val['captures'].cast(sym.type).dereference()
+                captures = ['%s = %s' % (f, env[f]) for f in gocaml_fields(env.type)]
+                return '<fun %s> {%s}' % (name, '; '.join(captures))
+        return '<fun %s>' % name
+
+
+def gocaml_lookup(val):
+    ty = val.type.strip_typedefs()
+    if ty.code == gdb.TYPE_CODE_PTR:
+        fields = gocaml_fields(ty.target())
+        if fields and fields == ['_%d' % i for i in range(len(fields))]:
+            return GoCamlTuplePrinter(val)
+        return None
+    if ty.code != gdb.TYPE_CODE_STRUCT:
+        return None
+    fields = gocaml_fields(ty)
+    if ty.tag == '()' and not fields:
+        return GoCamlUnitPrinter(val)
+    if fields == ['chars', 'size']:
+        return GoCamlStringPrinter(val)
+    if fields == ['buf', 'size']:
+        return GoCamlArrayPrinter(val)
+    if fields == ['tag', 'value']:
+        return GoCamlOptionPrinter(val)
+    if fields == ['fun', 'captures']:
+        return GoCamlClosurePrinter(val)
+    return None
+
+
+if gdb.current_objfile() is not None:
+    gdb.current_objfile().pretty_printers.append(gocaml_lookup)
+else:
+    gdb.pretty_printers.append(gocaml_lookup)
+`
+
+// SECTION_SCRIPT_ID_PYTHON_TEXT in GDB. The entry consists of the ID, script name, newline and
+// script text terminated with NUL.
+const gdbScriptIDPythonText = "\x04"
+
+// '.debug_gdb_scripts' section is only available on ELF.
+func supportsGDBScriptsSection(triple string) bool {
+	for _, name := range []string{"darwin", "macos", "ios", "windows"} {
+		if strings.Contains(triple, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *moduleBuilder) buildGDBScriptsSection() {
+	if !supportsGDBScriptsSection(b.module.Target()) {
+		return
+	}
+
+	content := gdbScriptIDPythonText + "gocaml-pretty-printers\n" + gdbPrettyPrinters
+	init := b.context.ConstString(content, true /*add null*/)
+	v := llvm.AddGlobal(b.module, init.Type(), "__gocaml_debug_gdb_scripts_section")
+	v.SetInitializer(init)
+	v.SetGlobalConstant(true)
+	v.SetUnnamedAddr(true)
+	v.SetLinkage(llvm.LinkOnceODRLinkage)
+	v.SetSection(".debug_gdb_scripts")
+	v.SetAlignment(1)
+
+	// Nothing refers the section. Prevent it from being removed by optimization.
+	used := llvm.ConstArray(b.typeBuilder.voidPtrT, []llvm.Value{llvm.ConstBitCast(v, b.typeBuilder.voidPtrT)})
+	usedVar := llvm.AddGlobal(b.module, used.Type(), "llvm.used")
+	usedVar.SetInitializer(used)
+	usedVar.SetLinkage(llvm.AppendingLinkage)
+	usedVar.SetSection("llvm.metadata")
+}
@@ -0,0 +1,125 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/alpha"
+	"github.com/rhysd/gocaml/gcil"
+	"llvm.org/llvm/bindings/go/llvm"
+	"sort"
+	"strings"
+)
+
+// Returns the name written in source for the toplevel function. Functions generated by compiler
+// (e.g. closures for partial application) are named as-is.
+func demangleFuncName(name string) string {
+	if n, ok := alpha.DisplayNameOf(name); ok {
+		return n
+	}
+	return name
+}
+
+// Builds readable symbol names of toplevel functions for profilers. When demangled names conflict
+// with each other or with symbols already defined in the module, the position of the function is
+// appended to disambiguate them (e.g. 'f:3:5').
+func (b *moduleBuilder) buildSymbolNames(top gcil.Toplevel) {
+	names := make([]string, 0, len(top))
+	counts := make(map[string]int, len(top))
+	for n := range top {
+		names = append(names, n)
+		counts[demangleFuncName(n)]++
+	}
+	sort.Strings(names)
+
+	b.symbols = make(map[string]string, len(top))
+	for _, n := range names {
+		sym := demangleFuncName(n)
+		if counts[sym] > 1 || sym == "__gocaml_main" || !b.module.NamedFunction(sym).IsNil() || !b.module.NamedGlobal(sym).IsNil() {
+			pos := top[n].Pos
+			sym = fmt.Sprintf("%s:%d:%d", sym, pos.Line, pos.Column)
+		}
+		b.symbols[n] = sym
+	}
+}
+
+// Returns the symbol name of the toplevel function in object file. Names generated by alpha
+// transform are used as-is unless readable names are requested for profiling.
+func (b *moduleBuilder) symbolName(name string) string {
+	if !b.profiling {
+		return name
+	}
+	return b.symbols[name]
+}
+
+// Sampling profilers such as perf walk call stacks with frame pointers.
+func (b *moduleBuilder) keepFramePointer(f llvm.Value) {
+	if b.profiling {
+		f.AddFunctionAttr(b.attributes["no-frame-pointer-elim"])
+	}
+}
+
+// Call profiling instrumentation. The runtime counts calls and measures cumulative time of each
+// toplevel function between __gocaml_profile_enter() at its entry and __gocaml_profile_leave()
+// before its return, then prints them as a table on exit.
+type callProfiler struct {
+	funcs map[string]int // Indices of toplevel functions
+	names []string
+}
+
+func newCallProfiler() *callProfiler {
+	return &callProfiler{map[string]int{}, nil}
+}
+
+// Declares the runtime functions for call profiling. Toplevel functions are indexed in order of
+// their names to make indices deterministic.
+func (b *moduleBuilder) buildProfileDecls(prog *gcil.Program) {
+	names := make([]string, 0, len(prog.Toplevel))
+	for n := range prog.Toplevel {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for i, n := range names {
+		b.profiler.funcs[n] = i
+		b.profiler.names = append(b.profiler.names, b.symbols[n])
+	}
+
+	i64T := b.context.Int64Type()
+	charPtrT := llvm.PointerType(b.context.Int8Type(), 0 /*address space*/)
+	for _, decl := range []struct {
+		name   string
+		ret    llvm.Type
+		params []llvm.Type
+	}{
+		{"__gocaml_profile_init", b.typeBuilder.voidT, []llvm.Type{charPtrT, i64T}},
+		{"__gocaml_profile_enter", i64T, []llvm.Type{i64T}},
+		{"__gocaml_profile_leave", b.typeBuilder.voidT, []llvm.Type{i64T, i64T}},
+	} {
+		t := llvm.FunctionType(decl.ret, decl.params, false /*varargs*/)
+		f := llvm.AddFunction(b.module, decl.name, t)
+		f.SetLinkage(llvm.ExternalLinkage)
+		f.AddFunctionAttr(b.attributes["nounwind"])
+		b.globalTable[decl.name] = f
+	}
+}
+
+// Registers names of toplevel functions to the runtime at the beginning of main. Names are passed
+// as one string separated by newlines.
+func (b *moduleBuilder) buildProfileInit() {
+	i64T := b.context.Int64Type()
+	args := []llvm.Value{
+		b.buildConstCString(strings.Join(b.profiler.names, "\n"), "__gocaml_profile_names"),
+		llvm.ConstInt(i64T, uint64(len(b.profiler.names)), false),
+	}
+	b.builder.CreateCall(b.globalTable["__gocaml_profile_init"], args, "")
+}
+
+// Notifies the runtime of entering the function. Returned value is the start time of the call.
+func (b *moduleBuilder) buildProfileEnter(name string) llvm.Value {
+	index := llvm.ConstInt(b.context.Int64Type(), uint64(b.profiler.funcs[name]), false)
+	return b.builder.CreateCall(b.globalTable["__gocaml_profile_enter"], []llvm.Value{index}, "profile.start")
+}
+
+// Notifies the runtime of leaving the function. This must be built just before 'ret' instruction.
+func (b *moduleBuilder) buildProfileLeave(name string, start llvm.Value) {
+	index := llvm.ConstInt(b.context.Int64Type(), uint64(b.profiler.funcs[name]), false)
+	b.builder.CreateCall(b.globalTable["__gocaml_profile_leave"], []llvm.Value{index, start}, "")
+}
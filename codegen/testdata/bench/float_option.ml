@@ -0,0 +1,22 @@
+(* Option-heavy numeric loop. Each iteration makes 'float option' and 'int option' values *)
+let rec safe_div x y =
+    if y = 0.0 then None else Some (x /. y)
+in
+let rec find_index n i acc =
+    if i = n then acc else
+    let acc = if i % 7 = 0 then Some i else acc in
+    find_index n (i + 1) acc
+in
+let rec loop i sum =
+    if i = 0 then sum else
+    let d = safe_div (int_to_float i) (int_to_float (i % 3)) in
+    let sum = match d with
+        | Some v -> sum +. v
+        | None -> sum
+    in
+    loop (i - 1) sum
+in
+println_float (loop 1000000 0.0);
+match find_index 1000000 0 None with
+    | Some i -> println_int i
+    | None -> println_str "not found"
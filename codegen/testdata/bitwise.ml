@@ -0,0 +1,25 @@
+println_int (12 land 10);
+println_int (12 lor 10);
+println_int (12 lxor 10);
+println_int (lnot 0);
+println_int (lnot 5);
+println_int (1 lsl 10);
+println_int (1 lsl 62);
+println_int (256 lsr 4);
+println_int (-256 lsr 60);
+println_int (-256 asr 4);
+println_int (256 asr 4);
+println_int (1 lor 2 land 3 + 1 lsl 2 lsl 1);
+let rec popcount x = if x = 0 then 0 else (x land 1) + popcount (x lsr 1) in
+println_int (popcount 255);
+println_int (popcount (-1));
+let rec xorshift x =
+    let x = x lxor (x lsl 13) in
+    let x = x lxor (x lsr 7) in
+    x lxor (x lsl 17)
+in
+println_int (xorshift 88172645463325252);
+(* Deprecated built-in functions are still available *)
+println_bool (bit_and 12 10 = 12 land 10);
+println_bool (bit_lsft 3 4 = 3 lsl 4);
+println_bool (bit_inv 7 = lnot 7)
@@ -0,0 +1,22 @@
+let b = Buffer.create 0 in
+let rec loop i =
+    if i < 10 then (
+        Buffer.add_int b i;
+        Buffer.add_char b ' ';
+        loop (i + 1)
+    ) else ()
+in
+loop 0;
+Buffer.add_string b "end";
+println_int (Buffer.length b);
+println_str (Buffer.contents b);
+let s = Buffer.contents b in
+Buffer.clear b;
+println_int (Buffer.length b);
+Buffer.add_string b "reused";
+println_str (Buffer.contents b);
+println_str s;
+let o = Some b in
+match o with
+| Some buf -> println_int (Buffer.length buf)
+| None -> println_str "none"
@@ -0,0 +1,37 @@
+let path = "testdata/channel.txt" in
+(match open_out path with
+| Some out ->
+    output_string out "first line\n";
+    output_string out "second line\n";
+    output_string out "\n";
+    flush out;
+    close_out out
+| None -> println_str "failed to open for writing");
+
+(match open_out_append path with
+| Some out -> output_string out "appended without newline"; close_out out
+| None -> println_str "failed to open for appending");
+
+let rec print_lines ic n =
+    match input_line ic with
+    | Some line -> printf "%d: [%s]\n" n line; print_lines ic (n + 1)
+    | None -> n
+in
+(match open_in path with
+| Some ic ->
+    let n = print_lines ic 1 in
+    println_int (n - 1);
+    close_in ic;
+    (match input_line ic with Some _ -> println_str "read after close" | None -> println_str "closed")
+| None -> println_str "failed to open for reading");
+
+(match open_in "testdata/unknown_file.txt" with
+| Some _ -> println_str "found"
+| None -> println_str "not found");
+
+output_string stdout "to stdout\n";
+flush stdout;
+prerrln_str "to stderr";
+prerr_int 42;
+output_string stderr "\n";
+println_str "end"
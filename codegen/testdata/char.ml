@@ -0,0 +1,33 @@
+let c = 'a' in
+print_char c;
+println_char 'b';
+println_int (to_char_code 'A');
+println_char (from_char_code 99);
+println_str (char_to_str 'z');
+let s = "hello, world" in
+println_char (String.get s 7);
+println_bool ('a' < 'b');
+println_bool ('z' <= 'a');
+println_bool (String.get s 0 = 'h');
+println_bool ('\xff' > 'a');
+println_int (to_char_code '\xff');
+let rec count_char s c i acc =
+    if i >= str_length s then acc else
+    let acc = if String.get s i = c then acc + 1 else acc in
+    count_char s c (i + 1) acc
+in
+println_int (count_char s 'l' 0 0);
+let rec is_digit c = '0' <= c && c <= '9' in
+println_bool (is_digit '5');
+println_bool (is_digit 'x');
+let o = Some '\n' in
+match o with
+| Some c -> println_int (to_char_code c)
+| None -> ();
+let t = ('x', 1) in
+let (c, _) = t in
+println_char c;
+let arr = Array.make 2 'q' in
+arr.(1) <- 'r';
+println_char arr.(1);
+printf "[%c|%3c]\n" '\'' '"'
@@ -0,0 +1,32 @@
+(* Tuples and closures which don't escape are allocated on stack *)
+let rec sum p = let (a, b) = p in a + b in
+let rec loop i acc =
+    if i = 0 then acc else
+    let t = (i, i * 2) in
+    loop (i - 1) (acc + sum t)
+in
+println_int (loop 100 0);
+
+let x = 10 in
+let rec add y = x + y in
+println_int (add 32);
+
+let rec apply f = f 1 in
+let t = (3, 4) in
+let rec g n = let (a, b) = t in a + b + n in
+println_int (apply g);
+
+(* Escaping values are still allocated in heap *)
+let rec make_pair i = (i, i + 1) in
+let (a, b) = make_pair 5 in
+println_int (a + b);
+
+let rec adder n = let rec f m = n + m in f in
+let add3 = adder 3 in
+println_int (add3 4);
+
+let arr = Array.make 1 (0, 0) in
+let rec store i = arr.(0) <- (i, i) in
+store 7;
+let (c, d) = arr.(0) in
+println_int (c + d)
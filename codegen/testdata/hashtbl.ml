@@ -0,0 +1,43 @@
+let t = Hashtbl.create 0 in
+let rec loop i =
+    if i < 100 then (
+        Hashtbl.add t (str_concat "key" (int_to_str i)) (i * i);
+        loop (i + 1)
+    ) else ()
+in
+loop 0;
+println_int (Hashtbl.length t);
+(match Hashtbl.find t "key7" with
+| Some v -> println_int v
+| None -> println_str "not found");
+(match Hashtbl.find t "key100" with
+| Some v -> println_int v
+| None -> println_str "not found");
+println_bool (Hashtbl.mem t "key99");
+Hashtbl.remove t "key99";
+println_bool (Hashtbl.mem t "key99");
+println_int (Hashtbl.length t);
+
+(* add shadows the previous binding and remove restores it *)
+Hashtbl.add t "key1" 10;
+(match Hashtbl.find t "key1" with Some v -> println_int v | None -> ());
+Hashtbl.remove t "key1";
+(match Hashtbl.find t "key1" with Some v -> println_int v | None -> ());
+Hashtbl.replace t "key1" 100;
+println_int (Hashtbl.length t);
+
+let sum = Array.make 1 0 in
+Hashtbl.iter (fun k v -> sum.(0) <- sum.(0) + v) t;
+println_int sum.(0);
+
+let points = Hashtbl.create 4 in
+Hashtbl.add points (1, "a") 1.5;
+Hashtbl.add points (2, "b") 2.5;
+Hashtbl.replace points (1, "a") 3.5;
+(match Hashtbl.find points (1, "a") with Some f -> println_float f | None -> ());
+(match Hashtbl.find points (1, "b") with Some f -> println_float f | None -> println_str "none");
+println_int (Hashtbl.length points);
+
+let floats = Hashtbl.create 1 in
+Hashtbl.add floats 0.0 "zero";
+(match Hashtbl.find floats (-.0.0) with Some s -> println_str s | None -> ())
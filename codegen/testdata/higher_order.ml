@@ -0,0 +1,24 @@
+let rec apply f x = f x in
+let rec inc x = x + 1 in
+let rec dec x = x - 1 in
+println_int (apply inc 41);
+println_int (apply dec 41);
+
+let n = 10 in
+let rec add x = x + n in
+println_int (apply add 32);
+
+let rec iter f arr i =
+  if i < Array.length arr then (f arr.(i); iter f arr (i + 1)) else ()
+in
+let rec p x = println_int x in
+let arr = Array.make 3 7 in
+arr.(1) <- 8;
+iter p arr 0;
+
+let rec twice g x = apply g (apply g x) in
+println_int (twice inc 1);
+
+let rec compose f g x = f (g x) in
+let rec dbl x = x * 2 in
+println_int (compose inc dbl 3)
@@ -0,0 +1,20 @@
+let rec make_rect ~width ~height ?(border = 1) ?label _ =
+  (match label with Some s -> println_str s | None -> ());
+  width * height + border
+in
+println_int (make_rect ~width:3 ~height:4 ());
+println_int (make_rect ~height:4 ~width:3 ~border:10 ());
+let width = 2 in
+println_int (make_rect ~width ~height:5 ~label:"rect" ());
+let rec sub ~x ~y = x - y in
+println_int (sub ~y:1 ~x:10);
+println_int (sub 10 1);
+let sub_from_10 = sub ~x:10 in
+println_int (sub_from_10 3);
+let rec greet ?(greeting = "Hello") name = println_str (str_concat greeting (str_concat ", " name)) in
+greet "world";
+greet ~greeting:"Hi" "there";
+let b = Some 5 in
+println_int (make_rect ~width:1 ~height:1 ?border:b ());
+let rec count ~x y = if y = 0 then x else count ~x:(x + 1) (y - 1) in
+println_int (count ~x:1 3)
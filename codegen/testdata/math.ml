@@ -0,0 +1,29 @@
+println_float (sqrt 16.0);
+println_float (sqrt 2.0);
+println_float (sin 0.0);
+println_float (cos 0.0);
+println_float (exp 0.0);
+println_float (log (exp 2.0));
+println_float (pow 2.0 10.0);
+println_float (floor 2.5);
+println_float (floor (-.2.5));
+println_float (ceil 2.5);
+println_float (ceil (-.2.5));
+println_float (abs_float (-.3.5));
+println_float (abs_float 3.5);
+println_bool (infinity > 1.0e308);
+println_bool (-.infinity < -.1.0e308);
+println_bool (is_nan nan);
+println_bool (is_nan (infinity -. infinity));
+println_bool (is_nan 1.0);
+println_bool (nan = nan);
+let rec apply f x = f x in
+println_float (apply sqrt 9.0);
+let rec newton x =
+    let rec go z =
+        let next = z -. (z *. z -. x) /. (2.0 *. z) in
+        if abs_float (next -. z) < 0.000001 then next else go next
+    in
+    go x
+in
+println_bool (abs_float (newton 10.0 -. sqrt 10.0) < 0.00001)
@@ -0,0 +1,22 @@
+(* Scalar payloads are unboxed into {tag, value} *)
+let rec div x y = if y = 0.0 then None else Some (x /. y) in
+(match div 1.0 4.0 with
+    | Some f -> println_float f
+    | None -> println_str "none");
+(match div 1.0 0.0 with
+    | Some f -> println_float f
+    | None -> println_str "none");
+println_bool ((div 1.0 0.0) = None);
+println_bool ((div 1.0 2.0) <> None);
+println_bool ((div 1.0 2.0) <> (Some 0.5));
+println_bool ((div 1.0 2.0) <> (Some 0.25));
+println_bool ((Some false) <> None);
+println_bool ((Some false) = (Some false));
+println_bool (None <> (Some true));
+let rec wrap i = if i < 0 then None else Some (Some i) in
+(match wrap 3 with
+    | Some o -> (match o with
+        | Some i -> println_int i
+        | None -> println_str "none")
+    | None -> println_str "none");
+println_bool ((wrap (-1)) = None)
@@ -0,0 +1,22 @@
+let rec add x y = x + y in
+let add3 = add 3 in
+println_int (add3 4);
+let rec mk x = fun y -> x * y in
+println_int (mk 2 21);
+let rec add4 a b c d = a + b + c + d in
+let f = add4 1 2 in
+println_int (f 3 4);
+let g = f 10 in
+println_int (g 20);
+let rec apply f x = f x in
+println_int (apply (add 10) 5);
+let p = println_int in
+let rec adder n = add n in
+let add5 = adder 5 in
+println_int (add5 1);
+let rec apply1 g = g 1 in
+let inc = apply1 (fun x -> add x) in
+println_int (inc 41);
+let rec apply2 g = g 1 2 in
+println_int (apply2 (fun x y -> mk x y));
+p 3
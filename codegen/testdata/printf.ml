@@ -0,0 +1,24 @@
+let n = 3 in
+let price = 1.5 in
+printf "%d items at %.2f\n" n price;
+printf "[%5d|%-5d|%05d|%+d]\n" 42 42 42 42;
+printf "[%x|%X|%o]\n" 255 255 8;
+printf "[%s|%5s|%-5s|%.2s]\n" "abc" "abc" "abc" "abc";
+printf "[%b|%6b]\n" true false;
+printf "[%c%c]\n" 'H' 'i';
+printf "[%f|%.3f|%8.1f|%e|%g]\n" 3.14 3.14 3.14 31400.0 0.5;
+printf "100%%\n";
+let s = sprintf "%s-%d" "id" 7 in
+println_str s;
+println_int (str_length s);
+let sub = str_sub "hello, world" 7 12 in
+printf "<%s>\n" sub;
+let rec show x = sprintf "(%d)" x in
+printf "%s %s\n" (show 1) (show 2);
+let p = printf "%d %.1f\n" in
+p 1 2.0;
+let q = sprintf "%s=%d" "x" in
+println_str (q 42);
+let r = printf "[%d-%d]\n" 7 in
+r 8;
+println_str (sprintf "")
@@ -0,0 +1,45 @@
+Random.init 42;
+let rec print_ints n =
+    if n = 0 then () else (
+        printf "%d " (Random.int 100);
+        print_ints (n - 1)
+    )
+in
+print_ints 5;
+println_str "";
+printf "%.6f\n" (Random.float 1.0);
+println_bool (Random.bool ());
+
+(* The same seed generates the same sequence *)
+Random.init 42;
+let first = Random.int 100 in
+Random.init 42;
+println_bool (first = Random.int 100);
+
+let s1 = Random.State.make 7 in
+let s2 = Random.State.make 7 in
+let rec same n =
+    if n = 0 then true else
+    if Random.State.int s1 1000000 = Random.State.int s2 1000000 then same (n - 1) else false
+in
+println_bool (same 100);
+let s3 = Random.State.copy s1 in
+println_bool (Random.State.bool s1 = Random.State.bool s3);
+let f = Random.State.float s1 10.0 in
+println_bool (f >= 0.0 && f < 10.0);
+println_int (Random.State.int s1 1);
+printf "%d %d %d\n" (Random.State.int s2 10) (Random.State.int s2 10) (Random.State.int s2 10);
+
+let counts = Array.make 4 0 in
+let rec histogram n =
+    if n = 0 then () else (
+        let i = Random.int 4 in
+        counts.(i) <- counts.(i) + 1;
+        histogram (n - 1)
+    )
+in
+histogram 10000;
+println_bool (counts.(0) > 2000 && counts.(1) > 2000 && counts.(2) > 2000 && counts.(3) > 2000);
+Random.self_init ();
+let r = Random.int 10 in
+println_bool (r >= 0 && r < 10)
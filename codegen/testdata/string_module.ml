@@ -0,0 +1,20 @@
+let s = "  Hello, World  " in
+println_int (String.length s);
+println_int (String.utf8_length "héllo");
+let t = String.trim s in
+println_str t;
+println_int (String.index_of t "World");
+println_int (String.index_of t "world");
+println_bool (String.contains t "lo, ");
+println_bool (String.starts_with ~prefix:"Hello" t);
+println_bool (String.starts_with ~prefix:"World" t);
+println_str (String.uppercase t);
+println_str (String.lowercase t);
+println_str (String.replace "a-b-c" "-" " + ");
+let words = String.split_on_char ',' "foo,bar,,baz" in
+println_int (Array.length words);
+println_str (String.concat " | " words);
+let n = Array.make 1 0 in
+String.iter (fun c -> if c = 'l' then n.(0) <- n.(0) + 1 else ()) t;
+println_int n.(0);
+String.iter print_char "done\n"
@@ -0,0 +1,33 @@
+println_bool (Sys.file_exists "testdata/test.txt");
+println_bool (Sys.file_exists "testdata");
+println_bool (Sys.file_exists "testdata/unknown_file.txt");
+
+(match Sys.readdir "testdata" with
+| Some entries ->
+    let rec find i =
+        if i >= Array.length entries then false else
+        if entries.(i) = "test.txt" then true else
+        find (i + 1)
+    in
+    println_bool (find 0)
+| None -> println_str "failed to read directory");
+(match Sys.readdir "testdata/unknown_dir" with
+| Some _ -> println_str "found"
+| None -> println_str "not found");
+
+(match getenv "PATH" with
+| Some p -> println_bool (str_length p > 0)
+| None -> println_str "PATH is not set");
+(match getenv "GOCAML_UNKNOWN_ENV_VAR" with
+| Some _ -> println_str "found"
+| None -> println_str "not found");
+
+println_int (Sys.command "exit 3");
+println_int (Sys.command "exit 0");
+
+let rec check x = if x < 0 then exit 0 else x in
+println_int (check 42);
+println_str "before exit";
+let code = check (-1) in
+println_str "after exit";
+println_int code
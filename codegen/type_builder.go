@@ -13,6 +13,7 @@ type typeBuilder struct {
 	intT      llvm.Type
 	floatT    llvm.Type
 	boolT     llvm.Type
+	charT     llvm.Type
 	stringT   llvm.Type
 	voidT     llvm.Type
 	voidPtrT  llvm.Type
@@ -20,11 +21,15 @@ type typeBuilder struct {
 	optIntT   llvm.Type
 	optBoolT  llvm.Type
 	optFloatT llvm.Type
+	optCharT  llvm.Type
 	captures  map[string]llvm.Type
 }
 
 func newTypeBuilder(ctx llvm.Context, intPtrTy llvm.Type, env *typing.Env) *typeBuilder {
 	integer := ctx.Int64Type()
+	float := ctx.DoubleType()
+	boolean := ctx.Int1Type()
+	char := ctx.Int8Type()
 	unit := ctx.StructCreateNamed("gocaml.unit")
 	unit.StructSetBody([]llvm.Type{}, false /*packed*/)
 	str := ctx.StructCreateNamed("gocaml.string")
@@ -38,15 +43,17 @@ func newTypeBuilder(ctx llvm.Context, intPtrTy llvm.Type, env *typing.Env) *type
 		env,
 		unit,
 		integer,
-		ctx.DoubleType(),
-		ctx.Int1Type(),
+		float,
+		boolean,
+		char,
 		str,
 		ctx.VoidType(),
 		llvm.PointerType(ctx.Int8Type(), 0 /*address space*/),
 		intPtrTy,
-		ctx.IntType(65), // 64bit int + 1bit flag
-		ctx.IntType(2),  // 1bit int + 1bit flag
-		ctx.IntType(65), // 64bit float + 1bit flag
+		ctx.StructType([]llvm.Type{boolean, integer}, false /*packed*/), // {i1 tag, i64 value}
+		ctx.StructType([]llvm.Type{boolean, boolean}, false /*packed*/), // {i1 tag, i1 value}
+		ctx.StructType([]llvm.Type{boolean, float}, false /*packed*/),   // {i1 tag, double value}
+		ctx.StructType([]llvm.Type{boolean, char}, false /*packed*/),    // {i1 tag, i8 value}
 		map[string]llvm.Type{},
 	}
 }
@@ -121,6 +128,14 @@ func (b *typeBuilder) buildClosure(ty *typing.Fun) llvm.Type {
 	return b.context.StructType([]llvm.Type{funPtr, b.voidPtrT}, false /*packed*/)
 }
 
+// Option value is represented as below.
+//
+//   - Pointer-like payloads (string, function, tuple, array) use NULL for 'None'
+//   - Other payloads are unboxed into flat {i1 tag, T value} struct. 'tag' is 1 when the
+//     value is 'Some'. 'value' is zero-initialized for 'None'
+//
+// Unlike packing a payload and a flag bit into one wider integer (e.g. i65 for float), the payload
+// can be made and extracted with insertvalue/extractvalue without shift or bitcast.
 func (b *typeBuilder) buildOption(ty *typing.Option) llvm.Type {
 	switch elem := ty.Elem.(type) {
 	case *typing.Int:
@@ -129,7 +144,9 @@ func (b *typeBuilder) buildOption(ty *typing.Option) llvm.Type {
 		return b.optBoolT
 	case *typing.Float:
 		return b.optFloatT
-	case *typing.String, *typing.Fun, *typing.Tuple, *typing.Array:
+	case *typing.Char:
+		return b.optCharT
+	case *typing.String, *typing.Fun, *typing.Tuple, *typing.Array, *typing.Opaque, *typing.Hashtbl:
 		// Represents 'None' value with NULL pointer
 		return b.convertGCIL(elem)
 	case *typing.Option:
@@ -159,8 +176,14 @@ func (b *typeBuilder) convertGCIL(from typing.Type) llvm.Type {
 		return b.intT
 	case *typing.Float:
 		return b.floatT
+	case *typing.Char:
+		return b.charT
 	case *typing.String:
 		return b.stringT
+	case *typing.Opaque, *typing.Hashtbl:
+		// Opaque value and hash table are pointers to the objects allocated in runtime. Their
+		// layouts are not known to compiler.
+		return b.voidPtrT
 	case *typing.Fun:
 		// Function type which occurs in normal expression's type is always closure because
 		// function type variable is always closure. Normal function pointer never occurs in value context.
@@ -7,6 +7,7 @@ import (
 	"github.com/rhysd/gocaml/ast"
 	"github.com/rhysd/gocaml/closure"
 	"github.com/rhysd/gocaml/codegen"
+	"github.com/rhysd/gocaml/escape"
 	"github.com/rhysd/gocaml/gcil"
 	"github.com/rhysd/gocaml/lexer"
 	"github.com/rhysd/gocaml/parser"
@@ -33,6 +34,22 @@ type Compiler struct {
 	LinkFlags    string
 	TargetTriple string
 	DebugInfo    bool
+	// When true, source is read as textual GCIL program printed by gcil.Program.Dump
+	FromGCIL bool
+	// When true, GCIL is verified after each transformation pass
+	VerifyGCIL bool
+	// When true, wall time and allocations of each phase are recorded. They are printed by PrintReport
+	TimePasses bool
+	// When true, statistics of compiled program are recorded. They are printed by PrintReport
+	Stats bool
+	// When true, counters for code coverage are instrumented in the executable
+	Coverage bool
+	// When true, frame pointers and readable symbol names are emitted for sampling profilers
+	Profiling bool
+	// When true, calls and cumulative time of each toplevel function are counted in the executable
+	ProfileCalls bool
+
+	report report
 }
 
 // PrintTokens returns the lexed tokens for a source code.
@@ -60,11 +77,39 @@ func (c *Compiler) PrintTokens(src *loc.Source) {
 	}
 }
 
+// Lexer runs concurrently with parser. To measure lexing separately, all tokens are lexed before
+// parsing.
+func bufferTokens(tokens chan token.Token) chan token.Token {
+	buf := []token.Token{}
+	for {
+		t := <-tokens
+		buf = append(buf, t)
+		if t.Kind == token.EOF || t.Kind == token.ILLEGAL {
+			break
+		}
+	}
+	buffered := make(chan token.Token, len(buf))
+	for _, t := range buf {
+		buffered <- t
+	}
+	return buffered
+}
+
 // Parse parses the source and returns the parsed AST.
 func (c *Compiler) Parse(src *loc.Source) (*ast.AST, error) {
 	tokens := c.Lex(src)
-	ast, err := parser.Parse(tokens)
+	if c.TimePasses {
+		c.phase("lexing", func() error {
+			tokens = bufferTokens(tokens)
+			return nil
+		})
+	}
 
+	var ast *ast.AST
+	err := c.phase("parsing", func() (err error) {
+		ast, err = parser.Parse(tokens)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -87,18 +132,52 @@ func (c *Compiler) PrintAST(src *loc.Source) {
 // SemanticAnalysis checks types and symbol duplicates.
 // It returns the result of type analysis or an error.
 func (c *Compiler) SemanticAnalysis(a *ast.AST) (*typing.Env, error) {
-	if err := alpha.Transform(a.Root); err != nil {
+	if err := c.phase("alpha transform", func() error { return alpha.Transform(a.Root) }); err != nil {
 		return nil, loc.Notef(err, "While semantic analysis (alpha transform) in %s\n", a.File.Path)
 	}
-	env, err := typing.TypeInferernce(a)
+	var env *typing.Env
+	err := c.phase("type inference", func() (err error) {
+		env, err = typing.TypeInferernce(a)
+		return
+	})
 	if err != nil {
 		return nil, loc.Notef(err, "While semantic analysis (type infererence) in %s", a.File.Path)
 	}
 	return env, nil
 }
 
+// Verifies GCIL when VerifyGCIL is true. 'pass' is the name of the pass executed just before.
+func (c *Compiler) verifyGCIL(prog *gcil.Program, env *typing.Env, pass string) error {
+	if !c.VerifyGCIL {
+		return nil
+	}
+	err := c.phase("GCIL verification", func() error { return gcil.Verify(prog, env) })
+	if err != nil {
+		return loc.Notef(err, "GCIL is broken after %s", pass)
+	}
+	return nil
+}
+
 // EmitGCIL emits GCIL tree representation.
+// When FromGCIL is true, the source is parsed as textual GCIL. Since the text is printed after
+// all transformations, no transformation is applied to the parsed program.
 func (c *Compiler) EmitGCIL(src *loc.Source) (*gcil.Program, *typing.Env, error) {
+	if c.FromGCIL {
+		var prog *gcil.Program
+		var env *typing.Env
+		err := c.phase("parsing GCIL", func() (err error) {
+			prog, env, err = gcil.Parse(src)
+			return
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := c.verifyGCIL(prog, env, "parsing GCIL"); err != nil {
+			return nil, nil, err
+		}
+		c.statsOfGCIL(prog)
+		return prog, env, nil
+	}
 	ast, err := c.Parse(src)
 	if err != nil {
 		return nil, nil, err
@@ -107,12 +186,52 @@ func (c *Compiler) EmitGCIL(src *loc.Source) (*gcil.Program, *typing.Env, error)
 	if err != nil {
 		return nil, nil, err
 	}
-	ir, err := gcil.FromAST(ast.Root, env)
+	var ir *gcil.Block
+	err = c.phase("K-normalization", func() (err error) {
+		ir, err = gcil.FromAST(ast.Root, env)
+		return
+	})
 	if err != nil {
 		return nil, nil, err
 	}
-	gcil.ElimRefs(ir, env)
-	prog := closure.Transform(ir)
+	if c.Stats {
+		c.stat("GCIL instructions after K-normalization", countInsns(ir))
+	}
+	// Before closure transform, the program consists of only the root block
+	unclosed := &gcil.Program{gcil.NewToplevel(), gcil.Closures{}, ir}
+	if err := c.verifyGCIL(unclosed, env, "K-normalization"); err != nil {
+		return nil, nil, err
+	}
+	c.phase("eliminating refs", func() error {
+		gcil.ElimRefs(ir, env)
+		return nil
+	})
+	if err := c.verifyGCIL(unclosed, env, "eliminating refs"); err != nil {
+		return nil, nil, err
+	}
+	var prog *gcil.Program
+	c.phase("closure transform", func() error {
+		prog = closure.Transform(ir)
+		return nil
+	})
+	if err := c.verifyGCIL(prog, env, "closure transform"); err != nil {
+		return nil, nil, err
+	}
+	c.phase("closure specialization", func() error {
+		closure.Specialize(prog, env)
+		return nil
+	})
+	if err := c.verifyGCIL(prog, env, "closure specialization"); err != nil {
+		return nil, nil, err
+	}
+	c.phase("escape analysis", func() error {
+		escape.Analyze(prog)
+		return nil
+	})
+	if err := c.verifyGCIL(prog, env, "escape analysis"); err != nil {
+		return nil, nil, err
+	}
+	c.statsOfGCIL(prog)
 	return prog, env, nil
 }
 
@@ -131,9 +250,26 @@ func (c *Compiler) emitterFromSource(src *loc.Source) (*codegen.Emitter, error)
 	case O3:
 		level = codegen.OptimizeAggressive
 	}
-	opts := codegen.EmitOptions{level, c.TargetTriple, c.LinkFlags, c.DebugInfo}
+	opts := codegen.EmitOptions{level, c.TargetTriple, c.LinkFlags, c.DebugInfo, c.Coverage, c.Profiling, c.ProfileCalls}
+
+	var emitter *codegen.Emitter
+	err = c.phase("LLVM IR generation", func() (err error) {
+		emitter, err = codegen.NewEmitter(prog, env, src, opts)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.stat("LLVM functions before optimization", emitter.NumFunctions())
+	return emitter, nil
+}
 
-	return codegen.NewEmitter(prog, env, src, opts)
+func (c *Compiler) optimize(emitter *codegen.Emitter) {
+	c.phase("LLVM optimization", func() error {
+		emitter.RunOptimizationPasses()
+		return nil
+	})
+	c.stat("LLVM functions after optimization", emitter.NumFunctions())
 }
 
 func (c *Compiler) EmitObjFile(src *loc.Source) error {
@@ -142,8 +278,12 @@ func (c *Compiler) EmitObjFile(src *loc.Source) error {
 		return err
 	}
 	defer emitter.Dispose()
-	emitter.RunOptimizationPasses()
-	obj, err := emitter.EmitObject()
+	c.optimize(emitter)
+	var obj []byte
+	err = c.phase("object emission", func() (err error) {
+		obj, err = emitter.EmitObject()
+		return
+	})
 	if err != nil {
 		return err
 	}
@@ -157,9 +297,14 @@ func (c *Compiler) EmitLLVMIR(src *loc.Source) (string, error) {
 		return "", err
 	}
 	defer emitter.Dispose()
-	emitter.RunOptimizationPasses()
+	c.optimize(emitter)
 
-	return emitter.EmitLLVMIR(), nil
+	var ir string
+	c.phase("LLVM IR printing", func() error {
+		ir = emitter.EmitLLVMIR()
+		return nil
+	})
+	return ir, nil
 }
 
 func (c *Compiler) EmitAsm(src *loc.Source) (string, error) {
@@ -168,26 +313,38 @@ func (c *Compiler) EmitAsm(src *loc.Source) (string, error) {
 		return "", err
 	}
 	defer emitter.Dispose()
-	emitter.RunOptimizationPasses()
+	c.optimize(emitter)
 
-	return emitter.EmitAsm()
+	var asm string
+	err = c.phase("assembly emission", func() (err error) {
+		asm, err = emitter.EmitAsm()
+		return
+	})
+	return asm, err
 }
 
+// Compile compiles the source into an executable. The executable is put in current directory and
+// named after the source file ('a.out' when the source is read from stdin).
 func (c *Compiler) Compile(source *loc.Source) error {
+	if !source.Exists {
+		executable, err := filepath.Abs("a.out")
+		if err != nil {
+			return err
+		}
+		return c.CompileTo(source, executable)
+	}
+	return c.CompileTo(source, source.BaseName())
+}
+
+// CompileTo compiles the source into an executable at the given path.
+func (c *Compiler) CompileTo(source *loc.Source, executable string) error {
 	emitter, err := c.emitterFromSource(source)
 	if err != nil {
 		return err
 	}
 	defer emitter.Dispose()
-	emitter.RunOptimizationPasses()
-	var executable string
-	if source.Exists {
-		executable = source.BaseName()
-	} else {
-		executable, err = filepath.Abs("a.out")
-		if err != nil {
-			return err
-		}
-	}
-	return emitter.EmitExecutable(executable)
+	c.optimize(emitter)
+	return c.phase("object emission and linking", func() error {
+		return emitter.EmitExecutable(executable)
+	})
 }
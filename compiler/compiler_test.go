@@ -0,0 +1,134 @@
+package compiler
+
+import (
+	"bytes"
+	"github.com/rhysd/loc"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Property: GCIL printed after all transformations can be read back with -from-gcil and the
+// result is printed as the same text.
+func TestGCILRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.FromSlash("../codegen/testdata/*.ml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No source file for test was found")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := loc.NewSourceFromFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := Compiler{}
+			prog, env, err := c.EmitGCIL(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			prog.Dump(&buf, env)
+			printed := buf.String()
+
+			c.FromGCIL = true
+			parsed, parsedEnv, err := c.EmitGCIL(loc.NewDummySource(printed))
+			if err != nil {
+				t.Fatalf("%s\n\nGCIL:\n%s", err.Error(), printed)
+			}
+			buf.Reset()
+			parsed.Dump(&buf, parsedEnv)
+			if reprinted := buf.String(); reprinted != printed {
+				t.Fatalf("Round trip failed.\n\nOriginal:\n%s\n\nReprinted:\n%s", printed, reprinted)
+			}
+		})
+	}
+}
+
+func TestVerifyGCILAfterEachPass(t *testing.T) {
+	files, err := filepath.Glob(filepath.FromSlash("../codegen/testdata/*.ml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := filepath.Glob(filepath.FromSlash("../testdata/from-mincaml/*.ml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, more...)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := loc.NewSourceFromFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := Compiler{VerifyGCIL: true}
+			if _, _, err := c.EmitGCIL(src); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestReportTimePassesAndStats(t *testing.T) {
+	src := loc.NewDummySource("let a = 1 in let rec f x = x + a in let rec g y = y in print_int (f (g 2))")
+	c := Compiler{TimePasses: true, Stats: true}
+	if _, _, err := c.EmitGCIL(src); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	c.PrintReport(&buf)
+	out := buf.String()
+
+	for _, phase := range []string{
+		"lexing",
+		"parsing",
+		"alpha transform",
+		"type inference",
+		"K-normalization",
+		"eliminating refs",
+		"closure transform",
+		"closure specialization",
+		"escape analysis",
+		"total",
+	} {
+		if !strings.Contains(out, "\n"+phase+" ") {
+			t.Errorf("Phase '%s' is not reported:\n%s", phase, out)
+		}
+	}
+	if strings.Contains(out, "GCIL verification") {
+		t.Errorf("Verification should not be run without VerifyGCIL:\n%s", out)
+	}
+
+	for _, stat := range []struct {
+		name  string
+		value int
+	}{
+		{"toplevel functions", 2},
+		{"known functions", 1},
+		{"closures", 1},
+		{"closure objects created (makecls)", 1},
+		{"external symbols referenced", 1},
+	} {
+		if !regexp.MustCompile(regexp.QuoteMeta(stat.name) + ` +` + strconv.Itoa(stat.value) + "\n").MatchString(out) {
+			t.Errorf("Stat '%s' should be %d:\n%s", stat.name, stat.value, out)
+		}
+	}
+}
+
+func TestReportNothingByDefault(t *testing.T) {
+	c := Compiler{}
+	if _, _, err := c.EmitGCIL(loc.NewDummySource("print_int 42")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	c.PrintReport(&buf)
+	if buf.Len() != 0 {
+		t.Fatalf("Nothing should be reported but got:\n%s", buf.String())
+	}
+}
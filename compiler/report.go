@@ -0,0 +1,174 @@
+package compiler
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/gcil"
+	"io"
+	"runtime"
+	"text/tabwriter"
+	"time"
+)
+
+type phaseReport struct {
+	name    string
+	elapsed time.Duration
+	allocs  uint64
+	bytes   uint64
+}
+
+type statReport struct {
+	name  string
+	value int
+}
+
+// Report of compilation which is recorded when TimePasses or Stats is enabled.
+type report struct {
+	phases []phaseReport
+	stats  []statReport
+}
+
+// The same phase may be run multiple times (e.g. verification). They are accumulated.
+func (r *report) addPhase(name string, elapsed time.Duration, allocs, bytes uint64) {
+	for i := range r.phases {
+		p := &r.phases[i]
+		if p.name == name {
+			p.elapsed += elapsed
+			p.allocs += allocs
+			p.bytes += bytes
+			return
+		}
+	}
+	r.phases = append(r.phases, phaseReport{name, elapsed, allocs, bytes})
+}
+
+// Runs the phase. When TimePasses is enabled, its wall time and allocations are recorded.
+func (c *Compiler) phase(name string, f func() error) error {
+	if !c.TimePasses {
+		return f()
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	err := f()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	c.report.addPhase(name, elapsed, after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc)
+	return err
+}
+
+func (c *Compiler) stat(name string, value int) {
+	if c.Stats {
+		c.report.stats = append(c.report.stats, statReport{name, value})
+	}
+}
+
+func countInsns(b *gcil.Block) int {
+	n := 0
+	begin, end := b.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		n++
+		switch val := i.Val.(type) {
+		case *gcil.If:
+			n += countInsns(val.Then) + countInsns(val.Else)
+		case *gcil.Fun:
+			n += countInsns(val.Body)
+		}
+	}
+	return n
+}
+
+// Counts 'makecls' instructions and collects external symbols referred in the block.
+func countClosuresAndExternals(b *gcil.Block, externals map[string]struct{}) int {
+	n := 0
+	begin, end := b.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		switch val := i.Val.(type) {
+		case *gcil.MakeCls:
+			n++
+		case *gcil.XRef:
+			externals[val.Ident] = struct{}{}
+		case *gcil.App:
+			if val.Kind == gcil.EXTERNAL_CALL {
+				externals[val.Callee] = struct{}{}
+			}
+		case *gcil.If:
+			n += countClosuresAndExternals(val.Then, externals)
+			n += countClosuresAndExternals(val.Else, externals)
+		case *gcil.Fun:
+			n += countClosuresAndExternals(val.Body, externals)
+		}
+	}
+	return n
+}
+
+func (c *Compiler) statsOfGCIL(prog *gcil.Program) {
+	if !c.Stats {
+		return
+	}
+	insns := countInsns(prog.Entry)
+	makeClss := 0
+	externals := map[string]struct{}{}
+	for _, f := range prog.Toplevel {
+		insns += countInsns(f.Val.Body)
+		makeClss += countClosuresAndExternals(f.Val.Body, externals)
+	}
+	makeClss += countClosuresAndExternals(prog.Entry, externals)
+
+	c.stat("GCIL instructions after all transformations", insns)
+	c.stat("toplevel functions", len(prog.Toplevel))
+	c.stat("known functions", len(prog.Toplevel)-len(prog.Closures))
+	c.stat("closures", len(prog.Closures))
+	c.stat("closure objects created (makecls)", makeClss)
+	c.stat("external symbols referenced", len(externals))
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
+
+// PrintReport outputs wall time and allocations of each phase (when TimePasses is enabled) and
+// statistics of compiled program (when Stats is enabled).
+func (c *Compiler) PrintReport(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	if c.TimePasses && len(c.report.phases) > 0 {
+		var total phaseReport
+		for _, p := range c.report.phases {
+			total.elapsed += p.elapsed
+			total.allocs += p.allocs
+			total.bytes += p.bytes
+		}
+		fmt.Fprintln(out, "===== Time and allocations per phase =====")
+		fmt.Fprintln(w, "Phase\tWall time\tRatio\tAllocs\tBytes")
+		for _, p := range append(c.report.phases, phaseReport{"total", total.elapsed, total.allocs, total.bytes}) {
+			ratio := 0.0
+			if total.elapsed > 0 {
+				ratio = float64(p.elapsed) / float64(total.elapsed) * 100
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%d\t%s\n", p.name, formatDuration(p.elapsed), ratio, p.allocs, formatBytes(p.bytes))
+		}
+		w.Flush()
+	}
+
+	if c.Stats && len(c.report.stats) > 0 {
+		if c.TimePasses {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintln(out, "===== Statistics =====")
+		for _, s := range c.report.stats {
+			fmt.Fprintf(w, "%s\t%d\n", s.name, s.value)
+		}
+		w.Flush()
+	}
+}
@@ -0,0 +1,217 @@
+// Package coverage provides data structures of code coverage of GoCaml programs and reports of them.
+//
+// When a program is compiled with coverage instrumentation, a counter is inserted at the entry of
+// each function and at the beginning of each branch of 'if' expressions. Counters are dumped to the
+// profile file when the program exits. Each run appends its counts to the profile as below.
+//
+//	source /path/to/source.ml
+//	func 1:9 fib$t1 177
+//	then 2:5 89
+//	else 3:5 88
+//
+// The first line is the source file of the following counters. Each counter line consists of the
+// kind of the counter point, its position (line:column), the name of function (only for 'func') and
+// the count.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/rhysd/gocaml/alpha"
+	"github.com/rhysd/loc"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultProfile is the name of profile file written by instrumented programs when
+// $GOCAML_COVERAGE_FILE environment variable is not set.
+const DefaultProfile = "gocaml.cover"
+
+type PointKind int
+
+const (
+	FUNC PointKind = iota
+	THEN
+	ELSE
+)
+
+var pointKindNames = [...]string{
+	FUNC: "func",
+	THEN: "then",
+	ELSE: "else",
+}
+
+func (k PointKind) String() string {
+	return pointKindNames[k]
+}
+
+// Point is a place in source where a counter is inserted.
+type Point struct {
+	Kind   PointKind
+	Line   int
+	Column int
+	Name   string // Name of function. Only for FUNC
+}
+
+// String returns the descriptor of the point used in profile.
+func (p Point) String() string {
+	s := fmt.Sprintf("%s %d:%d", p.Kind.String(), p.Line, p.Column)
+	if p.Kind == FUNC {
+		s += " " + p.Name
+	}
+	return s
+}
+
+// DisplayName returns the function name written in source (e.g. 'f' for 'f$t1').
+func (p Point) DisplayName() string {
+	if n, ok := alpha.DisplayNameOf(p.Name); ok {
+		return n
+	}
+	return p.Name
+}
+
+// Counter is a point and how many times the point was executed.
+type Counter struct {
+	Point
+	Count uint64
+}
+
+// FileProfile is coverage of one source file.
+type FileProfile struct {
+	Source   string
+	Counters []*Counter // Sorted by their positions
+}
+
+// Profile is coverage of all source files in profiles. Counts of the same point are summed up.
+type Profile struct {
+	Files []*FileProfile // Sorted by their source paths
+}
+
+func parsePoint(fields []string) (Point, error) {
+	var p Point
+	switch fields[0] {
+	case "func":
+		if len(fields) != 3 {
+			return p, fmt.Errorf("'func' counter requires position and name")
+		}
+		p.Kind = FUNC
+		p.Name = fields[2]
+	case "then", "else":
+		if len(fields) != 2 {
+			return p, fmt.Errorf("'%s' counter requires only position", fields[0])
+		}
+		p.Kind = THEN
+		if fields[0] == "else" {
+			p.Kind = ELSE
+		}
+	default:
+		return p, fmt.Errorf("Unknown kind of counter '%s'", fields[0])
+	}
+
+	pos := strings.Split(fields[1], ":")
+	if len(pos) != 2 {
+		return p, fmt.Errorf("Position must be in form 'line:column' but got '%s'", fields[1])
+	}
+	var err error
+	if p.Line, err = strconv.Atoi(pos[0]); err != nil {
+		return p, fmt.Errorf("Invalid line '%s'", pos[0])
+	}
+	if p.Column, err = strconv.Atoi(pos[1]); err != nil {
+		return p, fmt.Errorf("Invalid column '%s'", pos[1])
+	}
+	return p, nil
+}
+
+type profileBuilder struct {
+	files map[string]map[Point]uint64
+}
+
+func (b *profileBuilder) parse(r io.Reader, name string) error {
+	var counts map[Point]uint64
+	s := bufio.NewScanner(r)
+	for lnum := 1; s.Scan(); lnum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "source ") {
+			src := strings.TrimPrefix(line, "source ")
+			if counts = b.files[src]; counts == nil {
+				counts = map[Point]uint64{}
+				b.files[src] = counts
+			}
+			continue
+		}
+		if counts == nil {
+			return loc.Errorf("%s:%d: Counter appears before 'source' line", name, lnum)
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return loc.Errorf("%s:%d: Invalid counter line '%s'", name, lnum, line)
+		}
+		p, err := parsePoint(fields[:len(fields)-1])
+		if err != nil {
+			return loc.Errorf("%s:%d: %s", name, lnum, err.Error())
+		}
+		c, err := strconv.ParseUint(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			return loc.Errorf("%s:%d: Invalid count '%s'", name, lnum, fields[len(fields)-1])
+		}
+		counts[p] += c
+	}
+	return s.Err()
+}
+
+func (b *profileBuilder) build() *Profile {
+	prof := &Profile{make([]*FileProfile, 0, len(b.files))}
+	for src, counts := range b.files {
+		f := &FileProfile{src, make([]*Counter, 0, len(counts))}
+		for p, c := range counts {
+			f.Counters = append(f.Counters, &Counter{p, c})
+		}
+		sort.Slice(f.Counters, func(i, j int) bool {
+			l, r := f.Counters[i].Point, f.Counters[j].Point
+			if l.Line != r.Line {
+				return l.Line < r.Line
+			}
+			if l.Column != r.Column {
+				return l.Column < r.Column
+			}
+			return l.Kind < r.Kind
+		})
+		prof.Files = append(prof.Files, f)
+	}
+	sort.Slice(prof.Files, func(i, j int) bool {
+		return prof.Files[i].Source < prof.Files[j].Source
+	})
+	return prof
+}
+
+// ParseProfile parses profile content. name is used for error messages.
+func ParseProfile(r io.Reader, name string) (*Profile, error) {
+	b := &profileBuilder{map[string]map[Point]uint64{}}
+	if err := b.parse(r, name); err != nil {
+		return nil, err
+	}
+	return b.build(), nil
+}
+
+// LoadProfiles reads profile files and merges them into one profile.
+func LoadProfiles(paths []string) (*Profile, error) {
+	b := &profileBuilder{map[string]map[Point]uint64{}}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = b.parse(f, path)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.build(), nil
+}
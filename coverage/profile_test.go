@@ -0,0 +1,124 @@
+package coverage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseProfile(t *testing.T) {
+	input := `source /path/to/b.ml
+func 1:1 f$t1 3
+then 2:5 1
+else 3:5 2
+source /path/to/a.ml
+func 4:1 g$t2 0
+source /path/to/b.ml
+else 3:5 4
+then 2:5 0
+func 1:1 f$t1 1
+`
+	prof, err := ParseProfile(strings.NewReader(input), "test.cover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prof.Files) != 2 {
+		t.Fatalf("2 files should be in profile but got %d", len(prof.Files))
+	}
+	a, b := prof.Files[0], prof.Files[1]
+	if a.Source != "/path/to/a.ml" || b.Source != "/path/to/b.ml" {
+		t.Fatalf("Files should be sorted by their paths: %s, %s", a.Source, b.Source)
+	}
+
+	actual := []string{}
+	for _, c := range b.Counters {
+		actual = append(actual, c.String())
+	}
+	if s := strings.Join(actual, ","); s != "func 1:1 f$t1,then 2:5,else 3:5" {
+		t.Fatalf("Counters should be sorted by their positions: %s", s)
+	}
+	for i, expected := range []uint64{4, 1, 6} {
+		if c := b.Counters[i]; c.Count != expected {
+			t.Errorf("Count of '%s' should be summed up to %d but got %d", c.String(), expected, c.Count)
+		}
+	}
+	if name := b.Counters[0].DisplayName(); name != "f" {
+		t.Errorf("Display name of function should be 'f' but got '%s'", name)
+	}
+}
+
+func TestPointDisplayName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{"f$t1", "f"},
+		{"lambda.line3.col9$t4", "lambda:3:9"},
+		{"$k8", "$k8"},
+	} {
+		p := Point{FUNC, 1, 1, tc.name}
+		if actual := p.DisplayName(); actual != tc.expected {
+			t.Errorf("Display name of '%s' should be '%s' but got '%s'", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestParseInvalidProfile(t *testing.T) {
+	for _, tc := range []struct {
+		what     string
+		input    string
+		expected string
+	}{
+		{"no source", "func 1:1 f 0\n", "test.cover:1: Counter appears before 'source' line"},
+		{"too few fields", "source a.ml\nthen 1\n", "test.cover:2: Invalid counter line 'then 1'"},
+		{"unknown kind", "source a.ml\nloop 1:1 0\n", "Unknown kind of counter 'loop'"},
+		{"func without name", "source a.ml\nfunc 1:1 0\n", "'func' counter requires position and name"},
+		{"branch with name", "source a.ml\nthen 1:1 f 0\n", "'then' counter requires only position"},
+		{"invalid position", "source a.ml\nthen 1 0\n", "Position must be in form 'line:column' but got '1'"},
+		{"invalid line", "source a.ml\nthen x:1 0\n", "Invalid line 'x'"},
+		{"invalid column", "source a.ml\nthen 1:y 0\n", "Invalid column 'y'"},
+		{"invalid count", "source a.ml\nthen 1:1 -1\n", "Invalid count '-1'"},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			_, err := ParseProfile(strings.NewReader(tc.input), "test.cover")
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error message '%s' to contain '%s'", err.Error(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gocaml-coverage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths := []string{filepath.Join(dir, "1.cover"), filepath.Join(dir, "2.cover")}
+	for i, content := range []string{"source a.ml\nthen 1:1 1\n", "source a.ml\nthen 1:1 2\nelse 2:1 3\n"} {
+		if err := ioutil.WriteFile(paths[i], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prof, err := LoadProfiles(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prof.Files) != 1 || len(prof.Files[0].Counters) != 2 {
+		t.Fatalf("Profiles were not merged: %+v", prof.Files)
+	}
+	if c := prof.Files[0].Counters[0]; c.Count != 3 {
+		t.Errorf("Counts in profiles should be summed up but got %d", c.Count)
+	}
+
+	if _, err := LoadProfiles([]string{filepath.Join(dir, "unknown.cover")}); err == nil {
+		t.Fatal("Error did not occur for unknown file")
+	}
+}
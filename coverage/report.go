@@ -0,0 +1,275 @@
+package coverage
+
+import (
+	"fmt"
+	"github.com/rhysd/loc"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+type LineStatus int
+
+const (
+	// No counter is at the line
+	NOT_INSTRUMENTED LineStatus = iota
+	// All counters at the line were executed
+	COVERED
+	// Some counters at the line were executed but others were not
+	PARTIAL
+	// No counter at the line was executed
+	UNCOVERED
+)
+
+var lineStatusClasses = [...]string{
+	NOT_INSTRUMENTED: "none",
+	COVERED:          "covered",
+	PARTIAL:          "partial",
+	UNCOVERED:        "uncovered",
+}
+
+// Line is a line of source with counters at the line.
+type Line struct {
+	Number   int
+	Text     string
+	Counters []*Counter
+}
+
+// Count returns the maximum count of counters at the line.
+func (l *Line) Count() uint64 {
+	var max uint64
+	for _, c := range l.Counters {
+		if c.Count > max {
+			max = c.Count
+		}
+	}
+	return max
+}
+
+func (l *Line) Status() LineStatus {
+	if len(l.Counters) == 0 {
+		return NOT_INSTRUMENTED
+	}
+	executed := 0
+	for _, c := range l.Counters {
+		if c.Count > 0 {
+			executed++
+		}
+	}
+	switch executed {
+	case 0:
+		return UNCOVERED
+	case len(l.Counters):
+		return COVERED
+	default:
+		return PARTIAL
+	}
+}
+
+// Lines splits the source code into lines and maps counters to them.
+func (f *FileProfile) Lines(code []byte) []*Line {
+	texts := strings.Split(strings.TrimSuffix(string(code), "\n"), "\n")
+	lines := make([]*Line, 0, len(texts))
+	for i, t := range texts {
+		lines = append(lines, &Line{i + 1, strings.TrimRight(t, "\r"), nil})
+	}
+	for _, c := range f.Counters {
+		if 0 < c.Line && c.Line <= len(lines) {
+			l := lines[c.Line-1]
+			l.Counters = append(l.Counters, c)
+		}
+	}
+	return lines
+}
+
+// Summary is the number of counted points and executed points.
+type Summary struct {
+	Funcs           int
+	CoveredFuncs    int
+	Branches        int
+	CoveredBranches int
+}
+
+func (s *Summary) add(other Summary) {
+	s.Funcs += other.Funcs
+	s.CoveredFuncs += other.CoveredFuncs
+	s.Branches += other.Branches
+	s.CoveredBranches += other.CoveredBranches
+}
+
+func percentage(covered, total int) string {
+	if total == 0 {
+		return fmt.Sprintf("%d/%d (-)", covered, total)
+	}
+	return fmt.Sprintf("%d/%d (%.1f%%)", covered, total, float64(covered)/float64(total)*100)
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("functions: %s, branches: %s", percentage(s.CoveredFuncs, s.Funcs), percentage(s.CoveredBranches, s.Branches))
+}
+
+func (f *FileProfile) Summary() Summary {
+	var s Summary
+	for _, c := range f.Counters {
+		executed := 0
+		if c.Count > 0 {
+			executed = 1
+		}
+		if c.Kind == FUNC {
+			s.Funcs++
+			s.CoveredFuncs += executed
+		} else {
+			s.Branches++
+			s.CoveredBranches += executed
+		}
+	}
+	return s
+}
+
+// Summary returns the summary of all files in the profile.
+func (prof *Profile) Summary() Summary {
+	var s Summary
+	for _, f := range prof.Files {
+		s.add(f.Summary())
+	}
+	return s
+}
+
+// UncoveredFuncs returns counters of functions which were never called.
+func (f *FileProfile) UncoveredFuncs() []*Counter {
+	cs := []*Counter{}
+	for _, c := range f.Counters {
+		if c.Kind == FUNC && c.Count == 0 {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func (f *FileProfile) readLines() ([]*Line, error) {
+	code, err := ioutil.ReadFile(f.Source)
+	if err != nil {
+		return nil, loc.Notef(err, "Cannot read source '%s' in coverage profile", f.Source)
+	}
+	return f.Lines(code), nil
+}
+
+// WriteText writes the summary and annotated listing of each source file in plain text. Each line
+// is prefixed with its count. Lines which were never executed are marked with '#####' and lines
+// where some branches were not executed are marked with '*' after their counts. Lines without
+// counters are marked with '-'.
+func WriteText(out io.Writer, prof *Profile) error {
+	for _, f := range prof.Files {
+		lines, err := f.readLines()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "===== %s =====\n", f.Source)
+		fmt.Fprintln(out, f.Summary().String())
+		for _, c := range f.UncoveredFuncs() {
+			fmt.Fprintf(out, "uncovered function: %s (line %d)\n", c.DisplayName(), c.Line)
+		}
+		fmt.Fprintln(out)
+		for _, l := range lines {
+			count := "-"
+			switch l.Status() {
+			case COVERED:
+				count = fmt.Sprint(l.Count())
+			case PARTIAL:
+				count = fmt.Sprintf("%d*", l.Count())
+			case UNCOVERED:
+				count = "#####"
+			}
+			fmt.Fprintf(out, "%9s:%5d: %s\n", count, l.Number, l.Text)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "total: %s\n", prof.Summary().String())
+	return nil
+}
+
+var htmlReport = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>GoCaml coverage report</title>
+<style>
+body { font-family: sans-serif; }
+table.source { border-collapse: collapse; font-family: monospace; }
+table.source td { padding: 0 8px; white-space: pre; }
+td.num, td.count { text-align: right; color: #888; }
+tr.covered td.code { background-color: #d7f5d7; }
+tr.partial td.code { background-color: #f7efc4; }
+tr.uncovered td.code { background-color: #f7d0d0; }
+</style>
+</head>
+<body>
+<h1>GoCaml coverage report</h1>
+<p>Total: {{.Summary}}</p>
+<ul>
+{{- range .Files}}
+<li><a href="#file{{.Index}}">{{.Source}}</a>: {{.Summary}}</li>
+{{- end}}
+</ul>
+{{- range .Files}}
+<h2 id="file{{.Index}}">{{.Source}}</h2>
+<p>{{.Summary}}</p>
+<table class="source">
+{{- range .Lines}}
+<tr class="{{.Class}}"{{if .Title}} title="{{.Title}}"{{end}}><td class="num">{{.Number}}</td><td class="count">{{.Count}}</td><td class="code">{{.Text}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+type htmlLine struct {
+	Number int
+	Count  string
+	Class  string
+	Title  string // Counts of each counter at the line
+	Text   string
+}
+
+type htmlFile struct {
+	Index   int
+	Source  string
+	Summary string
+	Lines   []htmlLine
+}
+
+// WriteHTML writes the summary and annotated listing of each source file as HTML document. Lines
+// are colored by their status and counts of counters at each line are shown as tooltips.
+func WriteHTML(out io.Writer, prof *Profile) error {
+	files := make([]htmlFile, 0, len(prof.Files))
+	for i, f := range prof.Files {
+		lines, err := f.readLines()
+		if err != nil {
+			return err
+		}
+		hf := htmlFile{i, f.Source, f.Summary().String(), make([]htmlLine, 0, len(lines))}
+		for _, l := range lines {
+			hl := htmlLine{Number: l.Number, Class: lineStatusClasses[l.Status()], Text: l.Text}
+			if len(l.Counters) > 0 {
+				hl.Count = fmt.Sprint(l.Count())
+				titles := make([]string, 0, len(l.Counters))
+				for _, c := range l.Counters {
+					what := c.Kind.String()
+					if c.Kind == FUNC {
+						what += " " + c.DisplayName()
+					}
+					titles = append(titles, fmt.Sprintf("%s: %d", what, c.Count))
+				}
+				hl.Title = strings.Join(titles, ", ")
+			}
+			hf.Lines = append(hf.Lines, hl)
+		}
+		files = append(files, hf)
+	}
+	return htmlReport.Execute(out, struct {
+		Summary string
+		Files   []htmlFile
+	}{prof.Summary().String(), files})
+}
@@ -0,0 +1,151 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSource = `let rec abs x = if x < 0 then -x else x in
+let rec sign x =
+  if x < 0 then
+    -1
+  else
+    1 in
+let rec unused x = x + 1 in
+println_int (abs 3)
+`
+
+func testProfile(t *testing.T) (*Profile, func()) {
+	dir, err := ioutil.TempDir("", "gocaml-coverage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "test.ml")
+	if err := ioutil.WriteFile(src, []byte(testSource), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	profile := fmt.Sprintf(`source %s
+func 1:1 abs$t1 1
+then 1:31 0
+else 1:39 1
+func 2:1 sign$t3 2
+then 4:5 2
+else 6:5 0
+func 7:1 unused$t5 0
+`, src)
+	prof, err := ParseProfile(strings.NewReader(profile), "test.cover")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return prof, func() { os.RemoveAll(dir) }
+}
+
+func TestLineStatus(t *testing.T) {
+	prof, cleanup := testProfile(t)
+	defer cleanup()
+
+	lines := prof.Files[0].Lines([]byte(testSource))
+	if len(lines) != 8 {
+		t.Fatalf("Source should have 8 lines but got %d", len(lines))
+	}
+	for i, expected := range []struct {
+		status LineStatus
+		count  uint64
+	}{
+		{PARTIAL, 1},
+		{COVERED, 2},
+		{NOT_INSTRUMENTED, 0},
+		{COVERED, 2},
+		{NOT_INSTRUMENTED, 0},
+		{UNCOVERED, 0},
+		{UNCOVERED, 0},
+		{NOT_INSTRUMENTED, 0},
+	} {
+		l := lines[i]
+		if l.Status() != expected.status || l.Count() != expected.count {
+			t.Errorf("Line %d should be status=%d count=%d but got status=%d count=%d", l.Number, expected.status, expected.count, l.Status(), l.Count())
+		}
+	}
+
+	s := prof.Summary()
+	if s.Funcs != 3 || s.CoveredFuncs != 2 || s.Branches != 4 || s.CoveredBranches != 2 {
+		t.Fatalf("Unexpected summary: %+v", s)
+	}
+	if str := s.String(); str != "functions: 2/3 (66.7%), branches: 2/4 (50.0%)" {
+		t.Fatalf("Unexpected summary string: %s", str)
+	}
+	if str := (Summary{}).String(); str != "functions: 0/0 (-), branches: 0/0 (-)" {
+		t.Fatalf("Unexpected summary string for empty summary: %s", str)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	prof, cleanup := testProfile(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, prof); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`===== %s =====
+functions: 2/3 (66.7%%), branches: 2/4 (50.0%%)
+uncovered function: unused (line 7)
+
+       1*:    1: let rec abs x = if x < 0 then -x else x in
+        2:    2: let rec sign x =
+        -:    3:   if x < 0 then
+        2:    4:     -1
+        -:    5:   else
+    #####:    6:     1 in
+    #####:    7: let rec unused x = x + 1 in
+        -:    8: println_int (abs 3)
+
+total: functions: 2/3 (66.7%%), branches: 2/4 (50.0%%)
+`, prof.Files[0].Source)
+	if actual := buf.String(); actual != expected {
+		t.Fatalf("Unexpected text report.\n\nExpected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	prof, cleanup := testProfile(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, prof); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	for _, expected := range []string{
+		"<p>Total: functions: 2/3 (66.7%), branches: 2/4 (50.0%)</p>",
+		`<tr class="partial" title="func abs: 1, then: 0, else: 1"><td class="num">1</td><td class="count">1</td>`,
+		`<tr class="none"><td class="num">3</td><td class="count"></td><td class="code">  if x &lt; 0 then</td></tr>`,
+		`<tr class="uncovered" title="func unused: 0"><td class="num">7</td><td class="count">0</td>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("HTML report should contain '%s':\n%s", expected, html)
+		}
+	}
+}
+
+func TestReportMissingSource(t *testing.T) {
+	prof, err := ParseProfile(strings.NewReader("source /path/to/unknown.ml\nthen 1:1 0\n"), "test.cover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = WriteText(&buf, prof)
+	if err == nil {
+		t.Fatal("Error did not occur")
+	}
+	if !strings.Contains(err.Error(), "Cannot read source '/path/to/unknown.ml' in coverage profile") {
+		t.Fatal("Unexpected error:", err)
+	}
+}
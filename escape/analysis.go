@@ -0,0 +1,229 @@
+// Package escape provides escape analysis for closure-transformed GCIL program.
+//
+// Tuples and closures are allocated in heap memory with GC_malloc() by default.
+// But when the allocated value is never used after its function returns, it can be
+// allocated in the stack frame of the function instead. Escape analysis finds such values
+// and marks them with 'OnStack' flag. Code generator refers the flag to decide where the
+// value should be allocated.
+//
+// The analysis is conservative. A value escapes when it is
+//
+//   - returned from the function
+//   - stored into an array (arrays are always allocated in heap)
+//   - passed to an external function or an unknown closure
+//   - passed to a known function which makes the parameter escape
+//   - contained in (or captured by) other value which escapes. Payload of option value is
+//     treated in the same way as an element of tuple
+//
+// Since a function may call other functions recursively, summaries for all toplevel
+// functions are calculated repeatedly until they reach a fixed point.
+package escape
+
+import (
+	"github.com/rhysd/gocaml/gcil"
+)
+
+// Summary of a function. Each element is true when the corresponding parameter or
+// captured variable escapes from the function. 'self' is true when the closure object of
+// the function escapes in its own body.
+type summary struct {
+	params   []bool
+	captures []bool
+	self     bool
+}
+
+func (s *summary) equals(other *summary) bool {
+	if s.self != other.self {
+		return false
+	}
+	for i, p := range s.params {
+		if other.params[i] != p {
+			return false
+		}
+	}
+	for i, c := range s.captures {
+		if other.captures[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+type analysis struct {
+	prog      *gcil.Program
+	summaries map[string]*summary
+	// Edges of the flow graph. When the key escapes, all values in the mapped slice also escape.
+	flows map[string][]string
+	// Values escaping without any condition
+	roots []string
+}
+
+func (a *analysis) flow(from string, to ...string) {
+	a.flows[from] = append(a.flows[from], to...)
+}
+
+func (a *analysis) escapeAll(idents []string) {
+	a.roots = append(a.roots, idents...)
+}
+
+func (a *analysis) app(val *gcil.App) {
+	// Result of function call may contain the elements of the arguments. But parameters
+	// returned from callee are treated as escaped in summary of the callee. So we don't
+	// need to consider the returned value here.
+	if val.Kind == gcil.EXTERNAL_CALL {
+		a.escapeAll(val.Args)
+		return
+	}
+	s, ok := a.summaries[val.Callee]
+	if !ok {
+		// Callee is a closure passed as variable. We know nothing about it.
+		a.escapeAll(val.Args)
+		return
+	}
+	for i, arg := range val.Args {
+		// Over-saturated call never happens in programs from source, but malformed GCIL read by
+		// gcil.Parse may contain it. Extra arguments are treated as escaping conservatively.
+		if i >= len(s.params) || s.params[i] {
+			a.roots = append(a.roots, arg)
+		}
+	}
+}
+
+func (a *analysis) insn(insn *gcil.Insn) {
+	switch val := insn.Val.(type) {
+	case *gcil.Ref:
+		a.flow(insn.Ident, val.Ident)
+	case *gcil.If:
+		a.block(val.Then)
+		a.block(val.Else)
+		a.flow(insn.Ident, val.Then.Bottom.Prev.Ident, val.Else.Bottom.Prev.Ident)
+	case *gcil.Fun:
+		panic("unreachable because IR was closure-transformed")
+	case *gcil.App:
+		a.app(val)
+	case *gcil.Tuple:
+		a.flow(insn.Ident, val.Elems...)
+	case *gcil.Array:
+		a.roots = append(a.roots, val.Elem)
+	case *gcil.TplLoad:
+		a.flow(insn.Ident, val.From)
+	case *gcil.ArrStore:
+		a.roots = append(a.roots, val.Rhs)
+	case *gcil.Some:
+		a.flow(insn.Ident, val.Elem)
+	case *gcil.DerefSome:
+		a.flow(insn.Ident, val.SomeVal)
+	case *gcil.MakeCls:
+		a.flow(insn.Ident, val.Vars...)
+		if s, ok := a.summaries[val.Fun]; ok {
+			if s.self {
+				a.roots = append(a.roots, insn.Ident)
+			}
+			for i, v := range val.Vars {
+				if s.captures[i] {
+					a.roots = append(a.roots, v)
+				}
+			}
+		}
+	}
+}
+
+func (a *analysis) block(block *gcil.Block) {
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		a.insn(i)
+	}
+}
+
+// Analyzes the block and returns the set of escaping identifiers in it
+func (a *analysis) escapes(block *gcil.Block, returned bool) map[string]struct{} {
+	a.flows = map[string][]string{}
+	a.roots = []string{}
+	a.block(block)
+	if returned {
+		a.roots = append(a.roots, block.Bottom.Prev.Ident)
+	}
+
+	escaped := make(map[string]struct{}, len(a.roots))
+	stack := a.roots
+	for len(stack) > 0 {
+		last := len(stack) - 1
+		ident := stack[last]
+		stack = stack[:last]
+		if _, ok := escaped[ident]; ok {
+			continue
+		}
+		escaped[ident] = struct{}{}
+		stack = append(stack, a.flows[ident]...)
+	}
+	return escaped
+}
+
+func (a *analysis) summarize(name string, fun *gcil.Fun) *summary {
+	escaped := a.escapes(fun.Body, true)
+	captures := a.prog.Closures[name]
+	_, self := escaped[name]
+	s := &summary{make([]bool, len(fun.Params)), make([]bool, len(captures)), self}
+	for i, p := range fun.Params {
+		_, s.params[i] = escaped[p]
+	}
+	// When the closure itself escapes in its body, all its captures also escape.
+	for i, c := range captures {
+		_, ok := escaped[c]
+		s.captures[i] = ok || self
+	}
+	return s
+}
+
+func (a *analysis) decide(block *gcil.Block, returned bool) {
+	escaped := a.escapes(block, returned)
+	decideBlock(block, escaped)
+}
+
+func decideBlock(block *gcil.Block, escaped map[string]struct{}) {
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		_, ok := escaped[i.Ident]
+		switch val := i.Val.(type) {
+		case *gcil.Tuple:
+			val.OnStack = !ok
+		case *gcil.MakeCls:
+			val.OnStack = !ok
+		case *gcil.If:
+			decideBlock(val.Then, escaped)
+			decideBlock(val.Else, escaped)
+		}
+	}
+}
+
+// Analyze does escape analysis for the given closure-transformed program.
+// Allocations which are proven not to escape from their functions are marked with
+// 'OnStack' flag.
+func Analyze(prog *gcil.Program) {
+	a := &analysis{prog, make(map[string]*summary, len(prog.Toplevel)), nil, nil}
+
+	// Start from the optimistic assumption that nothing escapes, then propagate
+	// escaping parameters and captures until no summary changes.
+	for name, f := range prog.Toplevel {
+		a.summaries[name] = &summary{
+			make([]bool, len(f.Val.Params)),
+			make([]bool, len(prog.Closures[name])),
+			false,
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, f := range prog.Toplevel {
+			s := a.summarize(name, f.Val)
+			if !s.equals(a.summaries[name]) {
+				a.summaries[name] = s
+				changed = true
+			}
+		}
+	}
+
+	for _, f := range prog.Toplevel {
+		a.decide(f.Val.Body, true)
+	}
+	a.decide(prog.Entry, false)
+}
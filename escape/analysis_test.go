@@ -0,0 +1,259 @@
+package escape
+
+import (
+	"bytes"
+	"github.com/rhysd/gocaml/alpha"
+	"github.com/rhysd/gocaml/closure"
+	"github.com/rhysd/gocaml/gcil"
+	"github.com/rhysd/gocaml/lexer"
+	"github.com/rhysd/gocaml/parser"
+	"github.com/rhysd/gocaml/typing"
+	"github.com/rhysd/loc"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	cases := []struct {
+		what     string
+		code     string
+		expected []string
+	}{
+		{
+			what: "tuple only used locally",
+			code: "let (a, b) = (1, 2) in print_int (a + b)",
+			expected: []string{
+				"tuple $k1,$k2 ; type=int * int ; alloc=stack",
+			},
+		},
+		{
+			what: "tuple returned from function",
+			code: "let rec f x = (x, x) in let (a, _) = f 1 in print_int a",
+			expected: []string{
+				"tuple x$t2,x$t2 ; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "tuple stored in array",
+			code: "let a = Array.make 1 (1, 2) in ()",
+			expected: []string{
+				"tuple $k2,$k3 ; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "tuple passed to function which does not make it escape",
+			code: "let rec f p = let (a, b) = p in a + b in print_int (f (1, 2))",
+			expected: []string{
+				"; type=int * int ; alloc=stack",
+			},
+		},
+		{
+			what: "tuple passed to function which makes it escape",
+			code: "let rec f p = Some p in let o = f (1, 2) in ()",
+			expected: []string{
+				"; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "tuple contained in escaping tuple",
+			code: "let rec f x = (x, (x, x)) in f 1; ()",
+			expected: []string{
+				"tuple x$t2,x$t2 ; type=int * int ; alloc=heap",
+				"tuple x$t2,$k4 ; type=int * (int * int) ; alloc=heap",
+			},
+		},
+		{
+			what: "tuple returned via if expression",
+			code: "let rec f c = if c then (1, 2) else (3, 4) in f true; ()",
+			expected: []string{
+				"tuple $k2,$k3 ; type=int * int ; alloc=heap",
+				"tuple $k5,$k6 ; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "tuple escaping through option",
+			code: "let rec f x = let t = (x, x) in let o = Some t in o in f 1; ()",
+			expected: []string{
+				"t$t3 = tuple x$t2,x$t2 ; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "tuple literal in option only used locally",
+			code: "let o = Some (1, 2) in match o with Some p -> let (a, b) = p in print_int (a + b) | None -> ()",
+			expected: []string{
+				"tuple $k1,$k2 ; type=int * int ; alloc=stack",
+			},
+		},
+		{
+			what: "tuple literal in option returned from function",
+			code: "let rec f x = Some (x, x) in f 1; ()",
+			expected: []string{
+				"tuple x$t2,x$t2 ; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "tuple extracted from option and returned",
+			code: "let rec f x = let o = Some (x, x) in match o with Some p -> p | None -> (0, 0) in f 1; ()",
+			expected: []string{
+				"tuple x$t2,x$t2 ; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "tuple in option stored in array",
+			code: "let a = Array.make 1 (Some (1, 2)) in ()",
+			expected: []string{
+				"; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "closure in option only called locally",
+			code: "let x = 1 in let rec f a = a + x in let o = Some f in match o with Some g -> print_int (g 1) | None -> ()",
+			expected: []string{
+				"f$t2 = makecls (x$t1) f$t2 ; type=int -> int ; alloc=stack",
+			},
+		},
+		{
+			what: "closure in option returned from function",
+			code: "let rec f x = let rec g y = x + y in Some g in f 1; ()",
+			expected: []string{
+				"makecls (x$t2) g$t3 ; type=int -> int ; alloc=heap",
+			},
+		},
+		{
+			what: "closure only called locally",
+			code: "let x = 42 in let rec f a = a + x in print_int (f 1)",
+			expected: []string{
+				"f$t2 = makecls (x$t1) f$t2 ; type=int -> int ; alloc=stack",
+			},
+		},
+		{
+			what: "closure returned from function",
+			code: "let rec f x = let rec g y = x + y in g in print_int ((f 1) 2)",
+			expected: []string{
+				"makecls (x$t2) g$t3 ; type=int -> int ; alloc=heap",
+			},
+		},
+		{
+			what: "closure called in other function",
+			code: "let rec f g = g 1 in let x = 1 in let rec h a = a + x in print_int (f h)",
+			expected: []string{
+				"h$t4 = makecls (x$t3) h$t4 ; type=int -> int ; alloc=stack",
+			},
+		},
+		{
+			what: "closure passed to unknown closure",
+			code: "let rec f k g = k g in let x = 1 in let rec h a = a + x in print_int (f (fun g -> g 1) h)",
+			expected: []string{
+				"h$t5 = makecls (x$t4) h$t5 ; type=int -> int ; alloc=heap",
+			},
+		},
+		{
+			what: "captured tuple of non-escaping closure",
+			code: "let t = (1, 2) in let rec f a = let (x, y) = t in x + y + a in print_int (f 1)",
+			expected: []string{
+				"t$t1 = tuple $k1,$k2 ; type=int * int ; alloc=stack",
+				"makecls (t$t1) f$t2 ; type=int -> int ; alloc=stack",
+			},
+		},
+		{
+			what: "captured tuple returned from closure body",
+			code: "let t = (1, 2) in let rec f a = t in let (x, y) = f () in print_int x",
+			expected: []string{
+				"t$t1 = tuple $k1,$k2 ; type=int * int ; alloc=heap",
+			},
+		},
+		{
+			what: "recursive closure escaping in its own body",
+			code: "let x = 1 in let arr = Array.make 1 (fun y -> y) in let rec f a = (arr.(0) <- f; a + x) in print_int (f 1)",
+			expected: []string{
+				"f$t5 = makecls (arr$t4,x$t1) f$t5 ; type=int -> int ; alloc=heap",
+			},
+		},
+		{
+			what: "mutual recursion propagates escaping parameters",
+			code: "let rec f n p = if n = 0 then Some p else g (n - 1) p in let rec g n p = f n p in g 3 (1, 2); ()",
+			expected: []string{
+				"; type=int * int ; alloc=heap",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.what, func(t *testing.T) {
+			s := loc.NewDummySource(tc.code)
+			l := lexer.NewLexer(s)
+			go l.Lex()
+			root, err := parser.Parse(l.Tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = alpha.Transform(root.Root); err != nil {
+				t.Fatal(err)
+			}
+			env, err := typing.TypeInferernce(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ir, err := gcil.FromAST(root.Root, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gcil.ElimRefs(ir, env)
+			prog := closure.Transform(ir)
+			Analyze(prog)
+
+			var buf bytes.Buffer
+			prog.Println(&buf, env)
+			out := buf.String()
+			for _, e := range tc.expected {
+				if !strings.Contains(out, e) {
+					t.Errorf("Expected '%s' in output but not found. Output:\n%s", e, out)
+				}
+			}
+		})
+	}
+}
+
+func TestOverSaturatedCall(t *testing.T) {
+	s := loc.NewDummySource("let t = (1, 2) in let rec f x = x + 1 in print_int (f 1)")
+	l := lexer.NewLexer(s)
+	go l.Lex()
+	root, err := parser.Parse(l.Tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = alpha.Transform(root.Root); err != nil {
+		t.Fatal(err)
+	}
+	env, err := typing.TypeInferernce(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, err := gcil.FromAST(root.Root, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcil.ElimRefs(ir, env)
+	prog := closure.Transform(ir)
+
+	// Pass extra argument to 'f' as malformed GCIL given to -from-gcil may do
+	found := false
+	begin, end := prog.Entry.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		if app, ok := i.Val.(*gcil.App); ok && app.Callee == "f$t2" {
+			app.Args = append(app.Args, "t$t1")
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Call of 'f' was not found:", prog)
+	}
+
+	Analyze(prog)
+
+	var buf bytes.Buffer
+	prog.Println(&buf, env)
+	if out := buf.String(); !strings.Contains(out, "t$t1 = tuple $k1,$k2 ; type=int * int ; alloc=heap") {
+		t.Fatalf("Extra argument should escape. Output:\n%s", out)
+	}
+}
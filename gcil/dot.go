@@ -0,0 +1,253 @@
+package gcil
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rhysd/gocaml/typing"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Escapes the string to put it in quoted ID or label of DOT language.
+func dotEscape(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return strings.Replace(s, `"`, `\"`, -1)
+}
+
+// Lines of label are left-aligned with '\l'.
+func dotLines(lines []string) string {
+	escaped := make([]string, 0, len(lines))
+	for _, l := range lines {
+		escaped = append(escaped, dotEscape(l)+`\l`)
+	}
+	return strings.Join(escaped, "")
+}
+
+var appKindNames = [...]string{
+	DIRECT_CALL:   "direct",
+	CLOSURE_CALL:  "closure",
+	EXTERNAL_CALL: "external",
+}
+
+// Renders blocks as control flow graph. Each block is rendered as a cluster and its instructions
+// are split into nodes at 'if' instructions. Blocks of 'if' and 'fun' are nested clusters.
+type dotCFGWriter struct {
+	out   io.Writer
+	env   *typing.Env
+	count int
+	edges []string // Edges are written after all nodes are declared in their clusters
+}
+
+func (w *dotCFGWriter) newID(prefix string) string {
+	w.count++
+	return fmt.Sprintf("%s%d", prefix, w.count)
+}
+
+func (w *dotCFGWriter) edge(from, to, attrs string) {
+	if attrs != "" {
+		attrs = " [" + attrs + "]"
+	}
+	w.edges = append(w.edges, fmt.Sprintf("  %s -> %s%s;", from, to, attrs))
+}
+
+func (w *dotCFGWriter) insnLine(insn *Insn) string {
+	var buf bytes.Buffer
+	p := printer{w.env, &buf, ""}
+	p.printInsn(insn)
+	return buf.String()
+}
+
+// Writes the block as a cluster. It returns IDs of the first node and the last node of the block.
+func (w *dotCFGWriter) block(b *Block, label []string, indent string) (string, string) {
+	fmt.Fprintf(w.out, "%ssubgraph %s {\n", indent, w.newID("cluster_"))
+	inner := indent + "  "
+	fmt.Fprintf(w.out, "%slabel=\"%s\";\n", inner, dotLines(label))
+
+	var entry, cur string
+	var lines []string
+	var preds []string // Nodes which flow into the next node
+
+	open := func() {
+		if cur != "" {
+			return
+		}
+		cur = w.newID("n")
+		if entry == "" {
+			entry = cur
+		}
+		for _, p := range preds {
+			w.edge(p, cur, "")
+		}
+		preds = nil
+	}
+	flush := func() {
+		if len(lines) == 0 {
+			// Join point after 'if' at the end of block
+			fmt.Fprintf(w.out, "%s%s [shape=point];\n", inner, cur)
+		} else {
+			fmt.Fprintf(w.out, "%s%s [label=\"%s\"];\n", inner, cur, dotLines(lines))
+		}
+		preds = []string{cur}
+		cur = ""
+		lines = nil
+	}
+
+	begin, end := b.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		open()
+		lines = append(lines, w.insnLine(i))
+		switch val := i.Val.(type) {
+		case *If:
+			from := cur
+			flush()
+			thenEntry, thenExit := w.block(val.Then, []string{val.Then.Name}, inner)
+			elseEntry, elseExit := w.block(val.Else, []string{val.Else.Name}, inner)
+			w.edge(from, thenEntry, `label="then"`)
+			w.edge(from, elseEntry, `label="else"`)
+			preds = []string{thenExit, elseExit}
+		case *Fun:
+			bodyEntry, _ := w.block(val.Body, []string{val.Body.Name}, inner)
+			w.edge(cur, bodyEntry, `style=dashed, label="body"`)
+		}
+	}
+	open()
+	flush()
+
+	fmt.Fprintf(w.out, "%s}\n", indent)
+	return entry, preds[0]
+}
+
+// DumpDOT prints control flow graph of the program in DOT language of Graphviz.
+// Each block is rendered as a cluster and nested blocks of 'if' and 'fun' are rendered as nested
+// clusters. Captures of closures are shown in labels of toplevel functions.
+func (prog *Program) DumpDOT(out io.Writer, env *typing.Env) {
+	w := &dotCFGWriter{out, env, 0, nil}
+
+	fmt.Fprintln(out, "digraph gcil {")
+	fmt.Fprintln(out, `  node [shape=box, fontname="monospace"];`)
+	fmt.Fprintln(out, `  graph [fontname="monospace", labeljust=l];`)
+
+	for _, name := range prog.Toplevel.SortedNames() {
+		f := prog.Toplevel[name]
+		label := []string{w.insnLine(NewInsn(name, f.Val, f.Pos))}
+		if captures, ok := prog.Closures[name]; ok {
+			label = append(label, fmt.Sprintf("captures: (%s)", strings.Join(captures, ",")))
+		}
+		w.block(f.Val.Body, label, "  ")
+	}
+	w.block(prog.Entry, []string{prog.Entry.Name}, "  ")
+
+	for _, e := range w.edges {
+		fmt.Fprintln(out, e)
+	}
+	fmt.Fprintln(out, "}")
+}
+
+type callEdge struct {
+	caller string
+	callee string
+	kind   AppKind
+}
+
+// Collects call edges in the program. Before closure transform, nested functions are also
+// regarded as callers.
+type callGraphBuilder struct {
+	edges   map[callEdge]struct{}
+	callers []string
+}
+
+func (b *callGraphBuilder) block(caller string, block *Block) {
+	begin, end := block.WholeRange()
+	for i := begin; i != end; i = i.Next {
+		switch val := i.Val.(type) {
+		case *App:
+			b.edges[callEdge{caller, val.Callee, val.Kind}] = struct{}{}
+		case *If:
+			b.block(caller, val.Then)
+			b.block(caller, val.Else)
+		case *Fun:
+			b.callers = append(b.callers, i.Ident)
+			b.block(i.Ident, val.Body)
+		}
+	}
+}
+
+// DumpCallGraphDOT prints call graph of the program in DOT language of Graphviz.
+// Nodes are toplevel functions, the entry of program and called external symbols. Edges are
+// labeled with kinds of calls (direct, closure or external). Callees which are not functions
+// (e.g. closures passed as parameters) are rendered with dotted nodes.
+func (prog *Program) DumpCallGraphDOT(out io.Writer) {
+	const entry = "" // No identifier is empty
+	b := &callGraphBuilder{map[callEdge]struct{}{}, nil}
+	names := prog.Toplevel.SortedNames()
+	for _, n := range names {
+		b.block(n, prog.Toplevel[n].Val.Body)
+	}
+	b.block(entry, prog.Entry)
+
+	edges := make([]callEdge, 0, len(b.edges))
+	for e := range b.edges {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		l, r := edges[i], edges[j]
+		if l.caller != r.caller {
+			return l.caller < r.caller
+		}
+		if l.callee != r.callee {
+			return l.callee < r.callee
+		}
+		return l.kind < r.kind
+	})
+
+	// Node IDs are generated because names of external symbols may conflict with other names
+	ids := map[string]string{}
+	node := func(name, attrs string) {
+		if _, ok := ids[name]; ok {
+			return
+		}
+		id := fmt.Sprintf("n%d", len(ids)+1)
+		ids[name] = id
+		fmt.Fprintf(out, "  %s [%s];\n", id, attrs)
+	}
+	funcNode := func(name string) {
+		label := dotEscape(name)
+		style := ""
+		if captures, ok := prog.Closures[name]; ok {
+			label += `\ncaptures: (` + dotEscape(strings.Join(captures, ",")) + ")"
+			style = ", style=rounded"
+		}
+		node(name, fmt.Sprintf(`label="%s"%s`, label, style))
+	}
+
+	fmt.Fprintln(out, "digraph callgraph {")
+	fmt.Fprintln(out, `  node [shape=box, fontname="monospace"];`)
+	fmt.Fprintln(out, `  edge [fontname="monospace"];`)
+
+	node(entry, `label="program", shape=doubleoctagon`)
+	for _, n := range names {
+		funcNode(n)
+	}
+	for _, n := range b.callers {
+		funcNode(n)
+	}
+	for _, e := range edges {
+		if e.kind == EXTERNAL_CALL {
+			node("x:"+e.callee, fmt.Sprintf(`label="%s", shape=ellipse, style=dashed`, dotEscape(e.callee)))
+		} else {
+			// When the callee is not a function, it is a function value like a closure received as
+			// parameter. Nodes for functions were already declared.
+			node(e.callee, fmt.Sprintf(`label="%s", shape=ellipse, style=dotted`, dotEscape(e.callee)))
+		}
+	}
+
+	for _, e := range edges {
+		callee := e.callee
+		if e.kind == EXTERNAL_CALL {
+			callee = "x:" + callee
+		}
+		fmt.Fprintf(out, "  %s -> %s [label=\"%s\"];\n", ids[e.caller], ids[callee], appKindNames[e.kind])
+	}
+	fmt.Fprintln(out, "}")
+}
@@ -0,0 +1,146 @@
+package gcil
+
+import (
+	"bytes"
+	"github.com/rhysd/gocaml/typing"
+	"github.com/rhysd/loc"
+	"strings"
+	"testing"
+)
+
+const dotTestProgram = `[TOPLEVELS (2)]
+f$t1 = fun x$t2 ; type=int -> int
+  BEGIN: body (f$t1)
+  $k1 = binary < x$t2 a$t3 ; type=bool
+  $k2 = if $k1 ; type=int
+    BEGIN: then
+    $k3 = app g$t4 x$t2 ; type=int
+    END: then
+    BEGIN: else
+    $k4 = appx print_int x$t2 ; type=()
+    $k5 = int 0 ; type=int
+    END: else
+  END: body (f$t1)
+
+g$t4 = fun h$t5 ; type=int -> int
+  BEGIN: body (g$t4)
+  $k6 = unary - h$t5 ; type=int
+  END: body (g$t4)
+
+[CLOSURES (1)]
+f$t1:	a$t3
+
+[ENTRY]
+BEGIN: program
+a$t3 = int 42 ; type=int
+f$t1 = makecls (a$t3) f$t1 ; type=int -> int ; alloc=heap
+$k7 = appcls f$t1 a$t3 ; type=int
+END: program
+`
+
+func TestDumpDOT(t *testing.T) {
+	prog, env, err := Parse(loc.NewDummySource(dotTestProgram))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	prog.DumpDOT(&buf, env)
+	expected := `digraph gcil {
+  node [shape=box, fontname="monospace"];
+  graph [fontname="monospace", labeljust=l];
+  subgraph cluster_1 {
+    label="f$t1 = fun x$t2 ; type=int -> int\lcaptures: (a$t3)\l";
+    n2 [label="$k1 = binary < x$t2 a$t3 ; type=bool\l$k2 = if $k1 ; type=int\l"];
+    subgraph cluster_3 {
+      label="then\l";
+      n4 [label="$k3 = app g$t4 x$t2 ; type=int\l"];
+    }
+    subgraph cluster_5 {
+      label="else\l";
+      n6 [label="$k4 = appx print_int x$t2 ; type=()\l$k5 = int 0 ; type=int\l"];
+    }
+    n7 [shape=point];
+  }
+  subgraph cluster_8 {
+    label="g$t4 = fun h$t5 ; type=int -> int\l";
+    n9 [label="$k6 = unary - h$t5 ; type=int\l"];
+  }
+  subgraph cluster_10 {
+    label="program\l";
+    n11 [label="a$t3 = int 42 ; type=int\lf$t1 = makecls (a$t3) f$t1 ; type=int -> int ; alloc=heap\l$k7 = appcls f$t1 a$t3 ; type=int\l"];
+  }
+  n2 -> n4 [label="then"];
+  n2 -> n6 [label="else"];
+  n4 -> n7;
+  n6 -> n7;
+}
+`
+	if actual := buf.String(); actual != expected {
+		t.Fatalf("Unexpected DOT output.\n\nExpected:\n%s\n\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestDumpDOTNestedFunction(t *testing.T) {
+	env := typing.NewEnv()
+	env.Table["f$t1"] = &typing.Fun{typing.IntType, []typing.Type{typing.IntType}, nil}
+	env.Table["x$t2"] = typing.IntType
+	env.Table["$k1"] = typing.IntType
+	env.Table["$k2"] = typing.IntType
+	body := NewBlockFromArray("body (f$t1)", []*Insn{
+		NewInsn("$k1", &App{"f$t1", []string{"x$t2"}, DIRECT_CALL}, loc.Pos{}),
+	})
+	root := NewBlockFromArray("program", []*Insn{
+		NewInsn("f$t1", &Fun{[]string{"x$t2"}, body, true}, loc.Pos{}),
+		NewInsn("$k2", &Int{1}, loc.Pos{}),
+	})
+	prog := &Program{NewToplevel(), Closures{}, root}
+
+	var buf bytes.Buffer
+	prog.DumpDOT(&buf, env)
+	out := buf.String()
+	for _, s := range []string{
+		`label="body (f$t1)\l";`,
+		`n2 [label="f$t1 = recfun x$t2 ; type=int -> int\l$k2 = int 1 ; type=int\l"];`,
+		`n2 -> n4 [style=dashed, label="body"];`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Output does not contain '%s':\n%s", s, out)
+		}
+	}
+
+	buf.Reset()
+	prog.DumpCallGraphDOT(&buf)
+	out = buf.String()
+	for _, s := range []string{
+		`n2 [label="f$t1"];`,
+		`n2 -> n2 [label="direct"];`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Output does not contain '%s':\n%s", s, out)
+		}
+	}
+}
+
+func TestDumpCallGraphDOT(t *testing.T) {
+	prog, _, err := Parse(loc.NewDummySource(dotTestProgram))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	prog.DumpCallGraphDOT(&buf)
+	expected := `digraph callgraph {
+  node [shape=box, fontname="monospace"];
+  edge [fontname="monospace"];
+  n1 [label="program", shape=doubleoctagon];
+  n2 [label="f$t1\ncaptures: (a$t3)", style=rounded];
+  n3 [label="g$t4"];
+  n4 [label="print_int", shape=ellipse, style=dashed];
+  n1 -> n2 [label="closure"];
+  n2 -> n3 [label="direct"];
+  n2 -> n4 [label="external"];
+}
+`
+	if actual := buf.String(); actual != expected {
+		t.Fatalf("Unexpected DOT output.\n\nExpected:\n%s\n\nActual:\n%s", expected, actual)
+	}
+}
@@ -86,6 +86,9 @@ func (elim *elimRef) insn(insn *Insn) {
 		val.Rhs = elim.elimRef(val.Rhs)
 	case *ArrLen:
 		val.Array = elim.elimRef(val.Array)
+	case *StrLoad:
+		val.From = elim.elimRef(val.From)
+		val.Index = elim.elimRef(val.Index)
 	case *Some:
 		val.Elem = elim.elimRef(val.Elem)
 	case *IsSome:
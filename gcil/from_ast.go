@@ -67,6 +67,40 @@ func (e *emitter) emitLetInsn(node *ast.Let) *Insn {
 	return body
 }
 
+// Emits instructions to unwrap optional parameter at the top of function body. It returns the name
+// of parameter which receives option value.
+//
+// e.g. ?(x = 42)
+//
+//	$k1 = issome $k2
+//	x = if $k1
+//	  BEGIN: then
+//	  $k3 = derefsome $k2
+//	  END: then
+//	  BEGIN: else
+//	  $k4 = int 42
+//	  END: else
+func (e *emitter) emitDefaultParam(body *Block, param ast.Param) string {
+	pos := param.Default.Pos()
+	name := param.Ident.Name
+	ty := e.types.Table[name]
+
+	opt := e.genID()
+	e.types.Table[opt] = &typing.Option{ty}
+
+	cond := e.genID()
+	e.types.Table[cond] = typing.BoolType
+
+	deref := e.genID()
+	e.types.Table[deref] = ty
+	someBlk := NewBlockFromArray("then", []*Insn{NewInsn(deref, &DerefSome{opt}, pos)})
+	noneBlk, _ := e.emitBlock("else", param.Default)
+
+	body.Prepend(NewInsn(name, &If{cond, someBlk, noneBlk}, pos))
+	body.Prepend(NewInsn(cond, &IsSome{opt}, pos))
+	return opt
+}
+
 func (e *emitter) emitFunInsn(node *ast.LetRec) *Insn {
 	name := node.Func.Symbol.Name
 
@@ -83,6 +117,15 @@ func (e *emitter) emitFunInsn(node *ast.LetRec) *Insn {
 
 	blk, _ := e.emitBlock(fmt.Sprintf("body (%s)", name), node.Func.Body)
 
+	// Optional parameters with default values are passed as option values. Unwrap them at the
+	// beginning of the function body.
+	for i := len(node.Func.Params) - 1; i >= 0; i-- {
+		p := node.Func.Params[i]
+		if p.Default != nil {
+			params[i] = e.emitDefaultParam(blk, p)
+		}
+	}
+
 	val := &Fun{
 		params,
 		blk,
@@ -97,6 +140,261 @@ func (e *emitter) emitFunInsn(node *ast.LetRec) *Insn {
 	return body
 }
 
+// Emits a lambda for partial application. It captures the applied arguments and calls the callee
+// with them and its parameters.
+//
+// e.g. 'f a' where f is 'int -> int -> int'
+//
+//	$k3 = fun $k2
+//	  BEGIN: body ($k3)
+//	  $k1 = app f a,$k2
+//	  END: body ($k3)
+func (e *emitter) emitPartialAppInsn(callee string, args []string, fun *typing.Fun, pos loc.Pos) *Insn {
+	rest := fun.Params[len(args):]
+	params := make([]string, 0, len(rest))
+	for _, t := range rest {
+		p := e.genID()
+		e.types.Table[p] = t
+		params = append(params, p)
+	}
+
+	id := e.genID()
+	e.types.Table[id] = fun.Ret
+	allArgs := make([]string, 0, len(fun.Params))
+	allArgs = append(allArgs, args...)
+	allArgs = append(allArgs, params...)
+	app := NewInsn(id, &App{callee, allArgs, DIRECT_CALL}, pos)
+
+	name := e.genID()
+	e.types.Table[name] = &typing.Fun{fun.Ret, rest, nil}
+	body := NewBlockFromArray(fmt.Sprintf("body (%s)", name), []*Insn{app})
+	return NewInsn(name, &Fun{params, body, false}, pos)
+}
+
+// Functions are curried. Number of arguments may be different from the number of parameters of
+// callee. When arguments are less than parameters, a closure for partial application is created.
+// When arguments are more than parameters, the rest of arguments are applied to the returned
+// function.
+func (e *emitter) emitAppInsn(calleeID string, args []string, prev *Insn, pos loc.Pos) (typing.Type, Val, *Insn) {
+	for {
+		f, ok := e.types.Table[calleeID].(*typing.Fun)
+		if !ok {
+			panic(fmt.Sprintf("Callee of Apply node is not typed as function!: %s", e.types.Table[calleeID].String()))
+		}
+
+		if len(args) == len(f.Params) {
+			return f.Ret, &App{calleeID, args, DIRECT_CALL}, prev
+		}
+
+		if len(args) < len(f.Params) {
+			fun := e.emitPartialAppInsn(calleeID, args, f, pos)
+			fun.Append(prev)
+			return e.typeOf(fun), &Ref{fun.Ident}, fun
+		}
+
+		id := e.genID()
+		e.types.Table[id] = f.Ret
+		app := NewInsn(id, &App{calleeID, args[:len(f.Params)], DIRECT_CALL}, pos)
+		app.Append(prev)
+		prev = app
+		calleeID = id
+		args = args[len(f.Params):]
+	}
+}
+
+// Deprecated bitwise built-in functions which are aliases of operators
+var bitOperators = map[string]OperatorKind{
+	"bit_and":  LAND,
+	"bit_or":   LOR,
+	"bit_xor":  LXOR,
+	"bit_lsft": LSL,
+	"bit_rsft": ASR,
+}
+
+// Lowers a call of deprecated bitwise built-in function to its operator. The runtime functions are
+// only used when they are used as values (e.g. 'let f = bit_and in ...').
+func (e *emitter) emitBitOpInsn(node *ast.Apply) (typing.Type, Val, *Insn, bool) {
+	ref, ok := node.Callee.(*ast.VarRef)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	name := ref.Symbol.Name
+	if _, ok := e.types.Externals[name]; !ok {
+		return nil, nil, nil, false
+	}
+	if op, ok := bitOperators[name]; ok && len(node.Args) == 2 {
+		ty, val, prev := e.emitBinaryInsn(op, node.Args[0], node.Args[1])
+		return ty, val, prev, true
+	}
+	if name == "bit_inv" && len(node.Args) == 1 {
+		i := e.emitInsn(node.Args[0])
+		return e.typeOf(i), &Unary{LNOT, i.Ident}, i, true
+	}
+	return nil, nil, nil, false
+}
+
+func (e *emitter) emitApplyInsn(node *ast.Apply) (typing.Type, Val, *Insn) {
+	if ty, val, prev, ok := e.emitBitOpInsn(node); ok {
+		return ty, val, prev
+	}
+
+	callee := e.emitInsn(node.Callee)
+	prev := callee
+	args := make([]string, 0, len(node.Args))
+	for _, a := range node.Args {
+		arg := e.emitInsn(a)
+		arg.Append(prev)
+		args = append(args, arg.Ident)
+		prev = arg
+	}
+	return e.emitAppInsn(callee.Ident, args, prev, node.Pos())
+}
+
+// Runtime functions to format one value with the conversion specifier
+func formatFuncName(verb byte) string {
+	switch verb {
+	case 'd', 'i', 'x', 'X', 'o':
+		return "__format_int"
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		return "__format_float"
+	case 's':
+		return "__format_str"
+	case 'b':
+		return "__format_bool"
+	case 'c':
+		return "__format_char"
+	default:
+		panic(fmt.Sprintf("Unknown conversion '%c' in format string", verb))
+	}
+}
+
+func (e *emitter) emitXRefInsn(name string, prev *Insn, pos loc.Pos) *Insn {
+	t, ok := e.types.Externals[name]
+	if !ok {
+		panic(fmt.Sprintf("Runtime function '%s' not found in externals", name))
+	}
+	id := e.genID()
+	e.types.Table[id] = t
+	xref := NewInsn(id, &XRef{name}, pos)
+	xref.Append(prev)
+	return xref
+}
+
+// Emits a call to external function. 'prev' is linked before the emitted instructions.
+func (e *emitter) emitExternalCall(name string, args []string, prev *Insn, pos loc.Pos) *Insn {
+	xref := e.emitXRefInsn(name, prev, pos)
+	id := e.genID()
+	e.types.Table[id] = e.typeOf(xref).(*typing.Fun).Ret
+	app := NewInsn(id, &App{xref.Ident, args, DIRECT_CALL}, pos)
+	app.Append(xref)
+	return app
+}
+
+// Lowers printf and sprintf with the format string parsed in type inference. Each conversion
+// specifier is formatted by runtime function and all pieces are concatenated.
+//
+// e.g. 'sprintf "n=%d" n'
+//
+//	$k2 = "n="
+//	$k3 = "%d"
+//	$k4 = xref __format_int
+//	$k5 = app $k4 $k3,n
+//	$k6 = xref __format_concat
+//	$k7 = app $k6 $k2,$k5
+//
+// When some arguments are omitted, it is lowered to a lambda which receives the rest of arguments
+// in the same way as partial application of function.
+//
+// e.g. 'printf "%d %d" a'
+//
+//	$k9 = fun $k1
+//	  BEGIN: body ($k9)
+//	  ... (format a and $k1)
+//	  $k8 = app $k7 $k6
+//	  END: body ($k9)
+func (e *emitter) emitFormatInsn(node *ast.Apply, pieces []typing.FormatPiece) (typing.Type, Val, *Insn) {
+	pos := node.Pos()
+	var prev *Insn
+	args := make([]string, 0, len(node.Args)-1)
+	for _, a := range node.Args[1:] {
+		arg := e.emitInsn(a)
+		arg.Append(prev)
+		args = append(args, arg.Ident)
+		prev = arg
+	}
+
+	sprintf := node.Callee.(*ast.VarRef).Symbol.Name == "sprintf"
+
+	rest := []typing.Type{}
+	for _, p := range pieces {
+		if p.Verb != 0 {
+			rest = append(rest, p.ArgType())
+		}
+	}
+	rest = rest[len(args):]
+	if len(rest) == 0 {
+		return e.emitFormatPiecesInsn(pieces, args, sprintf, prev, pos)
+	}
+
+	params := make([]string, 0, len(rest))
+	for _, t := range rest {
+		p := e.genID()
+		e.types.Table[p] = t
+		params = append(params, p)
+	}
+
+	ty, val, last := e.emitFormatPiecesInsn(pieces, append(args, params...), sprintf, nil, pos)
+	id := e.genID()
+	e.types.Table[id] = ty
+	last = Concat(NewInsn(id, val, pos), last)
+
+	name := e.genID()
+	funTy := &typing.Fun{ty, rest, nil}
+	e.types.Table[name] = funTy
+	body := NewBlock(fmt.Sprintf("body (%s)", name), Reverse(last), last)
+	fun := NewInsn(name, &Fun{params, body, false}, pos)
+	fun.Append(prev)
+	return funTy, &Ref{name}, fun
+}
+
+// Emits instructions to format the pieces with the arguments. 'prev' is linked before the emitted
+// instructions.
+func (e *emitter) emitFormatPiecesInsn(pieces []typing.FormatPiece, args []string, sprintf bool, prev *Insn, pos loc.Pos) (typing.Type, Val, *Insn) {
+	emitStr := func(s string) {
+		id := e.genID()
+		e.types.Table[id] = typing.StringType
+		insn := NewInsn(id, &String{s}, pos)
+		insn.Append(prev)
+		prev = insn
+	}
+
+	result := ""
+	for _, p := range pieces {
+		emitStr(p.Text)
+		if p.Verb != 0 {
+			spec := prev.Ident
+			prev = e.emitExternalCall(formatFuncName(p.Verb), []string{spec, args[0]}, prev, pos)
+			args = args[1:]
+		}
+		if result != "" {
+			prev = e.emitExternalCall("__format_concat", []string{result, prev.Ident}, prev, pos)
+		}
+		result = prev.Ident
+	}
+
+	if result == "" {
+		emitStr("")
+		result = prev.Ident
+	}
+
+	if sprintf {
+		return typing.StringType, &Ref{result}, prev
+	}
+
+	xref := e.emitXRefInsn("print_str", prev, pos)
+	return typing.UnitType, &App{xref.Ident, []string{result}, DIRECT_CALL}, xref
+}
+
 func (e *emitter) emitMatchInsn(node *ast.Match) (typing.Type, Val, *Insn) {
 	pos := node.Pos()
 	matched := e.emitInsn(node.Target)
@@ -154,9 +452,9 @@ func (e *emitter) emitLessInsn(kind OperatorKind, lhs, rhs ast.Expr) (typing.Typ
 	operand, val, prev := e.emitBinaryInsn(kind, lhs, rhs)
 	// Note:
 	// This type constraint may be useful for type inference. But current HM type inference algorithm cannot
-	// handle a union type. In this context, the operand should be `int | float`
+	// handle a union type. In this context, the operand should be `int | float | char`
 	switch operand.(type) {
-	case *typing.Unit, *typing.Bool, *typing.String, *typing.Fun, *typing.Tuple, *typing.Array, *typing.Option:
+	case *typing.Unit, *typing.Bool, *typing.String, *typing.Fun, *typing.Tuple, *typing.Array, *typing.Option, *typing.Opaque, *typing.Hashtbl:
 		e.semanticError(fmt.Sprintf("'%s' can't be compared with operator '%s'", operand.String(), OpTable[kind]), lhs.Pos())
 	}
 	return typing.BoolType, val, prev
@@ -167,7 +465,8 @@ func (e *emitter) emitEqInsn(kind OperatorKind, lhs, rhs ast.Expr) (typing.Type,
 	// Note:
 	// This type constraint may be useful for type inference. But current HM type inference algorithm cannot
 	// handle a union type. In this context, the operand should be `() | bool | int | float | fun<R, TS...> | tuple<Args...>`
-	if _, ok := operand.(*typing.Array); ok {
+	switch operand.(type) {
+	case *typing.Array, *typing.Opaque, *typing.Hashtbl:
 		e.semanticError(fmt.Sprintf("'%s' can't be compared with operator '%s'", operand.String(), OpTable[kind]), lhs.Pos())
 	}
 	return typing.BoolType, val, prev
@@ -194,6 +493,9 @@ func (e *emitter) emitInsn(node ast.Expr) *Insn {
 	case *ast.String:
 		ty = typing.StringType
 		val = &String{n.Value}
+	case *ast.Char:
+		ty = typing.CharType
+		val = &Char{n.Value}
 	case *ast.Not:
 		i := e.emitInsn(n.Child)
 		ty, val = e.typeOf(i), &Unary{NOT, i.Ident}
@@ -216,6 +518,22 @@ func (e *emitter) emitInsn(node ast.Expr) *Insn {
 		ty, val, prev = e.emitBinaryInsn(DIV, n.Left, n.Right)
 	case *ast.Mod:
 		ty, val, prev = e.emitBinaryInsn(MOD, n.Left, n.Right)
+	case *ast.Lnot:
+		i := e.emitInsn(n.Child)
+		ty, val = e.typeOf(i), &Unary{LNOT, i.Ident}
+		prev = i
+	case *ast.Land:
+		ty, val, prev = e.emitBinaryInsn(LAND, n.Left, n.Right)
+	case *ast.Lor:
+		ty, val, prev = e.emitBinaryInsn(LOR, n.Left, n.Right)
+	case *ast.Lxor:
+		ty, val, prev = e.emitBinaryInsn(LXOR, n.Left, n.Right)
+	case *ast.Lsl:
+		ty, val, prev = e.emitBinaryInsn(LSL, n.Left, n.Right)
+	case *ast.Lsr:
+		ty, val, prev = e.emitBinaryInsn(LSR, n.Left, n.Right)
+	case *ast.Asr:
+		ty, val, prev = e.emitBinaryInsn(ASR, n.Left, n.Right)
 	case *ast.FAdd:
 		ty, val, prev = e.emitBinaryInsn(FADD, n.Left, n.Right)
 	case *ast.FSub:
@@ -259,27 +577,21 @@ func (e *emitter) emitInsn(node ast.Expr) *Insn {
 		} else if t, ok := e.types.Externals[n.Symbol.Name]; ok {
 			ty = t
 			val = &XRef{n.Symbol.Name}
+		} else if t, ok := e.types.Instances[n]; ok {
+			// Generic built-in function
+			ty = t
+			val = &XRef{n.Symbol.Name}
 		} else {
 			panic(fmt.Sprintf("Unknown identifier %s", n.Symbol.Name))
 		}
 	case *ast.LetRec:
 		return e.emitFunInsn(n)
 	case *ast.Apply:
-		callee := e.emitInsn(n.Callee)
-		prev = callee
-		args := make([]string, 0, len(n.Args))
-		for _, a := range n.Args {
-			arg := e.emitInsn(a)
-			arg.Append(prev)
-			args = append(args, arg.Ident)
-			prev = arg
-		}
-		val = &App{callee.Ident, args, DIRECT_CALL}
-		f, ok := e.typeOf(callee).(*typing.Fun)
-		if !ok {
-			panic(fmt.Sprintf("Callee of Apply node is not typed as function!: %s", e.typeOf(callee).String()))
+		if pieces, ok := e.types.Formats[n]; ok {
+			ty, val, prev = e.emitFormatInsn(n, pieces)
+		} else {
+			ty, val, prev = e.emitApplyInsn(n)
 		}
-		ty = f.Ret
 	case *ast.Tuple:
 		if len(n.Elems) == 0 {
 			panic("Tuple must not be empty!")
@@ -295,7 +607,7 @@ func (e *emitter) emitInsn(node ast.Expr) *Insn {
 			prev = elemInsn
 		}
 		ty = &typing.Tuple{types}
-		val = &Tuple{elems}
+		val = &Tuple{elems, false}
 	case *ast.LetTuple:
 		return e.emitLetTupleInsn(n)
 	case *ast.ArrayCreate:
@@ -318,8 +630,7 @@ func (e *emitter) emitInsn(node ast.Expr) *Insn {
 		val = &ArrLoad{array.Ident, index.Ident}
 	case *ast.Put:
 		array := e.emitInsn(n.Array)
-		arrayTy, ok := e.typeOf(array).(*typing.Array)
-		if !ok {
+		if _, ok := e.typeOf(array).(*typing.Array); !ok {
 			panic("'Put' node does not access to array!")
 		}
 		index := e.emitInsn(n.Index)
@@ -327,13 +638,20 @@ func (e *emitter) emitInsn(node ast.Expr) *Insn {
 		rhs := e.emitInsn(n.Assignee)
 		rhs.Append(index)
 		prev = rhs
-		ty = arrayTy.Elem
+		ty = typing.UnitType
 		val = &ArrStore{array.Ident, index.Ident, rhs.Ident}
 	case *ast.ArraySize:
 		array := e.emitInsn(n.Target)
 		prev = array
 		ty = typing.IntType
 		val = &ArrLen{array.Ident}
+	case *ast.StringGet:
+		str := e.emitInsn(n.String)
+		index := e.emitInsn(n.Index)
+		index.Append(str)
+		prev = index
+		ty = typing.CharType
+		val = &StrLoad{str.Ident, index.Ident}
 	case *ast.Some:
 		child := e.emitInsn(n.Child)
 		prev = child
@@ -95,6 +95,69 @@ func TestEmitInsn(t *testing.T) {
 				"binary /. $k10 $k11 ; type=float",
 			},
 		},
+		{
+			"bitwise op",
+			"lnot 1; 1 land 2; 1 lor 2; 1 lxor 2; 1 lsl 2; 1 lsr 2; 1 asr 2",
+			[]string{
+				"int 1 ; type=int",
+				"unary lnot $k1 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary land $k3 $k4 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary lor $k6 $k7 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary lxor $k9 $k10 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary lsl $k12 $k13 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary lsr $k15 $k16 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary asr $k18 $k19 ; type=int",
+			},
+		},
+		{
+			"deprecated bitwise functions",
+			"bit_inv 1; bit_and 1 2; bit_or 1 2; bit_xor 1 2; bit_lsft 1 2; bit_rsft 1 2",
+			[]string{
+				"int 1 ; type=int",
+				"unary lnot $k1 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary land $k3 $k4 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary lor $k6 $k7 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary lxor $k9 $k10 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary lsl $k12 $k13 ; type=int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"binary asr $k15 $k16 ; type=int",
+			},
+		},
+		{
+			"deprecated bitwise function as value",
+			"let f = bit_and in f 1 2; bit_and 1",
+			[]string{
+				"f$t1 = xref bit_and ; type=int -> int -> int",
+				"ref f$t1 ; type=int -> int -> int",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"app $k2 $k3,$k4 ; type=int",
+				"xref bit_and ; type=int -> int -> int",
+				"int 1 ; type=int",
+				"$k10 = fun $k8 ; type=int -> int",
+			},
+		},
 		{
 			"binary relational op",
 			"1 < 2; 1 = 2; 1 <= 2; 1 > 2; 1 >= 2; 1 <> 2",
@@ -171,6 +234,118 @@ func TestEmitInsn(t *testing.T) {
 				"app $k4 $k5 ; type=int",
 			},
 		},
+		{
+			"partial application",
+			"let rec f a b = a + b in f 1",
+			[]string{
+				"fun a$t2,b$t3 ; type=int -> int -> int",
+				"BEGIN: body (f$t1)",
+				"ref a$t2 ; type=int",
+				"ref b$t3 ; type=int",
+				"binary + $k1 $k2 ; type=int",
+				"END: body (f$t1)",
+				"ref f$t1 ; type=int -> int -> int",
+				"int 1 ; type=int",
+				"$k8 = fun $k6 ; type=int -> int",
+				"BEGIN: body ($k8)",
+				"app $k4 $k5,$k6 ; type=int",
+				"END: body ($k8)",
+				"ref $k8 ; type=int -> int",
+			},
+		},
+		{
+			"application with more arguments than parameters",
+			"let rec f a = fun b -> a + b in f 1 2",
+			[]string{
+				"fun a$t2 ; type=int -> (int -> int)",
+				"BEGIN: body (f$t1)",
+				"fun b$t4 ; type=int -> int",
+				"BEGIN: body (lambda.line1.col15$t3)",
+				"ref a$t2 ; type=int",
+				"ref b$t4 ; type=int",
+				"binary + $k1 $k2 ; type=int",
+				"END: body (lambda.line1.col15$t3)",
+				"ref lambda.line1.col15$t3 ; type=int -> int",
+				"END: body (f$t1)",
+				"ref f$t1 ; type=int -> (int -> int)",
+				"int 1 ; type=int",
+				"int 2 ; type=int",
+				"app $k5 $k6 ; type=int -> int",
+				"app $k8 $k7 ; type=int",
+			},
+		},
+		{
+			"labeled and optional arguments",
+			"let rec f ?(x = 1) y = x + y in f ~x:2 3",
+			[]string{
+				"fun $k4,y$t3 ; type=?x:int option -> int -> int",
+				"BEGIN: body (f$t1)",
+				"issome $k4 ; type=bool",
+				"x$t2 = if $k5 ; type=int",
+				"BEGIN: then",
+				"derefsome $k4 ; type=int",
+				"END: then",
+				"BEGIN: else",
+				"int 1 ; type=int",
+				"END: else",
+				"ref x$t2 ; type=int",
+				"ref y$t3 ; type=int",
+				"binary + $k1 $k2 ; type=int",
+				"END: body (f$t1)",
+				"ref f$t1 ; type=?x:int option -> int -> int",
+				"int 2 ; type=int",
+				"some $k9 ; type=int option",
+				"int 3 ; type=int",
+				"app $k8 $k10,$k11 ; type=int",
+			},
+		},
+		{
+			"printf with format string",
+			`printf "n=%d\n" 42`,
+			[]string{
+				"$k1 = int 42 ; type=int",
+				`$k2 = string "n=" ; type=string`,
+				`$k3 = string "%d" ; type=string`,
+				"$k4 = xref __format_int ; type=string -> int -> string",
+				"$k5 = app $k4 $k3,$k1 ; type=string",
+				"$k6 = xref __format_concat ; type=string -> string -> string",
+				"$k7 = app $k6 $k2,$k5 ; type=string",
+				`$k8 = string "\n" ; type=string`,
+				"$k9 = xref __format_concat ; type=string -> string -> string",
+				"$k10 = app $k9 $k7,$k8 ; type=string",
+				"$k11 = xref print_str ; type=string -> ()",
+				"app $k11 $k10 ; type=()",
+			},
+		},
+		{
+			"partially applied sprintf",
+			`sprintf "%d%s" 1`,
+			[]string{
+				"$k1 = int 1 ; type=int",
+				"$k12 = fun $k2 ; type=string -> string",
+				"BEGIN: body ($k12)",
+				`$k3 = string "%d" ; type=string`,
+				"$k4 = xref __format_int ; type=string -> int -> string",
+				"$k5 = app $k4 $k3,$k1 ; type=string",
+				`$k6 = string "%s" ; type=string`,
+				"$k7 = xref __format_str ; type=string -> string -> string",
+				"$k8 = app $k7 $k6,$k2 ; type=string",
+				"$k9 = xref __format_concat ; type=string -> string -> string",
+				"$k10 = app $k9 $k5,$k8 ; type=string",
+				"$k11 = ref $k10 ; type=string",
+				"END: body ($k12)",
+				"ref $k12 ; type=string -> string",
+			},
+		},
+		{
+			"sprintf without conversion",
+			`println_str (sprintf "100%%")`,
+			[]string{
+				"$k1 = xref println_str ; type=string -> ()",
+				`$k2 = string "100%" ; type=string`,
+				"ref $k2 ; type=string",
+			},
+		},
 		{
 			"tuple literal",
 			"(1, 2, 3)",
@@ -178,7 +353,7 @@ func TestEmitInsn(t *testing.T) {
 				"int 1 ; type=int",
 				"int 2 ; type=int",
 				"int 3 ; type=int",
-				"tuple $k1,$k2,$k3 ; type=int * int * int",
+				"tuple $k1,$k2,$k3 ; type=int * int * int ; alloc=heap",
 			},
 		},
 		{
@@ -187,7 +362,7 @@ func TestEmitInsn(t *testing.T) {
 			[]string{
 				"int 1 ; type=int",
 				"int 2 ; type=int",
-				"tuple $k1,$k2 ; type=int * int",
+				"tuple $k1,$k2 ; type=int * int ; alloc=heap",
 				"tplload 0 $k3 ; type=int",
 				"tplload 1 $k3 ; type=int",
 				"ref a$t1 ; type=int",
@@ -214,6 +389,24 @@ func TestEmitInsn(t *testing.T) {
 				"arrlen $k3 ; type=int",
 			},
 		},
+		{
+			"character literal",
+			"'a' < '\\n'",
+			[]string{
+				"char 'a' ; type=char",
+				"char '\\n' ; type=char",
+				"binary < $k1 $k2 ; type=bool",
+			},
+		},
+		{
+			"access to character of string",
+			"String.get \"abc\" 1",
+			[]string{
+				`string "abc" ; type=string`,
+				"int 1 ; type=int",
+				"strload $k2 $k1 ; type=char",
+			},
+		},
 		{
 			"access to array",
 			"let a = Array.make 3 true in a.(1)",
@@ -236,7 +429,7 @@ func TestEmitInsn(t *testing.T) {
 				"ref a$t1 ; type=bool array",
 				"int 1 ; type=int",
 				"bool false ; type=bool",
-				"arrstore $k5 $k4 $k6 ; type=bool",
+				"arrstore $k5 $k4 $k6 ; type=()",
 			},
 		},
 		{
@@ -405,6 +598,26 @@ func TestSemanticError(t *testing.T) {
 			code:     "let a = Array.make  3 3 in a = a",
 			expected: "'int array' can't be compared with operator '='",
 		},
+		{
+			what:     "buffer is invalid for operator '='",
+			code:     "let b = Buffer.create 8 in b = b",
+			expected: "'Buffer.t' can't be compared with operator '='",
+		},
+		{
+			what:     "buffer is invalid for operator '<'",
+			code:     "let b = Buffer.create 8 in b < b",
+			expected: "'Buffer.t' can't be compared with operator '<'",
+		},
+		{
+			what:     "hash table is invalid for operator '='",
+			code:     "let t: (int, int) Hashtbl.t = Hashtbl.create 8 in t = t",
+			expected: "'(int, int) Hashtbl.t' can't be compared with operator '='",
+		},
+		{
+			what:     "hash table is invalid for operator '<'",
+			code:     "let t: (int, int) Hashtbl.t = Hashtbl.create 8 in t < t",
+			expected: "'(int, int) Hashtbl.t' can't be compared with operator '<'",
+		},
 	}
 
 	for _, tc := range cases {
@@ -0,0 +1,777 @@
+package gcil
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/typing"
+	"github.com/rhysd/loc"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parser for textual GCIL format printed by Program.Dump. It enables to write GCIL program
+// directly to test transformations after converting from AST (e.g. closure transform, codegen).
+//
+// Since the textual format is line-oriented, the parser reads the source line by line. Positions
+// of instructions are set to their lines in the source.
+
+type textParser struct {
+	src     *loc.Source
+	lines   []string
+	offsets []int // Offsets of the beginning of lines
+	line    int   // Index of the next line to read
+	env     *typing.Env
+}
+
+type textLine struct {
+	text string
+	pos  loc.Pos
+}
+
+// Parse parses the textual GCIL program and returns the program and its type environment.
+// Built-in external symbols are always available in the environment.
+func Parse(src *loc.Source) (*Program, *typing.Env, error) {
+	lines := strings.Split(string(src.Code), "\n")
+	offsets := make([]int, 0, len(lines))
+	offset := 0
+	for _, l := range lines {
+		offsets = append(offsets, offset)
+		offset += len(l) + 1
+	}
+	p := &textParser{
+		src:     src,
+		lines:   lines,
+		offsets: offsets,
+		env:     typing.NewEnv(),
+	}
+	prog, err := p.parseProgram()
+	if err != nil {
+		return nil, nil, loc.Notef(err, "While parsing GCIL")
+	}
+	return prog, p.env, nil
+}
+
+// Returns the next non-empty line without consuming it. ok is false at the end of source.
+func (p *textParser) peek() (textLine, bool) {
+	for i := p.line; i < len(p.lines); i++ {
+		l := p.lines[i]
+		trimmed := strings.TrimSpace(l)
+		if trimmed != "" {
+			col := strings.Index(l, trimmed)
+			pos := loc.Pos{p.offsets[i] + col, i + 1, col + 1, p.src}
+			return textLine{trimmed, pos}, true
+		}
+	}
+	return textLine{}, false
+}
+
+func (p *textParser) next() (textLine, bool) {
+	l, ok := p.peek()
+	if ok {
+		p.line = l.pos.Line
+	}
+	return l, ok
+}
+
+func (p *textParser) endPos() loc.Pos {
+	return loc.Pos{len(p.src.Code), len(p.lines), 1, p.src}
+}
+
+func (p *textParser) expect(what string) (textLine, error) {
+	l, ok := p.next()
+	if !ok {
+		return l, loc.ErrorfAt(p.endPos(), "Expected %s but reached end of input", what)
+	}
+	return l, nil
+}
+
+// Parses section header like '[TOPLEVELS (3)]' and returns the number of entries.
+func (p *textParser) parseSectionHeader(name string) (int, error) {
+	l, err := p.expect(fmt.Sprintf("section '%s'", name))
+	if err != nil {
+		return 0, err
+	}
+	prefix := fmt.Sprintf("[%s (", name)
+	if !strings.HasPrefix(l.text, prefix) || !strings.HasSuffix(l.text, ")]") {
+		return 0, loc.ErrorfAt(l.pos, "Expected section '%s' but got '%s'", name, l.text)
+	}
+	n, err := strconv.Atoi(l.text[len(prefix) : len(l.text)-2])
+	if err != nil {
+		return 0, loc.ErrorfAt(l.pos, "Invalid number of entries in section '%s': %s", name, err.Error())
+	}
+	return n, nil
+}
+
+func (p *textParser) parseProgram() (*Program, error) {
+	if l, ok := p.peek(); ok && strings.HasPrefix(l.text, "[EXTERNALS ") {
+		if err := p.parseExternals(); err != nil {
+			return nil, err
+		}
+	}
+
+	toplevel, err := p.parseToplevels()
+	if err != nil {
+		return nil, err
+	}
+
+	closures, err := p.parseClosures()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := p.expect("section '[ENTRY]'")
+	if err != nil {
+		return nil, err
+	}
+	if l.text != "[ENTRY]" {
+		return nil, loc.ErrorfAt(l.pos, "Expected section '[ENTRY]' but got '%s'", l.text)
+	}
+	entry, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if l, ok := p.next(); ok {
+		return nil, loc.ErrorfAt(l.pos, "Unexpected line after entry block: '%s'", l.text)
+	}
+
+	return &Program{toplevel, closures, entry}, nil
+}
+
+func (p *textParser) parseExternals() error {
+	n, err := p.parseSectionHeader("EXTERNALS")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		l, err := p.expect("external symbol")
+		if err != nil {
+			return err
+		}
+		idx := strings.Index(l.text, ": ")
+		if idx <= 0 {
+			return loc.ErrorfAt(l.pos, "External symbol must be in form '{name}: {type}' but got '%s'", l.text)
+		}
+		t, err := parseTypeAt(l.text[idx+2:], l.pos)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return loc.ErrorfAt(l.pos, "Type of external symbol '%s' must be known", l.text[:idx])
+		}
+		p.env.Externals[l.text[:idx]] = t
+	}
+	return nil
+}
+
+func (p *textParser) parseToplevels() (Toplevel, error) {
+	n, err := p.parseSectionHeader("TOPLEVELS")
+	if err != nil {
+		return nil, err
+	}
+	toplevel := NewToplevel()
+	for i := 0; i < n; i++ {
+		insn, err := p.parseInsn()
+		if err != nil {
+			return nil, err
+		}
+		fun, ok := insn.Val.(*Fun)
+		if !ok {
+			return nil, loc.ErrorfAt(insn.Pos, "Toplevel must be a function but '%s' is not", insn.Ident)
+		}
+		if _, ok := toplevel[insn.Ident]; ok {
+			return nil, loc.ErrorfAt(insn.Pos, "Toplevel function '%s' is defined twice", insn.Ident)
+		}
+		toplevel.Add(insn.Ident, fun, insn.Pos)
+	}
+	return toplevel, nil
+}
+
+func (p *textParser) parseClosures() (Closures, error) {
+	n, err := p.parseSectionHeader("CLOSURES")
+	if err != nil {
+		return nil, err
+	}
+	closures := make(Closures, n)
+	for i := 0; i < n; i++ {
+		l, err := p.expect("closure")
+		if err != nil {
+			return nil, err
+		}
+		idx := strings.IndexRune(l.text, ':')
+		if idx <= 0 {
+			return nil, loc.ErrorfAt(l.pos, "Closure must be in form '{name}: {captures}' but got '%s'", l.text)
+		}
+		closures[l.text[:idx]] = splitIdents(strings.TrimSpace(l.text[idx+1:]))
+	}
+	return closures, nil
+}
+
+func (p *textParser) parseBlock() (*Block, error) {
+	l, err := p.expect("beginning of block")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(l.text, "BEGIN: ") {
+		return nil, loc.ErrorfAt(l.pos, "Expected 'BEGIN: {name}' but got '%s'", l.text)
+	}
+	name := l.text[len("BEGIN: "):]
+	begin := l.pos
+
+	insns := []*Insn{}
+	for {
+		l, ok := p.peek()
+		if !ok {
+			return nil, loc.ErrorfAt(p.endPos(), "Block '%s' is not closed with 'END: %s'", name, name)
+		}
+		if strings.HasPrefix(l.text, "END: ") {
+			p.next()
+			if end := l.text[len("END: "):]; end != name {
+				return nil, loc.ErrorfAt(l.pos, "Block '%s' is closed with mismatched name '%s'", name, end)
+			}
+			break
+		}
+		insn, err := p.parseInsn()
+		if err != nil {
+			return nil, err
+		}
+		insns = append(insns, insn)
+	}
+
+	if len(insns) == 0 {
+		return nil, loc.ErrorfAt(begin, "Block '%s' must contain at least one instruction", name)
+	}
+	return NewBlockFromArray(name, insns), nil
+}
+
+// Splits instruction line into value part and annotations part. ' ; ' in string or character
+// literal is not a separator.
+func splitAnnotations(line string) (string, []string) {
+	start := 0
+	if strings.HasPrefix(line, "string ") || strings.HasPrefix(line, "char ") {
+		// Skip the quoted literal
+		start = strings.IndexByte(line, ' ') + 1
+		if start < len(line) {
+			quote := line[start]
+			for i := start + 1; i < len(line); i++ {
+				if line[i] == '\\' {
+					i++
+				} else if line[i] == quote {
+					start = i
+					break
+				}
+			}
+		}
+	}
+	idx := strings.Index(line[start:], " ; ")
+	if idx < 0 {
+		return strings.TrimSpace(line), nil
+	}
+	idx += start
+	return strings.TrimSpace(line[:idx]), strings.Split(line[idx+3:], " ; ")
+}
+
+// Splits comma-separated identifiers. Commas in brackets are a part of identifier because names of
+// specialized functions contain them (e.g. 'f[g,h]').
+func splitIdents(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	idents := []string{}
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				idents = append(idents, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(idents, s[start:])
+}
+
+func (p *textParser) parseInsn() (*Insn, error) {
+	l, err := p.expect("instruction")
+	if err != nil {
+		return nil, err
+	}
+	pos := l.pos
+
+	idx := strings.Index(l.text, " = ")
+	if idx <= 0 {
+		return nil, loc.ErrorfAt(pos, "Instruction must be in form '{ident} = {value} ; type={type}' but got '%s'", l.text)
+	}
+	ident := l.text[:idx]
+	rest, annots := splitAnnotations(l.text[idx+3:])
+
+	var ty typing.Type
+	hasType := false
+	onStack := false
+	for _, a := range annots {
+		switch {
+		case strings.HasPrefix(a, "type="):
+			ty, err = parseTypeAt(a[len("type="):], pos)
+			if err != nil {
+				return nil, err
+			}
+			hasType = true
+		case a == "alloc=stack":
+			onStack = true
+		case a == "alloc=heap":
+			onStack = false
+		default:
+			return nil, loc.ErrorfAt(pos, "Unknown annotation '%s' for instruction '%s'", a, ident)
+		}
+	}
+	if !hasType {
+		return nil, loc.ErrorfAt(pos, "Type annotation 'type=' is missing for instruction '%s'", ident)
+	}
+
+	val, err := p.parseVal(rest, ty, onStack, pos)
+	if err != nil {
+		return nil, loc.NotefAt(pos, err, "Instruction '%s'", ident)
+	}
+
+	if err := p.defineType(ident, ty, pos); err != nil {
+		return nil, err
+	}
+	return NewInsn(ident, val, pos), nil
+}
+
+// Note:
+// Name of closure is used for both the toplevel function and the closure value. They always have
+// the same type. Other identifiers defined more than once are reported by Verify.
+func (p *textParser) defineType(ident string, ty typing.Type, pos loc.Pos) error {
+	if prev, ok := p.env.Table[ident]; ok && typeString(prev) != typeString(ty) {
+		return loc.ErrorfAt(pos, "Type of '%s' is '%s' but it was previously defined as '%s'", ident, typeString(ty), typeString(prev))
+	}
+	p.env.Table[ident] = ty
+	return nil
+}
+
+func typeString(t typing.Type) string {
+	if t == nil {
+		return unknownTypeName
+	}
+	return t.String()
+}
+
+// Splits value into operator and operands. Quoted literal is treated as one operand.
+func splitOperands(s string) []string {
+	fields := strings.Fields(s)
+	if len(fields) >= 2 && (fields[0] == "string" || fields[0] == "char") {
+		return []string{fields[0], strings.TrimSpace(s[len(fields[0]):])}
+	}
+	return fields
+}
+
+func unaryOpOf(s string) (OperatorKind, bool) {
+	for _, op := range []OperatorKind{NOT, NEG, FNEG, LNOT} {
+		if OpTable[op] == s {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+func binaryOpOf(s string) (OperatorKind, bool) {
+	for op, name := range OpTable {
+		switch k := OperatorKind(op); k {
+		case NOT, NEG, FNEG, LNOT:
+			continue
+		default:
+			if name == s {
+				return k, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func parseChar(lit string) (byte, error) {
+	if len(lit) == 6 && strings.HasPrefix(lit, `'\x`) && lit[5] == '\'' {
+		b, err := strconv.ParseUint(lit[3:5], 16, 8)
+		return byte(b), err
+	}
+	r, _, tail, err := strconv.UnquoteChar(strings.TrimSuffix(strings.TrimPrefix(lit, "'"), "'"), '\'')
+	if err != nil {
+		return 0, err
+	}
+	if tail != "" || r >= utf8.RuneSelf || len(lit) < 3 || lit[0] != '\'' || lit[len(lit)-1] != '\'' {
+		return 0, fmt.Errorf("Invalid character literal %s", lit)
+	}
+	return byte(r), nil
+}
+
+func (p *textParser) parseVal(s string, ty typing.Type, onStack bool, pos loc.Pos) (Val, error) {
+	ops := splitOperands(s)
+	if len(ops) == 0 {
+		return nil, loc.ErrorAt(pos, "Value is empty")
+	}
+	kind, args := ops[0], ops[1:]
+
+	arity := map[string]int{
+		"unit": 0, "none": 0, "nop": 0,
+		"bool": 1, "int": 1, "float": 1, "string": 1, "char": 1,
+		"ref": 1, "xref": 1, "if": 1, "arrlen": 1, "some": 1, "issome": 1, "derefsome": 1,
+		"unary": 2, "array": 2, "tplload": 2, "arrload": 2, "strload": 2, "makecls": 2,
+		"binary": 3, "arrstore": 3,
+	}
+	if n, ok := arity[kind]; ok && len(args) != n {
+		return nil, loc.ErrorfAt(pos, "'%s' requires %d operand(s) but got %d: '%s'", kind, n, len(args), s)
+	}
+
+	switch kind {
+	case "unit":
+		return UnitVal, nil
+	case "none":
+		return NoneVal, nil
+	case "nop":
+		return NOPVal, nil
+	case "bool":
+		b, err := strconv.ParseBool(args[0])
+		if err != nil {
+			return nil, loc.ErrorfAt(pos, "Invalid boolean constant '%s'", args[0])
+		}
+		return &Bool{b}, nil
+	case "int":
+		i, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return nil, loc.ErrorfAt(pos, "Invalid integer constant '%s'", args[0])
+		}
+		return &Int{i}, nil
+	case "float":
+		f, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil, loc.ErrorfAt(pos, "Invalid float constant '%s'", args[0])
+		}
+		return &Float{f}, nil
+	case "string":
+		str, err := strconv.Unquote(args[0])
+		if err != nil || !strings.HasPrefix(args[0], `"`) {
+			return nil, loc.ErrorfAt(pos, "Invalid string literal %s", args[0])
+		}
+		return &String{str}, nil
+	case "char":
+		c, err := parseChar(args[0])
+		if err != nil {
+			return nil, loc.ErrorfAt(pos, "Invalid character literal %s", args[0])
+		}
+		return &Char{c}, nil
+	case "unary":
+		op, ok := unaryOpOf(args[0])
+		if !ok {
+			return nil, loc.ErrorfAt(pos, "Unknown unary operator '%s'", args[0])
+		}
+		return &Unary{op, args[1]}, nil
+	case "binary":
+		op, ok := binaryOpOf(args[0])
+		if !ok {
+			return nil, loc.ErrorfAt(pos, "Unknown binary operator '%s'", args[0])
+		}
+		return &Binary{op, args[1], args[2]}, nil
+	case "ref":
+		return &Ref{args[0]}, nil
+	case "xref":
+		return &XRef{args[0]}, nil
+	case "if":
+		then, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		els, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &If{args[0], then, els}, nil
+	case "fun", "recfun":
+		if len(args) != 1 {
+			return nil, loc.ErrorfAt(pos, "'%s' requires comma separated parameters: '%s'", kind, s)
+		}
+		params := splitIdents(args[0])
+		fun, ok := ty.(*typing.Fun)
+		if !ok || len(fun.Params) != len(params) {
+			return nil, loc.ErrorfAt(pos, "Type of function with %d parameter(s) must be function type with the same number of parameters", len(params))
+		}
+		for i, param := range params {
+			if err := p.defineType(param, fun.Params[i], pos); err != nil {
+				return nil, err
+			}
+		}
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &Fun{params, body, kind == "recfun"}, nil
+	case "app", "appcls", "appx":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, loc.ErrorfAt(pos, "'%s' requires callee and comma separated arguments: '%s'", kind, s)
+		}
+		app := &App{args[0], []string{}, DIRECT_CALL}
+		if len(args) == 2 {
+			app.Args = splitIdents(args[1])
+		}
+		switch kind {
+		case "appcls":
+			app.Kind = CLOSURE_CALL
+		case "appx":
+			app.Kind = EXTERNAL_CALL
+		}
+		return app, nil
+	case "tuple":
+		if len(args) != 1 {
+			return nil, loc.ErrorfAt(pos, "'tuple' requires comma separated elements: '%s'", s)
+		}
+		return &Tuple{splitIdents(args[0]), onStack}, nil
+	case "array":
+		return &Array{args[0], args[1]}, nil
+	case "tplload":
+		idx, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, loc.ErrorfAt(pos, "Index of 'tplload' must be constant but got '%s'", args[0])
+		}
+		return &TplLoad{args[1], idx}, nil
+	case "arrload":
+		return &ArrLoad{args[1], args[0]}, nil
+	case "arrstore":
+		return &ArrStore{args[1], args[0], args[2]}, nil
+	case "arrlen":
+		return &ArrLen{args[0]}, nil
+	case "strload":
+		return &StrLoad{args[1], args[0]}, nil
+	case "some":
+		return &Some{args[0]}, nil
+	case "issome":
+		return &IsSome{args[0]}, nil
+	case "derefsome":
+		return &DerefSome{args[0]}, nil
+	case "makecls":
+		vars := args[0]
+		if !strings.HasPrefix(vars, "(") || !strings.HasSuffix(vars, ")") {
+			return nil, loc.ErrorfAt(pos, "Captures of 'makecls' must be enclosed with parens but got '%s'", vars)
+		}
+		return &MakeCls{splitIdents(vars[1 : len(vars)-1]), args[1], onStack}, nil
+	default:
+		return nil, loc.ErrorfAt(pos, "Unknown value '%s'", kind)
+	}
+}
+
+// Parser for types in type annotations. It accepts the string representation of typing.Type.
+//
+//	type    := param ('->' param)*
+//	param   := label? tuple
+//	tuple   := postfix ('*' postfix)*
+//	postfix := atom ('array' | 'option')*
+//	atom    := name | '()' | '(' type ')' | '(' type ',' type ')' 'Hashtbl.t'
+type typeParser struct {
+	tokens []string
+	idx    int
+	pos    loc.Pos
+}
+
+// Unknown type is printed when the variable is never used.
+const unknownTypeName = "unknown (unused)"
+
+var primitiveTypes = map[string]typing.Type{
+	"int":            typing.IntType,
+	"bool":           typing.BoolType,
+	"float":          typing.FloatType,
+	"string":         typing.StringType,
+	"char":           typing.CharType,
+	"Buffer.t":       typing.BufferType,
+	"in_channel":     typing.InChannelType,
+	"out_channel":    typing.OutChannelType,
+	"Random.State.t": typing.RandomStateType,
+}
+
+func isTypeNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '\'' || c == '.'
+}
+
+func tokenizeType(s string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ':
+			i++
+		case c == '(' || c == ')' || c == ',' || c == '*':
+			tokens = append(tokens, s[i:i+1])
+			i++
+		case strings.HasPrefix(s[i:], "->"):
+			tokens = append(tokens, "->")
+			i += 2
+		case c == '?' || isTypeNameChar(c):
+			start := i
+			i++
+			for i < len(s) && isTypeNameChar(s[i]) {
+				i++
+			}
+			// Label of parameter like 'x:' or '?x:'
+			if i < len(s) && s[i] == ':' {
+				i++
+			} else if c == '?' {
+				return nil, fmt.Errorf("Type variable '%s' is not resolved", s[start:])
+			}
+			tokens = append(tokens, s[start:i])
+		default:
+			return nil, fmt.Errorf("Unexpected character '%c' in type", c)
+		}
+	}
+	return tokens, nil
+}
+
+// Parses the string representation of type. nil is returned for unknown type.
+func parseTypeAt(s string, pos loc.Pos) (typing.Type, error) {
+	if s == unknownTypeName {
+		return nil, nil
+	}
+	tokens, err := tokenizeType(s)
+	if err != nil {
+		return nil, loc.NotefAt(pos, err, "Type '%s'", s)
+	}
+	p := &typeParser{tokens, 0, pos}
+	t, err := p.parseArrow()
+	if err != nil {
+		return nil, loc.NotefAt(pos, err, "Type '%s'", s)
+	}
+	if p.idx < len(p.tokens) {
+		return nil, loc.ErrorfAt(pos, "Unexpected token '%s' in type '%s'", p.tokens[p.idx], s)
+	}
+	return t, nil
+}
+
+func (p *typeParser) peek() string {
+	if p.idx < len(p.tokens) {
+		return p.tokens[p.idx]
+	}
+	return ""
+}
+
+func (p *typeParser) consume(tok string) error {
+	if p.peek() != tok {
+		return fmt.Errorf("Expected '%s' but got '%s'", tok, p.peek())
+	}
+	p.idx++
+	return nil
+}
+
+func (p *typeParser) parseArrow() (typing.Type, error) {
+	params := []typing.Type{}
+	labels := []typing.Label{}
+	labeled := false
+	for {
+		label := typing.Label{}
+		if tok := p.peek(); strings.HasSuffix(tok, ":") {
+			label.Optional = strings.HasPrefix(tok, "?")
+			label.Name = strings.TrimPrefix(strings.TrimSuffix(tok, ":"), "?")
+			labeled = true
+			p.idx++
+		}
+		t, err := p.parseTuple()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != "->" {
+			if label.Name != "" {
+				return nil, fmt.Errorf("Return type cannot have label '%s'", label.String())
+			}
+			if len(params) == 0 {
+				return t, nil
+			}
+			fun := &typing.Fun{t, params, nil}
+			if labeled {
+				fun.Labels = labels
+			}
+			return fun, nil
+		}
+		p.idx++
+		params = append(params, t)
+		labels = append(labels, label)
+	}
+}
+
+func (p *typeParser) parseTuple() (typing.Type, error) {
+	t, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "*" {
+		return t, nil
+	}
+	elems := []typing.Type{t}
+	for p.peek() == "*" {
+		p.idx++
+		t, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, t)
+	}
+	return &typing.Tuple{elems}, nil
+}
+
+func (p *typeParser) parsePostfix() (typing.Type, error) {
+	t, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case "array":
+			t = &typing.Array{t}
+		case "option":
+			t = &typing.Option{t}
+		default:
+			return t, nil
+		}
+		p.idx++
+	}
+}
+
+func (p *typeParser) parseAtom() (typing.Type, error) {
+	tok := p.peek()
+	if tok == "" {
+		return nil, fmt.Errorf("Unexpected end of type")
+	}
+	p.idx++
+
+	if tok != "(" {
+		if t, ok := primitiveTypes[tok]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("Unknown type name '%s'", tok)
+	}
+
+	if p.peek() == ")" {
+		p.idx++
+		return typing.UnitType, nil
+	}
+	t, err := p.parseArrow()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() == "," {
+		p.idx++
+		v, err := p.parseArrow()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.consume(")"); err != nil {
+			return nil, err
+		}
+		if err := p.consume("Hashtbl.t"); err != nil {
+			return nil, err
+		}
+		return &typing.Hashtbl{t, v}, nil
+	}
+	if err := p.consume(")"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
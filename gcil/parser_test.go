@@ -0,0 +1,346 @@
+package gcil
+
+import (
+	"bytes"
+	"github.com/rhysd/gocaml/alpha"
+	"github.com/rhysd/gocaml/lexer"
+	"github.com/rhysd/gocaml/parser"
+	"github.com/rhysd/gocaml/typing"
+	"github.com/rhysd/loc"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTypes(t *testing.T) {
+	for _, s := range []string{
+		"int",
+		"()",
+		"bool",
+		"float",
+		"string",
+		"char",
+		"Buffer.t",
+		"in_channel",
+		"out_channel",
+		"Random.State.t",
+		"int -> int",
+		"int -> bool -> ()",
+		"(int -> int) -> int",
+		"int -> (int -> int)",
+		"int * int",
+		"int * (int * int)",
+		"(int * int) * int",
+		"int * int -> int * int",
+		"int array",
+		"(int * int) array",
+		"int array array",
+		"(int -> int) option",
+		"int option option",
+		"(int, string) Hashtbl.t",
+		"(int * char, float array) Hashtbl.t",
+		"x:int -> ?y:int option -> int",
+		"(?x:int option -> int) -> int",
+	} {
+		ty, err := parseTypeAt(s, loc.Pos{})
+		if err != nil {
+			t.Errorf("Failed to parse type '%s': %s", s, err)
+			continue
+		}
+		if actual := ty.String(); actual != s {
+			t.Errorf("Parsed type '%s' was printed as '%s'", s, actual)
+		}
+	}
+
+	ty, err := parseTypeAt("unknown (unused)", loc.Pos{})
+	if err != nil || ty != nil {
+		t.Errorf("Unknown type should be parsed as nil but got %v (error: %v)", ty, err)
+	}
+}
+
+func TestParseInvalidTypes(t *testing.T) {
+	for _, tc := range []struct {
+		what     string
+		src      string
+		expected string
+	}{
+		{"unknown name", "foo", "Unknown type name 'foo'"},
+		{"type variable", "?(0xc420010000) -> int", "Type variable '?(0xc420010000) -> int' is not resolved"},
+		{"unclosed paren", "(int -> int", "Expected ')' but got ''"},
+		{"missing Hashtbl.t", "(int, int)", "Expected 'Hashtbl.t' but got ''"},
+		{"labeled return type", "int -> x:int", "Return type cannot have label 'x:'"},
+		{"trailing token", "int int", "Unexpected token 'int' in type"},
+		{"unexpected char", "int -> #", "Unexpected character '#' in type"},
+		{"empty", "", "Unexpected end of type"},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			_, err := parseTypeAt(tc.src, loc.Pos{})
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error message '%s' to contain '%s'", err.Error(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseProgram(t *testing.T) {
+	src := loc.NewDummySource(`
+[EXTERNALS (1)]
+x: int
+
+[TOPLEVELS (1)]
+f$t1 = recfun a$t2,b$t3 ; type=int -> string -> int * string
+  BEGIN: body (f$t1)
+  $k1 = int 0 ; type=int
+  $k2 = binary < a$t2 $k1 ; type=bool
+  $k3 = if $k2 ; type=int * string
+    BEGIN: then
+    $k4 = tuple a$t2,b$t3 ; type=int * string ; alloc=heap
+    END: then
+    BEGIN: else
+    $k5 = int 1 ; type=int
+    $k6 = binary - a$t2 $k5 ; type=int
+    $k7 = app f$t1 $k6,b$t3 ; type=int * string
+    END: else
+  END: body (f$t1)
+
+[CLOSURES (0)]
+
+[ENTRY]
+BEGIN: program
+$k8 = xref x ; type=int
+$k9 = string "a ; type=b" ; type=string
+$k10 = app f$t1 $k8,$k9 ; type=int * string
+$k11 = tplload 0 $k10 ; type=int
+$k12 = char '\x80' ; type=char
+$k13 = float 0.1 ; type=float
+$k14 = appx print_int $k11 ; type=()
+END: program
+`)
+
+	prog, env, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ty, ok := env.Externals["x"]; !ok || ty != typing.IntType {
+		t.Errorf("External symbol 'x' was not declared as int: %v", ty)
+	}
+	if _, ok := env.Externals["print_int"]; !ok {
+		t.Errorf("Built-in external symbols should be available")
+	}
+
+	f, ok := prog.Toplevel["f$t1"]
+	if !ok {
+		t.Fatalf("Toplevel function 'f$t1' was not parsed: %v", prog.Toplevel)
+	}
+	if !f.Val.IsRecursive || len(f.Val.Params) != 2 {
+		t.Errorf("Function was not parsed correctly: %v", f.Val)
+	}
+	if f.Pos.Line != 6 {
+		t.Errorf("Position of function should be line 6 but %d", f.Pos.Line)
+	}
+	if ty, ok := env.Table["b$t3"]; !ok || ty != typing.StringType {
+		t.Errorf("Type of parameter was not registered: %v", ty)
+	}
+
+	var insns []*Insn
+	for i := prog.Entry.Top.Next; i.Next != nil; i = i.Next {
+		insns = append(insns, i)
+	}
+	if len(insns) != 7 {
+		t.Fatalf("Entry block should contain 7 instructions but %d", len(insns))
+	}
+	if s, ok := insns[1].Val.(*String); !ok || s.Const != "a ; type=b" {
+		t.Errorf("String literal containing separator was not parsed correctly: %v", insns[1].Val)
+	}
+	if c, ok := insns[4].Val.(*Char); !ok || c.Const != 0x80 {
+		t.Errorf("Character literal was not parsed correctly: %v", insns[4].Val)
+	}
+	if f, ok := insns[5].Val.(*Float); !ok || f.Const != 0.1 {
+		t.Errorf("Float literal was not parsed correctly: %v", insns[5].Val)
+	}
+	if a, ok := insns[6].Val.(*App); !ok || a.Kind != EXTERNAL_CALL {
+		t.Errorf("External call was not parsed correctly: %v", insns[6].Val)
+	}
+	if ty, ok := env.Table["$k10"].(*typing.Tuple); !ok || len(ty.Elems) != 2 {
+		t.Errorf("Type of instruction was not registered: %v", env.Table["$k10"])
+	}
+}
+
+func TestSplitIdents(t *testing.T) {
+	for _, tc := range []struct {
+		src      string
+		expected []string
+	}{
+		{"", []string{}},
+		{"a$t1", []string{"a$t1"}},
+		{"a$t1,$k2", []string{"a$t1", "$k2"}},
+		{"f$t1[g$t2,h$t3],x$t4[g$t2,h$t3],$k5", []string{"f$t1[g$t2,h$t3]", "x$t4[g$t2,h$t3]", "$k5"}},
+	} {
+		actual := splitIdents(tc.src)
+		if strings.Join(actual, " ") != strings.Join(tc.expected, " ") || len(actual) != len(tc.expected) {
+			t.Errorf("'%s' should be split into %v but got %v", tc.src, tc.expected, actual)
+		}
+	}
+}
+
+func TestParseInvalidProgram(t *testing.T) {
+	header := "[TOPLEVELS (0)]\n[CLOSURES (0)]\n[ENTRY]\n"
+	for _, tc := range []struct {
+		what     string
+		src      string
+		expected string
+	}{
+		{
+			"empty",
+			"",
+			"Expected section 'TOPLEVELS' but reached end of input",
+		},
+		{
+			"missing entry",
+			"[TOPLEVELS (0)]\n[CLOSURES (0)]\n",
+			"Expected section '[ENTRY]' but reached end of input",
+		},
+		{
+			"wrong number of toplevels",
+			"[TOPLEVELS (1)]\n[CLOSURES (0)]\n",
+			"Instruction must be in form '{ident} = {value} ; type={type}' but got '[CLOSURES (0)]'",
+		},
+		{
+			"unclosed block",
+			header + "BEGIN: program\n$k1 = unit ; type=()\n",
+			"Block 'program' is not closed with 'END: program'",
+		},
+		{
+			"mismatched block name",
+			header + "BEGIN: program\n$k1 = unit ; type=()\nEND: foo\n",
+			"Block 'program' is closed with mismatched name 'foo'",
+		},
+		{
+			"empty block",
+			header + "BEGIN: program\nEND: program\n",
+			"Block 'program' must contain at least one instruction",
+		},
+		{
+			"missing type",
+			header + "BEGIN: program\n$k1 = unit\nEND: program\n",
+			"Type annotation 'type=' is missing for instruction '$k1'",
+		},
+		{
+			"unknown annotation",
+			header + "BEGIN: program\n$k1 = unit ; type=() ; foo=bar\nEND: program\n",
+			"Unknown annotation 'foo=bar' for instruction '$k1'",
+		},
+		{
+			"unknown value",
+			header + "BEGIN: program\n$k1 = foo ; type=()\nEND: program\n",
+			"Unknown value 'foo'",
+		},
+		{
+			"wrong number of operands",
+			header + "BEGIN: program\n$k1 = binary + $k2 ; type=int\nEND: program\n",
+			"'binary' requires 3 operand(s) but got 2",
+		},
+		{
+			"unknown operator",
+			header + "BEGIN: program\n$k1 = binary ** $k2 $k3 ; type=int\nEND: program\n",
+			"Unknown binary operator '**'",
+		},
+		{
+			"invalid constant",
+			header + "BEGIN: program\n$k1 = int foo ; type=int\nEND: program\n",
+			"Invalid integer constant 'foo'",
+		},
+		{
+			"inconsistent type",
+			header + "BEGIN: program\n$k1 = int 1 ; type=int\n$k1 = bool true ; type=bool\nEND: program\n",
+			"Type of '$k1' is 'bool' but it was previously defined as 'int'",
+		},
+		{
+			"toplevel is not a function",
+			"[TOPLEVELS (1)]\n$k1 = int 1 ; type=int\n",
+			"Toplevel must be a function but '$k1' is not",
+		},
+		{
+			"function type mismatch",
+			"[TOPLEVELS (1)]\nf = fun a,b ; type=int -> int\n",
+			"Type of function with 2 parameter(s) must be function type with the same number of parameters",
+		},
+		{
+			"trailing line",
+			header + "BEGIN: program\n$k1 = unit ; type=()\nEND: program\nfoo\n",
+			"Unexpected line after entry block: 'foo'",
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			_, _, err := Parse(loc.NewDummySource(tc.src))
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error message '%s' to contain '%s'", err.Error(), tc.expected)
+			}
+		})
+	}
+}
+
+// Property: printing the parsed program reproduces the original text.
+func TestParseRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.FromSlash("../testdata/from-mincaml/*.ml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := filepath.Glob(filepath.FromSlash("../codegen/testdata/*.ml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, more...)
+	if len(files) == 0 {
+		t.Fatal("No source file for test was found")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := loc.NewSourceFromFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := lexer.NewLexer(src)
+			go l.Lex()
+			ast, err := parser.Parse(l.Tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = alpha.Transform(ast.Root); err != nil {
+				t.Fatal(err)
+			}
+			env, err := typing.TypeInferernce(ast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ir, err := FromAST(ast.Root, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ElimRefs(ir, env)
+
+			var buf bytes.Buffer
+			prog := &Program{NewToplevel(), Closures{}, ir}
+			prog.Dump(&buf, env)
+			printed := buf.String()
+
+			parsed, parsedEnv, err := Parse(loc.NewDummySource(printed))
+			if err != nil {
+				t.Fatalf("%s\n\nGCIL:\n%s", err.Error(), printed)
+			}
+			buf.Reset()
+			parsed.Dump(&buf, parsedEnv)
+			if reprinted := buf.String(); reprinted != printed {
+				t.Fatalf("Round trip failed.\n\nOriginal:\n%s\n\nReprinted:\n%s", printed, reprinted)
+			}
+		})
+	}
+}
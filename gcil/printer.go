@@ -23,10 +23,31 @@ func (p *printer) getTypeNameOf(insn *Insn) string {
 	return t.String()
 }
 
-func (p *printer) printlnInsn(insn *Insn) {
+// Shows where the value is allocated. It is decided by escape analysis.
+func (p *printer) printAlloc(onStack bool) {
+	if onStack {
+		fmt.Fprint(p.out, " ; alloc=stack")
+	} else {
+		fmt.Fprint(p.out, " ; alloc=heap")
+	}
+}
+
+// Prints one line of the instruction without blocks of its value.
+func (p *printer) printInsn(insn *Insn) {
 	fmt.Fprintf(p.out, "%s%s = ", p.indent, insn.Ident)
 	insn.Val.Print(p.out)
-	fmt.Fprintf(p.out, " ; type=%s\n", p.getTypeNameOf(insn))
+	fmt.Fprintf(p.out, " ; type=%s", p.getTypeNameOf(insn))
+	switch v := insn.Val.(type) {
+	case *Tuple:
+		p.printAlloc(v.OnStack)
+	case *MakeCls:
+		p.printAlloc(v.OnStack)
+	}
+}
+
+func (p *printer) printlnInsn(insn *Insn) {
+	p.printInsn(insn)
+	fmt.Fprintln(p.out)
 	switch i := insn.Val.(type) {
 	case *If:
 		indented := printer{p.types, p.out, p.indent + "  "}
@@ -5,6 +5,7 @@ import (
 	"github.com/rhysd/gocaml/typing"
 	"github.com/rhysd/loc"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -25,6 +26,17 @@ func (top Toplevel) Add(n string, f *Fun, p loc.Pos) {
 	top[n] = FunInsn{n, f, p}
 }
 
+// SortedNames returns names of toplevel functions in sorted order. Passes which depend on order of
+// functions should iterate with them instead of ranging over the map to make the output stable.
+func (top Toplevel) SortedNames() []string {
+	names := make([]string, 0, len(top))
+	for n := range top {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Program representation. Program can be obtained after closure transform because
 // all functions must be at the top.
 type Program struct {
@@ -33,21 +45,52 @@ type Program struct {
 	Entry    *Block
 }
 
+// Toplevel functions are printed in order of their names to make the output stable.
 func (prog *Program) PrintToplevels(out io.Writer, env *typing.Env) {
 	p := printer{env, out, ""}
-	for n, f := range prog.Toplevel {
+	for _, n := range prog.Toplevel.SortedNames() {
+		f := prog.Toplevel[n]
 		p.printlnInsn(NewInsn(n, f.Val, f.Pos))
 		fmt.Fprintln(out)
 	}
 }
 
+// Returns names of external symbols which are not built-in. Types of built-ins are always
+// available so they don't need to be printed.
+func userExternals(env *typing.Env) []string {
+	builtins := typing.NewEnv().Externals
+	names := []string{}
+	for n, t := range env.Externals {
+		if b, ok := builtins[n]; ok && b.String() == t.String() {
+			continue
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dump prints the whole program in textual GCIL format. The output can be read back with Parse.
+// Please see gcil/README.md for the grammar.
 func (prog *Program) Dump(out io.Writer, env *typing.Env) {
+	externals := userExternals(env)
+	fmt.Fprintf(out, "[EXTERNALS (%d)]\n", len(externals))
+	for _, n := range externals {
+		fmt.Fprintf(out, "%s: %s\n", n, env.Externals[n].String())
+	}
+	fmt.Fprintln(out)
+
 	fmt.Fprintf(out, "[TOPLEVELS (%d)]\n", len(prog.Toplevel))
 	prog.PrintToplevels(out, env)
 
+	closures := make([]string, 0, len(prog.Closures))
+	for c := range prog.Closures {
+		closures = append(closures, c)
+	}
+	sort.Strings(closures)
 	fmt.Fprintf(out, "[CLOSURES (%d)]\n", len(prog.Closures))
-	for c, fv := range prog.Closures {
-		fmt.Fprintf(out, "%s:\t%s\n", c, strings.Join(fv, ","))
+	for _, c := range closures {
+		fmt.Fprintf(out, "%s:\t%s\n", c, strings.Join(prog.Closures[c], ","))
 	}
 	fmt.Fprintln(out)
 
@@ -5,6 +5,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type Val interface {
@@ -35,6 +36,13 @@ const (
 	GTE
 	AND
 	OR
+	LNOT
+	LAND
+	LOR
+	LXOR
+	LSL
+	LSR
+	ASR
 )
 
 var OpTable = [...]string{
@@ -58,6 +66,13 @@ var OpTable = [...]string{
 	GTE:  ">=",
 	AND:  "&&",
 	OR:   "||",
+	LNOT: "lnot",
+	LAND: "land",
+	LOR:  "lor",
+	LXOR: "lxor",
+	LSL:  "lsl",
+	LSR:  "lsr",
+	ASR:  "asr",
 }
 
 // Kind of function call.
@@ -90,6 +105,9 @@ type (
 	String struct {
 		Const string
 	}
+	Char struct {
+		Const byte
+	}
 	Unary struct {
 		Op    OperatorKind
 		Child string
@@ -117,7 +135,8 @@ type (
 		Kind   AppKind
 	}
 	Tuple struct {
-		Elems []string
+		Elems   []string
+		OnStack bool // Set by escape analysis when the tuple never escapes from its function
 	}
 	Array struct {
 		Size, Elem string
@@ -135,6 +154,9 @@ type (
 	ArrLen struct {
 		Array string
 	}
+	StrLoad struct {
+		From, Index string
+	}
 	Some struct {
 		Elem string
 	}
@@ -153,8 +175,9 @@ type (
 	}
 	// Introduced at closure-transform.
 	MakeCls struct {
-		Vars []string
-		Fun  string
+		Vars    []string
+		Fun     string
+		OnStack bool // Set by escape analysis when the closure never escapes from its function
 	}
 )
 
@@ -174,11 +197,23 @@ func (v *Int) Print(out io.Writer) {
 	fmt.Fprintf(out, "int %d", v.Const)
 }
 func (v *Float) Print(out io.Writer) {
-	fmt.Fprintf(out, "float %f", v.Const)
+	s := fmt.Sprintf("%f", v.Const)
+	if f, err := strconv.ParseFloat(s, 64); err != nil || f != v.Const {
+		// Fall back to the shortest representation which can be read back precisely
+		s = strconv.FormatFloat(v.Const, 'g', -1, 64)
+	}
+	fmt.Fprintf(out, "float %s", s)
 }
 func (v *String) Print(out io.Writer) {
 	fmt.Fprintf(out, "string %s", strconv.Quote(v.Const))
 }
+func (v *Char) Print(out io.Writer) {
+	if v.Const < utf8.RuneSelf {
+		fmt.Fprintf(out, "char %s", strconv.QuoteRune(rune(v.Const)))
+	} else {
+		fmt.Fprintf(out, "char '\\x%02x'", v.Const)
+	}
+}
 func (v *Unary) Print(out io.Writer) {
 	fmt.Fprintf(out, "unary %s %s", OpTable[v.Op], v.Child)
 }
@@ -219,6 +254,9 @@ func (v *ArrStore) Print(out io.Writer) {
 func (v *ArrLen) Print(out io.Writer) {
 	fmt.Fprintf(out, "arrlen %s", v.Array)
 }
+func (v *StrLoad) Print(out io.Writer) {
+	fmt.Fprintf(out, "strload %s %s", v.Index, v.From)
+}
 func (v *XRef) Print(out io.Writer) {
 	fmt.Fprintf(out, "xref %s", v.Ident)
 }
@@ -0,0 +1,615 @@
+package gcil
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/typing"
+	"github.com/rhysd/loc"
+	"strings"
+)
+
+// Verifier of GCIL program. It checks the invariants which passes after K-normalization and
+// code generator assume:
+//
+//   - Each identifier is assigned only once in the program (SSA)
+//   - Each referenced identifier is defined and dominates the reference
+//   - Each value is consistent with types in type environment
+//   - Captures of 'makecls' match Program.Closures
+//   - Each block contains at least one instruction and does not end with NOP
+//
+// It can verify programs both before and after closure transform. Before closure transform,
+// program has no toplevel function and 'fun' values are nested in blocks.
+
+type verifyScope struct {
+	names  map[string]struct{}
+	parent *verifyScope
+}
+
+func (s *verifyScope) has(ident string) bool {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.names[ident]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type verifier struct {
+	prog     *Program
+	env      *typing.Env
+	scope    *verifyScope
+	assigned map[string]struct{} // Identifiers assigned in the program
+}
+
+// Instructions created by transformations may not have their positions
+func verifyError(pos loc.Pos, format string, args ...interface{}) *loc.Error {
+	if pos.File == nil {
+		return loc.Errorf(format, args...)
+	}
+	return loc.ErrorfAt(pos, format, args...)
+}
+
+func (v *verifier) pushScope() {
+	v.scope = &verifyScope{map[string]struct{}{}, v.scope}
+}
+
+func (v *verifier) popScope() {
+	v.scope = v.scope.parent
+}
+
+// Note:
+// Name of toplevel function can be defined in multiple places because it is also the name of its
+// closure value. Closure value is made by 'makecls' and functions specialized by closure.Specialize
+// receive it as parameter.
+func (v *verifier) define(ident string, pos loc.Pos) error {
+	if _, ok := v.assigned[ident]; ok && !v.isToplevel(ident) {
+		return verifyError(pos, "Identifier '%s' is assigned more than once", ident)
+	}
+	v.assigned[ident] = struct{}{}
+	v.scope.names[ident] = struct{}{}
+	return nil
+}
+
+func (v *verifier) use(ident string, pos loc.Pos) error {
+	if !v.scope.has(ident) {
+		return verifyError(pos, "Identifier '%s' is not defined or does not dominate its use", ident)
+	}
+	return nil
+}
+
+func (v *verifier) isToplevel(ident string) bool {
+	_, ok := v.prog.Toplevel[ident]
+	return ok
+}
+
+func (v *verifier) isExternal(ident string) bool {
+	if _, ok := v.env.Externals[ident]; ok {
+		return true
+	}
+	return typing.IsGenericBuiltin(ident)
+}
+
+// Returns nil when the type is unknown. Unknown type is not checked.
+func (v *verifier) typeOf(ident string, pos loc.Pos) (typing.Type, error) {
+	t, ok := v.env.Table[ident]
+	if !ok {
+		return nil, verifyError(pos, "Type of '%s' is not found in type environment", ident)
+	}
+	return resolveVar(t), nil
+}
+
+func resolveVar(t typing.Type) typing.Type {
+	for {
+		v, ok := t.(*typing.Var)
+		if !ok || v.Ref == nil {
+			return t
+		}
+		t = v.Ref
+	}
+}
+
+func typeName(t typing.Type) string {
+	if t == nil {
+		return unknownTypeName
+	}
+	return t.String()
+}
+
+// Compares two types structurally. Labels of function parameters are ignored because they don't
+// change the representation of function (e.g. passing labeled function to higher-order function).
+// Unknown types and unresolved type variables match to any type.
+func sameType(l, r typing.Type) bool {
+	l, r = resolveVar(l), resolveVar(r)
+	if l == nil || r == nil {
+		return true
+	}
+	if _, ok := l.(*typing.Var); ok {
+		return true
+	}
+	if _, ok := r.(*typing.Var); ok {
+		return true
+	}
+	switch l := l.(type) {
+	case *typing.Fun:
+		r, ok := r.(*typing.Fun)
+		if !ok || len(l.Params) != len(r.Params) || !sameType(l.Ret, r.Ret) {
+			return false
+		}
+		for i, p := range l.Params {
+			if !sameType(p, r.Params[i]) {
+				return false
+			}
+		}
+		return true
+	case *typing.Tuple:
+		r, ok := r.(*typing.Tuple)
+		if !ok || len(l.Elems) != len(r.Elems) {
+			return false
+		}
+		for i, e := range l.Elems {
+			if !sameType(e, r.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *typing.Array:
+		r, ok := r.(*typing.Array)
+		return ok && sameType(l.Elem, r.Elem)
+	case *typing.Option:
+		r, ok := r.(*typing.Option)
+		return ok && sameType(l.Elem, r.Elem)
+	case *typing.Hashtbl:
+		r, ok := r.(*typing.Hashtbl)
+		return ok && sameType(l.Key, r.Key) && sameType(l.Value, r.Value)
+	case *typing.Opaque:
+		r, ok := r.(*typing.Opaque)
+		return ok && l.Name == r.Name
+	default:
+		// Primitive types are singletons
+		return l == r
+	}
+}
+
+func (v *verifier) expectType(what string, actual, expected typing.Type, pos loc.Pos) error {
+	if !sameType(actual, expected) {
+		return verifyError(pos, "Type of %s must be '%s' but it is '%s'", what, typeName(expected), typeName(actual))
+	}
+	return nil
+}
+
+// Checks the operand is defined and has the expected type. It returns the type of operand.
+func (v *verifier) operand(ident string, expected typing.Type, pos loc.Pos) (typing.Type, error) {
+	if err := v.use(ident, pos); err != nil {
+		return nil, err
+	}
+	t, err := v.typeOf(ident, pos)
+	if err != nil {
+		return nil, err
+	}
+	if expected != nil {
+		if err := v.expectType(fmt.Sprintf("operand '%s'", ident), t, expected, pos); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (v *verifier) operands(idents []string, pos loc.Pos) ([]typing.Type, error) {
+	types := make([]typing.Type, 0, len(idents))
+	for _, i := range idents {
+		t, err := v.operand(i, nil, pos)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func (v *verifier) block(b *Block, pos loc.Pos) (typing.Type, error) {
+	if _, ok := b.Top.Val.(*NOP); !ok || b.Top.Prev != nil {
+		return nil, verifyError(pos, "Top of block '%s' must be NOP sentinel", b.Name)
+	}
+	if _, ok := b.Bottom.Val.(*NOP); !ok || b.Bottom.Next != nil {
+		return nil, verifyError(pos, "Bottom of block '%s' must be NOP sentinel", b.Name)
+	}
+
+	v.pushScope()
+	defer v.popScope()
+
+	last := b.Top
+	for i := b.Top.Next; i != b.Bottom; i = i.Next {
+		if i == nil || i.Prev != last {
+			return nil, verifyError(pos, "Instruction list of block '%s' is broken after '%s'", b.Name, last.Ident)
+		}
+		if _, ok := i.Val.(*NOP); ok {
+			return nil, verifyError(i.Pos, "NOP instruction '%s' is not allowed in block '%s'", i.Ident, b.Name)
+		}
+		if err := v.insn(i); err != nil {
+			return nil, loc.Notef(err, "In block '%s'", b.Name)
+		}
+		last = i
+	}
+	if b.Bottom.Prev != last {
+		return nil, verifyError(pos, "Instruction list of block '%s' is broken at the bottom", b.Name)
+	}
+	if last == b.Top {
+		return nil, verifyError(pos, "Block '%s' must contain at least one instruction", b.Name)
+	}
+
+	// Value of block is the value of the last instruction
+	return v.typeOf(last.Ident, last.Pos)
+}
+
+func (v *verifier) insn(insn *Insn) error {
+	ty, err := v.typeOf(insn.Ident, insn.Pos)
+	if err != nil {
+		return err
+	}
+
+	if f, ok := insn.Val.(*Fun); ok {
+		// Function can refer itself in its body
+		if err := v.define(insn.Ident, insn.Pos); err != nil {
+			return err
+		}
+		return v.fun(insn.Ident, f, ty, insn.Pos, nil)
+	}
+
+	actual, err := v.val(insn.Ident, insn.Val, insn.Pos)
+	if err != nil {
+		return loc.Notef(err, "In value of '%s'", insn.Ident)
+	}
+	if err := v.expectType(fmt.Sprintf("'%s'", insn.Ident), ty, actual, insn.Pos); err != nil {
+		return err
+	}
+	return v.define(insn.Ident, insn.Pos)
+}
+
+// Verifies the function. 'captures' are identifiers available in its body in addition to
+// parameters (captures of closure or closure itself).
+func (v *verifier) fun(name string, f *Fun, ty typing.Type, pos loc.Pos, captures []string) error {
+	funTy, ok := ty.(*typing.Fun)
+	if !ok {
+		return verifyError(pos, "Type of function '%s' must be function type but it is '%s'", name, typeName(ty))
+	}
+	if len(funTy.Params) != len(f.Params) {
+		return verifyError(pos, "Function '%s' has %d parameter(s) but its type '%s' has %d", name, len(f.Params), funTy.String(), len(funTy.Params))
+	}
+
+	v.pushScope()
+	defer v.popScope()
+
+	for _, c := range captures {
+		v.scope.names[c] = struct{}{}
+	}
+	for i, p := range f.Params {
+		if err := v.define(p, pos); err != nil {
+			return loc.Notef(err, "In parameters of function '%s'", name)
+		}
+		t, err := v.typeOf(p, pos)
+		if err != nil {
+			return err
+		}
+		if err := v.expectType(fmt.Sprintf("parameter '%s'", p), t, funTy.Params[i], pos); err != nil {
+			return err
+		}
+	}
+
+	ret, err := v.block(f.Body, pos)
+	if err != nil {
+		return loc.Notef(err, "In body of function '%s'", name)
+	}
+	if err := v.expectType(fmt.Sprintf("returned value of function '%s'", name), ret, funTy.Ret, pos); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *verifier) app(ident string, app *App, pos loc.Pos) (typing.Type, error) {
+	var calleeTy typing.Type
+	switch app.Kind {
+	case EXTERNAL_CALL:
+		if !v.isExternal(app.Callee) {
+			return nil, verifyError(pos, "Callee of external call '%s' is not an external symbol", app.Callee)
+		}
+		t, ok := v.env.Externals[app.Callee]
+		if !ok {
+			// Generic built-in function. Its instantiated type is not remained after eliminating
+			// 'xref' instruction.
+			if _, err := v.operands(app.Args, pos); err != nil {
+				return nil, err
+			}
+			return v.typeOf(ident, pos)
+		}
+		calleeTy = t
+	case CLOSURE_CALL:
+		t, err := v.operand(app.Callee, nil, pos)
+		if err != nil {
+			return nil, err
+		}
+		calleeTy = t
+	default:
+		// Known function is called directly. Before closure transform, callee is a variable.
+		if !v.isToplevel(app.Callee) {
+			if err := v.use(app.Callee, pos); err != nil {
+				return nil, err
+			}
+		}
+		t, err := v.typeOf(app.Callee, pos)
+		if err != nil {
+			return nil, err
+		}
+		calleeTy = t
+	}
+
+	funTy, ok := resolveVar(calleeTy).(*typing.Fun)
+	if !ok {
+		return nil, verifyError(pos, "Callee '%s' must be a function but its type is '%s'", app.Callee, typeName(calleeTy))
+	}
+	if len(funTy.Params) != len(app.Args) {
+		return nil, verifyError(pos, "Function '%s' requires %d argument(s) but %d given", app.Callee, len(funTy.Params), len(app.Args))
+	}
+	for i, a := range app.Args {
+		if _, err := v.operand(a, funTy.Params[i], pos); err != nil {
+			return nil, err
+		}
+	}
+	return funTy.Ret, nil
+}
+
+func (v *verifier) makeCls(val *MakeCls, pos loc.Pos) (typing.Type, error) {
+	if !v.isToplevel(val.Fun) {
+		return nil, verifyError(pos, "Function '%s' of closure is not a toplevel function", val.Fun)
+	}
+	captures, ok := v.prog.Closures[val.Fun]
+	if !ok {
+		return nil, verifyError(pos, "Function '%s' of closure is not registered as closure", val.Fun)
+	}
+	if strings.Join(captures, ",") != strings.Join(val.Vars, ",") {
+		return nil, verifyError(pos, "Captures of closure '%s' must be '%s' but got '%s'", val.Fun, strings.Join(captures, ","), strings.Join(val.Vars, ","))
+	}
+	if _, err := v.operands(val.Vars, pos); err != nil {
+		return nil, err
+	}
+	return v.typeOf(val.Fun, pos)
+}
+
+// Checks the operand is an array and returns the type of its element.
+func (v *verifier) arrayOperand(ident string, pos loc.Pos) (typing.Type, error) {
+	t, err := v.operand(ident, nil, pos)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := resolveVar(t).(*typing.Array)
+	if !ok {
+		return nil, verifyError(pos, "Operand '%s' must be an array but its type is '%s'", ident, typeName(t))
+	}
+	return arr.Elem, nil
+}
+
+// Checks the operand is an option and returns the type of its element.
+func (v *verifier) optionOperand(ident string, pos loc.Pos) (typing.Type, error) {
+	t, err := v.operand(ident, nil, pos)
+	if err != nil {
+		return nil, err
+	}
+	opt, ok := resolveVar(t).(*typing.Option)
+	if !ok {
+		return nil, verifyError(pos, "Operand '%s' must be an option but its type is '%s'", ident, typeName(t))
+	}
+	return opt.Elem, nil
+}
+
+// Checks operands of the value and returns the type of value. nil is returned when the type of
+// value cannot be determined from its operands.
+func (v *verifier) val(ident string, val Val, pos loc.Pos) (typing.Type, error) {
+	switch val := val.(type) {
+	case *Unit:
+		return typing.UnitType, nil
+	case *Bool:
+		return typing.BoolType, nil
+	case *Int:
+		return typing.IntType, nil
+	case *Float:
+		return typing.FloatType, nil
+	case *String:
+		return typing.StringType, nil
+	case *Char:
+		return typing.CharType, nil
+	case *Unary:
+		var t typing.Type
+		switch val.Op {
+		case NOT:
+			t = typing.BoolType
+		case NEG, LNOT:
+			t = typing.IntType
+		case FNEG:
+			t = typing.FloatType
+		default:
+			return nil, verifyError(pos, "Operator '%s' is not a unary operator", OpTable[val.Op])
+		}
+		return v.operand(val.Child, t, pos)
+	case *Binary:
+		var t typing.Type
+		switch val.Op {
+		case ADD, SUB, MUL, DIV, MOD, LAND, LOR, LXOR, LSL, LSR, ASR:
+			t = typing.IntType
+		case FADD, FSUB, FMUL, FDIV:
+			t = typing.FloatType
+		case AND, OR:
+			t = typing.BoolType
+		case LT, LTE, EQ, NEQ, GT, GTE:
+			lhs, err := v.operand(val.Lhs, nil, pos)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := v.operand(val.Rhs, lhs, pos); err != nil {
+				return nil, err
+			}
+			return typing.BoolType, nil
+		default:
+			return nil, verifyError(pos, "Operator '%s' is not a binary operator", OpTable[val.Op])
+		}
+		if _, err := v.operand(val.Lhs, t, pos); err != nil {
+			return nil, err
+		}
+		return v.operand(val.Rhs, t, pos)
+	case *Ref:
+		return v.operand(val.Ident, nil, pos)
+	case *If:
+		if _, err := v.operand(val.Cond, typing.BoolType, pos); err != nil {
+			return nil, err
+		}
+		then, err := v.block(val.Then, pos)
+		if err != nil {
+			return nil, err
+		}
+		els, err := v.block(val.Else, pos)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.expectType("else branch", els, then, pos); err != nil {
+			return nil, err
+		}
+		return then, nil
+	case *App:
+		return v.app(ident, val, pos)
+	case *Tuple:
+		elems, err := v.operands(val.Elems, pos)
+		if err != nil {
+			return nil, err
+		}
+		return &typing.Tuple{elems}, nil
+	case *Array:
+		if _, err := v.operand(val.Size, typing.IntType, pos); err != nil {
+			return nil, err
+		}
+		elem, err := v.operand(val.Elem, nil, pos)
+		if err != nil {
+			return nil, err
+		}
+		return &typing.Array{elem}, nil
+	case *TplLoad:
+		t, err := v.operand(val.From, nil, pos)
+		if err != nil {
+			return nil, err
+		}
+		tpl, ok := resolveVar(t).(*typing.Tuple)
+		if !ok {
+			return nil, verifyError(pos, "Operand '%s' of 'tplload' must be a tuple but its type is '%s'", val.From, typeName(t))
+		}
+		if val.Index < 0 || len(tpl.Elems) <= val.Index {
+			return nil, verifyError(pos, "Index %d is out of range of tuple '%s'", val.Index, tpl.String())
+		}
+		return tpl.Elems[val.Index], nil
+	case *ArrLoad:
+		elem, err := v.arrayOperand(val.From, pos)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := v.operand(val.Index, typing.IntType, pos); err != nil {
+			return nil, err
+		}
+		return elem, nil
+	case *ArrStore:
+		elem, err := v.arrayOperand(val.To, pos)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := v.operand(val.Index, typing.IntType, pos); err != nil {
+			return nil, err
+		}
+		if _, err := v.operand(val.Rhs, elem, pos); err != nil {
+			return nil, err
+		}
+		return typing.UnitType, nil
+	case *ArrLen:
+		if _, err := v.arrayOperand(val.Array, pos); err != nil {
+			return nil, err
+		}
+		return typing.IntType, nil
+	case *StrLoad:
+		if _, err := v.operand(val.From, typing.StringType, pos); err != nil {
+			return nil, err
+		}
+		if _, err := v.operand(val.Index, typing.IntType, pos); err != nil {
+			return nil, err
+		}
+		return typing.CharType, nil
+	case *Some:
+		elem, err := v.operand(val.Elem, nil, pos)
+		if err != nil {
+			return nil, err
+		}
+		return &typing.Option{elem}, nil
+	case *None:
+		t, err := v.typeOf(ident, pos)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := t.(*typing.Option); !ok && t != nil {
+			return nil, verifyError(pos, "Type of 'none' must be an option but it is '%s'", typeName(t))
+		}
+		return t, nil
+	case *IsSome:
+		if _, err := v.optionOperand(val.OptVal, pos); err != nil {
+			return nil, err
+		}
+		return typing.BoolType, nil
+	case *DerefSome:
+		return v.optionOperand(val.SomeVal, pos)
+	case *XRef:
+		if !v.isExternal(val.Ident) {
+			return nil, verifyError(pos, "'%s' is not an external symbol", val.Ident)
+		}
+		// Generic built-in function has no type in externals table (nil)
+		return v.env.Externals[val.Ident], nil
+	case *MakeCls:
+		return v.makeCls(val, pos)
+	default:
+		return nil, verifyError(pos, "Unexpected value %T", val)
+	}
+}
+
+func (v *verifier) toplevel(name string, f FunInsn) error {
+	if f.Name != name {
+		return verifyError(f.Pos, "Toplevel function '%s' is registered as '%s'", f.Name, name)
+	}
+	ty, err := v.typeOf(name, f.Pos)
+	if err != nil {
+		return err
+	}
+	var visible []string
+	if captures, ok := v.prog.Closures[name]; ok {
+		visible = captures
+		if f.Val.IsRecursive {
+			// Recursive closure can refer itself as closure value
+			visible = append(visible, name)
+		}
+	}
+	return v.fun(name, f.Val, ty, f.Pos, visible)
+}
+
+// Verify checks the program is well-formed. The first violation is returned as an error.
+// Program before closure transform can be verified by wrapping the root block with a program
+// which has no toplevel function.
+func Verify(prog *Program, env *typing.Env) error {
+	v := &verifier{prog, env, nil, map[string]struct{}{}}
+
+	for name := range prog.Closures {
+		if !v.isToplevel(name) {
+			return loc.Errorf("Closure '%s' is not a toplevel function", name)
+		}
+	}
+
+	// Iterate in order of names to make the reported error stable
+	for _, name := range prog.Toplevel.SortedNames() {
+		if err := v.toplevel(name, prog.Toplevel[name]); err != nil {
+			return loc.Notef(err, "In toplevel function '%s'", name)
+		}
+	}
+
+	if _, err := v.block(prog.Entry, loc.Pos{}); err != nil {
+		return loc.Note(err, "In entry of program")
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package gcil
+
+import (
+	"github.com/rhysd/gocaml/alpha"
+	"github.com/rhysd/gocaml/lexer"
+	"github.com/rhysd/gocaml/parser"
+	"github.com/rhysd/gocaml/typing"
+	"github.com/rhysd/loc"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyBeforeClosureTransform(t *testing.T) {
+	files, err := filepath.Glob(filepath.FromSlash("../testdata/from-mincaml/*.ml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := filepath.Glob(filepath.FromSlash("../codegen/testdata/*.ml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, more...)
+	if len(files) == 0 {
+		t.Fatal("No source file for test was found")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := loc.NewSourceFromFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := lexer.NewLexer(src)
+			go l.Lex()
+			ast, err := parser.Parse(l.Tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = alpha.Transform(ast.Root); err != nil {
+				t.Fatal(err)
+			}
+			env, err := typing.TypeInferernce(ast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ir, err := FromAST(ast.Root, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog := &Program{NewToplevel(), Closures{}, ir}
+			if err := Verify(prog, env); err != nil {
+				t.Fatalf("After K-normalization: %s", err.Error())
+			}
+			ElimRefs(ir, env)
+			if err := Verify(prog, env); err != nil {
+				t.Fatalf("After eliminating refs: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestVerifyValidProgram(t *testing.T) {
+	src := loc.NewDummySource(`
+[TOPLEVELS (2)]
+f$t1 = recfun x$t2 ; type=int -> int
+  BEGIN: body (f$t1)
+  $k1 = binary < x$t2 a$t3 ; type=bool
+  $k2 = if $k1 ; type=int
+    BEGIN: then
+    $k3 = appcls f$t1 a$t3 ; type=int
+    END: then
+    BEGIN: else
+    $k4 = binary + x$t2 a$t3 ; type=int
+    END: else
+  END: body (f$t1)
+
+g$t4 = fun y$t5 ; type=int -> int option
+  BEGIN: body (g$t4)
+  $k5 = some y$t5 ; type=int option
+  END: body (g$t4)
+
+[CLOSURES (1)]
+f$t1:	a$t3
+
+[ENTRY]
+BEGIN: program
+a$t3 = int 42 ; type=int
+f$t1 = makecls (a$t3) f$t1 ; type=int -> int ; alloc=heap
+$k6 = appcls f$t1 a$t3 ; type=int
+$k7 = app g$t4 $k6 ; type=int option
+$k8 = issome $k7 ; type=bool
+$k9 = int 16 ; type=int
+$k10 = appx Hashtbl.create $k9 ; type=(int, string) Hashtbl.t
+$k11 = none ; type=int option
+$k12 = binary = $k7 $k11 ; type=bool
+END: program
+`)
+	prog, env, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(prog, env); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyInvalidProgram(t *testing.T) {
+	header := "[TOPLEVELS (0)]\n[CLOSURES (0)]\n[ENTRY]\n"
+	closure := "[TOPLEVELS (1)]\nf = fun x ; type=int -> int\n  BEGIN: body (f)\n  $k1 = binary + x a ; type=int\n  END: body (f)\n"
+	for _, tc := range []struct {
+		what     string
+		src      string
+		expected string
+	}{
+		{
+			"assigned twice",
+			header + "BEGIN: program\n$k1 = int 1 ; type=int\n$k1 = int 2 ; type=int\nEND: program\n",
+			"Identifier '$k1' is assigned more than once",
+		},
+		{
+			"assigned in both function and entry",
+			"[TOPLEVELS (1)]\nf = fun x ; type=int -> int\n  BEGIN: body (f)\n  $k1 = unary - x ; type=int\n  END: body (f)\n[CLOSURES (0)]\n[ENTRY]\nBEGIN: program\n$k1 = int 1 ; type=int\nEND: program\n",
+			"Identifier '$k1' is assigned more than once",
+		},
+		{
+			"undefined identifier",
+			header + "BEGIN: program\n$k1 = unary - $k2 ; type=int\nEND: program\n",
+			"Identifier '$k2' is not defined or does not dominate its use",
+		},
+		{
+			"identifier does not dominate",
+			header + "BEGIN: program\n$k1 = bool true ; type=bool\n$k2 = if $k1 ; type=int\nBEGIN: then\n$k3 = int 1 ; type=int\nEND: then\nBEGIN: else\n$k4 = int 2 ; type=int\nEND: else\n$k5 = unary - $k3 ; type=int\nEND: program\n",
+			"Identifier '$k3' is not defined or does not dominate its use",
+		},
+		{
+			"used before definition",
+			header + "BEGIN: program\n$k1 = unary - $k2 ; type=int\n$k2 = int 1 ; type=int\nEND: program\n",
+			"Identifier '$k2' is not defined or does not dominate its use",
+		},
+		{
+			"inconsistent type of value",
+			header + "BEGIN: program\n$k1 = int 1 ; type=bool\nEND: program\n",
+			"Type of '$k1' must be 'int' but it is 'bool'",
+		},
+		{
+			"inconsistent type of operand",
+			header + "BEGIN: program\n$k1 = bool true ; type=bool\n$k2 = unary - $k1 ; type=int\nEND: program\n",
+			"Type of operand '$k1' must be 'int' but it is 'bool'",
+		},
+		{
+			"mismatched branches",
+			header + "BEGIN: program\n$k1 = bool true ; type=bool\n$k2 = if $k1 ; type=int\nBEGIN: then\n$k3 = int 1 ; type=int\nEND: then\nBEGIN: else\n$k4 = unit ; type=()\nEND: else\nEND: program\n",
+			"Type of else branch must be 'int' but it is '()'",
+		},
+		{
+			"wrong number of arguments",
+			header + "BEGIN: program\n$k1 = int 1 ; type=int\n$k2 = appx print_int $k1,$k1 ; type=()\nEND: program\n",
+			"Function 'print_int' requires 1 argument(s) but 2 given",
+		},
+		{
+			"unknown external symbol",
+			header + "BEGIN: program\n$k1 = xref foo ; type=int\nEND: program\n",
+			"'foo' is not an external symbol",
+		},
+		{
+			"tuple index out of range",
+			header + "BEGIN: program\n$k1 = int 1 ; type=int\n$k2 = tuple $k1,$k1 ; type=int * int ; alloc=heap\n$k3 = tplload 2 $k2 ; type=int\nEND: program\n",
+			"Index 2 is out of range of tuple 'int * int'",
+		},
+		{
+			"wrong return type",
+			"[TOPLEVELS (1)]\nf = fun x ; type=int -> bool\n  BEGIN: body (f)\n  $k1 = unary - x ; type=int\n  END: body (f)\n[CLOSURES (0)]\n[ENTRY]\nBEGIN: program\n$k2 = unit ; type=()\nEND: program\n",
+			"Type of returned value of function 'f' must be 'bool' but it is 'int'",
+		},
+		{
+			"closure is not a toplevel function",
+			"[TOPLEVELS (0)]\n[CLOSURES (1)]\nf:\ta\n[ENTRY]\nBEGIN: program\n$k1 = unit ; type=()\nEND: program\n",
+			"Closure 'f' is not a toplevel function",
+		},
+		{
+			"free variable of known function",
+			closure + "[CLOSURES (0)]\n[ENTRY]\nBEGIN: program\n$k2 = unit ; type=()\nEND: program\n",
+			"Identifier 'a' is not defined or does not dominate its use",
+		},
+		{
+			"mismatched captures",
+			closure + "[CLOSURES (1)]\nf:\ta\n[ENTRY]\nBEGIN: program\na = int 1 ; type=int\nf = makecls () f ; type=int -> int ; alloc=heap\nEND: program\n",
+			"Captures of closure 'f' must be 'a' but got ''",
+		},
+		{
+			"closure of known function",
+			"[TOPLEVELS (1)]\nf = fun x ; type=int -> int\n  BEGIN: body (f)\n  $k1 = unary - x ; type=int\n  END: body (f)\n[CLOSURES (0)]\n[ENTRY]\nBEGIN: program\nf = makecls () f ; type=int -> int ; alloc=heap\nEND: program\n",
+			"Function 'f' of closure is not registered as closure",
+		},
+		{
+			"non-recursive closure refers itself",
+			"[TOPLEVELS (1)]\nf = fun x ; type=int -> int\n  BEGIN: body (f)\n  $k1 = appcls f a ; type=int\n  END: body (f)\n[CLOSURES (1)]\nf:\ta\n[ENTRY]\nBEGIN: program\na = int 1 ; type=int\nf = makecls (a) f ; type=int -> int ; alloc=heap\nEND: program\n",
+			"Identifier 'f' is not defined or does not dominate its use",
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			prog, env, err := Parse(loc.NewDummySource(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Verify(prog, env)
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error message '%s' to contain '%s'", err.Error(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestVerifyBlockEndingWithNOP(t *testing.T) {
+	env := typing.NewEnv()
+	env.Table["$k1"] = typing.IntType
+	env.Table["$k2"] = typing.UnitType
+	block := NewBlockFromArray("program", []*Insn{
+		NewInsn("$k1", &Int{1}, loc.Pos{}),
+		NewInsn("$k2", NOPVal, loc.Pos{}),
+	})
+	err := Verify(&Program{NewToplevel(), Closures{}, block}, env)
+	if err == nil {
+		t.Fatal("Error did not occur")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "NOP instruction '$k2' is not allowed in block 'program'") {
+		t.Fatalf("Unexpected error message: %s", msg)
+	}
+
+	// Block which only has sentinels
+	block.Top.Next = block.Bottom
+	block.Bottom.Prev = block.Top
+	err = Verify(&Program{NewToplevel(), Closures{}, block}, env)
+	if err == nil {
+		t.Fatal("Error did not occur for empty block")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "Block 'program' must contain at least one instruction") {
+		t.Fatalf("Unexpected error message: %s", msg)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/rhysd/loc"
 	"io"
 	"os"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -105,6 +106,20 @@ func (l *Lexer) emitIdent(ident string) {
 		l.emit(token.FUN)
 	case "type":
 		l.emit(token.TYPE)
+	case "land":
+		l.emit(token.LAND)
+	case "lor":
+		l.emit(token.LOR)
+	case "lxor":
+		l.emit(token.LXOR)
+	case "lsl":
+		l.emit(token.LSL)
+	case "lsr":
+		l.emit(token.LSR)
+	case "asr":
+		l.emit(token.ASR)
+	case "lnot":
+		l.emit(token.LNOT)
 	default:
 		l.emit(token.IDENT)
 	}
@@ -378,15 +393,27 @@ func isDigit(r rune) bool {
 	return '0' <= r && r <= '9'
 }
 
-func lexArrayCreate(l *Lexer) stateFn {
+// Lexes qualified name of module member like 'String.trim'. 'Array.make', 'Array.length' and
+// 'String.get' are special forms. Other qualified names are emitted as identifiers which refer
+// to built-in functions in the module. Member of nested module like 'Random.State.int' is also
+// lexed as one identifier.
+func lexModuleMember(l *Lexer) stateFn {
 	if l.top != '.' {
 		l.expected("'.' for 'Array.make'", l.top)
 		return nil
 	}
 	l.eat()
 
-	if !l.eatIdent() {
-		return nil
+	for {
+		start := l.current.Offset
+		if !l.eatIdent() {
+			return nil
+		}
+		r, _ := utf8.DecodeRune(l.src.Code[start:])
+		if !unicode.IsUpper(r) || !l.followsMember() {
+			break
+		}
+		l.eat()
 	}
 
 	ident := string(l.src.Code[l.start.Offset:l.current.Offset])
@@ -397,15 +424,33 @@ func lexArrayCreate(l *Lexer) stateFn {
 	// current token string.
 	case "Array.make":
 		l.emit(token.ARRAY_MAKE)
-		return lex
 	case "Array.length":
 		l.emit(token.ARRAY_LENGTH)
-		return lex
+	case "String.get":
+		l.emit(token.STRING_GET)
 	default:
-		l.errmsg(fmt.Sprintf("Expected 'make' or 'length' for Array.make but got '%s'", ident))
-		l.emitIllegal()
-		return nil
+		if strings.HasPrefix(ident, "Array.") {
+			l.errmsg(fmt.Sprintf("Expected 'make' or 'length' for Array.make but got '%s'", ident))
+			l.emitIllegal()
+			return nil
+		}
+		l.emit(token.IDENT)
 	}
+	return lex
+}
+
+// Returns true when '.' and a letter follow the current position. It means the identifier
+// lexed just before is a module name.
+func (l *Lexer) followsMember() bool {
+	if l.top != '.' {
+		return false
+	}
+	next := l.current.Offset + 1
+	if next >= len(l.src.Code) {
+		return false
+	}
+	r, _ := utf8.DecodeRune(l.src.Code[next:])
+	return isLetter(r)
 }
 
 func lexIdent(l *Lexer) stateFn {
@@ -413,13 +458,49 @@ func lexIdent(l *Lexer) stateFn {
 		return nil
 	}
 	i := string(l.src.Code[l.start.Offset:l.current.Offset])
-	if i == "Array" {
-		return lexArrayCreate
+	if i == "Array" || unicode.IsUpper([]rune(i)[0]) && l.followsMember() {
+		return lexModuleMember
 	}
 	l.emitIdent(i)
 	return lex
 }
 
+// Lexes '~' and '?'. When an identifier and ':' follow like '~foo:', they are lexed as one label
+// token. Otherwise '~' (or '?') and the following identifier are separate tokens.
+func lexLabel(l *Lexer) stateFn {
+	sigil, label := token.TILDE, token.LABEL
+	if l.top == '?' {
+		sigil, label = token.QUESTION, token.OPTLABEL
+	}
+	l.eat()
+
+	if !isLetter(l.top) {
+		l.emit(sigil)
+		return lex
+	}
+
+	identStart := l.current
+	if !l.eatIdent() {
+		return nil
+	}
+
+	if l.top == ':' {
+		l.eat()
+		l.emit(label)
+		return lex
+	}
+
+	l.Tokens <- token.Token{
+		sigil,
+		l.start,
+		identStart,
+		l.src,
+	}
+	l.start = identStart
+	l.emitIdent(string(l.src.Code[l.start.Offset:l.current.Offset]))
+	return lex
+}
+
 func lexStringLiteral(l *Lexer) stateFn {
 	l.eat() // Eat first '"'
 	for !l.eof {
@@ -440,6 +521,29 @@ func lexStringLiteral(l *Lexer) stateFn {
 	return nil
 }
 
+func lexCharLiteral(l *Lexer) stateFn {
+	l.eat() // Eat first '\''
+	if l.top == '\\' {
+		// Skip escape ('\' and next char). Rest of escape sequence like '\x41' is eaten below
+		l.eat()
+		l.eat()
+	}
+	for !l.eof {
+		if l.top == '\'' {
+			l.eat()
+			l.emit(token.CHAR_LITERAL)
+			return lex
+		}
+		if l.top == '\n' {
+			break
+		}
+		l.eat()
+	}
+	l.errmsg("Unclosed character literal")
+	l.emitIllegal()
+	return nil
+}
+
 func lex(l *Lexer) stateFn {
 	for {
 		if l.eof {
@@ -485,9 +589,13 @@ func lex(l *Lexer) stateFn {
 			return lexLogicalAnd
 		case '"':
 			return lexStringLiteral
+		case '\'':
+			return lexCharLiteral
 		case ':':
 			l.eat()
 			l.emit(token.COLON)
+		case '~', '?':
+			return lexLabel
 		default:
 			switch {
 			case unicode.IsSpace(l.top):
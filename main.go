@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/rhysd/gocaml/codegen"
 	"github.com/rhysd/gocaml/compiler"
+	"github.com/rhysd/gocaml/coverage"
+	"github.com/rhysd/gocaml/project"
 	"github.com/rhysd/loc"
 	"os"
 )
@@ -14,6 +16,10 @@ var (
 	showTokens  = flag.Bool("tokens", false, "Show tokens for input")
 	showAST     = flag.Bool("ast", false, "Show AST for input")
 	showGCIL    = flag.Bool("gcil", false, "Emit GoCaml Intermediate Language representation to stdout")
+	gcilDot     = flag.Bool("gcil-dot", false, "Emit control flow graph of GoCaml Intermediate Language in Graphviz DOT format to stdout")
+	callDot     = flag.Bool("callgraph-dot", false, "Emit call graph of toplevel functions in Graphviz DOT format to stdout")
+	fromGCIL    = flag.Bool("from-gcil", false, "Read input as GoCaml Intermediate Language emitted by -gcil")
+	verifyGCIL  = flag.Bool("verify-gcil", false, "Verify GoCaml Intermediate Language after each transformation pass")
 	externals   = flag.Bool("externals", false, "Display external symbols")
 	llvm        = flag.Bool("llvm", false, "Emit LLVM IR to stdout")
 	asm         = flag.Bool("asm", false, "Emit assembler code to stdout")
@@ -23,6 +29,11 @@ var (
 	debug       = flag.Bool("g", false, "Compile with debug information")
 	target      = flag.String("target", "", "Target architecture triple")
 	showTargets = flag.Bool("show-targets", false, "Show all available targets")
+	timePasses  = flag.Bool("time-passes", false, "Report wall time and allocations of each compilation phase to stderr")
+	stats       = flag.Bool("stats", false, "Report statistics of compiled program to stderr")
+	cover       = flag.Bool("coverage", false, "Instrument counters for code coverage. Executable dumps them to $GOCAML_COVERAGE_FILE or 'gocaml.cover' on exit")
+	profiling   = flag.Bool("profiling", false, "Emit frame pointers and readable symbol names for sampling profilers such as perf")
+	profCalls   = flag.Bool("profile-calls", false, "Count calls and cumulative time of each toplevel function. Executable prints them as a table to stderr or $GOCAML_PROFILE_FILE on exit")
 )
 
 const usageHeader = `Usage: gocaml [flags] [file]
@@ -31,6 +42,14 @@ const usageHeader = `Usage: gocaml [flags] [file]
   When file is given as argument, compiler will compile it. Otherwise, compiler
   attempt to read from STDIN as source code to compile.
 
+Subcommands:
+  build    Build executables declared in project manifest (gocaml.toml).
+           See 'gocaml build -help'.
+  test     Compile and run tests, and check their outputs and exit statuses.
+           See 'gocaml test -help'.
+  cover    Report code coverage from profile dumped by executables compiled
+           with -coverage. See 'gocaml cover -help'.
+
 Flags:`
 
 func usage() {
@@ -38,6 +57,54 @@ func usage() {
 	flag.PrintDefaults()
 }
 
+const buildUsageHeader = `Usage: gocaml build [flags] [executables...]
+
+  Build executables declared in project manifest into its output directory.
+  When executable names are given, only they are built. Executables which are
+  up to date are not rebuilt.
+
+Flags:`
+
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, buildUsageHeader)
+		flags.PrintDefaults()
+	}
+	manifest := flags.String("manifest", project.ManifestFile, "Path to project manifest")
+	force := flags.Bool("force", false, "Rebuild executables even if they are up to date")
+	quiet := flags.Bool("quiet", false, "Do not report progress of build")
+	flags.Parse(args)
+
+	m, err := project.LoadManifest(*manifest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 4
+	}
+
+	b := project.NewBuilder(m)
+	b.Force = *force
+	if !*quiet {
+		b.Log = os.Stderr
+	}
+	if self, err := os.Executable(); err == nil {
+		if hash, err := project.HashFile(self); err == nil {
+			b.CompilerHash = hash
+		}
+	}
+	if rt, err := codegen.RuntimePath(); err == nil {
+		if hash, err := project.HashFile(rt); err == nil {
+			b.RuntimeHash = hash
+		}
+	}
+
+	if err := b.Build(flags.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 4
+	}
+	return 0
+}
+
 func getOptLevel() compiler.OptLevel {
 	switch *opt {
 	case 0:
@@ -56,7 +123,132 @@ func getOptLevel() compiler.OptLevel {
 	}
 }
 
+const testUsageHeader = `Usage: gocaml test [flags] [files or directories...]
+
+  Compile and run tests, and check their outputs and exit statuses. Tests are
+  files named '*_test.ml' and '.ml' files which contain 'expect' comments in
+  the given directories (current directory by default). Expected output is
+  written in '(* expect: ... *)' comment or in '.out' file next to the test.
+  Expected exit status is written in '(* expect-exit: N *)' comment.
+
+Flags:`
+
+func test(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, testUsageHeader)
+		flags.PrintDefaults()
+	}
+	r := project.NewTestRunner()
+	update := flags.Bool("update", false, "Rewrite expectations of failed tests with their actual results")
+	jobs := flags.Int("jobs", r.Jobs, "Number of tests run in parallel")
+	timeout := flags.Duration("timeout", r.Timeout, "Kill test which does not finish within the duration. 0 means no timeout")
+	opt := flags.Int("opt", 2, "Optimization level (0~3) to compile tests")
+	ldflags := flags.String("ldflags", "", "Flags passed to underlying linker")
+	cover := flags.Bool("coverage", false, "Compile tests with coverage instrumentation. Profile is dumped to $GOCAML_COVERAGE_FILE or 'gocaml.cover'")
+	flags.Parse(args)
+
+	if *opt < 0 || 3 < *opt {
+		fmt.Fprintf(os.Stderr, "Optimization level must be 0~3 but got %d\n", *opt)
+		return 4
+	}
+	r.Optimization = compiler.OptLevel(*opt)
+	r.LinkFlags = *ldflags
+	r.Update = *update
+	r.Jobs = *jobs
+	r.Timeout = *timeout
+	r.Coverage = *cover
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	tests, err := project.DiscoverTests(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 4
+	}
+	if len(tests) == 0 {
+		fmt.Fprintln(os.Stderr, "No test was found")
+		return 4
+	}
+
+	results, err := r.Run(tests)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 4
+	}
+	if !project.ReportTestResults(os.Stdout, results) {
+		return 1
+	}
+	return 0
+}
+
+const coverUsageHeader = `Usage: gocaml cover [flags] [profiles...]
+
+  Report code coverage from profiles dumped by executables compiled with
+  -coverage. Counts in multiple profiles are summed up. When no profile is
+  given, 'gocaml.cover' is read. Annotated listings of sources are output
+  in plain text by default.
+
+Flags:`
+
+func reportCoverage(args []string) int {
+	flags := flag.NewFlagSet("cover", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, coverUsageHeader)
+		flags.PrintDefaults()
+	}
+	html := flags.String("html", "", "Write annotated listings as HTML to the file")
+	summary := flags.Bool("summary", false, "Show only summary of coverage")
+	flags.Parse(args)
+
+	profiles := flags.Args()
+	if len(profiles) == 0 {
+		profiles = []string{coverage.DefaultProfile}
+	}
+	prof, err := coverage.LoadProfiles(profiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 4
+	}
+
+	if *summary {
+		for _, f := range prof.Files {
+			fmt.Printf("%s: %s\n", f.Source, f.Summary().String())
+		}
+		fmt.Printf("total: %s\n", prof.Summary().String())
+		return 0
+	}
+
+	if *html == "" {
+		err = coverage.WriteText(os.Stdout, prof)
+	} else {
+		var f *os.File
+		if f, err = os.Create(*html); err == nil {
+			err = coverage.WriteHTML(f, prof)
+			f.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 4
+	}
+	return 0
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "build":
+			os.Exit(build(os.Args[2:]))
+		case "test":
+			os.Exit(test(os.Args[2:]))
+		case "cover":
+			os.Exit(reportCoverage(os.Args[2:]))
+		}
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 
@@ -91,6 +283,13 @@ func main() {
 		TargetTriple: *target,
 		LinkFlags:    *ldflags,
 		DebugInfo:    *debug,
+		FromGCIL:     *fromGCIL,
+		VerifyGCIL:   *verifyGCIL,
+		TimePasses:   *timePasses,
+		Stats:        *stats,
+		Coverage:     *cover,
+		Profiling:    *profiling,
+		ProfileCalls: *profCalls,
 	}
 
 	switch {
@@ -104,7 +303,21 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(4)
 		}
-		prog.Println(os.Stdout, env)
+		prog.Dump(os.Stdout, env)
+	case *gcilDot:
+		prog, env, err := c.EmitGCIL(src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
+		prog.DumpDOT(os.Stdout, env)
+	case *callDot:
+		prog, _, err := c.EmitGCIL(src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
+		prog.DumpCallGraphDOT(os.Stdout)
 	case *llvm:
 		ir, err := c.EmitLLVMIR(src)
 		if err != nil {
@@ -130,4 +343,6 @@ func main() {
 			os.Exit(4)
 		}
 	}
+
+	c.PrintReport(os.Stderr)
 }
@@ -22,6 +22,7 @@ import (
 	decls []*ast.Symbol
 	decl *ast.Symbol
 	params []ast.Param
+	param ast.Param
 	type_decls []*ast.TypeDecl
 }
 
@@ -73,6 +74,19 @@ import (
 %token<token> FUN
 %token<token> COLON
 %token<token> TYPE
+%token<token> TILDE
+%token<token> QUESTION
+%token<token> LABEL
+%token<token> OPTLABEL
+%token<token> CHAR_LITERAL
+%token<token> STRING_GET
+%token<token> LAND
+%token<token> LOR
+%token<token> LXOR
+%token<token> LSL
+%token<token> LSR
+%token<token> ASR
+%token<token> LNOT
 
 %right prec_let
 %right SEMICOLON
@@ -85,7 +99,8 @@ import (
 %left AND_AND
 %left EQUAL LESS_GREATER LESS GREATER LESS_EQUAL GREATER_EQUAL
 %left PLUS MINUS PLUS_DOT MINUS_DOT
-%left STAR SLASH STAR_DOT SLASH_DOT PERCENT
+%left STAR SLASH STAR_DOT SLASH_DOT PERCENT LAND LOR LXOR
+%right LSL LSR ASR
 %right prec_unary_minus
 %left prec_app
 %left DOT
@@ -94,7 +109,9 @@ import (
 %type<node> parenless_exp
 %type<nodes> elems
 %type<nodes> args
+%type<node> arg
 %type<params> params
+%type<param> param
 %type<decls> pat
 %type<funcdef> fundef
 %type<token> match_arm_start
@@ -152,6 +169,21 @@ exp:
 		{ $$ = &ast.Div{$1, $3} }
 	| exp PERCENT exp
 		{ $$ = &ast.Mod{$1, $3} }
+	| LNOT exp
+		%prec prec_app
+		{ $$ = &ast.Lnot{$1, $2} }
+	| exp LAND exp
+		{ $$ = &ast.Land{$1, $3} }
+	| exp LOR exp
+		{ $$ = &ast.Lor{$1, $3} }
+	| exp LXOR exp
+		{ $$ = &ast.Lxor{$1, $3} }
+	| exp LSL exp
+		{ $$ = &ast.Lsl{$1, $3} }
+	| exp LSR exp
+		{ $$ = &ast.Lsr{$1, $3} }
+	| exp ASR exp
+		{ $$ = &ast.Asr{$1, $3} }
 	| exp EQUAL exp
 		{ $$ = &ast.Eq{$1, $3} }
 	| exp LESS_GREATER exp
@@ -217,6 +249,9 @@ exp:
 	| ARRAY_LENGTH parenless_exp
 		%prec prec_app
 		{ $$ = &ast.ArraySize{$1, $2} }
+	| STRING_GET parenless_exp parenless_exp
+		%prec prec_app
+		{ $$ = &ast.StringGet{$1, $2, $3} }
 	| SOME parenless_exp
 		{ $$ = &ast.Some{$1, $2} }
 	| FUN params simple_type_annotation MINUS_GREATER exp
@@ -239,21 +274,45 @@ fundef:
 		{ $$ = &ast.FuncDef{ast.NewSymbol($1.Value()), $2, $5, $3} }
 
 params:
+	param
+		{ $$ = []ast.Param{$1} }
+	| params param
+		{ $$ = append($1, $2) }
+
+param:
 	IDENT
-		{ $$ = []ast.Param{{sym($1), nil}} }
+		{ $$ = ast.Param{sym($1), nil, "", false, nil} }
 	| LPAREN IDENT COLON type RPAREN
-		{ $$ = []ast.Param{{sym($2), $4}} }
-	| params IDENT
-		{ $$ = append($1, ast.Param{sym($2), nil}) }
-	| params LPAREN IDENT COLON type RPAREN
-		{ $$ = append($1, ast.Param{sym($3), $5}) }
+		{ $$ = ast.Param{sym($2), $4, "", false, nil} }
+	| TILDE IDENT
+		{ $$ = ast.Param{sym($2), nil, $2.Value(), false, nil} }
+	| TILDE LPAREN IDENT COLON type RPAREN
+		{ $$ = ast.Param{sym($3), $5, $3.Value(), false, nil} }
+	| QUESTION IDENT
+		{ $$ = ast.Param{sym($2), nil, $2.Value(), true, nil} }
+	| QUESTION LPAREN IDENT COLON type RPAREN
+		{ $$ = ast.Param{sym($3), $5, $3.Value(), true, nil} }
+	| QUESTION LPAREN IDENT type_annotation EQUAL exp RPAREN
+		{ $$ = ast.Param{sym($3), $4, $3.Value(), true, $6} }
 
 args:
-	args parenless_exp
+	args arg
 		{ $$ = append($1, $2) }
-	| parenless_exp
+	| arg
 		{ $$ = []ast.Expr{$1} }
 
+arg:
+	parenless_exp
+		{ $$ = $1 }
+	| LABEL parenless_exp
+		{ $$ = &ast.LabeledArg{$1, labelName($1), false, $2} }
+	| OPTLABEL parenless_exp
+		{ $$ = &ast.LabeledArg{$1, labelName($1), true, $2} }
+	| TILDE IDENT
+		{ $$ = &ast.LabeledArg{$1, $2.Value(), false, &ast.VarRef{$2, sym($2)}} }
+	| QUESTION IDENT
+		{ $$ = &ast.LabeledArg{$1, $2.Value(), true, &ast.VarRef{$2, sym($2)}} }
+
 elems:
 	elems COMMA exp
 		{ $$ = append($1, $3) }
@@ -308,6 +367,16 @@ parenless_exp:
 				$$ = &ast.String{$1, s}
 			}
 		}
+	| CHAR_LITERAL
+		{
+			from := $1.Value()
+			c, err := unquoteChar(from)
+			if err != nil {
+				yylex.Error(fmt.Sprintf("Parse error at character literal %s: %s", from, err.Error()))
+			} else {
+				$$ = &ast.Char{$1, c}
+			}
+		}
 	| NONE
 		{ $$ = &ast.None{$1} }
 	| IDENT
@@ -401,4 +470,28 @@ func sym(tok *token.Token) *ast.Symbol {
 		return ast.NewSymbol(s)
 	}
 }
+
+// Returns 'foo' from label token '~foo:' or '?foo:'
+func labelName(tok *token.Token) string {
+	s := tok.Value()
+	return s[1 : len(s)-1]
+}
+
+// Unquotes character literal like 'a' or '\n'. Character must be one byte.
+func unquoteChar(lit string) (byte, error) {
+	if len(lit) < 3 {
+		return 0, fmt.Errorf("empty character literal")
+	}
+	v, multibyte, tail, err := strconv.UnquoteChar(lit[1:len(lit)-1], '\'')
+	if err != nil {
+		return 0, err
+	}
+	if tail != "" {
+		return 0, fmt.Errorf("more than one character in literal")
+	}
+	if multibyte || v > 0xff {
+		return 0, fmt.Errorf("character must be one byte")
+	}
+	return byte(v), nil
+}
 // vim: noet
@@ -138,6 +138,38 @@ func TestInvalidStringLiteral(t *testing.T) {
 	}
 }
 
+func TestInvalidCharLiteral(t *testing.T) {
+	for _, lit := range []string{"''", "'ab'", "'\\q'", "'\u00e9'", "'é'"} {
+		t.Run(lit, func(t *testing.T) {
+			src := loc.NewDummySource(lit)
+			tokens := []token.Token{
+				token.Token{
+					Kind:  token.CHAR_LITERAL,
+					Start: loc.Pos{0, 1, 1, src},
+					End:   loc.Pos{len(lit), 1, len(lit) + 1, src},
+					File:  src,
+				},
+				token.Token{
+					Kind:  token.EOF,
+					Start: loc.Pos{len(lit), 1, len(lit) + 1, src},
+					End:   loc.Pos{len(lit), 1, len(lit) + 1, src},
+					File:  src,
+				},
+			}
+			c := make(chan token.Token)
+			go func() {
+				for _, t := range tokens {
+					c <- t
+				}
+			}()
+			r, err := Parse(c)
+			if err == nil {
+				t.Fatalf("Invalid character literal %s must raise an error but got %v", lit, r)
+			}
+		})
+	}
+}
+
 func TestTooLargeFloatLiteral(t *testing.T) {
 	src := loc.NewDummySource("1.7976931348623159e308")
 	tokens := []token.Token{
@@ -0,0 +1,152 @@
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/rhysd/gocaml/compiler"
+	"github.com/rhysd/loc"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Hashes of built executables are stored in this directory under the output directory.
+const hashDir = ".gocaml-build"
+
+// Builder builds executables declared in manifest into its output directory. Executables are
+// rebuilt only when they are out of date. Whether an executable is up to date is checked with
+// content hash of its entry source, its options, the compiler and the runtime library.
+type Builder struct {
+	Manifest *Manifest
+	// When true, executables are rebuilt even if they are up to date
+	Force bool
+	// Content hash of the compiler itself. When the compiler is changed, all executables are rebuilt
+	CompilerHash string
+	// Content hash of the runtime library. When the runtime is changed, all executables are rebuilt
+	RuntimeHash string
+	// Progress of build is reported to this writer. Nothing is reported when it is nil
+	Log io.Writer
+
+	compile func(exe *Executable, src *loc.Source, output string) error
+}
+
+// NewBuilder creates a builder for the manifest.
+func NewBuilder(m *Manifest) *Builder {
+	return &Builder{Manifest: m, compile: compileExecutable}
+}
+
+func compileExecutable(exe *Executable, src *loc.Source, output string) error {
+	c := compiler.Compiler{
+		Optimization: exe.Optimization,
+		TargetTriple: exe.TargetTriple,
+		LinkFlags:    exe.LinkFlags,
+		DebugInfo:    exe.DebugInfo,
+	}
+	return c.CompileTo(src, output)
+}
+
+// HashFile returns hex-encoded SHA-256 hash of the file content. It is used for CompilerHash and
+// RuntimeHash.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (b *Builder) log(format string, args ...interface{}) {
+	if b.Log != nil {
+		fmt.Fprintf(b.Log, format+"\n", args...)
+	}
+}
+
+// Path to the built executable.
+func (b *Builder) output(exe *Executable) string {
+	return filepath.Join(b.Manifest.OutDir, exe.Name)
+}
+
+func (b *Builder) hashPath(exe *Executable) string {
+	return filepath.Join(b.Manifest.OutDir, hashDir, exe.Name)
+}
+
+// Calculates the hash of all inputs which affect the built executable.
+func (b *Builder) hash(exe *Executable, src *loc.Source) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "compiler:%s\nruntime:%s\n", b.CompilerHash, b.RuntimeHash)
+	fmt.Fprintf(h, "opt:%d\ntarget:%s\ndebug:%v\nldflags:%s\n", exe.Optimization, exe.TargetTriple, exe.DebugInfo, exe.LinkFlags)
+	fmt.Fprintf(h, "source:%d\n", len(src.Code))
+	h.Write(src.Code)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (b *Builder) upToDate(exe *Executable, hash string) bool {
+	if _, err := os.Stat(b.output(exe)); err != nil {
+		return false
+	}
+	prev, err := ioutil.ReadFile(b.hashPath(exe))
+	return err == nil && string(prev) == hash
+}
+
+// BuildExecutable builds the executable when it is out of date. It returns true when the executable
+// was actually built.
+func (b *Builder) BuildExecutable(exe *Executable) (bool, error) {
+	src, err := loc.NewSourceFromFile(exe.Main)
+	if err != nil {
+		return false, loc.NotefAt(exe.Pos, err, "Cannot read entry source of executable '%s'", exe.Name)
+	}
+
+	hash := b.hash(exe, src)
+	if !b.Force && b.upToDate(exe, hash) {
+		b.log("%s is up to date", exe.Name)
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(b.Manifest.OutDir, hashDir), 0755); err != nil {
+		return false, err
+	}
+	// Remove the previous hash at first so that the executable is rebuilt next time when this build fails
+	if err := os.Remove(b.hashPath(exe)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	b.log("Building %s from %s", exe.Name, exe.Main)
+	if err := b.compile(exe, src, b.output(exe)); err != nil {
+		return false, loc.Notef(err, "While building executable '%s'", exe.Name)
+	}
+
+	if err := ioutil.WriteFile(b.hashPath(exe), []byte(hash), 0644); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// Build builds executables which have the given names. When no name is given, all executables in
+// manifest are built. It stops at the first error.
+func (b *Builder) Build(names []string) error {
+	exes := b.Manifest.Executables
+	if len(names) > 0 {
+		exes = make([]*Executable, 0, len(names))
+		for _, n := range names {
+			exe := b.Manifest.Find(n)
+			if exe == nil {
+				return loc.Errorf("Executable '%s' is not declared in manifest", n)
+			}
+			exes = append(exes, exe)
+		}
+	}
+
+	for _, exe := range exes {
+		if _, err := b.BuildExecutable(exe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
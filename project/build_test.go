@@ -0,0 +1,175 @@
+package project
+
+import (
+	"bytes"
+	"github.com/rhysd/loc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Prepares a project in temporary directory. Executables are not compiled actually. Instead, built
+// executables are recorded.
+func testProject(t *testing.T, manifest string) (*Builder, *[]string, func()) {
+	dir, err := ioutil.TempDir("", "gocaml-project-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, code := range map[string]string{
+		"gocaml.toml": manifest,
+		"hello.ml":    `println_str "hello"`,
+		"fib.ml":      `let rec fib n = if n <= 1 then n else fib (n - 1) + fib (n - 2) in println_int (fib 10)`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(code), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+
+	m, err := LoadManifest(filepath.Join(dir, "gocaml.toml"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	built := []string{}
+	b := NewBuilder(m)
+	b.compile = func(exe *Executable, src *loc.Source, output string) error {
+		if strings.Contains(string(src.Code), "error") {
+			return loc.Errorf("Compile error")
+		}
+		built = append(built, exe.Name)
+		return ioutil.WriteFile(output, src.Code, 0755)
+	}
+	return b, &built, func() { os.RemoveAll(dir) }
+}
+
+func expectBuilt(t *testing.T, what string, b *Builder, built *[]string, names []string, expected ...string) {
+	*built = []string{}
+	if err := b.Build(names); err != nil {
+		t.Fatalf("%s: %s", what, err.Error())
+	}
+	if strings.Join(*built, ",") != strings.Join(expected, ",") {
+		t.Fatalf("%s: Expected %v to be built but actually %v were built", what, expected, *built)
+	}
+}
+
+const testManifest = `
+output_dir = "out"
+
+[[executable]]
+name = "hello"
+main = "hello.ml"
+
+[[executable]]
+name = "fib"
+main = "fib.ml"
+opt = 3
+`
+
+func TestBuildUpToDate(t *testing.T) {
+	b, built, cleanup := testProject(t, testManifest)
+	defer cleanup()
+
+	expectBuilt(t, "first build", b, built, nil, "hello", "fib")
+	if _, err := os.Stat(filepath.Join(b.Manifest.Dir, "out", "hello")); err != nil {
+		t.Fatal("Executable was not put in output directory:", err)
+	}
+	expectBuilt(t, "nothing changed", b, built, nil)
+
+	// Updating modification time without changing content does not cause rebuild
+	hello := filepath.Join(b.Manifest.Dir, "hello.ml")
+	if err := ioutil.WriteFile(hello, []byte(`println_str "hello"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectBuilt(t, "touched source", b, built, nil)
+
+	if err := ioutil.WriteFile(hello, []byte(`println_str "bye"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectBuilt(t, "source changed", b, built, nil, "hello")
+
+	b.Manifest.Find("fib").Optimization = 1
+	expectBuilt(t, "option changed", b, built, nil, "fib")
+
+	if err := os.Remove(filepath.Join(b.Manifest.OutDir, "fib")); err != nil {
+		t.Fatal(err)
+	}
+	expectBuilt(t, "executable removed", b, built, nil, "fib")
+
+	b.CompilerHash = "updated"
+	expectBuilt(t, "compiler changed", b, built, nil, "hello", "fib")
+
+	b.RuntimeHash = "updated"
+	expectBuilt(t, "runtime changed", b, built, nil, "hello", "fib")
+
+	b.Force = true
+	expectBuilt(t, "forced", b, built, []string{"fib"}, "fib")
+}
+
+func TestBuildSelectedExecutables(t *testing.T) {
+	b, built, cleanup := testProject(t, testManifest)
+	defer cleanup()
+
+	expectBuilt(t, "build fib", b, built, []string{"fib"}, "fib")
+	expectBuilt(t, "build all", b, built, nil, "hello")
+
+	err := b.Build([]string{"foo"})
+	if err == nil {
+		t.Fatal("Error did not occur for unknown executable")
+	}
+	if !strings.Contains(err.Error(), "Executable 'foo' is not declared in manifest") {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+func TestBuildFailure(t *testing.T) {
+	b, built, cleanup := testProject(t, testManifest)
+	defer cleanup()
+
+	var log bytes.Buffer
+	b.Log = &log
+	expectBuilt(t, "first build", b, built, nil, "hello", "fib")
+	if !strings.Contains(log.String(), "Building hello from ") {
+		t.Errorf("Progress was not reported: %s", log.String())
+	}
+
+	hello := filepath.Join(b.Manifest.Dir, "hello.ml")
+	if err := ioutil.WriteFile(hello, []byte(`error`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := b.Build(nil)
+	if err == nil {
+		t.Fatal("Error did not occur")
+	}
+	if !strings.Contains(err.Error(), "While building executable 'hello'") {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// Reverting the source must rebuild the executable since the previous build failed
+	if err := ioutil.WriteFile(hello, []byte(`println_str "hello"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectBuilt(t, "reverted", b, built, nil, "hello")
+
+	log.Reset()
+	expectBuilt(t, "nothing changed", b, built, nil)
+	if !strings.Contains(log.String(), "hello is up to date") {
+		t.Errorf("Up-to-date executable was not reported: %s", log.String())
+	}
+}
+
+func TestBuildMissingSource(t *testing.T) {
+	b, _, cleanup := testProject(t, "[[executable]]\nname = \"foo\"\nmain = \"foo.ml\"\n")
+	defer cleanup()
+
+	err := b.Build(nil)
+	if err == nil {
+		t.Fatal("Error did not occur")
+	}
+	if !strings.Contains(err.Error(), "Cannot read entry source of executable 'foo'") {
+		t.Fatal("Unexpected error:", err)
+	}
+}
@@ -0,0 +1,171 @@
+package project
+
+import (
+	"fmt"
+	"github.com/rhysd/loc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expectations of a test are written in comments of the test source.
+//
+//   (* expect: hello *)
+//
+//   (* expect:
+//   hello
+//   world
+//   *)
+//
+//   (* expect-exit: 1 *)
+//
+// 'expect' is the expected stdout and 'expect-exit' is the expected exit status (0 by default).
+// When the source has no 'expect' comment, the content of '.out' file next to the source (e.g.
+// 'foo_test.out' for 'foo_test.ml') is the expected stdout.
+// Trailing whitespaces of each line and trailing empty lines are ignored on comparing outputs.
+
+var (
+	expectComment     = regexp.MustCompile(`\(\*[ \t]*expect:(?s:(.*?))\*\)`)
+	expectExitComment = regexp.MustCompile(`\(\*[ \t]*expect-exit:[ \t]*(-?[0-9]+)[ \t]*\*\)`)
+)
+
+// Expectation is expected results of running a test.
+type Expectation struct {
+	Stdout    string // Normalized expected output
+	HasStdout bool   // False when no expected output is found
+	InComment bool   // True when expected output is written in 'expect' comment
+	OutFile   string // Path to '.out' file which contains expected output
+	ExitCode  int
+}
+
+// Normalizes output by removing trailing whitespaces of each line and trailing empty lines.
+func normalizeOutput(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t\r")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Extracts expected output from content of 'expect' comment.
+func expectedText(content string) string {
+	if !strings.Contains(content, "\n") {
+		return strings.TrimSpace(content)
+	}
+	lines := strings.Split(content, "\n")
+	if strings.TrimSpace(lines[0]) == "" {
+		// Output starts at the next line of 'expect:'
+		lines = lines[1:]
+	} else {
+		lines[0] = strings.TrimLeft(lines[0], " \t")
+	}
+	return normalizeOutput(strings.Join(lines, "\n"))
+}
+
+func outFileOf(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".out"
+}
+
+// ParseExpectation extracts expectation of the test source at path from its code.
+func ParseExpectation(path string, code []byte) (*Expectation, error) {
+	e := &Expectation{}
+
+	switch matches := expectComment.FindAllSubmatch(code, -1); len(matches) {
+	case 0:
+		out := outFileOf(path)
+		b, err := ioutil.ReadFile(out)
+		if err == nil {
+			e.Stdout = normalizeOutput(string(b))
+			e.HasStdout = true
+			e.OutFile = out
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	case 1:
+		e.Stdout = expectedText(string(matches[0][1]))
+		e.HasStdout = true
+		e.InComment = true
+	default:
+		return nil, loc.Errorf("Only one 'expect' comment is allowed in test '%s' but %d found", path, len(matches))
+	}
+
+	switch matches := expectExitComment.FindAllSubmatch(code, -1); len(matches) {
+	case 0:
+	case 1:
+		i, err := strconv.Atoi(string(matches[0][1]))
+		if err != nil {
+			return nil, loc.Errorf("Invalid exit status in 'expect-exit' comment in test '%s': %s", path, err.Error())
+		}
+		e.ExitCode = i
+	default:
+		return nil, loc.Errorf("Only one 'expect-exit' comment is allowed in test '%s' but %d found", path, len(matches))
+	}
+
+	return e, nil
+}
+
+// Formats output as 'expect' comment.
+func formatExpectComment(stdout string) (string, error) {
+	out := normalizeOutput(stdout)
+	if strings.Contains(out, "*)") {
+		return "", loc.Errorf("Output containing '*)' cannot be written in 'expect' comment")
+	}
+	if !strings.Contains(out, "\n") {
+		if out == "" {
+			return "(* expect: *)", nil
+		}
+		return fmt.Sprintf("(* expect: %s *)", out), nil
+	}
+	return fmt.Sprintf("(* expect:\n%s\n*)", out), nil
+}
+
+// UpdateExpectation rewrites expectation of the test source at path with the actual results.
+// When expected output was written in 'expect' comment, the comment is rewritten. Otherwise the
+// output is written to '.out' file. 'expect-exit' comment is added at the head of source when the
+// exit status is not 0 and the comment does not exist yet.
+func UpdateExpectation(path string, e *Expectation, stdout string, exitCode int) error {
+	if !e.InComment && (!e.HasStdout || normalizeOutput(stdout) != e.Stdout) {
+		out := e.OutFile
+		if out == "" {
+			out = outFileOf(path)
+		}
+		if err := ioutil.WriteFile(out, []byte(stdout), 0644); err != nil {
+			return err
+		}
+	}
+
+	code, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	updated := code
+
+	if e.InComment && normalizeOutput(stdout) != e.Stdout {
+		comment, err := formatExpectComment(stdout)
+		if err != nil {
+			return loc.Notef(err, "Cannot update test '%s'", path)
+		}
+		idx := expectComment.FindIndex(updated)
+		updated = append(append(append([]byte{}, updated[:idx[0]]...), comment...), updated[idx[1]:]...)
+	}
+
+	if exitCode != e.ExitCode {
+		comment := fmt.Sprintf("(* expect-exit: %d *)", exitCode)
+		if idx := expectExitComment.FindIndex(updated); idx != nil {
+			updated = append(append(append([]byte{}, updated[:idx[0]]...), comment...), updated[idx[1]:]...)
+		} else {
+			updated = append([]byte(comment+"\n"), updated...)
+		}
+	}
+
+	if string(updated) == string(code) {
+		return nil
+	}
+	return ioutil.WriteFile(path, updated, 0644)
+}
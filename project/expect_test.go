@@ -0,0 +1,210 @@
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseExpectation(t *testing.T) {
+	for _, tc := range []struct {
+		what     string
+		code     string
+		stdout   string
+		exitCode int
+	}{
+		{"single line", `print_int 42 (* expect: 42 *)`, "42", 0},
+		{"empty output", `() (* expect: *)`, "", 0},
+		{"multiple lines", "println_int 1; println_int 2\n(* expect:\n1\n2\n*)", "1\n2", 0},
+		{"output at first line", "(* expect: 1\n2   \n\n*)", "1\n2", 0},
+		{"exit status", "(* expect: bye *)\n(* expect-exit: 3 *)", "bye", 3},
+		{"negative exit status", "(* expect-exit: -1 *) (* expect:*)", "", -1},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			e, err := ParseExpectation("test.ml", []byte(tc.code))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !e.HasStdout || !e.InComment {
+				t.Fatalf("Expected output in comment was not found: %+v", e)
+			}
+			if e.Stdout != tc.stdout {
+				t.Errorf("Expected output should be %q but got %q", tc.stdout, e.Stdout)
+			}
+			if e.ExitCode != tc.exitCode {
+				t.Errorf("Expected exit status should be %d but got %d", tc.exitCode, e.ExitCode)
+			}
+		})
+	}
+}
+
+func TestParseExpectationInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		what     string
+		code     string
+		expected string
+	}{
+		{"multiple expect", "(* expect: a *) (* expect: b *)", "Only one 'expect' comment is allowed in test 'test.ml' but 2 found"},
+		{"multiple expect-exit", "(* expect-exit: 1 *) (* expect-exit: 2 *)", "Only one 'expect-exit' comment is allowed"},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			_, err := ParseExpectation("test.ml", []byte(tc.code))
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error message '%s' to contain '%s'", err.Error(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestExpectationInOutFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gocaml-expect-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "foo_test.ml")
+	e, err := ParseExpectation(path, []byte(`print_str "foo"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.HasStdout {
+		t.Fatalf("Expected output should not be found: %+v", e)
+	}
+
+	out := filepath.Join(dir, "foo_test.out")
+	if err := ioutil.WriteFile(out, []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err = ParseExpectation(path, []byte(`print_str "foo"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.HasStdout || e.InComment || e.OutFile != out || e.Stdout != "foo" {
+		t.Fatalf("Expected output in .out file was not found: %+v", e)
+	}
+
+	// 'expect' comment precedes .out file
+	e, err = ParseExpectation(path, []byte(`print_str "foo" (* expect: bar *)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.InComment || e.Stdout != "bar" {
+		t.Fatalf("Expected output in comment should be used: %+v", e)
+	}
+}
+
+func TestUpdateExpectation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gocaml-expect-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		what     string
+		code     string
+		stdout   string
+		exitCode int
+		expected string
+	}{
+		{
+			"single line",
+			"print_int 42 (* expect: 41 *)\n",
+			"42",
+			0,
+			"print_int 42 (* expect: 42 *)\n",
+		},
+		{
+			"multiple lines",
+			"println_int 1; println_int 2\n(* expect: 1 *)\n",
+			"1\n2\n",
+			0,
+			"println_int 1; println_int 2\n(* expect:\n1\n2\n*)\n",
+		},
+		{
+			"add exit status",
+			"exit 3 (* expect: *)\n",
+			"",
+			3,
+			"(* expect-exit: 3 *)\nexit 3 (* expect: *)\n",
+		},
+		{
+			"rewrite exit status",
+			"(* expect-exit: 1 *)\nexit 2 (* expect: *)\n",
+			"",
+			2,
+			"(* expect-exit: 2 *)\nexit 2 (* expect: *)\n",
+		},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			path := filepath.Join(dir, "update.ml")
+			if err := ioutil.WriteFile(path, []byte(tc.code), 0644); err != nil {
+				t.Fatal(err)
+			}
+			e, err := ParseExpectation(path, []byte(tc.code))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := UpdateExpectation(path, e, tc.stdout, tc.exitCode); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := string(b); actual != tc.expected {
+				t.Fatalf("Updated source is unexpected.\n\nExpected:\n%s\n\nActual:\n%s", tc.expected, actual)
+			}
+			updated, err := ParseExpectation(path, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.Stdout != normalizeOutput(tc.stdout) || updated.ExitCode != tc.exitCode {
+				t.Fatalf("Updated expectation does not match to the actual results: %+v", updated)
+			}
+		})
+	}
+
+	path := filepath.Join(dir, "new_test.ml")
+	if err := ioutil.WriteFile(path, []byte(`print_str "hi"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateExpectation(path, &Expectation{}, "hi", 0); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "new_test.out"))
+	if err != nil {
+		t.Fatal(".out file was not created:", err)
+	}
+	if string(b) != "hi" {
+		t.Fatalf("Unexpected content of .out file: %q", string(b))
+	}
+
+	path = filepath.Join(dir, "comment.ml")
+	code := `print_str "*)" (* expect: *)`
+	if err := ioutil.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := ParseExpectation(path, []byte(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UpdateExpectation(path, e, "*)", 0)
+	if err == nil || !strings.Contains(err.Error(), "Output containing '*)' cannot be written in 'expect' comment") {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	diff := diffLines([]string{"a", "b", "c", "d"}, []string{"a", "c", "x", "d", "e"})
+	expected := []string{" a", "-b", " c", "+x", " d", "+e"}
+	if strings.Join(diff, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected diff: %v", diff)
+	}
+}
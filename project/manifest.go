@@ -0,0 +1,318 @@
+// Package project provides a build tool for GoCaml projects. A project is described by manifest
+// file 'gocaml.toml' which declares executables and options to compile them.
+package project
+
+import (
+	"github.com/rhysd/gocaml/compiler"
+	"github.com/rhysd/loc"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestFile is the default file name of project manifest.
+const ManifestFile = "gocaml.toml"
+
+// Executable is a target of build declared with '[[executable]]' table in manifest.
+type Executable struct {
+	Name         string
+	Main         string // Absolute path to the entry source
+	Optimization compiler.OptLevel
+	TargetTriple string
+	DebugInfo    bool
+	LinkFlags    string
+	Pos          loc.Pos
+}
+
+// Manifest represents the content of manifest file.
+type Manifest struct {
+	Dir         string // Absolute path to the directory where manifest is put
+	OutDir      string // Absolute path to the directory where executables are put
+	Executables []*Executable
+}
+
+// Find returns the executable which has the name. It returns nil when not found.
+func (m *Manifest) Find(name string) *Executable {
+	for _, e := range m.Executables {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Manifest is written in a small subset of TOML. Only the following syntax is supported:
+//
+//   # comment
+//   key = "basic string"
+//   key = 'literal string'
+//   key = 42
+//   key = true
+//   [[executable]]
+//
+// Keys before the first '[[executable]]' table are project-wide settings. 'opt', 'target', 'debug'
+// and 'ldflags' are used as default values of all executables.
+
+type manifestValue struct {
+	val interface{} // One of string, int or bool
+	pos loc.Pos
+}
+
+type manifestTable struct {
+	pos    loc.Pos
+	values map[string]manifestValue
+}
+
+type manifestParser struct {
+	src     *loc.Source
+	project *manifestTable
+	tables  []*manifestTable
+}
+
+func (p *manifestParser) current() *manifestTable {
+	if len(p.tables) == 0 {
+		return p.project
+	}
+	return p.tables[len(p.tables)-1]
+}
+
+// Removes the trailing comment in the line. '#' in strings is not a comment.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseManifestValue(s string, pos loc.Pos) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, loc.ErrorAt(pos, "Value is missing")
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, loc.ErrorfAt(pos, "Invalid string %s: %s", s, err.Error())
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' || strings.ContainsRune(s[1:len(s)-1], '\'') {
+			return nil, loc.ErrorfAt(pos, "Invalid literal string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	default:
+		i, err := strconv.Atoi(strings.Replace(s, "_", "", -1))
+		if err != nil {
+			return nil, loc.ErrorfAt(pos, "Invalid value '%s'. Only string, integer and boolean are supported", s)
+		}
+		return i, nil
+	}
+}
+
+func (p *manifestParser) parseLine(line string, pos loc.Pos) error {
+	if strings.HasPrefix(line, "[") {
+		if line != "[[executable]]" {
+			return loc.ErrorfAt(pos, "Unknown table '%s'. Only '[[executable]]' is supported", line)
+		}
+		p.tables = append(p.tables, &manifestTable{pos, map[string]manifestValue{}})
+		return nil
+	}
+
+	eq := strings.IndexByte(line, '=')
+	if eq < 0 {
+		return loc.ErrorfAt(pos, "Expected 'key = value' but got '%s'", line)
+	}
+	key := strings.TrimSpace(line[:eq])
+	if key == "" {
+		return loc.ErrorfAt(pos, "Key is missing in '%s'", line)
+	}
+	val, err := parseManifestValue(strings.TrimSpace(line[eq+1:]), pos)
+	if err != nil {
+		return loc.NotefAt(pos, err, "Value of key '%s'", key)
+	}
+
+	t := p.current()
+	if _, ok := t.values[key]; ok {
+		return loc.ErrorfAt(pos, "Key '%s' is defined twice in the same table", key)
+	}
+	t.values[key] = manifestValue{val, pos}
+	return nil
+}
+
+type manifestKey struct {
+	name    string
+	example interface{} // Zero value of expected type
+}
+
+var (
+	projectKeys    = []manifestKey{{"output_dir", ""}, {"opt", 0}, {"target", ""}, {"debug", false}, {"ldflags", ""}}
+	executableKeys = []manifestKey{{"name", ""}, {"main", ""}, {"opt", 0}, {"target", ""}, {"debug", false}, {"ldflags", ""}}
+)
+
+func typeNameOf(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case int:
+		return "integer"
+	default:
+		return "boolean"
+	}
+}
+
+// Checks that all keys in the table are known and their values have expected types.
+func checkKeys(t *manifestTable, keys []manifestKey, table string) error {
+	for name, v := range t.values {
+		found := false
+		for _, k := range keys {
+			if k.name != name {
+				continue
+			}
+			found = true
+			if expected, actual := typeNameOf(k.example), typeNameOf(v.val); expected != actual {
+				return loc.ErrorfAt(v.pos, "Value of '%s' must be %s but got %s", name, expected, actual)
+			}
+		}
+		if !found {
+			return loc.ErrorfAt(v.pos, "Unknown key '%s' in %s", name, table)
+		}
+	}
+	return nil
+}
+
+func (t *manifestTable) lookup(key string) (manifestValue, bool) {
+	v, ok := t.values[key]
+	return v, ok
+}
+
+// Looks up the key in the table. When it is not found, it falls back to project-wide setting.
+func (p *manifestParser) lookup(t *manifestTable, key string) (manifestValue, bool) {
+	if v, ok := t.lookup(key); ok {
+		return v, true
+	}
+	return p.project.lookup(key)
+}
+
+func (p *manifestParser) executable(t *manifestTable, dir string) (*Executable, error) {
+	if err := checkKeys(t, executableKeys, "[[executable]] table"); err != nil {
+		return nil, err
+	}
+
+	exe := &Executable{Optimization: compiler.O2, Pos: t.pos}
+
+	name, ok := t.lookup("name")
+	if !ok {
+		return nil, loc.ErrorAt(t.pos, "'name' is required for executable")
+	}
+	exe.Name = name.val.(string)
+	if exe.Name == "" || strings.ContainsAny(exe.Name, `/\`) || exe.Name == "." || exe.Name == ".." {
+		return nil, loc.ErrorfAt(name.pos, "Invalid executable name '%s'. It must be a file name", exe.Name)
+	}
+
+	main, ok := t.lookup("main")
+	if !ok {
+		return nil, loc.ErrorfAt(t.pos, "'main' is required for executable '%s'", exe.Name)
+	}
+	exe.Main = resolvePath(dir, main.val.(string))
+
+	if v, ok := p.lookup(t, "opt"); ok {
+		o := v.val.(int)
+		if o < 0 || 3 < o {
+			return nil, loc.ErrorfAt(v.pos, "Optimization level must be 0~3 but got %d", o)
+		}
+		exe.Optimization = compiler.OptLevel(o)
+	}
+	if v, ok := p.lookup(t, "target"); ok {
+		exe.TargetTriple = v.val.(string)
+	}
+	if v, ok := p.lookup(t, "debug"); ok {
+		exe.DebugInfo = v.val.(bool)
+	}
+	if v, ok := p.lookup(t, "ldflags"); ok {
+		exe.LinkFlags = v.val.(string)
+	}
+
+	return exe, nil
+}
+
+// Resolves the path in manifest. Relative path is resolved from dir and absolute path is used as-is.
+func resolvePath(dir, path string) string {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// ParseManifest parses the manifest source. Relative paths in the manifest are resolved from dir and
+// absolute paths are used as-is.
+func ParseManifest(src *loc.Source, dir string) (*Manifest, error) {
+	p := &manifestParser{src, &manifestTable{loc.Pos{0, 1, 1, src}, map[string]manifestValue{}}, nil}
+
+	offset := 0
+	for i, line := range strings.Split(string(src.Code), "\n") {
+		trimmed := strings.TrimSpace(stripComment(line))
+		if trimmed != "" {
+			col := strings.Index(line, trimmed)
+			pos := loc.Pos{offset + col, i + 1, col + 1, src}
+			if err := p.parseLine(trimmed, pos); err != nil {
+				return nil, loc.Notef(err, "While parsing manifest")
+			}
+		}
+		offset += len(line) + 1
+	}
+
+	if err := checkKeys(p.project, projectKeys, "project settings"); err != nil {
+		return nil, loc.Notef(err, "While parsing manifest")
+	}
+
+	m := &Manifest{Dir: dir, OutDir: filepath.Join(dir, "bin")}
+	if v, ok := p.project.lookup("output_dir"); ok {
+		m.OutDir = resolvePath(dir, v.val.(string))
+	}
+
+	if len(p.tables) == 0 {
+		return nil, loc.ErrorAt(p.project.pos, "No executable is declared in manifest. Add '[[executable]]' table")
+	}
+	for _, t := range p.tables {
+		exe, err := p.executable(t, dir)
+		if err != nil {
+			return nil, loc.Notef(err, "While parsing manifest")
+		}
+		if prev := m.Find(exe.Name); prev != nil {
+			return nil, loc.ErrorfAt(exe.Pos, "Executable '%s' is declared twice", exe.Name).NotefAt(prev.Pos, "Previous declaration is here")
+		}
+		m.Executables = append(m.Executables, exe)
+	}
+
+	return m, nil
+}
+
+// LoadManifest reads and parses the manifest file.
+func LoadManifest(path string) (*Manifest, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := loc.NewSourceFromFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(src, filepath.Dir(abs))
+}
@@ -0,0 +1,149 @@
+package project
+
+import (
+	"fmt"
+	"github.com/rhysd/gocaml/compiler"
+	"github.com/rhysd/loc"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	src := loc.NewDummySource(`
+# Settings shared by all executables
+output_dir = "out/bin"
+opt = 3
+ldflags = "-lm" # Trailing comment
+
+[[executable]]
+name = "hello"
+main = "src/hello.ml"
+
+[[executable]]
+name = 'fib'
+main = "src/fib.ml"
+opt = 0
+debug = true
+target = "x86_64-apple-darwin"
+ldflags = "-L/opt/lib # not a comment"
+`)
+	dir := filepath.FromSlash("/path/to/project")
+	m, err := ParseManifest(src, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Dir != dir {
+		t.Errorf("Unexpected project directory: %s", m.Dir)
+	}
+	if expected := filepath.Join(dir, "out", "bin"); m.OutDir != expected {
+		t.Errorf("Output directory should be '%s' but got '%s'", expected, m.OutDir)
+	}
+	if len(m.Executables) != 2 {
+		t.Fatalf("2 executables should be declared but got %d", len(m.Executables))
+	}
+
+	hello := m.Find("hello")
+	if hello == nil {
+		t.Fatal("Executable 'hello' was not found")
+	}
+	if expected := filepath.Join(dir, "src", "hello.ml"); hello.Main != expected {
+		t.Errorf("Entry source should be '%s' but got '%s'", expected, hello.Main)
+	}
+	if hello.Optimization != compiler.O3 || hello.LinkFlags != "-lm" || hello.DebugInfo || hello.TargetTriple != "" {
+		t.Errorf("Project-wide settings were not inherited: %+v", hello)
+	}
+	if hello.Pos.Line != 7 {
+		t.Errorf("Position of executable should be line 7 but got %d", hello.Pos.Line)
+	}
+
+	fib := m.Find("fib")
+	if fib == nil {
+		t.Fatal("Executable 'fib' was not found")
+	}
+	if fib.Optimization != compiler.O0 || !fib.DebugInfo || fib.TargetTriple != "x86_64-apple-darwin" {
+		t.Errorf("Settings of executable were not set: %+v", fib)
+	}
+	if fib.LinkFlags != "-L/opt/lib # not a comment" {
+		t.Errorf("'#' in string should not be a comment: %s", fib.LinkFlags)
+	}
+
+	if m.Find("foo") != nil {
+		t.Error("Unknown executable should not be found")
+	}
+}
+
+func TestParseManifestDefaults(t *testing.T) {
+	src := loc.NewDummySource("[[executable]]\nname = \"a\"\nmain = \"a.ml\"\n")
+	m, err := ParseManifest(src, "proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join("proj", "bin"); m.OutDir != expected {
+		t.Errorf("Default output directory should be '%s' but got '%s'", expected, m.OutDir)
+	}
+	exe := m.Executables[0]
+	if exe.Optimization != compiler.O2 || exe.DebugInfo || exe.TargetTriple != "" || exe.LinkFlags != "" {
+		t.Errorf("Unexpected default settings: %+v", exe)
+	}
+}
+
+func TestParseManifestAbsolutePaths(t *testing.T) {
+	out, err := filepath.Abs(filepath.FromSlash("path/to/out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, err := filepath.Abs(filepath.FromSlash("path/to/main.ml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := loc.NewDummySource(fmt.Sprintf("output_dir = %q\n[[executable]]\nname = \"a\"\nmain = %q\n", filepath.ToSlash(out), filepath.ToSlash(main)))
+	m, err := ParseManifest(src, "proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.OutDir != out {
+		t.Errorf("Absolute output directory should be used as-is: want '%s' but got '%s'", out, m.OutDir)
+	}
+	if exe := m.Executables[0]; exe.Main != main {
+		t.Errorf("Absolute entry source should be used as-is: want '%s' but got '%s'", main, exe.Main)
+	}
+}
+
+func TestParseInvalidManifest(t *testing.T) {
+	exe := "[[executable]]\nname = \"a\"\nmain = \"a.ml\"\n"
+	for _, tc := range []struct {
+		what     string
+		src      string
+		expected string
+	}{
+		{"no executable", "opt = 1\n", "No executable is declared in manifest"},
+		{"unknown table", "[package]\n", "Unknown table '[package]'"},
+		{"not key-value", exe + "foo\n", "Expected 'key = value' but got 'foo'"},
+		{"missing key", exe + "= 1\n", "Key is missing"},
+		{"missing value", exe + "opt =\n", "Value is missing"},
+		{"invalid value", exe + "opt = two\n", "Invalid value 'two'"},
+		{"invalid string", exe + "target = \"foo\n", "Invalid string \"foo"},
+		{"duplicate key", exe + "opt = 1\nopt = 2\n", "Key 'opt' is defined twice"},
+		{"unknown key", exe + "foo = 1\n", "Unknown key 'foo' in [[executable]] table"},
+		{"unknown project key", "name = \"a\"\n" + exe, "Unknown key 'name' in project settings"},
+		{"wrong type", exe + "debug = 1\n", "Value of 'debug' must be boolean but got integer"},
+		{"missing name", "[[executable]]\nmain = \"a.ml\"\n", "'name' is required"},
+		{"missing main", "[[executable]]\nname = \"a\"\n", "'main' is required for executable 'a'"},
+		{"invalid name", "[[executable]]\nname = \"a/b\"\nmain = \"a.ml\"\n", "Invalid executable name 'a/b'"},
+		{"invalid opt", exe + "opt = 4\n", "Optimization level must be 0~3 but got 4"},
+		{"invalid project opt", "opt = -1\n" + exe, "Optimization level must be 0~3 but got -1"},
+		{"duplicate executable", exe + exe, "Executable 'a' is declared twice"},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			_, err := ParseManifest(loc.NewDummySource(tc.src), "proj")
+			if err == nil {
+				t.Fatal("Error did not occur")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error message '%s' to contain '%s'", err.Error(), tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package project
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/rhysd/gocaml/compiler"
+	"github.com/rhysd/loc"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscoverTests collects test sources from the paths. Files given directly are always regarded as
+// tests. Directories are searched recursively for files named '*_test.ml' and '.ml' files which
+// contain 'expect' comments. Hidden directories (e.g. '.git') are skipped.
+func DiscoverTests(paths []string) ([]string, error) {
+	found := []string{}
+	seen := map[string]struct{}{}
+	add := func(path string) {
+		if _, ok := seen[path]; !ok {
+			seen[path] = struct{}{}
+			found = append(found, path)
+		}
+	}
+
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			add(root)
+			continue
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != root && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(path) != ".ml" {
+				return nil
+			}
+			if strings.HasSuffix(path, "_test.ml") {
+				add(path)
+				return nil
+			}
+			code, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if expectComment.Match(code) || expectExitComment.Match(code) {
+				add(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return found, nil
+}
+
+// TestResult is a result of running a test.
+type TestResult struct {
+	Path     string
+	Expect   *Expectation
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Elapsed  time.Duration
+	// Error which prevented from running the test (e.g. compile error, timeout)
+	Err error
+	// True when the expectation was rewritten with the actual results
+	Updated bool
+}
+
+// Passed returns whether the actual results match to the expectation.
+func (r *TestResult) Passed() bool {
+	return r.Err == nil && r.Expect.HasStdout && normalizeOutput(r.Stdout) == r.Expect.Stdout && r.ExitCode == r.Expect.ExitCode
+}
+
+// TestRunner compiles and runs tests in parallel and checks their results.
+type TestRunner struct {
+	Optimization compiler.OptLevel
+	LinkFlags    string
+	// When true, expectations of failed tests are rewritten with the actual results
+	Update bool
+	// Number of tests run in parallel
+	Jobs int
+	// Each test is killed when it does not finish within this duration. 0 means no timeout
+	Timeout time.Duration
+	// When true, tests are compiled with coverage instrumentation
+	Coverage bool
+
+	compile func(src *loc.Source, executable string) error
+}
+
+// NewTestRunner creates a test runner with the default options.
+func NewTestRunner() *TestRunner {
+	r := &TestRunner{
+		Optimization: compiler.O2,
+		Jobs:         runtime.NumCPU(),
+		Timeout:      30 * time.Second,
+	}
+	r.compile = r.compileTest
+	return r
+}
+
+func (tr *TestRunner) compileTest(src *loc.Source, executable string) error {
+	c := compiler.Compiler{
+		Optimization: tr.Optimization,
+		LinkFlags:    tr.LinkFlags,
+		Coverage:     tr.Coverage,
+	}
+	return c.CompileTo(src, executable)
+}
+
+func (tr *TestRunner) runTest(path, executable string, compileMu *sync.Mutex) *TestResult {
+	res := &TestResult{Path: path}
+	start := time.Now()
+	defer func() {
+		res.Elapsed = time.Since(start)
+	}()
+
+	src, err := loc.NewSourceFromFile(path)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if res.Expect, err = ParseExpectation(path, src.Code); err != nil {
+		res.Err = err
+		return res
+	}
+
+	// LLVM context is shared in a process. So compilation cannot be run in parallel.
+	compileMu.Lock()
+	err = tr.compile(src, executable)
+	compileMu.Unlock()
+	if err != nil {
+		res.Err = loc.Note(err, "Compile error")
+		return res
+	}
+
+	ctx := context.Background()
+	if tr.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tr.Timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, executable)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	if ctx.Err() == context.DeadlineExceeded {
+		res.Err = loc.Errorf("Test did not finish within %s", tr.Timeout)
+		return res
+	}
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		res.Err = err
+		return res
+	}
+	res.ExitCode = cmd.ProcessState.ExitCode()
+
+	if tr.Update && !res.Passed() {
+		if err := UpdateExpectation(path, res.Expect, res.Stdout, res.ExitCode); err != nil {
+			res.Err = err
+			return res
+		}
+		res.Updated = true
+	}
+	return res
+}
+
+// Run runs the tests in parallel and returns their results in the same order as the tests.
+func (tr *TestRunner) Run(tests []string) ([]*TestResult, error) {
+	dir, err := ioutil.TempDir("", "gocaml-test")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	jobs := tr.Jobs
+	if jobs <= 0 {
+		jobs = 1
+	}
+
+	results := make([]*TestResult, len(tests))
+	indices := make(chan int)
+	var compileMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				executable := filepath.Join(dir, fmt.Sprintf("test%d", idx))
+				results[idx] = tr.runTest(tests[idx], executable, &compileMu)
+			}
+		}()
+	}
+	for i := range tests {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	return results, nil
+}
+
+// Computes line-based diff of expected and actual outputs. Lines only in expected output are
+// prefixed with '-' and lines only in actual output are prefixed with '+'.
+func diffLines(want, got []string) []string {
+	// Table of lengths of longest common subsequences of want[i:] and got[j:]
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if want[i] == got[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := []string{}
+	i, j := 0, 0
+	for i < len(want) && j < len(got) {
+		switch {
+		case want[i] == got[j]:
+			diff = append(diff, " "+want[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+want[i])
+			i++
+		default:
+			diff = append(diff, "+"+got[j])
+			j++
+		}
+	}
+	for ; i < len(want); i++ {
+		diff = append(diff, "-"+want[i])
+	}
+	for ; j < len(got); j++ {
+		diff = append(diff, "+"+got[j])
+	}
+	return diff
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+func printIndented(out io.Writer, lines []string) {
+	for _, l := range lines {
+		fmt.Fprintf(out, "    %s\n", l)
+	}
+}
+
+// ReportTestResults prints results of tests and the summary. It returns true when all tests passed
+// or were updated.
+func ReportTestResults(out io.Writer, results []*TestResult) bool {
+	passed, failed, updated := 0, 0, 0
+	for _, r := range results {
+		elapsed := fmt.Sprintf("(%.2fs)", r.Elapsed.Seconds())
+		switch {
+		case r.Err != nil:
+			failed++
+			fmt.Fprintf(out, "FAIL    %s %s\n", r.Path, elapsed)
+			printIndented(out, splitLines(r.Err.Error()))
+		case r.Updated:
+			updated++
+			fmt.Fprintf(out, "UPDATE  %s %s\n", r.Path, elapsed)
+		case r.Passed():
+			passed++
+			fmt.Fprintf(out, "PASS    %s %s\n", r.Path, elapsed)
+		default:
+			failed++
+			fmt.Fprintf(out, "FAIL    %s %s\n", r.Path, elapsed)
+			if !r.Expect.HasStdout {
+				fmt.Fprintln(out, "    No expected output was found. Add 'expect' comment or run with -update")
+			} else if actual := normalizeOutput(r.Stdout); actual != r.Expect.Stdout {
+				fmt.Fprintln(out, "    Output differs from expectation (-expected +actual):")
+				printIndented(out, diffLines(splitLines(r.Expect.Stdout), splitLines(actual)))
+			}
+			if r.ExitCode != r.Expect.ExitCode {
+				fmt.Fprintf(out, "    Exit status should be %d but got %d\n", r.Expect.ExitCode, r.ExitCode)
+			}
+			if r.Stderr != "" {
+				fmt.Fprintln(out, "    Stderr:")
+				printIndented(out, splitLines(strings.TrimRight(r.Stderr, "\n")))
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("%d passed, %d failed", passed, failed)
+	if updated > 0 {
+		summary += fmt.Sprintf(", %d updated", updated)
+	}
+	fmt.Fprintf(out, "\n%d tests: %s\n", len(results), summary)
+	return failed == 0
+}
@@ -0,0 +1,214 @@
+package project
+
+import (
+	"bytes"
+	"github.com/rhysd/loc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Instead of compiling GoCaml code, the test runner in tests makes a shell script from '(* sh: ... *)'
+// comment in the source.
+var shComment = regexp.MustCompile(`\(\* sh: (.*?) \*\)`)
+
+func newTestRunnerForTest(t *testing.T) *TestRunner {
+	if runtime.GOOS == "windows" {
+		t.Skip("Shell script is not available on Windows")
+	}
+	r := NewTestRunner()
+	r.compile = func(src *loc.Source, executable string) error {
+		m := shComment.FindSubmatch(src.Code)
+		if m == nil {
+			return loc.Errorf("Type error!")
+		}
+		return ioutil.WriteFile(executable, []byte("#!/bin/sh\n"+string(m[1])+"\n"), 0755)
+	}
+	return r
+}
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "gocaml-runner-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDiscoverTests(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"a_test.ml":         `()`,
+		"main.ml":           `()`,
+		"expect.ml":         `() (* expect: *)`,
+		"exit.ml":           `exit 1 (* expect-exit: 1 *)`,
+		"sub/b_test.ml":     `()`,
+		"sub/c_test.out":    ``,
+		".hidden/d_test.ml": `()`,
+	})
+	defer os.RemoveAll(dir)
+
+	tests, err := DiscoverTests([]string{dir, filepath.Join(dir, "main.ml"), filepath.Join(dir, "a_test.ml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range tests {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests[i] = filepath.ToSlash(rel)
+	}
+	expected := "a_test.ml,exit.ml,expect.ml,sub/b_test.ml,main.ml"
+	if actual := strings.Join(tests, ","); actual != expected {
+		t.Fatalf("Expected tests '%s' but got '%s'", expected, actual)
+	}
+
+	if _, err := DiscoverTests([]string{filepath.Join(dir, "unknown")}); err == nil {
+		t.Fatal("Error did not occur for unknown path")
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	r := newTestRunnerForTest(t)
+	dir := writeTestFiles(t, map[string]string{
+		"pass_test.ml":          "(* sh: echo hello; echo world *)\n(* expect:\nhello\nworld\n*)",
+		"out_test.ml":           `(* sh: printf 42 *)`,
+		"out_test.out":          "42\n",
+		"exit_test.ml":          "(* sh: echo bye; exit 3 *)\n(* expect: bye *)\n(* expect-exit: 3 *)",
+		"wrong_output_test.ml":  "(* sh: echo foo; echo baz *)\n(* expect:\nfoo\nbar\n*)",
+		"wrong_exit_test.ml":    "(* sh: echo oops >&2; exit 2 *)\n(* expect: *)",
+		"no_expect_test.ml":     `(* sh: echo hi *)`,
+		"compile_error_test.ml": `(* expect: *)`,
+	})
+	defer os.RemoveAll(dir)
+
+	tests, err := DiscoverTests([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := r.Run(tests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 7 {
+		t.Fatalf("7 results should be returned but got %d", len(results))
+	}
+	for i, res := range results {
+		if res.Path != tests[i] {
+			t.Fatalf("Results should be in the same order as tests: %v vs %v", res.Path, tests[i])
+		}
+		passed := strings.HasSuffix(res.Path, "pass_test.ml") || strings.HasSuffix(res.Path, "out_test.ml") || strings.HasSuffix(res.Path, "exit_test.ml") && !strings.Contains(res.Path, "wrong")
+		if res.Passed() != passed {
+			t.Errorf("Test '%s' should pass=%v: %+v", res.Path, passed, res)
+		}
+	}
+
+	var buf bytes.Buffer
+	if ReportTestResults(&buf, results) {
+		t.Error("Report should indicate failure")
+	}
+	report := buf.String()
+	for _, expected := range []string{
+		"PASS    " + filepath.Join(dir, "pass_test.ml"),
+		"FAIL    " + filepath.Join(dir, "compile_error_test.ml"),
+		"      Note: Compile error",
+		"    Type error!",
+		"    Output differs from expectation (-expected +actual):\n     foo\n    -bar\n    +baz\n",
+		"    Exit status should be 0 but got 2\n    Stderr:\n    oops\n",
+		"    No expected output was found. Add 'expect' comment or run with -update\n",
+		"7 tests: 3 passed, 4 failed\n",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Report should contain %q:\n%s", expected, report)
+		}
+	}
+}
+
+func TestRunTestsWithUpdate(t *testing.T) {
+	r := newTestRunnerForTest(t)
+	r.Update = true
+	r.Jobs = 2
+	dir := writeTestFiles(t, map[string]string{
+		"pass_test.ml":      "(* sh: echo ok *) (* expect: ok *)",
+		"comment_test.ml":   "(* sh: echo 1; echo 2; exit 1 *)\n(* expect: 1 *)\n",
+		"out_test.ml":       `(* sh: echo new *)`,
+		"out_test.out":      "old\n",
+		"no_expect_test.ml": `(* sh: echo hi *)`,
+		"error_test.ml":     `(* expect: *)`,
+	})
+	defer os.RemoveAll(dir)
+
+	tests, err := DiscoverTests([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := r.Run(tests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if ReportTestResults(&buf, results) {
+		t.Error("Report should indicate failure due to compile error")
+	}
+	if !strings.Contains(buf.String(), "5 tests: 1 passed, 1 failed, 3 updated\n") {
+		t.Errorf("Unexpected summary:\n%s", buf.String())
+	}
+
+	for file, expected := range map[string]string{
+		"comment_test.ml":    "(* expect-exit: 1 *)\n(* sh: echo 1; echo 2; exit 1 *)\n(* expect:\n1\n2\n*)\n",
+		"out_test.out":       "new\n",
+		"no_expect_test.out": "hi\n",
+		"pass_test.ml":       "(* sh: echo ok *) (* expect: ok *)",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("Unexpected content of '%s' after update: %q", file, string(b))
+		}
+	}
+
+	// All tests except for compile error pass after update
+	r.Update = false
+	results, err = r.Run(tests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if res.Passed() == strings.HasSuffix(res.Path, "error_test.ml") {
+			t.Errorf("Unexpected result of '%s' after update: %+v", res.Path, res)
+		}
+	}
+}
+
+func TestRunTestsTimeout(t *testing.T) {
+	r := newTestRunnerForTest(t)
+	r.Timeout = 100 * time.Millisecond
+	dir := writeTestFiles(t, map[string]string{
+		"slow_test.ml": "(* sh: exec sleep 5 *) (* expect: *)",
+	})
+	defer os.RemoveAll(dir)
+
+	results, err := r.Run([]string{filepath.Join(dir, "slow_test.ml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := results[0].Err; err == nil || !strings.Contains(err.Error(), "Test did not finish within 100ms") {
+		t.Fatalf("Unexpected result: %+v", results[0])
+	}
+}
@@ -6,6 +6,7 @@
 typedef int64_t gocaml_int;
 typedef int gocaml_bool;
 typedef double gocaml_float;
+typedef uint8_t gocaml_char;
 
 typedef struct {
     void *buf;
@@ -19,4 +20,10 @@ typedef struct {
 
 typedef struct {} gocaml_unit;
 
+// Closure value. 'fun' points to a function which receives 'env' as its first parameter.
+typedef struct {
+    void *fun;
+    void *env;
+} gocaml_closure;
+
 #endif    // GOCAML_H_INCLUDED
@@ -0,0 +1,147 @@
+# Data formatters for GoCaml values in LLDB.
+#
+# Values of string, array, option, tuple and closure are rendered in OCaml-like syntax.
+# Load this file in LLDB as below:
+#
+#   (lldb) command script import /path/to/gocaml/runtime/gocaml_lldb.py
+#
+# Values are recognized by the names of struct members in debug information emitted by the
+# compiler (see codegen/debug_info_builder.go).
+
+import lldb
+import re
+
+MAX_ELEMENTS = 100
+
+
+def display_name(name):
+    # Remove suffixes added by alpha transform and closure wrappers (e.g. 'f$t1' -> 'f')
+    return re.sub(r'\$t\d+|\$closure$', '', name)
+
+
+def member_names(valobj):
+    ty = valobj.GetType().GetCanonicalType()
+    return [ty.GetFieldAtIndex(i).GetName() for i in range(ty.GetNumberOfFields())]
+
+
+def format_value(valobj):
+    s = valobj.GetSummary()
+    if s is None:
+        s = valobj.GetValue()
+    if s is None:
+        return '?'
+    return s
+
+
+def format_elem(valobj):
+    s = format_value(valobj)
+    if ' ' in s and s[0] not in '([{"<':
+        return '(' + s + ')'
+    return s
+
+
+def string_summary(valobj):
+    chars = valobj.GetChildMemberWithName('chars').GetValueAsUnsigned(0)
+    if chars == 0:
+        return 'None'
+    size = valobj.GetChildMemberWithName('size').GetValueAsSigned(0)
+    if size == 0:
+        return '""'
+    err = lldb.SBError()
+    data = valobj.GetProcess().ReadMemory(chars, size, err)
+    if not err.Success():
+        return '<invalid string>'
+    s = data.decode('utf-8', 'replace')
+    s = s.replace('\\', '\\\\').replace('"', '\\"').replace('\n', '\\n').replace('\t', '\\t')
+    return '"' + s + '"'
+
+
+def array_summary(valobj):
+    buf = valobj.GetChildMemberWithName('buf')
+    if buf.GetValueAsUnsigned(0) == 0:
+        return 'None'
+    size = valobj.GetChildMemberWithName('size').GetValueAsSigned(0)
+    count = min(size, MAX_ELEMENTS)
+    elem_ty = buf.GetType().GetPointeeType()
+    stride = elem_ty.GetByteSize()
+    elems = []
+    for i in range(count):
+        elem = buf.CreateChildAtOffset('[%d]' % i, i * stride, elem_ty)
+        elems.append(format_elem(elem))
+    if count < size:
+        elems.append('...')
+    return '[|' + '; '.join(elems) + '|]'
+
+
+def option_summary(valobj):
+    if valobj.GetChildMemberWithName('tag').GetValueAsUnsigned(0) == 0:
+        return 'None'
+    return 'Some ' + format_elem(valobj.GetChildMemberWithName('value'))
+
+
+def tuple_summary(valobj):
+    if valobj.GetValueAsUnsigned(0) == 0:
+        return 'None'
+    tpl = valobj.Dereference()
+    return '(' + ', '.join(format_value(tpl.GetChildAtIndex(i)) for i in range(tpl.GetNumChildren())) + ')'
+
+
+def closure_summary(valobj):
+    addr = valobj.GetChildMemberWithName('fun').GetValueAsUnsigned(0)
+    if addr == 0:
+        return 'None'
+    target = valobj.GetTarget()
+    fun = target.ResolveLoadAddress(addr).GetFunction()
+    if not fun.IsValid():
+        return '<fun>'
+    name = display_name(fun.GetName())
+    args = fun.GetBlock().GetVariables(target, True, False, False)
+    for i in range(args.GetSize()):
+        arg = args.GetValueAtIndex(i)
+        if arg.GetName() != 'closure':
+            continue
+        env = valobj.GetChildMemberWithName('captures').Cast(arg.GetType()).Dereference()
+        captures = []
+        for j in range(env.GetNumChildren()):
+            c = env.GetChildAtIndex(j)
+            captures.append('%s = %s' % (c.GetName(), format_value(c)))
+        return '<fun %s> {%s}' % (name, '; '.join(captures))
+    return '<fun %s>' % name
+
+
+def summary(valobj, internal_dict):
+    if valobj.GetType().IsPointerType():
+        names = member_names(valobj.Dereference())
+        if names and names == ['_%d' % i for i in range(len(names))]:
+            return tuple_summary(valobj)
+        return None
+    names = member_names(valobj)
+    if names == ['chars', 'size']:
+        return string_summary(valobj)
+    if names == ['buf', 'size']:
+        return array_summary(valobj)
+    if names == ['tag', 'value']:
+        return option_summary(valobj)
+    if names == ['fun', 'captures']:
+        return closure_summary(valobj)
+    if valobj.GetTypeName() == '()':
+        return '()'
+    return None
+
+
+# Type names of GoCaml values in debug information. Their structures are checked in summary().
+TYPE_NAME_PATTERNS = [
+    r'^string$',
+    r'^\(\)$',
+    r' array$',
+    r' option$',
+    r'->',
+    r' \* ',
+]
+
+
+def __lldb_init_module(debugger, internal_dict):
+    for pattern in TYPE_NAME_PATTERNS:
+        debugger.HandleCommand(
+            'type summary add -w gocaml -x "%s" -F %s.summary' % (pattern, __name__))
+    debugger.HandleCommand('type category enable gocaml')
@@ -1,3 +1,6 @@
+// For clock_gettime() and sigaltstack() with -std=c99
+#define _XOPEN_SOURCE 700
+
 #include <stdio.h>
 #include <inttypes.h>
 #include <stdlib.h>
@@ -5,11 +8,27 @@
 #include <gc.h>
 #include "gocaml.h"
 #include <time.h>
+#include <stdarg.h>
+#include <ctype.h>
+#include <signal.h>
+#include <sys/stat.h>
+#include <dirent.h>
+#if !defined(_WIN32)
+#include <sys/wait.h>
+#include <unistd.h>
+#endif
 
 #define SNPRINTF_MAX 128
 #define LINE_MAX 1024
 #define BUF_CHUNK 1024
 
+// Exit codes on runtime failures. They are distinct from 0 and 1 so that the caller can know what
+// happened.
+#define EXIT_OUT_OF_MEMORY 2
+#define EXIT_ARITHMETIC_ERROR 3
+#define EXIT_INVALID_MEMORY_ACCESS 4
+#define EXIT_INVALID_ARGUMENT 5
+
 // Note:
 // Need to guard with this 'if' statement because when the string is allocated as global
 // constant variable, we can't modify it. And we does not need to modify global constant
@@ -34,8 +53,301 @@ typedef struct {
 } argv_t;
 argv_t argv;
 
+// Channels for file I/O. 'in_channel' and 'out_channel' are opaque pointers to 'channel_t' in
+// GoCaml. 'file' is set to NULL after the channel is closed.
+typedef struct {
+    FILE *file;
+} channel_t;
+
+// 'stdin', 'stdout' and 'stderr' in GoCaml. They are renamed by compiler because C standard library
+// already defines the names.
+channel_t *gocaml_stdin;
+channel_t *gocaml_stdout;
+channel_t *gocaml_stderr;
+
+static channel_t *new_channel(FILE *const file)
+{
+    channel_t *const c = (channel_t *) GC_malloc(sizeof(channel_t));
+    c->file = file;
+    return c;
+}
+
+static void *out_of_memory(size_t const size)
+{
+    fflush(stdout);
+    fprintf(stderr, "Runtime error: Out of memory while allocating %zu bytes\n", size);
+    exit(EXIT_OUT_OF_MEMORY);
+}
+
+static void invalid_argument(char const* const msg)
+{
+    fflush(stdout);
+    fprintf(stderr, "Runtime error: Invalid argument: %s\n", msg);
+    exit(EXIT_INVALID_ARGUMENT);
+}
+
+#if defined(_WIN32)
+static void runtime_failure(int const sig)
+{
+    // Flush outputs so far because the process can't continue
+    fflush(stdout);
+    if (sig == SIGFPE) {
+        fputs("Runtime error: Arithmetic error (e.g. division by zero)\n", stderr);
+        _Exit(EXIT_ARITHMETIC_ERROR);
+    }
+    fputs("Runtime error: Invalid memory access\n", stderr);
+    _Exit(EXIT_INVALID_MEMORY_ACCESS);
+}
+
+static void handle_runtime_failures(void)
+{
+    signal(SIGFPE, runtime_failure);
+    signal(SIGSEGV, runtime_failure);
+}
+#else
+static void write_all(int const fd, char const* buf, size_t size)
+{
+    while (size > 0) {
+        ssize_t const written = write(fd, buf, size);
+        if (written <= 0) {
+            return;
+        }
+        buf += written;
+        size -= (size_t) written;
+    }
+}
+
+// Writes outputs buffered in stdout with write(2). fflush() cannot be used in signal handler because
+// it is not async-signal-safe. Standard C provides no way to access the buffer of FILE, so fields of
+// FILE are read directly on glibc and BSD libc (including macOS). On other C libraries, the buffered
+// outputs are lost.
+static void write_pending_stdout(void)
+{
+#if defined(__GLIBC__)
+    char const* const base = stdout->_IO_write_base;
+    char const* const ptr = stdout->_IO_write_ptr;
+#elif defined(__APPLE__) || defined(__FreeBSD__) || defined(__NetBSD__) || defined(__OpenBSD__)
+    char const* const base = (char const*) stdout->_bf._base;
+    char const* const ptr = (char const*) stdout->_p;
+#else
+    char const* const base = NULL;
+    char const* const ptr = NULL;
+#endif
+    if (base != NULL && ptr != NULL && ptr > base) {
+        write_all(STDOUT_FILENO, base, (size_t) (ptr - base));
+    }
+}
+
+// Only async-signal-safe functions can be called in signal handler. stdio functions are not safe
+// because the signal may be raised while they are holding a lock or modifying their buffers.
+// Functions registered with atexit() (dumping coverage profile and call profile) are intentionally
+// not run because they use stdio. Counts until the failure are not reliable anyway.
+static void runtime_failure(int const sig)
+{
+    static char const arith[] = "Runtime error: Arithmetic error (e.g. division by zero)\n";
+    static char const memory[] = "Runtime error: Invalid memory access\n";
+    write_pending_stdout();
+    if (sig == SIGFPE) {
+        write_all(STDERR_FILENO, arith, sizeof(arith) - 1);
+        _exit(EXIT_ARITHMETIC_ERROR);
+    }
+    write_all(STDERR_FILENO, memory, sizeof(memory) - 1);
+    _exit(EXIT_INVALID_MEMORY_ACCESS);
+}
+
+// On stack overflow, the signal handler cannot run on the exhausted stack. So it runs on the
+// alternate signal stack.
+static void handle_runtime_failures(void)
+{
+    stack_t ss;
+    ss.ss_sp = malloc(SIGSTKSZ);
+    ss.ss_size = SIGSTKSZ;
+    ss.ss_flags = 0;
+    if (ss.ss_sp != NULL) {
+        sigaltstack(&ss, NULL);
+    }
+
+    struct sigaction sa;
+    memset(&sa, 0, sizeof(sa));
+    sa.sa_handler = runtime_failure;
+    sa.sa_flags = SA_ONSTACK;
+    sigemptyset(&sa.sa_mask);
+    sigaction(SIGFPE, &sa, NULL);
+    sigaction(SIGSEGV, &sa, NULL);
+    sigaction(SIGBUS, &sa, NULL);
+}
+#endif
+
+// Counters for code coverage. They are registered by __gocaml_coverage_init() when the program is
+// compiled with coverage instrumentation and dumped to the profile file on exit.
+static struct {
+    char const* source;
+    char const* points; // Descriptors of counters separated by newlines
+    uint64_t const* counters;
+    int64_t size;
+} coverage;
+
+static void dump_coverage(void)
+{
+    char const* path = getenv("GOCAML_COVERAGE_FILE");
+    if (path == NULL || *path == '\0') {
+        path = "gocaml.cover";
+    }
+
+    // Profile is appended so that counts of multiple runs can be merged
+    FILE *const f = fopen(path, "a");
+    if (f == NULL) {
+        fprintf(stderr, "Cannot open coverage profile '%s'\n", path);
+        return;
+    }
+
+    fprintf(f, "source %s\n", coverage.source);
+    char const* desc = coverage.points;
+    for (int64_t i = 0; i < coverage.size; ++i) {
+        char const* const end = strchr(desc, '\n');
+        int const len = end == NULL ? (int) strlen(desc) : (int) (end - desc);
+        fprintf(f, "%.*s %" PRIu64 "\n", len, desc, coverage.counters[i]);
+        if (end == NULL) {
+            break;
+        }
+        desc = end + 1;
+    }
+
+    fclose(f);
+}
+
+void __gocaml_coverage_init(char const* const source, char const* const points, uint64_t const* const counters, int64_t const size)
+{
+    coverage.source = source;
+    coverage.points = points;
+    coverage.counters = counters;
+    coverage.size = size;
+    atexit(dump_coverage);
+}
+
+// Call counts and cumulative times of toplevel functions. They are registered by
+// __gocaml_profile_init() when the program is compiled with -profile-calls and printed as a table
+// on exit. Time of recursive calls is counted only at the outermost call so that it is not counted
+// twice.
+typedef struct {
+    char const* name;
+    uint64_t calls;
+    uint64_t nanosecs;
+    uint64_t depth;
+} call_profile_t;
+
+static struct {
+    call_profile_t *funcs;
+    int64_t size;
+} call_profile;
+
+static uint64_t now_nanosecs(void)
+{
+    struct timespec ts;
+    clock_gettime(CLOCK_MONOTONIC, &ts);
+    return (uint64_t) ts.tv_sec * 1000000000 + (uint64_t) ts.tv_nsec;
+}
+
+static int compare_call_profiles(void const* const lhs, void const* const rhs)
+{
+    call_profile_t const* const l = lhs;
+    call_profile_t const* const r = rhs;
+    if (l->nanosecs != r->nanosecs) {
+        return l->nanosecs < r->nanosecs ? 1 : -1;
+    }
+    if (l->calls != r->calls) {
+        return l->calls < r->calls ? 1 : -1;
+    }
+    return strcmp(l->name, r->name);
+}
+
+static void print_call_profile(void)
+{
+    FILE *out = stderr;
+    char const* const path = getenv("GOCAML_PROFILE_FILE");
+    if (path != NULL && *path != '\0') {
+        out = fopen(path, "w");
+        if (out == NULL) {
+            fprintf(stderr, "Cannot open call profile '%s'\n", path);
+            return;
+        }
+    }
+
+    qsort(call_profile.funcs, call_profile.size, sizeof(call_profile_t), compare_call_profiles);
+
+    fprintf(out, "%12s %14s %14s  %s\n", "calls", "total (ms)", "per call (us)", "function");
+    for (int64_t i = 0; i < call_profile.size; ++i) {
+        call_profile_t const* const f = &call_profile.funcs[i];
+        if (f->calls == 0) {
+            continue;
+        }
+        double const total = (double) f->nanosecs / 1000000.0;
+        double const per_call = (double) f->nanosecs / 1000.0 / (double) f->calls;
+        fprintf(out, "%12" PRIu64 " %14.3f %14.3f  %s\n", f->calls, total, per_call, f->name);
+    }
+
+    if (out != stderr) {
+        fclose(out);
+    }
+}
+
+void __gocaml_profile_init(char const* const names, int64_t const size)
+{
+    call_profile.funcs = calloc(size > 0 ? size : 1, sizeof(call_profile_t));
+    if (call_profile.funcs == NULL) {
+        out_of_memory(size * sizeof(call_profile_t));
+    }
+    call_profile.size = size;
+
+    // Names are separated by newlines. They are copied because they are NUL-terminated here.
+    char const* name = names;
+    for (int64_t i = 0; i < size; ++i) {
+        char const* const end = strchr(name, '\n');
+        size_t const len = end == NULL ? strlen(name) : (size_t) (end - name);
+        char *const copied = malloc(len + 1);
+        if (copied == NULL) {
+            out_of_memory(len + 1);
+        }
+        memcpy(copied, name, len);
+        copied[len] = '\0';
+        call_profile.funcs[i].name = copied;
+        if (end == NULL) {
+            break;
+        }
+        name = end + 1;
+    }
+
+    atexit(print_call_profile);
+}
+
+// Called at the entry of each toplevel function. Returns the time when the function was entered.
+uint64_t __gocaml_profile_enter(int64_t const index)
+{
+    call_profile_t *const f = &call_profile.funcs[index];
+    f->calls++;
+    f->depth++;
+    return now_nanosecs();
+}
+
+// Called before each toplevel function returns. 'start' is the value returned from
+// __gocaml_profile_enter() at the entry of the function.
+void __gocaml_profile_leave(int64_t const index, uint64_t const start)
+{
+    uint64_t const end = now_nanosecs();
+    call_profile_t *const f = &call_profile.funcs[index];
+    f->depth--;
+    if (f->depth == 0) {
+        f->nanosecs += end - start;
+    }
+}
+
 int main(int const argc, char const* const argv_[]) {
     GC_init();
+    GC_set_oom_fn(out_of_memory);
+    handle_runtime_failures();
+    gocaml_stdin = new_channel(stdin);
+    gocaml_stdout = new_channel(stdout);
+    gocaml_stderr = new_channel(stderr);
     gocaml_string *ptr = (gocaml_string *) GC_malloc(argc * sizeof(gocaml_string *));
     for (int i = 0; i < argc; ++i) {
         gocaml_string s;
@@ -69,6 +381,11 @@ void print_str(gocaml_string const s)
     printf("%.*s", (int) s.size, (char *)s.chars);
 }
 
+void print_char(gocaml_char const c)
+{
+    putchar((int) c);
+}
+
 void println_int(gocaml_int const i)
 {
     printf("%" PRId64 "\n", i);
@@ -89,6 +406,63 @@ void println_str(gocaml_string const s)
     printf("%.*s\n", (int) s.size, (char *)s.chars);
 }
 
+void println_char(gocaml_char const c)
+{
+    putchar((int) c);
+    putchar('\n');
+}
+
+void prerr_int(gocaml_int const i)
+{
+    fprintf(stderr, "%" PRId64, i);
+}
+
+void prerr_bool(gocaml_bool const i)
+{
+    fprintf(stderr, "%s", i ? "true" : "false");
+}
+
+void prerr_float(gocaml_float const d)
+{
+    fprintf(stderr, "%lg", d);
+}
+
+void prerr_str(gocaml_string const s)
+{
+    fprintf(stderr, "%.*s", (int) s.size, (char *)s.chars);
+}
+
+void prerr_char(gocaml_char const c)
+{
+    fputc((int) c, stderr);
+}
+
+void prerrln_int(gocaml_int const i)
+{
+    fprintf(stderr, "%" PRId64 "\n", i);
+}
+
+void prerrln_bool(gocaml_bool const i)
+{
+    fprintf(stderr, "%s\n", i ? "true" : "false");
+}
+
+void prerrln_float(gocaml_float const d)
+{
+    fprintf(stderr, "%lg\n", d);
+}
+
+void prerrln_str(gocaml_string const s)
+{
+    fprintf(stderr, "%.*s\n", (int) s.size, (char *)s.chars);
+}
+
+void prerrln_char(gocaml_char const c)
+{
+    fputc((int) c, stderr);
+    fputc('\n', stderr);
+}
+
 gocaml_int float_to_int(gocaml_float const f)
 {
     return (gocaml_int) f;
@@ -222,30 +596,26 @@ gocaml_string get_line(gocaml_unit _)
     return ret;
 }
 
-gocaml_string get_char(gocaml_unit _)
+gocaml_char get_char(gocaml_unit _)
 {
     (void) _;
-    gocaml_string ret;
-    int *const s = (int *) GC_malloc(sizeof(int) * 2);
-    *s = getchar();
-    *(s + 1) = '\0';
-    ret.chars = (int8_t *) s;
-    ret.size = 1;
-    return ret;
+    return (gocaml_char) getchar();
 }
 
-gocaml_int to_char_code(gocaml_string const s)
+gocaml_int to_char_code(gocaml_char const c)
 {
-    if (s.size == 0) {
-        return 0;
-    }
-    return (int64_t) s.chars[0];
+    return (gocaml_int) c;
+}
+
+gocaml_char from_char_code(gocaml_int const i)
+{
+    return (gocaml_char) i;
 }
 
-gocaml_string from_char_code(gocaml_int const i)
+gocaml_string char_to_str(gocaml_char const c)
 {
     char *const ptr = GC_malloc(2);
-    *ptr = (char) i;
+    *ptr = (char) c;
     *(ptr + 1) = '\0';
     gocaml_string ret;
     ret.chars = (int8_t *) ptr;
@@ -360,3 +730,876 @@ gocaml_bool write_file(gocaml_string const filename, gocaml_string const content
     GOCAML_STRING_RESTORE_NULL(content);
     return (gocaml_bool) 1;
 }
+
+gocaml_string gocaml_getenv(gocaml_string const name)
+{
+    GOCAML_STRING_ENSURE_NULL(name);
+    char const* const value = getenv((char *) name.chars);
+    GOCAML_STRING_RESTORE_NULL(name);
+
+    gocaml_string ret;
+    if (value == NULL) {
+        ret.chars = NULL;
+        ret.size = 0;
+        return ret;
+    }
+
+    // Copy the value because it may be modified by later call of getenv() or setenv()
+    size_t const len = strlen(value);
+    ret.chars = (int8_t *) GC_malloc(len + 1);
+    memcpy(ret.chars, value, len + 1);
+    ret.size = (gocaml_int) len;
+    return ret;
+}
+
+// Runs the command with shell and returns its exit status
+gocaml_int Sys_command(gocaml_string const cmd)
+{
+    fflush(stdout);
+    GOCAML_STRING_ENSURE_NULL(cmd);
+    int const status = system((char *) cmd.chars);
+    GOCAML_STRING_RESTORE_NULL(cmd);
+#if defined(_WIN32)
+    return (gocaml_int) status;
+#else
+    if (status == -1) {
+        return (gocaml_int) -1;
+    }
+    if (WIFEXITED(status)) {
+        return (gocaml_int) WEXITSTATUS(status);
+    }
+    // Killed by signal. Follow the convention of shell
+    return (gocaml_int) (128 + WTERMSIG(status));
+#endif
+}
+
+gocaml_bool Sys_file_exists(gocaml_string const path)
+{
+    GOCAML_STRING_ENSURE_NULL(path);
+    struct stat st;
+    int const ret = stat((char *) path.chars, &st);
+    GOCAML_STRING_RESTORE_NULL(path);
+    return (gocaml_bool) (ret == 0);
+}
+
+// Returns names of entries in the directory except for '.' and '..'. Order of them is unspecified.
+gocaml_array Sys_readdir(gocaml_string const path)
+{
+    GOCAML_STRING_ENSURE_NULL(path);
+    DIR *const dir = opendir((char *) path.chars);
+    GOCAML_STRING_RESTORE_NULL(path);
+
+    gocaml_array ret;
+    if (dir == NULL) {
+        ret.buf = NULL;
+        ret.size = 0;
+        return ret;
+    }
+
+    gocaml_int capacity = 16;
+    gocaml_int size = 0;
+    gocaml_string *entries = (gocaml_string *) GC_malloc(sizeof(gocaml_string) * capacity);
+    struct dirent *ent;
+    while ((ent = readdir(dir)) != NULL) {
+        char const* const name = ent->d_name;
+        if (strcmp(name, ".") == 0 || strcmp(name, "..") == 0) {
+            continue;
+        }
+        if (size == capacity) {
+            capacity *= 2;
+            entries = (gocaml_string *) GC_realloc(entries, sizeof(gocaml_string) * capacity);
+        }
+        size_t const len = strlen(name);
+        gocaml_string s;
+        s.chars = (int8_t *) GC_malloc(len + 1);
+        memcpy(s.chars, name, len + 1);
+        s.size = (gocaml_int) len;
+        entries[size++] = s;
+    }
+    closedir(dir);
+
+    ret.buf = entries;
+    ret.size = size;
+    return ret;
+}
+
+static channel_t *open_channel(gocaml_string const filename, char const* const mode)
+{
+    GOCAML_STRING_ENSURE_NULL(filename);
+    FILE *const file = fopen((char *) filename.chars, mode);
+    GOCAML_STRING_RESTORE_NULL(filename);
+    if (file == NULL) {
+        return NULL;
+    }
+    return new_channel(file);
+}
+
+channel_t *open_in(gocaml_string const filename)
+{
+    return open_channel(filename, "r");
+}
+
+channel_t *open_out(gocaml_string const filename)
+{
+    return open_channel(filename, "w");
+}
+
+channel_t *open_out_append(gocaml_string const filename)
+{
+    return open_channel(filename, "a");
+}
+
+// Returns a line without trailing newline. Returns None at the end of input.
+gocaml_string input_line(channel_t *const c)
+{
+    gocaml_string ret;
+    if (c->file == NULL) {
+        ret.chars = NULL;
+        ret.size = 0;
+        return ret;
+    }
+
+    size_t capacity = BUF_CHUNK;
+    size_t size = 0;
+    char *buf = (char *) GC_malloc(capacity);
+    int ch;
+    while ((ch = getc(c->file)) != EOF && ch != '\n') {
+        if (size + 1 >= capacity) {
+            capacity *= 2;
+            buf = (char *) GC_realloc(buf, capacity);
+        }
+        buf[size++] = (char) ch;
+    }
+
+    if (ch == EOF && size == 0) {
+        ret.chars = NULL;
+        ret.size = 0;
+        return ret;
+    }
+
+    buf[size] = '\0';
+    ret.chars = (int8_t *) buf;
+    ret.size = (gocaml_int) size;
+    return ret;
+}
+
+void output_string(channel_t *const c, gocaml_string const s)
+{
+    if (c->file == NULL) {
+        return;
+    }
+    fwrite(s.chars, sizeof(char), (size_t) s.size, c->file);
+}
+
+void flush(channel_t *const c)
+{
+    if (c->file == NULL) {
+        return;
+    }
+    fflush(c->file);
+}
+
+static void close_channel(channel_t *const c)
+{
+    if (c->file == NULL) {
+        return;
+    }
+    fclose(c->file);
+    c->file = NULL;
+}
+
+void close_in(channel_t *const c)
+{
+    close_channel(c);
+}
+
+void close_out(channel_t *const c)
+{
+    close_channel(c);
+}
+
+// Formatting functions for printf and sprintf. 'spec' is a conversion specifier like "%-5d"
+// which was already validated by compiler.
+
+static gocaml_string format_value(char const* const fmt, ...)
+{
+    va_list args;
+    va_list copied;
+    va_start(args, fmt);
+    va_copy(copied, args);
+    int const n = vsnprintf(NULL, 0, fmt, args);
+    va_end(args);
+
+    char *const s = (char *) GC_malloc(n + 1);
+    vsnprintf(s, n + 1, fmt, copied);
+    va_end(copied);
+
+    gocaml_string ret;
+    ret.chars = (int8_t *) s;
+    ret.size = (gocaml_int) n;
+    return ret;
+}
+
+// Make C format string from the specifier by replacing its conversion character with 'conv'.
+// 'dst' must have enough space for spec.size + strlen(conv).
+static void c_format(char *const dst, gocaml_string const spec, char const* const conv)
+{
+    size_t const len = (size_t) spec.size - 1;
+    memcpy(dst, spec.chars, len);
+    strcpy(dst + len, conv);
+}
+
+gocaml_string __format_int(gocaml_string const spec, gocaml_int const i)
+{
+    char conv[] = {'l', 'l', (char) spec.chars[spec.size - 1], '\0'};
+    char fmt[spec.size + sizeof(conv)];
+    c_format(fmt, spec, conv);
+    return format_value(fmt, (long long) i);
+}
+
+gocaml_string __format_float(gocaml_string const spec, gocaml_float const f)
+{
+    char conv[] = {(char) spec.chars[spec.size - 1], '\0'};
+    char fmt[spec.size + sizeof(conv)];
+    c_format(fmt, spec, conv);
+    return format_value(fmt, f);
+}
+
+gocaml_string __format_str(gocaml_string const spec, gocaml_string const s)
+{
+    // Do not expect NUL-terminated string because of string slices
+    char *const str = (char *) GC_malloc(s.size + 1);
+    memcpy(str, s.chars, (size_t) s.size);
+    str[s.size] = '\0';
+
+    char fmt[spec.size + 2];
+    c_format(fmt, spec, "s");
+    return format_value(fmt, str);
+}
+
+gocaml_string __format_bool(gocaml_string const spec, gocaml_bool const b)
+{
+    char fmt[spec.size + 2];
+    c_format(fmt, spec, "s");
+    return format_value(fmt, b ? "true" : "false");
+}
+
+gocaml_string __format_char(gocaml_string const spec, gocaml_char const c)
+{
+    char fmt[spec.size + 2];
+    c_format(fmt, spec, "c");
+    return format_value(fmt, (int) c);
+}
+
+gocaml_string __format_concat(gocaml_string const l, gocaml_string const r)
+{
+    gocaml_int const size = l.size + r.size;
+    char *const s = (char *) GC_malloc(size + 1);
+    memcpy(s, l.chars, (size_t) l.size);
+    memcpy(s + l.size, r.chars, (size_t) r.size);
+    s[size] = '\0';
+
+    gocaml_string ret;
+    ret.chars = (int8_t *) s;
+    ret.size = size;
+    return ret;
+}
+
+// Functions of String module. Their names in GoCaml are 'String.xxx'. Strings returned from them
+// may be slices of the given strings. So they do not expect NUL-terminated strings.
+
+static gocaml_string new_string(gocaml_int const size)
+{
+    char *const s = (char *) GC_malloc(size + 1);
+    s[size] = '\0';
+    gocaml_string ret;
+    ret.chars = (int8_t *) s;
+    ret.size = size;
+    return ret;
+}
+
+static gocaml_string string_slice(gocaml_string const s, gocaml_int const start, gocaml_int const size)
+{
+    gocaml_string ret;
+    ret.chars = s.chars + start;
+    ret.size = size;
+    return ret;
+}
+
+static gocaml_int string_find(gocaml_string const s, gocaml_string const sub, gocaml_int const from)
+{
+    for (gocaml_int i = from; i + sub.size <= s.size; ++i) {
+        if (memcmp(s.chars + i, sub.chars, (size_t) sub.size) == 0) {
+            return i;
+        }
+    }
+    return -1;
+}
+
+// Length in bytes
+gocaml_int String_length(gocaml_string const s)
+{
+    return s.size;
+}
+
+// Length in Unicode code points. 's' is assumed to be encoded in UTF-8
+gocaml_int String_utf8_length(gocaml_string const s)
+{
+    gocaml_int len = 0;
+    for (gocaml_int i = 0; i < s.size; ++i) {
+        // Count bytes except for continuation bytes (10xxxxxx)
+        if ((s.chars[i] & 0xc0) != 0x80) {
+            len++;
+        }
+    }
+    return len;
+}
+
+gocaml_int String_index_of(gocaml_string const s, gocaml_string const sub)
+{
+    return string_find(s, sub, 0);
+}
+
+gocaml_bool String_contains(gocaml_string const s, gocaml_string const sub)
+{
+    return string_find(s, sub, 0) >= 0;
+}
+
+gocaml_bool String_starts_with(gocaml_string const prefix, gocaml_string const s)
+{
+    return prefix.size <= s.size && memcmp(s.chars, prefix.chars, (size_t) prefix.size) == 0;
+}
+
+gocaml_array String_split_on_char(gocaml_char const sep, gocaml_string const s)
+{
+    gocaml_int count = 1;
+    for (gocaml_int i = 0; i < s.size; ++i) {
+        if ((gocaml_char) s.chars[i] == sep) {
+            count++;
+        }
+    }
+
+    gocaml_string *const buf = (gocaml_string *) GC_malloc(sizeof(gocaml_string) * count);
+    gocaml_int start = 0;
+    gocaml_int idx = 0;
+    for (gocaml_int i = 0; i < s.size; ++i) {
+        if ((gocaml_char) s.chars[i] == sep) {
+            buf[idx++] = string_slice(s, start, i - start);
+            start = i + 1;
+        }
+    }
+    buf[idx] = string_slice(s, start, s.size - start);
+
+    gocaml_array ret;
+    ret.buf = buf;
+    ret.size = count;
+    return ret;
+}
+
+static int is_trimmed_space(int8_t const c)
+{
+    return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f';
+}
+
+gocaml_string String_trim(gocaml_string const s)
+{
+    gocaml_int start = 0;
+    while (start < s.size && is_trimmed_space(s.chars[start])) {
+        start++;
+    }
+    gocaml_int last = s.size;
+    while (start < last && is_trimmed_space(s.chars[last - 1])) {
+        last--;
+    }
+    return string_slice(s, start, last - start);
+}
+
+// Only ASCII characters are converted
+gocaml_string String_uppercase(gocaml_string const s)
+{
+    gocaml_string ret = new_string(s.size);
+    for (gocaml_int i = 0; i < s.size; ++i) {
+        ret.chars[i] = (int8_t) toupper((unsigned char) s.chars[i]);
+    }
+    return ret;
+}
+
+gocaml_string String_lowercase(gocaml_string const s)
+{
+    gocaml_string ret = new_string(s.size);
+    for (gocaml_int i = 0; i < s.size; ++i) {
+        ret.chars[i] = (int8_t) tolower((unsigned char) s.chars[i]);
+    }
+    return ret;
+}
+
+// Replace all occurrences of 'sub' in 's' with 'by'
+gocaml_string String_replace(gocaml_string const s, gocaml_string const sub, gocaml_string const by)
+{
+    if (sub.size == 0) {
+        return s;
+    }
+
+    gocaml_int count = 0;
+    for (gocaml_int i = string_find(s, sub, 0); i >= 0; i = string_find(s, sub, i + sub.size)) {
+        count++;
+    }
+    if (count == 0) {
+        return s;
+    }
+
+    gocaml_string ret = new_string(s.size + count * (by.size - sub.size));
+    gocaml_int src = 0;
+    gocaml_int dst = 0;
+    for (gocaml_int i = string_find(s, sub, 0); i >= 0; i = string_find(s, sub, src)) {
+        memcpy(ret.chars + dst, s.chars + src, (size_t) (i - src));
+        dst += i - src;
+        memcpy(ret.chars + dst, by.chars, (size_t) by.size);
+        dst += by.size;
+        src = i + sub.size;
+    }
+    memcpy(ret.chars + dst, s.chars + src, (size_t) (s.size - src));
+    return ret;
+}
+
+// Concatenate strings in the array inserting 'sep' between them
+gocaml_string String_concat(gocaml_string const sep, gocaml_array const strs)
+{
+    gocaml_string const* const elems = (gocaml_string *) strs.buf;
+    if (strs.size == 0) {
+        return new_string(0);
+    }
+
+    gocaml_int size = sep.size * (strs.size - 1);
+    for (gocaml_int i = 0; i < strs.size; ++i) {
+        size += elems[i].size;
+    }
+
+    gocaml_string ret = new_string(size);
+    gocaml_int dst = 0;
+    for (gocaml_int i = 0; i < strs.size; ++i) {
+        if (i > 0) {
+            memcpy(ret.chars + dst, sep.chars, (size_t) sep.size);
+            dst += sep.size;
+        }
+        memcpy(ret.chars + dst, elems[i].chars, (size_t) elems[i].size);
+        dst += elems[i].size;
+    }
+    return ret;
+}
+
+// Call the closure for each byte of the string
+void String_iter(gocaml_closure const f, gocaml_string const s)
+{
+    gocaml_unit (*const fun)(void *, gocaml_char) = (gocaml_unit (*)(void *, gocaml_char)) f.fun;
+    for (gocaml_int i = 0; i < s.size; ++i) {
+        fun(f.env, (gocaml_char) s.chars[i]);
+    }
+}
+
+// Random module. Pseudo-random numbers are generated by xorshift128+ algorithm. Its 128bit state
+// is initialized from seed with splitmix64 algorithm. So the same seed always generates the same
+// sequence on all platforms. 'Random.State.t' is an opaque pointer to 'random_state_t' in GoCaml.
+// Random.* functions use the default state which is initialized with seed 0.
+
+typedef struct {
+    uint64_t s[2];
+} random_state_t;
+
+static random_state_t default_random_state = {{0, 0}};
+static int default_random_state_initialized = 0;
+
+static uint64_t splitmix64(uint64_t *const x)
+{
+    uint64_t z = (*x += UINT64_C(0x9E3779B97F4A7C15));
+    z = (z ^ (z >> 30)) * UINT64_C(0xBF58476D1CE4E5B9);
+    z = (z ^ (z >> 27)) * UINT64_C(0x94D049BB133111EB);
+    return z ^ (z >> 31);
+}
+
+static void random_seed(random_state_t *const st, gocaml_int const seed)
+{
+    uint64_t x = (uint64_t) seed;
+    st->s[0] = splitmix64(&x);
+    st->s[1] = splitmix64(&x);
+}
+
+static uint64_t random_next(random_state_t *const st)
+{
+    uint64_t x = st->s[0];
+    uint64_t const y = st->s[1];
+    st->s[0] = y;
+    x ^= x << 23;
+    st->s[1] = x ^ y ^ (x >> 17) ^ (y >> 26);
+    return st->s[1] + y;
+}
+
+static gocaml_int random_self_seed()
+{
+    uint64_t seed = (uint64_t) time(NULL);
+    seed ^= (uint64_t) clock() << 32;
+    seed ^= (uint64_t) (uintptr_t) &seed;
+    return (gocaml_int) seed;
+}
+
+// Returns integer in [0, bound). Rejection sampling avoids the bias of modulo.
+static gocaml_int random_int(random_state_t *const st, gocaml_int const bound)
+{
+    if (bound <= 0) {
+        invalid_argument("Bound of Random.int must be positive");
+    }
+    uint64_t const b = (uint64_t) bound;
+    uint64_t const limit = (UINT64_C(1) << 63) - (UINT64_C(1) << 63) % b;
+    uint64_t r;
+    do {
+        r = random_next(st) >> 1;
+    } while (r >= limit);
+    return (gocaml_int) (r % b);
+}
+
+// Returns float in [0, bound). Upper 53 bits are used for the mantissa.
+static gocaml_float random_float(random_state_t *const st, gocaml_float const bound)
+{
+    return (gocaml_float) (random_next(st) >> 11) * (1.0 / 9007199254740992.0) * bound;
+}
+
+static gocaml_bool random_bool(random_state_t *const st)
+{
+    return (gocaml_bool) (random_next(st) >> 63);
+}
+
+static random_state_t *default_state()
+{
+    if (!default_random_state_initialized) {
+        random_seed(&default_random_state, 0);
+        default_random_state_initialized = 1;
+    }
+    return &default_random_state;
+}
+
+void Random_init(gocaml_int const seed)
+{
+    random_seed(&default_random_state, seed);
+    default_random_state_initialized = 1;
+}
+
+void Random_self_init(gocaml_unit _)
+{
+    (void) _;
+    Random_init(random_self_seed());
+}
+
+gocaml_int Random_int(gocaml_int const bound)
+{
+    return random_int(default_state(), bound);
+}
+
+gocaml_float Random_float(gocaml_float const bound)
+{
+    return random_float(default_state(), bound);
+}
+
+gocaml_bool Random_bool(gocaml_unit _)
+{
+    (void) _;
+    return random_bool(default_state());
+}
+
+random_state_t *Random_State_make(gocaml_int const seed)
+{
+    random_state_t *const st = (random_state_t *) GC_malloc(sizeof(random_state_t));
+    random_seed(st, seed);
+    return st;
+}
+
+random_state_t *Random_State_copy(random_state_t const* const st)
+{
+    random_state_t *const copied = (random_state_t *) GC_malloc(sizeof(random_state_t));
+    *copied = *st;
+    return copied;
+}
+
+gocaml_int Random_State_int(random_state_t *const st, gocaml_int const bound)
+{
+    return random_int(st, bound);
+}
+
+gocaml_float Random_State_float(random_state_t *const st, gocaml_float const bound)
+{
+    return random_float(st, bound);
+}
+
+gocaml_bool Random_State_bool(random_state_t *const st)
+{
+    return random_bool(st);
+}
+
+// Buffer module. 'Buffer.t' is an opaque pointer to 'buffer_t' in GoCaml.
+
+typedef struct {
+    char *chars;
+    gocaml_int size;
+    gocaml_int capacity;
+} buffer_t;
+
+#define BUFFER_MIN_CAPACITY 16
+
+static void buffer_reserve(buffer_t *const b, gocaml_int const additional)
+{
+    gocaml_int const required = b->size + additional;
+    if (required <= b->capacity) {
+        return;
+    }
+    gocaml_int cap = b->capacity * 2;
+    if (cap < required) {
+        cap = required;
+    }
+    b->chars = (char *) GC_realloc(b->chars, (size_t) cap);
+    b->capacity = cap;
+}
+
+buffer_t *Buffer_create(gocaml_int const capacity)
+{
+    buffer_t *const b = (buffer_t *) GC_malloc(sizeof(buffer_t));
+    gocaml_int const cap = capacity < BUFFER_MIN_CAPACITY ? BUFFER_MIN_CAPACITY : capacity;
+    b->chars = (char *) GC_malloc((size_t) cap);
+    b->size = 0;
+    b->capacity = cap;
+    return b;
+}
+
+void Buffer_add_string(buffer_t *const b, gocaml_string const s)
+{
+    buffer_reserve(b, s.size);
+    memcpy(b->chars + b->size, s.chars, (size_t) s.size);
+    b->size += s.size;
+}
+
+void Buffer_add_char(buffer_t *const b, gocaml_char const c)
+{
+    buffer_reserve(b, 1);
+    b->chars[b->size] = (char) c;
+    b->size++;
+}
+
+void Buffer_add_int(buffer_t *const b, gocaml_int const i)
+{
+    char s[SNPRINTF_MAX];
+    int const n = snprintf(s, SNPRINTF_MAX, "%" PRId64, i);
+    buffer_reserve(b, n);
+    memcpy(b->chars + b->size, s, (size_t) n);
+    b->size += n;
+}
+
+gocaml_int Buffer_length(buffer_t const* const b)
+{
+    return b->size;
+}
+
+// Contents are copied because strings are immutable and the buffer may be modified after
+gocaml_string Buffer_contents(buffer_t const* const b)
+{
+    gocaml_string ret = new_string(b->size);
+    memcpy(ret.chars, b->chars, (size_t) b->size);
+    return ret;
+}
+
+// Capacity of the buffer is kept for reuse
+void Buffer_clear(buffer_t *const b)
+{
+    b->size = 0;
+}
+
+// Hash functions for keys of hash table. Compiler combines them to hash keys of tuple types.
+
+gocaml_int __hash_int(gocaml_int const i)
+{
+    // Finalizer of MurmurHash3
+    uint64_t x = (uint64_t) i;
+    x ^= x >> 33;
+    x *= UINT64_C(0xff51afd7ed558ccd);
+    x ^= x >> 33;
+    x *= UINT64_C(0xc4ceb9fe1a85ec53);
+    x ^= x >> 33;
+    return (gocaml_int) x;
+}
+
+gocaml_int __hash_float(gocaml_float const f)
+{
+    // 0.0 and -0.0 are equal. So they must have the same hash value
+    gocaml_float const normalized = f == 0.0 ? 0.0 : f;
+    uint64_t bits;
+    memcpy(&bits, &normalized, sizeof(bits));
+    return __hash_int((gocaml_int) bits);
+}
+
+gocaml_int __hash_str(gocaml_string const s)
+{
+    // FNV-1a
+    uint64_t h = UINT64_C(0xcbf29ce484222325);
+    for (gocaml_int i = 0; i < s.size; ++i) {
+        h ^= (uint8_t) s.chars[i];
+        h *= UINT64_C(0x100000001b3);
+    }
+    return (gocaml_int) h;
+}
+
+gocaml_int __hash_combine(gocaml_int const seed, gocaml_int const h)
+{
+    uint64_t const s = (uint64_t) seed;
+    return (gocaml_int) (s ^ ((uint64_t) h + UINT64_C(0x9e3779b97f4a7c15) + (s << 6) + (s >> 2)));
+}
+
+// Hash table. Keys and values are boxed by compiler and runtime only sees pointers to them.
+// 'hash' and 'eq' are functions for the key type generated by compiler. Collisions are resolved
+// by chaining. As OCaml's Hashtbl, 'add' hides the previous binding of the same key and 'remove'
+// restores it.
+
+typedef gocaml_int (*hashtbl_hash_fn)(void *);
+typedef gocaml_int (*hashtbl_eq_fn)(void *, void *);
+typedef void (*hashtbl_iter_fn)(void *, void *, void *);
+
+typedef struct hashtbl_entry {
+    void *key;
+    void *value;
+    uint64_t hash;
+    struct hashtbl_entry *next;
+} hashtbl_entry;
+
+typedef struct {
+    hashtbl_entry **buckets;
+    gocaml_int num_buckets; // Always power of 2
+    gocaml_int size;
+    hashtbl_hash_fn hash;
+    hashtbl_eq_fn eq;
+} hashtbl_t;
+
+#define HASHTBL_MIN_BUCKETS 8
+
+static hashtbl_entry **hashtbl_alloc_buckets(gocaml_int const num)
+{
+    size_t const size = sizeof(hashtbl_entry *) * (size_t) num;
+    hashtbl_entry **const buckets = (hashtbl_entry **) GC_malloc(size);
+    memset(buckets, 0, size);
+    return buckets;
+}
+
+static hashtbl_entry **hashtbl_bucket(hashtbl_t const* const t, uint64_t const hash)
+{
+    return &t->buckets[hash & (uint64_t) (t->num_buckets - 1)];
+}
+
+static void hashtbl_grow(hashtbl_t *const t)
+{
+    hashtbl_entry **const old = t->buckets;
+    gocaml_int const old_num = t->num_buckets;
+    t->num_buckets = old_num * 2;
+    t->buckets = hashtbl_alloc_buckets(t->num_buckets);
+
+    for (gocaml_int i = 0; i < old_num; ++i) {
+        // Reverse each chain twice to keep the order of bindings for the same key
+        hashtbl_entry *rev = NULL;
+        for (hashtbl_entry *e = old[i]; e != NULL;) {
+            hashtbl_entry *const next = e->next;
+            e->next = rev;
+            rev = e;
+            e = next;
+        }
+        for (hashtbl_entry *e = rev; e != NULL;) {
+            hashtbl_entry *const next = e->next;
+            hashtbl_entry **const bucket = hashtbl_bucket(t, e->hash);
+            e->next = *bucket;
+            *bucket = e;
+            e = next;
+        }
+    }
+}
+
+static hashtbl_entry *hashtbl_lookup(hashtbl_t const* const t, void *const key)
+{
+    uint64_t const hash = (uint64_t) t->hash(key);
+    for (hashtbl_entry *e = *hashtbl_bucket(t, hash); e != NULL; e = e->next) {
+        if (e->hash == hash && t->eq(e->key, key)) {
+            return e;
+        }
+    }
+    return NULL;
+}
+
+hashtbl_t *__hashtbl_create(gocaml_int const size, hashtbl_hash_fn const hash, hashtbl_eq_fn const eq)
+{
+    gocaml_int num = HASHTBL_MIN_BUCKETS;
+    while (num < size) {
+        num *= 2;
+    }
+    hashtbl_t *const t = (hashtbl_t *) GC_malloc(sizeof(hashtbl_t));
+    t->buckets = hashtbl_alloc_buckets(num);
+    t->num_buckets = num;
+    t->size = 0;
+    t->hash = hash;
+    t->eq = eq;
+    return t;
+}
+
+void __hashtbl_add(hashtbl_t *const t, void *const key, void *const value)
+{
+    if (t->size >= t->num_buckets * 2) {
+        hashtbl_grow(t);
+    }
+    hashtbl_entry *const e = (hashtbl_entry *) GC_malloc(sizeof(hashtbl_entry));
+    e->key = key;
+    e->value = value;
+    e->hash = (uint64_t) t->hash(key);
+    hashtbl_entry **const bucket = hashtbl_bucket(t, e->hash);
+    e->next = *bucket;
+    *bucket = e;
+    t->size++;
+}
+
+void __hashtbl_replace(hashtbl_t *const t, void *const key, void *const value)
+{
+    hashtbl_entry *const e = hashtbl_lookup(t, key);
+    if (e == NULL) {
+        __hashtbl_add(t, key, value);
+        return;
+    }
+    e->value = value;
+}
+
+// Returns pointer to boxed value, or NULL when the key is not found
+void *__hashtbl_find(hashtbl_t const* const t, void *const key)
+{
+    hashtbl_entry const* const e = hashtbl_lookup(t, key);
+    return e == NULL ? NULL : e->value;
+}
+
+void __hashtbl_remove(hashtbl_t *const t, void *const key)
+{
+    uint64_t const hash = (uint64_t) t->hash(key);
+    for (hashtbl_entry **p = hashtbl_bucket(t, hash); *p != NULL; p = &(*p)->next) {
+        if ((*p)->hash == hash && t->eq((*p)->key, key)) {
+            *p = (*p)->next;
+            t->size--;
+            return;
+        }
+    }
+}
+
+gocaml_bool __hashtbl_mem(hashtbl_t const* const t, void *const key)
+{
+    return hashtbl_lookup(t, key) != NULL;
+}
+
+gocaml_int __hashtbl_length(hashtbl_t const* const t)
+{
+    return t->size;
+}
+
+void __hashtbl_iter(hashtbl_t const* const t, hashtbl_iter_fn const f, void *const closure)
+{
+    for (gocaml_int i = 0; i < t->num_buckets; ++i) {
+        for (hashtbl_entry *e = t->buckets[i]; e != NULL; e = e->next) {
+            f(closure, e->key, e->value);
+        }
+    }
+}
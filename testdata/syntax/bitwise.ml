@@ -0,0 +1,6 @@
+let x = 255 in
+let y = 1 lor 2 land 3 lxor 4 in
+let z = 1 lsl 2 lsl 3 + x lsr 4 - x asr 1 in
+let w = lnot x land lnot (y lor z) in
+let rec f a b = a lxor b in
+f (lnot 1) (2 lsl 1) land -1
@@ -0,0 +1,12 @@
+let c = 'a' in
+let newline = '\n' in
+let quote = '\'' in
+let dq = '"' in
+let hex = '\x41' in
+let s = "hello" in
+let h = String.get s 0 in
+let lt = 'a' < 'b' in
+let eq = String.get "abc" (1 + 1) = 'c' in
+let t: char = '\\' in
+print_char c;
+println_char (String.get s 4)
@@ -0,0 +1,9 @@
+let rec f ~x ~(y: int) ?z ?(w = 42) ?(v: float = 3.14) u = x + y in
+f ~x:1 ~y:2 ();
+f ~y:(1 + 2) ~x:3 ~w:10 ?z:None ();
+let x = 1 in
+let y = 2 in
+let z = Some 3 in
+f ~x ~y ?z ();
+let g = fun ~a ?(b = 1) c -> a + b + c in
+g ~a:1 2
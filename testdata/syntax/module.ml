@@ -0,0 +1,8 @@
+let s = String.trim "  foo, bar  " in
+let words = String.split_on_char ',' s in
+let b = String.starts_with ~prefix:"foo" s in
+String.iter (fun c -> print_char c) s;
+println_str (String.concat "-" words);
+println_int (String.length s + Array.length words);
+let st: Random.State.t = Random.State.make 42 in
+println_int (Random.State.int st 10)
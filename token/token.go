@@ -57,6 +57,19 @@ const (
 	FUN
 	COLON
 	TYPE
+	TILDE
+	QUESTION
+	LABEL
+	OPTLABEL
+	CHAR_LITERAL
+	STRING_GET
+	LAND
+	LOR
+	LXOR
+	LSL
+	LSR
+	ASR
+	LNOT
 	EOF
 )
 
@@ -110,6 +123,19 @@ var tokenTable = [...]string{
 	FUN:            "fun",
 	COLON:          ":",
 	TYPE:           "type",
+	TILDE:          "~",
+	QUESTION:       "?",
+	LABEL:          "LABEL",
+	OPTLABEL:       "OPTLABEL",
+	CHAR_LITERAL:   "CHAR_LITERAL",
+	STRING_GET:     "String.get",
+	LAND:           "land",
+	LOR:            "lor",
+	LXOR:           "lxor",
+	LSL:            "lsl",
+	LSR:            "lsr",
+	ASR:            "asr",
+	LNOT:           "lnot",
 }
 
 // Token instance for GoCaml.
@@ -3,40 +3,172 @@ package typing
 func builtinPopulatedTable() map[string]Type {
 	table := map[string]Type{
 		"argv":                       &Array{StringType},
-		"print_int":                  &Fun{UnitType, []Type{IntType}},
-		"print_bool":                 &Fun{UnitType, []Type{BoolType}},
-		"print_float":                &Fun{UnitType, []Type{FloatType}},
-		"print_str":                  &Fun{UnitType, []Type{StringType}},
-		"println_int":                &Fun{UnitType, []Type{IntType}},
-		"println_bool":               &Fun{UnitType, []Type{BoolType}},
-		"println_float":              &Fun{UnitType, []Type{FloatType}},
-		"println_str":                &Fun{UnitType, []Type{StringType}},
-		"float_to_int":               &Fun{IntType, []Type{FloatType}},
-		"int_to_float":               &Fun{FloatType, []Type{IntType}},
-		"str_length":                 &Fun{IntType, []Type{StringType}},
-		"__str_equal":                &Fun{BoolType, []Type{StringType, StringType}},
-		"str_concat":                 &Fun{StringType, []Type{StringType, StringType}},
-		"str_sub":                    &Fun{StringType, []Type{StringType, IntType, IntType}},
-		"int_to_str":                 &Fun{StringType, []Type{IntType}},
-		"float_to_str":               &Fun{StringType, []Type{FloatType}},
-		"str_to_int":                 &Fun{IntType, []Type{StringType}},
-		"str_to_float":               &Fun{FloatType, []Type{StringType}},
-		"get_line":                   &Fun{StringType, []Type{UnitType}},
-		"get_char":                   &Fun{StringType, []Type{UnitType}},
-		"to_char_code":               &Fun{IntType, []Type{StringType}},
-		"from_char_code":             &Fun{StringType, []Type{IntType}},
-		"bit_and":                    &Fun{IntType, []Type{IntType, IntType}},
-		"bit_or":                     &Fun{IntType, []Type{IntType, IntType}},
-		"bit_xor":                    &Fun{IntType, []Type{IntType, IntType}},
-		"bit_rsft":                   &Fun{IntType, []Type{IntType, IntType}},
-		"bit_lsft":                   &Fun{IntType, []Type{IntType, IntType}},
-		"bit_inv":                    &Fun{IntType, []Type{IntType}},
-		"time_now":                   &Fun{IntType, []Type{UnitType}},
-		"read_file":                  &Fun{&Option{StringType}, []Type{StringType}},
-		"write_file":                 &Fun{BoolType, []Type{StringType, StringType}},
-		"do_garbage_collection":      &Fun{UnitType, []Type{UnitType}},
-		"enable_garbage_collection":  &Fun{UnitType, []Type{UnitType}},
-		"disable_garbage_collection": &Fun{UnitType, []Type{UnitType}},
+		"print_int":                  &Fun{UnitType, []Type{IntType}, nil},
+		"print_bool":                 &Fun{UnitType, []Type{BoolType}, nil},
+		"print_float":                &Fun{UnitType, []Type{FloatType}, nil},
+		"print_str":                  &Fun{UnitType, []Type{StringType}, nil},
+		"println_int":                &Fun{UnitType, []Type{IntType}, nil},
+		"println_bool":               &Fun{UnitType, []Type{BoolType}, nil},
+		"println_float":              &Fun{UnitType, []Type{FloatType}, nil},
+		"println_str":                &Fun{UnitType, []Type{StringType}, nil},
+		"float_to_int":               &Fun{IntType, []Type{FloatType}, nil},
+		"int_to_float":               &Fun{FloatType, []Type{IntType}, nil},
+		"str_length":                 &Fun{IntType, []Type{StringType}, nil},
+		"__str_equal":                &Fun{BoolType, []Type{StringType, StringType}, nil},
+		"str_concat":                 &Fun{StringType, []Type{StringType, StringType}, nil},
+		"str_sub":                    &Fun{StringType, []Type{StringType, IntType, IntType}, nil},
+		"int_to_str":                 &Fun{StringType, []Type{IntType}, nil},
+		"float_to_str":               &Fun{StringType, []Type{FloatType}, nil},
+		"str_to_int":                 &Fun{IntType, []Type{StringType}, nil},
+		"str_to_float":               &Fun{FloatType, []Type{StringType}, nil},
+		"get_line":                   &Fun{StringType, []Type{UnitType}, nil},
+		"get_char":                   &Fun{CharType, []Type{UnitType}, nil},
+		"to_char_code":               &Fun{IntType, []Type{CharType}, nil},
+		"from_char_code":             &Fun{CharType, []Type{IntType}, nil},
+		"char_to_str":                &Fun{StringType, []Type{CharType}, nil},
+		"print_char":                 &Fun{UnitType, []Type{CharType}, nil},
+		"println_char":               &Fun{UnitType, []Type{CharType}, nil},
+		"bit_and":                    &Fun{IntType, []Type{IntType, IntType}, nil},
+		"bit_or":                     &Fun{IntType, []Type{IntType, IntType}, nil},
+		"bit_xor":                    &Fun{IntType, []Type{IntType, IntType}, nil},
+		"bit_rsft":                   &Fun{IntType, []Type{IntType, IntType}, nil},
+		"bit_lsft":                   &Fun{IntType, []Type{IntType, IntType}, nil},
+		"bit_inv":                    &Fun{IntType, []Type{IntType}, nil},
+		"sqrt":                       &Fun{FloatType, []Type{FloatType}, nil},
+		"sin":                        &Fun{FloatType, []Type{FloatType}, nil},
+		"cos":                        &Fun{FloatType, []Type{FloatType}, nil},
+		"exp":                        &Fun{FloatType, []Type{FloatType}, nil},
+		"log":                        &Fun{FloatType, []Type{FloatType}, nil},
+		"pow":                        &Fun{FloatType, []Type{FloatType, FloatType}, nil},
+		"floor":                      &Fun{FloatType, []Type{FloatType}, nil},
+		"ceil":                       &Fun{FloatType, []Type{FloatType}, nil},
+		"abs_float":                  &Fun{FloatType, []Type{FloatType}, nil},
+		"infinity":                   FloatType,
+		"nan":                        FloatType,
+		"is_nan":                     &Fun{BoolType, []Type{FloatType}, nil},
+		"time_now":                   &Fun{IntType, []Type{UnitType}, nil},
+		"read_file":                  &Fun{&Option{StringType}, []Type{StringType}, nil},
+		"write_file":                 &Fun{BoolType, []Type{StringType, StringType}, nil},
+		"getenv":                     &Fun{&Option{StringType}, []Type{StringType}, nil},
+		"Sys.command":                &Fun{IntType, []Type{StringType}, nil},
+		"Sys.file_exists":            &Fun{BoolType, []Type{StringType}, nil},
+		"Sys.readdir":                &Fun{&Option{&Array{StringType}}, []Type{StringType}, nil},
+		"stdin":                      InChannelType,
+		"stdout":                     OutChannelType,
+		"stderr":                     OutChannelType,
+		"open_in":                    &Fun{&Option{InChannelType}, []Type{StringType}, nil},
+		"open_out":                   &Fun{&Option{OutChannelType}, []Type{StringType}, nil},
+		"open_out_append":            &Fun{&Option{OutChannelType}, []Type{StringType}, nil},
+		"input_line":                 &Fun{&Option{StringType}, []Type{InChannelType}, nil},
+		"output_string":              &Fun{UnitType, []Type{OutChannelType, StringType}, nil},
+		"flush":                      &Fun{UnitType, []Type{OutChannelType}, nil},
+		"close_in":                   &Fun{UnitType, []Type{InChannelType}, nil},
+		"close_out":                  &Fun{UnitType, []Type{OutChannelType}, nil},
+		"prerr_int":                  &Fun{UnitType, []Type{IntType}, nil},
+		"prerr_bool":                 &Fun{UnitType, []Type{BoolType}, nil},
+		"prerr_float":                &Fun{UnitType, []Type{FloatType}, nil},
+		"prerr_str":                  &Fun{UnitType, []Type{StringType}, nil},
+		"prerr_char":                 &Fun{UnitType, []Type{CharType}, nil},
+		"prerrln_int":                &Fun{UnitType, []Type{IntType}, nil},
+		"prerrln_bool":               &Fun{UnitType, []Type{BoolType}, nil},
+		"prerrln_float":              &Fun{UnitType, []Type{FloatType}, nil},
+		"prerrln_str":                &Fun{UnitType, []Type{StringType}, nil},
+		"prerrln_char":               &Fun{UnitType, []Type{CharType}, nil},
+		"do_garbage_collection":      &Fun{UnitType, []Type{UnitType}, nil},
+		"enable_garbage_collection":  &Fun{UnitType, []Type{UnitType}, nil},
+		"disable_garbage_collection": &Fun{UnitType, []Type{UnitType}, nil},
+		"String.length":              &Fun{IntType, []Type{StringType}, nil},
+		"String.utf8_length":         &Fun{IntType, []Type{StringType}, nil},
+		"String.index_of":            &Fun{IntType, []Type{StringType, StringType}, nil},
+		"String.contains":            &Fun{BoolType, []Type{StringType, StringType}, nil},
+		"String.starts_with":         &Fun{BoolType, []Type{StringType, StringType}, []Label{{"prefix", false}, {"", false}}},
+		"String.split_on_char":       &Fun{&Array{StringType}, []Type{CharType, StringType}, nil},
+		"String.trim":                &Fun{StringType, []Type{StringType}, nil},
+		"String.uppercase":           &Fun{StringType, []Type{StringType}, nil},
+		"String.lowercase":           &Fun{StringType, []Type{StringType}, nil},
+		"String.replace":             &Fun{StringType, []Type{StringType, StringType, StringType}, nil},
+		"String.concat":              &Fun{StringType, []Type{StringType, &Array{StringType}}, nil},
+		"String.iter":                &Fun{UnitType, []Type{&Fun{UnitType, []Type{CharType}, nil}, StringType}, nil},
+		"Random.init":                &Fun{UnitType, []Type{IntType}, nil},
+		"Random.self_init":           &Fun{UnitType, []Type{UnitType}, nil},
+		"Random.int":                 &Fun{IntType, []Type{IntType}, nil},
+		"Random.float":               &Fun{FloatType, []Type{FloatType}, nil},
+		"Random.bool":                &Fun{BoolType, []Type{UnitType}, nil},
+		"Random.State.make":          &Fun{RandomStateType, []Type{IntType}, nil},
+		"Random.State.copy":          &Fun{RandomStateType, []Type{RandomStateType}, nil},
+		"Random.State.int":           &Fun{IntType, []Type{RandomStateType, IntType}, nil},
+		"Random.State.float":         &Fun{FloatType, []Type{RandomStateType, FloatType}, nil},
+		"Random.State.bool":          &Fun{BoolType, []Type{RandomStateType}, nil},
+		"Buffer.create":              &Fun{BufferType, []Type{IntType}, nil},
+		"Buffer.add_string":          &Fun{UnitType, []Type{BufferType, StringType}, nil},
+		"Buffer.add_char":            &Fun{UnitType, []Type{BufferType, CharType}, nil},
+		"Buffer.add_int":             &Fun{UnitType, []Type{BufferType, IntType}, nil},
+		"Buffer.length":              &Fun{IntType, []Type{BufferType}, nil},
+		"Buffer.contents":            &Fun{StringType, []Type{BufferType}, nil},
+		"Buffer.clear":               &Fun{UnitType, []Type{BufferType}, nil},
+		"__hash_int":                 &Fun{IntType, []Type{IntType}, nil},
+		"__hash_float":               &Fun{IntType, []Type{FloatType}, nil},
+		"__hash_str":                 &Fun{IntType, []Type{StringType}, nil},
+		"__hash_combine":             &Fun{IntType, []Type{IntType, IntType}, nil},
+		"__format_int":               &Fun{StringType, []Type{StringType, IntType}, nil},
+		"__format_float":             &Fun{StringType, []Type{StringType, FloatType}, nil},
+		"__format_str":               &Fun{StringType, []Type{StringType, StringType}, nil},
+		"__format_bool":              &Fun{StringType, []Type{StringType, BoolType}, nil},
+		"__format_char":              &Fun{StringType, []Type{StringType, CharType}, nil},
+		"__format_concat":            &Fun{StringType, []Type{StringType, StringType}, nil},
 	}
 	return table
 }
+
+// Returns the type of generic built-in function instantiated with fresh type variables. Second
+// returned value is false when the name is not a generic built-in function.
+func instantiateGenericBuiltin(name string) (*Fun, bool) {
+	key, val := &Var{}, &Var{}
+	tbl := &Hashtbl{key, val}
+	switch name {
+	case "exit":
+		// 'exit' never returns. So its return type can be any type.
+		return &Fun{&Var{}, []Type{IntType}, nil}, true
+	case "Hashtbl.create":
+		return &Fun{tbl, []Type{IntType}, nil}, true
+	case "Hashtbl.add", "Hashtbl.replace":
+		return &Fun{UnitType, []Type{tbl, key, val}, nil}, true
+	case "Hashtbl.find":
+		return &Fun{&Option{val}, []Type{tbl, key}, nil}, true
+	case "Hashtbl.remove":
+		return &Fun{UnitType, []Type{tbl, key}, nil}, true
+	case "Hashtbl.mem":
+		return &Fun{BoolType, []Type{tbl, key}, nil}, true
+	case "Hashtbl.length":
+		return &Fun{IntType, []Type{tbl}, nil}, true
+	case "Hashtbl.iter":
+		return &Fun{UnitType, []Type{&Fun{UnitType, []Type{key, val}, nil}, tbl}, nil}, true
+	default:
+		return nil, false
+	}
+}
+
+// IsGenericBuiltin returns true when the name is a generic built-in function like 'Hashtbl.add'.
+// Its type is not in externals table because it is instantiated at each reference.
+func IsGenericBuiltin(name string) bool {
+	_, ok := instantiateGenericBuiltin(name)
+	return ok
+}
+
+// IsHashableType returns true when values of the type can be keys of hash table. They must be
+// hashed and compared structurally in runtime.
+func IsHashableType(t Type) bool {
+	switch t := t.(type) {
+	case *Unit, *Bool, *Int, *Float, *Char, *String:
+		return true
+	case *Tuple:
+		for _, e := range t.Elems {
+			if !IsHashableType(e) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
@@ -57,6 +57,17 @@ func unwrap(target Type) (Type, bool) {
 			return nil, false
 		}
 		t.Elem = e
+	case *Hashtbl:
+		k, ok := unwrap(t.Key)
+		if !ok {
+			return nil, false
+		}
+		v, ok := unwrap(t.Value)
+		if !ok {
+			return nil, false
+		}
+		t.Key = k
+		t.Value = v
 	case *Var:
 		return unwrapVar(t)
 	}
@@ -191,8 +202,32 @@ func (d *typeVarDereferencer) Visit(node ast.Expr) ast.Visitor {
 	return d
 }
 
+func checkHashtblKey(ref *ast.VarRef, t Type) *loc.Error {
+	tbl, ok := t.(*Hashtbl)
+	if !ok || IsHashableType(tbl.Key) {
+		return nil
+	}
+	return loc.ErrorfAt(ref.Pos(), "Key of hash table must be unit, bool, int, float, char, string or tuple of them but '%s' is used as key type of '%s'", tbl.Key.String(), ref.Symbol.DisplayName)
+}
+
 func derefTypeVars(env *Env, root ast.Expr) error {
 	v := &typeVarDereferencer{nil, env}
+	for _, fun := range env.Instances {
+		// Return type of generic built-in function like 'exit' is not determined when the returned
+		// value is not used. It is fixed to unit as the same as external functions.
+		if r, ok := fun.Ret.(*Var); ok {
+			for r.Ref != nil {
+				next, ok := r.Ref.(*Var)
+				if !ok {
+					break
+				}
+				r = next
+			}
+			if r.Ref == nil {
+				r.Ref = UnitType
+			}
+		}
+	}
 	for n, t := range env.Externals {
 		env.Externals[n] = v.derefExternalSym(n, t)
 	}
@@ -202,6 +237,20 @@ func derefTypeVars(env *Env, root ast.Expr) error {
 		return v.err
 	}
 
+	for ref, fun := range env.Instances {
+		if _, ok := unwrapFun(fun); !ok {
+			return loc.ErrorfAt(ref.Pos(), "Cannot infer type of '%s'. Inferred type was '%s'", ref.Symbol.DisplayName, fun.String())
+		}
+		for _, t := range fun.Params {
+			if err := checkHashtblKey(ref, t); err != nil {
+				return err
+			}
+		}
+		if err := checkHashtblKey(ref, fun.Ret); err != nil {
+			return err
+		}
+	}
+
 	for n, t := range env.NoneTypes {
 		deref, ok := unwrap(t.Elem)
 		if !ok {
@@ -6,7 +6,7 @@ import (
 
 func testTypeEquals(l, r Type) bool {
 	switch l := l.(type) {
-	case *Unit, *Int, *Float, *Bool, *String:
+	case *Unit, *Int, *Float, *Bool, *String, *Char, *Opaque:
 		return l == r
 	case *Tuple:
 		r, ok := r.(*Tuple)
@@ -51,6 +51,12 @@ func testTypeEquals(l, r Type) bool {
 			return false
 		}
 		return testTypeEquals(l.Elem, r.Elem)
+	case *Hashtbl:
+		r, ok := r.(*Hashtbl)
+		if !ok {
+			return false
+		}
+		return testTypeEquals(l.Key, r.Key) && testTypeEquals(l.Value, r.Value)
 	default:
 		panic("Unreachable")
 	}
@@ -73,9 +79,10 @@ func TestUnwrapExternalSimpleTypes(t *testing.T) {
 				&Array{IntType},
 				&Option{IntType},
 				&Option{&Option{&Array{IntType}}},
+				&Hashtbl{StringType, &Option{IntType}},
 			},
 		},
-		&Fun{IntType, []Type{FloatType, BoolType}},
+		&Fun{IntType, []Type{FloatType, BoolType}, nil},
 	} {
 		a := v.derefExternalSym("test", ty)
 		if a != ty {
@@ -116,10 +123,10 @@ func TestRaiseErrorOnUnknownTypeInExternals(t *testing.T) {
 		&Var{&Var{}},
 		&Tuple{[]Type{IntType, &Var{}}},
 		&Array{&Var{}},
-		&Fun{IntType, []Type{&Var{&Var{}}}},
-		&Fun{&Array{&Var{}}, []Type{}},
+		&Fun{IntType, []Type{&Var{&Var{}}}, nil},
+		&Fun{&Array{&Var{}}, []Type{}, nil},
 		&Option{&Var{}},
-		&Fun{&Option{&Var{}}, []Type{}},
+		&Fun{&Option{&Var{}}, []Type{}, nil},
 	} {
 		v.derefExternalSym("test", ty)
 		if v.err == nil {
@@ -131,9 +138,9 @@ func TestRaiseErrorOnUnknownTypeInExternals(t *testing.T) {
 func TestFixReturnTypeOfExternalFunction(t *testing.T) {
 	v := &typeVarDereferencer{nil, NewEnv()}
 	for _, ty := range []Type{
-		&Fun{&Var{}, []Type{}},
-		&Fun{&Var{&Var{}}, []Type{IntType}},
-		&Var{&Fun{&Var{}, []Type{FloatType}}},
+		&Fun{&Var{}, []Type{}, nil},
+		&Fun{&Var{&Var{}}, []Type{IntType}, nil},
+		&Var{&Fun{&Var{}, []Type{FloatType}, nil}},
 	} {
 		derefed := v.derefExternalSym("test", ty)
 		f, ok := derefed.(*Fun)
@@ -27,6 +27,12 @@ type Env struct {
 	// Type of `None` will be inferred. To know what type the `None` values is typed,
 	// we need to memorize them in type inference.
 	NoneTypes map[*ast.None]*Option
+	// Format strings of printf and sprintf are parsed in type inference. Parsed pieces are
+	// memorized to lower the calls to runtime formatting functions.
+	Formats map[*ast.Apply][]FormatPiece
+	// Generic built-in functions like 'Hashtbl.add' are instantiated with fresh type variables at
+	// each reference. Their instantiated types are memorized per reference.
+	Instances map[*ast.VarRef]*Fun
 }
 
 // NewEnv creates empty Env instance.
@@ -35,6 +41,8 @@ func NewEnv() *Env {
 		map[string]Type{},
 		builtinPopulatedTable(),
 		map[*ast.None]*Option{},
+		map[*ast.Apply][]FormatPiece{},
+		map[*ast.VarRef]*Fun{},
 	}
 }
 
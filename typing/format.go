@@ -0,0 +1,197 @@
+package typing
+
+import (
+	"github.com/rhysd/gocaml/ast"
+	"github.com/rhysd/gocaml/common"
+	"github.com/rhysd/loc"
+)
+
+// FormatPiece is a piece of format string of printf and sprintf. When Verb is zero, the piece
+// is a plain text. Otherwise it is a conversion specifier like "%-5d" and Text is the whole
+// specifier.
+type FormatPiece struct {
+	Text string
+	Verb byte
+	Pos  loc.Pos
+}
+
+// ArgType returns the type of argument which the specifier requires.
+func (p *FormatPiece) ArgType() Type {
+	switch p.Verb {
+	case 'd', 'i', 'x', 'X', 'o':
+		return IntType
+	case 'c':
+		return CharType
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		return FloatType
+	case 's':
+		return StringType
+	case 'b':
+		return BoolType
+	default:
+		return nil
+	}
+}
+
+// Returns the offsets of '%' characters in string literal token. They are used to know the
+// positions of conversion specifiers in source.
+func percentOffsets(raw string) []int {
+	offsets := []int{}
+	for i := 1; i < len(raw)-1; i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case '%':
+			offsets = append(offsets, i)
+		}
+	}
+	return offsets
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isFormatFlag(c byte) bool {
+	return c == '-' || c == '+' || c == ' ' || c == '0' || c == '#'
+}
+
+// Parses the string literal as format string. Format string can contain conversion specifiers
+// like '%d' with flags ('-', '+', ' ', '0', '#'), width and precision.
+func parseFormat(lit *ast.String) ([]FormatPiece, error) {
+	start := lit.Token.Start
+	offsets := percentOffsets(lit.Token.Value())
+	percents := 0
+	posAt := func(idx int) loc.Pos {
+		p := start
+		if idx < len(offsets) {
+			p.Offset += offsets[idx]
+			p.Column += offsets[idx]
+		}
+		return p
+	}
+
+	pieces := []FormatPiece{}
+	text := []byte{}
+	s := lit.Value
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			text = append(text, s[i])
+			continue
+		}
+		pos := posAt(percents)
+		percents++
+
+		if i+1 < len(s) && s[i+1] == '%' {
+			text = append(text, '%')
+			percents++
+			i++
+			continue
+		}
+
+		begin := i
+		i++
+		for i < len(s) && isFormatFlag(s[i]) {
+			i++
+		}
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '.' {
+			i++
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+		}
+		if i >= len(s) {
+			return nil, loc.ErrorfAt(pos, "Conversion specifier '%s' in format string is not terminated", s[begin:])
+		}
+
+		p := FormatPiece{s[begin : i+1], s[i], pos}
+		if p.ArgType() == nil {
+			return nil, loc.ErrorfAt(pos, "Unknown conversion '%c' in format specifier '%s'. Available conversions are d, i, x, X, o, f, F, e, E, g, G, s, b and c", p.Verb, p.Text)
+		}
+
+		if len(text) > 0 {
+			pieces = append(pieces, FormatPiece{string(text), 0, start})
+			text = []byte{}
+		}
+		pieces = append(pieces, p)
+	}
+
+	if len(text) > 0 {
+		pieces = append(pieces, FormatPiece{string(text), 0, start})
+	}
+
+	return pieces, nil
+}
+
+// Returns true when the symbol refers to built-in printf or sprintf. They are not usual functions
+// since their types depend on format string.
+func (inf *Inferer) isFormatFunc(sym *ast.Symbol) bool {
+	if sym.Name != "printf" && sym.Name != "sprintf" {
+		return false
+	}
+	_, ok := inf.env.Table[sym.Name]
+	return !ok
+}
+
+// Format string literal given to printf or sprintf is parsed at compile time. Types of rest
+// arguments are derived from the conversion specifiers in it. When some of the arguments are
+// omitted, it is partially applied and results in a function which receives the rest.
+//
+// e.g.
+//
+//	printf "%d items at %.2f\n"    ; int -> float -> unit
+//	printf "%d items at %.2f\n" 3  ; float -> unit
+func (inf *Inferer) inferFormatApply(node *ast.Apply, callee *ast.VarRef) (Type, error) {
+	name := callee.Symbol.DisplayName
+	lit, ok := node.Args[0].(*ast.String)
+	if !ok {
+		return nil, loc.ErrorfAt(node.Args[0].Pos(), "First argument of '%s' must be a string literal for format", name)
+	}
+
+	pieces, err := parseFormat(lit)
+	if err != nil {
+		return nil, loc.Notef(err, "Invalid format string for '%s'", name)
+	}
+
+	specs := make([]*FormatPiece, 0, len(pieces))
+	for i := range pieces {
+		if pieces[i].Verb != 0 {
+			specs = append(specs, &pieces[i])
+		}
+	}
+
+	args := node.Args[1:]
+	if len(args) > len(specs) {
+		return nil, loc.ErrorfAt(node.Pos(), "Format string of '%s' requires %d argument(s) but %d argument(s) given", name, len(specs), len(args))
+	}
+
+	for i, a := range args {
+		t, err := inf.infer(a)
+		if err != nil {
+			return nil, err
+		}
+		spec := specs[i]
+		if err := Unify(spec.ArgType(), t); err != nil {
+			return nil, loc.NotefAt(spec.Pos, err, "Type error: %s argument of '%s' must be '%s' for format specifier '%s'", common.Ordinal(i+2), name, spec.ArgType().String(), spec.Text)
+		}
+	}
+
+	inf.env.Formats[node] = pieces
+
+	var ret Type = UnitType
+	if callee.Symbol.Name == "sprintf" {
+		ret = StringType
+	}
+	if len(args) == len(specs) {
+		return ret, nil
+	}
+
+	rest := make([]Type, 0, len(specs)-len(args))
+	for _, spec := range specs[len(args):] {
+		rest = append(rest, spec.ArgType())
+	}
+	return &Fun{ret, rest, nil}, nil
+}
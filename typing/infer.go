@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/rhysd/gocaml/ast"
 	"github.com/rhysd/gocaml/common"
+	"github.com/rhysd/gocaml/token"
 	"github.com/rhysd/loc"
 )
 
@@ -76,6 +77,196 @@ func (inf *Inferer) inferLogicalOp(op string, left, right ast.Expr) (Type, error
 	return BoolType, nil
 }
 
+// Follows references of type variables and returns the type they point to.
+func followVar(t Type) Type {
+	for {
+		v, ok := t.(*Var)
+		if !ok || v.Ref == nil {
+			return t
+		}
+		t = v.Ref
+	}
+}
+
+// Functions are curried. Function type 'a -> b -> c' can be applied to one argument (partial
+// application) and results in 'b -> c'. When the function returns another function, it can also be
+// applied to more arguments than its parameters. Code generation does not need to curry saturated
+// calls. So parameters of function type remain flat and application with different number of
+// arguments is resolved here, only when the type of callee is already known as function.
+// Otherwise number of parameters is a part of function type. For example, function value of
+// 'int -> int -> int' cannot be passed where 'int -> (int -> int)' is expected.
+func (inf *Inferer) inferApply(node *ast.Apply, callee Type, args []Type) (Type, error) {
+	for {
+		fun, ok := followVar(callee).(*Fun)
+		if !ok || len(fun.Params) == len(args) {
+			// Return type of callee is unknown in this point.
+			// So make a new type variable and allocate it as return type.
+			ret := &Var{}
+			if err := Unify(callee, &Fun{ret, args, nil}); err != nil {
+				return nil, loc.NoteAt(node.Pos(), err, "type of called function")
+			}
+			return ret, nil
+		}
+
+		applied := len(args)
+		if applied > len(fun.Params) {
+			applied = len(fun.Params)
+		}
+		for i, a := range args[:applied] {
+			if err := Unify(fun.Params[i], a); err != nil {
+				return nil, loc.NotefAt(node.Pos(), err, "On unifying %s argument of function '%s'", common.Ordinal(i+1), fun.String())
+			}
+		}
+
+		if applied < len(fun.Params) {
+			// Partial application
+			var labels []Label
+			if fun.Labels != nil {
+				labels = fun.Labels[applied:]
+			}
+			return &Fun{fun.Ret, fun.Params[applied:], labels}, nil
+		}
+
+		// Rest of arguments are applied to returned function
+		callee = fun.Ret
+		args = args[applied:]
+	}
+}
+
+// Generic built-in function is instantiated at each application. Its instantiated type is
+// memorized for the callee to lower the call with concrete types. It must be applied to all
+// parameters because it is not a first-class value.
+func (inf *Inferer) inferGenericBuiltinApply(node *ast.Apply, callee *ast.VarRef, fun *Fun) (Type, error) {
+	args := make([]Type, len(node.Args))
+	for i, a := range node.Args {
+		t, err := inf.infer(a)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = t
+	}
+
+	args, err := inf.reorderLabeledArgs(node, fun, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) != len(fun.Params) {
+		return nil, loc.ErrorfAt(node.Pos(), "Generic built-in function '%s' requires %d argument(s) but %d argument(s) given. It cannot be partially applied", callee.Symbol.DisplayName, len(fun.Params), len(args))
+	}
+
+	inf.env.Instances[callee] = fun
+	return inf.inferApply(node, fun, args)
+}
+
+func labelIndex(labels []Label, name string) int {
+	for i, l := range labels {
+		if l.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reorders arguments of the application for labeled parameters of callee. Arguments of the Apply
+// node are replaced with reordered ones so that following passes only see positional arguments.
+//
+//   - Labeled argument '~x:e' is passed to parameter '~x'. When the parameter is optional '?x',
+//     it is passed as 'Some e'. '?x:e' passes option value 'e' to optional parameter directly.
+//   - Other arguments are passed to rest of non-optional parameters in order.
+//   - Optional parameters before the last applied argument are omitted and 'None' is passed.
+func (inf *Inferer) reorderLabeledArgs(node *ast.Apply, callee Type, args []Type) ([]Type, error) {
+	fun, ok := followVar(callee).(*Fun)
+	if !ok || fun.Labels == nil {
+		for _, a := range node.Args {
+			if l, ok := a.(*ast.LabeledArg); ok {
+				return nil, loc.ErrorfAt(l.Pos(), "Labeled argument '%s' is applied to function which has no labeled parameter", l.Label)
+			}
+		}
+		return args, nil
+	}
+
+	exprs := make([]ast.Expr, len(fun.Params))
+	types := make([]Type, len(fun.Params))
+	positional := make([]ast.Expr, 0, len(node.Args))
+	positionalTypes := make([]Type, 0, len(args))
+
+	for i, a := range node.Args {
+		l, ok := a.(*ast.LabeledArg)
+		if !ok {
+			positional = append(positional, a)
+			positionalTypes = append(positionalTypes, args[i])
+			continue
+		}
+
+		idx := labelIndex(fun.Labels, l.Label)
+		if idx < 0 {
+			return nil, loc.ErrorfAt(l.Pos(), "Function '%s' has no parameter labeled '%s'", fun.String(), l.Label)
+		}
+		if exprs[idx] != nil {
+			return nil, loc.ErrorfAt(l.Pos(), "Argument labeled '%s' is applied twice", l.Label)
+		}
+
+		param := fun.Labels[idx]
+		switch {
+		case l.Optional && !param.Optional:
+			return nil, loc.ErrorfAt(l.Pos(), "Parameter '~%s' is not optional. Use '~%s' instead of '?%s'", l.Label, l.Label, l.Label)
+		case !l.Optional && param.Optional:
+			exprs[idx] = &ast.Some{l.StartToken, l.Child}
+			types[idx] = &Option{args[i]}
+		default:
+			exprs[idx] = l.Child
+			types[idx] = args[i]
+		}
+	}
+
+	applied := 0
+	for idx, l := range fun.Labels {
+		if applied == len(positional) {
+			break
+		}
+		if exprs[idx] != nil || l.Optional {
+			continue
+		}
+		exprs[idx] = positional[applied]
+		types[idx] = positionalTypes[applied]
+		applied++
+	}
+
+	// When all parameters are applied and more arguments remain, the call is saturated and the rest
+	// of arguments are applied to the returned function.
+	last := len(fun.Params) - 1
+	if applied == len(positional) {
+		for last >= 0 && exprs[last] == nil {
+			last--
+		}
+	}
+
+	for idx := 0; idx <= last; idx++ {
+		if exprs[idx] != nil {
+			continue
+		}
+		l := fun.Labels[idx]
+		if !l.Optional {
+			if l.Name == "" {
+				return nil, loc.ErrorfAt(node.Pos(), "%s argument of function '%s' is missing", common.Ordinal(idx+1), fun.String())
+			}
+			return nil, loc.ErrorfAt(node.Pos(), "Argument labeled '%s' of function '%s' is missing", l.Name, fun.String())
+		}
+		pos := node.End()
+		none := &ast.None{&token.Token{token.NONE, pos, pos, pos.File}}
+		t, err := inf.infer(none)
+		if err != nil {
+			return nil, err
+		}
+		exprs[idx] = none
+		types[idx] = t
+	}
+
+	node.Args = append(exprs[:last+1], positional[applied:]...)
+	return append(types[:last+1], positionalTypes[applied:]...), nil
+}
+
 func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 	switch n := e.(type) {
 	case *ast.Unit:
@@ -86,6 +277,8 @@ func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 		return FloatType, nil
 	case *ast.String:
 		return StringType, nil
+	case *ast.Char:
+		return CharType, nil
 	case *ast.Bool:
 		return BoolType, nil
 	case *ast.Not:
@@ -108,6 +301,23 @@ func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 		return inf.inferArithmeticBinOp("/", n.Left, n.Right, IntType)
 	case *ast.Mod:
 		return inf.inferArithmeticBinOp("%", n.Left, n.Right, IntType)
+	case *ast.Lnot:
+		if err := inf.checkNodeType("operand of operator 'lnot'", n.Child, IntType); err != nil {
+			return nil, err
+		}
+		return IntType, nil
+	case *ast.Land:
+		return inf.inferArithmeticBinOp("land", n.Left, n.Right, IntType)
+	case *ast.Lor:
+		return inf.inferArithmeticBinOp("lor", n.Left, n.Right, IntType)
+	case *ast.Lxor:
+		return inf.inferArithmeticBinOp("lxor", n.Left, n.Right, IntType)
+	case *ast.Lsl:
+		return inf.inferArithmeticBinOp("lsl", n.Left, n.Right, IntType)
+	case *ast.Lsr:
+		return inf.inferArithmeticBinOp("lsr", n.Left, n.Right, IntType)
+	case *ast.Asr:
+		return inf.inferArithmeticBinOp("asr", n.Left, n.Right, IntType)
 	case *ast.FNeg:
 		if err := inf.checkNodeType("operand of unary operator '-.'", n.Child, FloatType); err != nil {
 			return nil, err
@@ -184,9 +394,18 @@ func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 		if t, ok := inf.env.Table[n.Symbol.Name]; ok {
 			return t, nil
 		}
+		if inf.isFormatFunc(n.Symbol) {
+			return nil, loc.ErrorfAt(n.Pos(), "'%s' must be applied to a format string literal directly. It cannot be used as a value", n.Symbol.DisplayName)
+		}
 		if t, ok := inf.env.Externals[n.Symbol.Name]; ok {
 			return t, nil
 		}
+		if _, ok := instantiateGenericBuiltin(n.Symbol.Name); ok {
+			return nil, loc.ErrorfAt(n.Pos(), "Generic built-in function '%s' must be applied directly. It cannot be used as a value", n.Symbol.DisplayName)
+		}
+		if n.Symbol.IsQualified() {
+			return nil, loc.ErrorfAt(n.Pos(), "Unknown module member '%s'", n.Symbol.DisplayName)
+		}
 		// Assume as free variable. If free variable's type is not identified,
 		// It falls into compilation error
 		t := &Var{}
@@ -199,6 +418,7 @@ func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 
 		// Register parameters of function as variables to table
 		params := make([]Type, len(n.Func.Params))
+		var labels []Label
 		for i, p := range n.Func.Params {
 			var t Type
 			var err error
@@ -210,15 +430,54 @@ func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 			} else {
 				t = &Var{}
 			}
-			inf.env.Table[p.Ident.Name] = t
 			params[i] = t
+			if p.Optional {
+				// Optional parameter is passed as option value. When it has default value, callee
+				// unwraps it so the type of the parameter variable in function body is not option.
+				params[i] = &Option{t}
+				if p.Default == nil {
+					t = params[i]
+				}
+			}
+			inf.env.Table[p.Ident.Name] = t
+
+			if p.Label != "" {
+				if labels == nil {
+					labels = make([]Label, len(n.Func.Params))
+				}
+				labels[i] = Label{p.Label, p.Optional}
+			}
+		}
+
+		for _, p := range n.Func.Params {
+			if p.Default == nil {
+				continue
+			}
+			if err := inf.checkNodeType(fmt.Sprintf("default value of parameter '?%s'", p.Label), p.Default, inf.env.Table[p.Ident.Name]); err != nil {
+				return nil, err
+			}
+		}
+
+		// Function type is determined before inferring its body except for its return type. Recursive
+		// calls in the body can apply labeled arguments.
+		ret := &Var{}
+		fun := &Fun{
+			Params: params,
+			Ret:    ret,
+			Labels: labels,
+		}
+		if err := Unify(fun, f); err != nil {
+			return nil, loc.NotefAt(n.Pos(), err, "function '%s'", n.Func.Symbol.DisplayName)
 		}
 
 		// Infer return type of function from its body
-		ret, err := inf.infer(n.Func.Body)
+		body, err := inf.infer(n.Func.Body)
 		if err != nil {
 			return nil, err
 		}
+		if err = Unify(ret, body); err != nil {
+			return nil, loc.NotefAt(n.Pos(), err, "return type of function '%s'", n.Func.Symbol.DisplayName)
+		}
 
 		if n.Func.RetType != nil {
 			t, err := inf.conv.nodeToType(n.Func.RetType)
@@ -230,19 +489,16 @@ func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 			}
 		}
 
-		fun := &Fun{
-			Params: params,
-			Ret:    ret,
-		}
-
-		// n.Func.Type represents its function type. So unify it with
-		// inferred function type from its parameters and body.
-		if err = Unify(fun, f); err != nil {
-			return nil, loc.NotefAt(n.Pos(), err, "function '%s'", n.Func.Symbol.DisplayName)
-		}
-
 		return inf.infer(n.Body)
 	case *ast.Apply:
+		if ref, ok := n.Callee.(*ast.VarRef); ok {
+			if inf.isFormatFunc(ref.Symbol) {
+				return inf.inferFormatApply(n, ref)
+			}
+			if fun, ok := instantiateGenericBuiltin(ref.Symbol.Name); ok {
+				return inf.inferGenericBuiltinApply(n, ref, fun)
+			}
+		}
 		args := make([]Type, len(n.Args))
 		for i, a := range n.Args {
 			t, err := inf.infer(a)
@@ -252,24 +508,19 @@ func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 			args[i] = t
 		}
 
-		// Return type of callee is unknown in this point.
-		// So make a new type variable and allocate it as return type.
-		ret := &Var{}
-		fun := &Fun{
-			Ret:    ret,
-			Params: args,
-		}
-
 		callee, err := inf.infer(n.Callee)
 		if err != nil {
 			return nil, err
 		}
 
-		if err = Unify(callee, fun); err != nil {
-			return nil, loc.NoteAt(n.Pos(), err, "type of called function")
+		args, err = inf.reorderLabeledArgs(n, callee, args)
+		if err != nil {
+			return nil, err
 		}
 
-		return ret, nil
+		return inf.inferApply(n, callee, args)
+	case *ast.LabeledArg:
+		return inf.infer(n.Child)
 	case *ast.Tuple:
 		elems := make([]Type, len(n.Elems))
 		for i, e := range n.Elems {
@@ -330,6 +581,14 @@ func (inf *Inferer) infer(e ast.Expr) (Type, error) {
 			return nil, err
 		}
 		return IntType, nil
+	case *ast.StringGet:
+		if err := inf.checkNodeType("string argument of 'String.get'", n.String, StringType); err != nil {
+			return nil, err
+		}
+		if err := inf.checkNodeType("index argument of 'String.get'", n.Index, IntType); err != nil {
+			return nil, err
+		}
+		return CharType, nil
 	case *ast.Get:
 		// Lhs of Get must be array but its element type is unknown.
 		// So introduce new type variable for it.
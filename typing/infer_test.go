@@ -173,9 +173,214 @@ func TestInvalidExpressions(t *testing.T) {
 			expected: "On unifying 2nd parameter of function 'int -> int -> int' and 'int -> float -> int'",
 		},
 		{
-			what:     "wrong number of arguments",
-			code:     "let rec f a b = a + b in f 1",
-			expected: "Number of parameters of function does not match: 2 vs 1 (between 'int -> int -> int' and 'int -> int')",
+			what:     "too many arguments",
+			code:     "let rec f a b = a + b in f 1 2 3",
+			expected: "type of called function",
+		},
+		{
+			what:     "wrong number of parameters of function variable",
+			code:     "let rec f a b = a + b in let rec g h = h 1 in g f",
+			expected: "Number of parameters of function does not match: 1 vs 2",
+		},
+		{
+			what:     "wrong number of parameters of function in tuple",
+			code:     "let rec f a b = a + b in let rec g t = let (h, _) = t in h 1 in g (f, 1)",
+			expected: "Number of parameters of function does not match: 1 vs 2",
+		},
+		{
+			what:     "mismatch argument type in partial application",
+			code:     "let rec f a b = a + b in f 1.0",
+			expected: "On unifying 1st argument of function 'int -> int -> int'",
+		},
+		{
+			what:     "unknown label",
+			code:     "let rec f ~x ~y = x + y in f ~x:1 ~z:2",
+			expected: "Function 'x:int -> y:int -> int' has no parameter labeled 'z'",
+		},
+		{
+			what:     "labeled argument applied twice",
+			code:     "let rec f ~x ~y = x + y in f ~x:1 ~x:2",
+			expected: "Argument labeled 'x' is applied twice",
+		},
+		{
+			what:     "missing labeled argument",
+			code:     "let rec f ~x ~y = x + y in f ~y:1",
+			expected: "Argument labeled 'x' of function 'x:int -> y:int -> int' is missing",
+		},
+		{
+			what:     "partial application skipping preceding labeled parameter",
+			code:     "let rec f ~x ~y = x + y in let g = f ~y:1 in g ~x:5",
+			expected: "Argument labeled 'x' of function 'x:int -> y:int -> int' is missing",
+		},
+		{
+			what:     "labeled argument to function without labels",
+			code:     "let rec f x y = x + y in f ~x:1 2",
+			expected: "Labeled argument 'x' is applied to function which has no labeled parameter",
+		},
+		{
+			what:     "optional argument to non-optional parameter",
+			code:     "let rec f ~x = x + 1 in f ?x:(Some 1)",
+			expected: "Parameter '~x' is not optional",
+		},
+		{
+			what:     "mismatch default value type",
+			code:     "let rec f ?(x: int = true) y = x + y in f 1",
+			expected: "default value of parameter '?x'",
+		},
+		{
+			what:     "mismatch labels of function types",
+			code:     "let rec f ~x ~y = x - y in let rec g ~y ~x = x - y in let h = if true then f else g in ()",
+			expected: "Label of 1st parameter of function does not match",
+		},
+		{
+			what:     "char and int are different types",
+			code:     "let c = 'a' in c + 1",
+			expected: "Type mismatch between 'int' and 'char'",
+		},
+		{
+			what:     "char and one-byte string are different types",
+			code:     "'a' = \"a\"",
+			expected: "Type mismatch between 'char' and 'string'",
+		},
+		{
+			what:     "String.get with non-string argument",
+			code:     "String.get 'a' 0",
+			expected: "string argument of 'String.get'",
+		},
+		{
+			what:     "String.get with non-int index",
+			code:     "String.get \"abc\" 'a'",
+			expected: "index argument of 'String.get'",
+		},
+		{
+			what:     "unknown member of String module",
+			code:     `String.strip "abc"`,
+			expected: "Unknown module member 'String.strip'",
+		},
+		{
+			what:     "String.starts_with without prefix label",
+			code:     `String.starts_with ~suffix:"a" "abc"`,
+			expected: "has no parameter labeled 'suffix'",
+		},
+		{
+			what:     "String.iter with wrong callback",
+			code:     `String.iter (fun x -> x + 1) "abc"`,
+			expected: "Type mismatch between",
+		},
+		{
+			what:     "Buffer.t is not a string",
+			code:     `let b = Buffer.create 16 in str_length b`,
+			expected: "Type mismatch between 'string' and 'Buffer.t'",
+		},
+		{
+			what:     "Hashtbl function used as value",
+			code:     `let f = Hashtbl.find in ()`,
+			expected: "Generic built-in function 'Hashtbl.find' must be applied directly",
+		},
+		{
+			what:     "partial application of Hashtbl function",
+			code:     `let t = Hashtbl.create 1 in let f = Hashtbl.add t in f 1 2`,
+			expected: "Generic built-in function 'Hashtbl.add' requires 3 argument(s) but 1 argument(s) given",
+		},
+		{
+			what:     "mismatch of key type in hash table",
+			code:     `let t = Hashtbl.create 1 in Hashtbl.add t "a" 1; Hashtbl.mem t 1`,
+			expected: "Type mismatch between 'string' and 'int'",
+		},
+		{
+			what:     "mismatch of value type in hash table",
+			code:     `let t = Hashtbl.create 1 in Hashtbl.add t 1 "a"; match Hashtbl.find t 1 with Some x -> x + 1 | None -> 0`,
+			expected: "Type mismatch between 'int' and 'string'",
+		},
+		{
+			what:     "lnot with bool",
+			code:     "lnot true",
+			expected: "operand of operator 'lnot'",
+		},
+		{
+			what:     "land with float",
+			code:     "1.0 land 2",
+			expected: "left hand of operator 'land' must be int",
+		},
+		{
+			what:     "lsl with bool",
+			code:     "1 lsl true",
+			expected: "right hand of operator 'lsl' must be int",
+		},
+		{
+			what:     "exit code is not int",
+			code:     `exit "1"`,
+			expected: "Type mismatch between 'int' and 'string'",
+		},
+		{
+			what:     "exit used as value",
+			code:     `let f = exit in f 1`,
+			expected: "Generic built-in function 'exit' must be applied directly",
+		},
+		{
+			what:     "Sys.readdir returns option",
+			code:     `Array.length (Sys.readdir ".")`,
+			expected: "argument of 'Array.length' must be",
+		},
+		{
+			what:     "random state is not int",
+			code:     `Random.State.int 42 10`,
+			expected: "Type mismatch between 'Random.State.t' and 'int'",
+		},
+		{
+			what:     "unknown member of nested module",
+			code:     `Random.State.foo 42`,
+			expected: "Unknown module member 'Random.State.foo'",
+		},
+		{
+			what:     "in_channel is not out_channel",
+			code:     `output_string stdin "foo"`,
+			expected: "Type mismatch between 'out_channel' and 'in_channel'",
+		},
+		{
+			what:     "channel is not option",
+			code:     `close_in (open_in "foo")`,
+			expected: "Type mismatch between 'in_channel' and 'in_channel option'",
+		},
+		{
+			what:     "format specifier %c requires char",
+			code:     `printf "%c" 65`,
+			expected: "must be 'char' for format specifier '%c'",
+		},
+		{
+			what:     "argument type mismatch for format specifier",
+			code:     `printf "%d items\n" 1.0`,
+			expected: "2nd argument of 'printf' must be 'int' for format specifier '%d'",
+		},
+		{
+			what:     "wrong argument type for partially applied format string",
+			code:     `let p = sprintf "%s=%d" "x" in p 1.0`,
+			expected: "On unifying 1st parameter of function 'int -> string' and 'float -> string'",
+		},
+		{
+			what:     "too many arguments for format string",
+			code:     `printf "%%d" 1`,
+			expected: "Format string of 'printf' requires 0 argument(s) but 1 argument(s) given",
+		},
+		{
+			what:     "unknown conversion in format string",
+			code:     `printf "%5y" 1`,
+			expected: "Unknown conversion 'y' in format specifier '%5y'",
+		},
+		{
+			what:     "unterminated conversion in format string",
+			code:     `printf "value: %-5" 1`,
+			expected: "Conversion specifier '%-5' in format string is not terminated",
+		},
+		{
+			what:     "format string is not a literal",
+			code:     `let f = "%d" in printf f 1`,
+			expected: "First argument of 'printf' must be a string literal for format",
+		},
+		{
+			what:     "printf used as value",
+			code:     "let p = printf in ()",
+			expected: "'printf' must be applied to a format string literal directly",
 		},
 		{
 			what:     "type mismatch in return type",
@@ -397,6 +602,126 @@ func TestRegisterNoneTypes(t *testing.T) {
 	}
 }
 
+func TestRegisterFormats(t *testing.T) {
+	s := loc.NewDummySource(`printf "%d items at %5.2f\n" 3 1.5; let s = sprintf "100%%" in printf "%s" s`)
+	l := lexer.NewLexer(s)
+	go l.Lex()
+	ast, err := parser.Parse(l.Tokens)
+	if err != nil {
+		panic(err)
+	}
+	if err = alpha.Transform(ast.Root); err != nil {
+		panic(err)
+	}
+	i := NewInferer()
+	i.conv, err = newNodeTypeConv(ast.TypeDecls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = i.infer(ast.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(i.env.Formats) != 3 {
+		t.Fatalf("3 format strings should be detected but actually %d", len(i.env.Formats))
+	}
+	for _, pieces := range i.env.Formats {
+		var texts []string
+		for _, p := range pieces {
+			texts = append(texts, p.Text)
+		}
+		actual := strings.Join(texts, "|")
+		switch actual {
+		case "%d| items at |%5.2f|\n":
+			if pieces[0].Pos.Column != 9 || pieces[2].Pos.Column != 21 {
+				t.Errorf("Specifiers should point into format string but %%d at %v and %%5.2f at %v", pieces[0].Pos, pieces[2].Pos)
+			}
+		case "100%", "%s":
+		default:
+			t.Errorf("Unexpected format pieces: %s", actual)
+		}
+	}
+}
+
+func TestInvalidGenericBuiltinInstances(t *testing.T) {
+	testcases := []struct {
+		what     string
+		code     string
+		expected string
+	}{
+		{
+			what:     "array as key of hash table",
+			code:     `let t = Hashtbl.create 1 in Hashtbl.add t (Array.make 1 1) 1`,
+			expected: "Key of hash table must be unit, bool, int, float, char, string or tuple of them but 'int array' is used as key type",
+		},
+		{
+			what:     "function in tuple key of hash table",
+			code:     `let t = Hashtbl.create 1 in Hashtbl.replace t (1, fun x -> x + 1) true`,
+			expected: "Key of hash table must be unit, bool, int, float, char, string or tuple of them but 'int * (int -> int)' is used",
+		},
+		{
+			what:     "unknown types of hash table",
+			code:     `let t = Hashtbl.create 1 in ()`,
+			expected: "Cannot infer type of variable 't'",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.what, func(t *testing.T) {
+			s := loc.NewDummySource(tc.code)
+			l := lexer.NewLexer(s)
+			go l.Lex()
+			ast, err := parser.Parse(l.Tokens)
+			if err != nil {
+				panic(err)
+			}
+			if err = alpha.Transform(ast.Root); err != nil {
+				panic(err)
+			}
+			err = NewInferer().Infer(ast)
+			if err == nil {
+				t.Fatalf("Type check did not raise an error for code '%s'", tc.code)
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error message '%s' to contain '%s'", err.Error(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestFixReturnTypeOfExit(t *testing.T) {
+	s := loc.NewDummySource(`exit 1; let i = exit 2 in println_int i; let x = exit 3 in ()`)
+	l := lexer.NewLexer(s)
+	go l.Lex()
+	ast, err := parser.Parse(l.Tokens)
+	if err != nil {
+		panic(err)
+	}
+	if err = alpha.Transform(ast.Root); err != nil {
+		panic(err)
+	}
+	i := NewInferer()
+	if err := i.Infer(ast); err != nil {
+		t.Fatal(err)
+	}
+	if len(i.env.Instances) != 3 {
+		t.Fatalf("3 instances of 'exit' should be detected but actually %d", len(i.env.Instances))
+	}
+	units := 0
+	for ref, fun := range i.env.Instances {
+		switch fun.Ret.(type) {
+		case *Unit:
+			units++
+		case *Int:
+		default:
+			t.Errorf("Return type of '%s' was not determined: %s", ref.Symbol.DisplayName, fun.String())
+		}
+	}
+	if units != 2 {
+		t.Errorf("Return type of 2 'exit' calls should be fixed to unit but actually %d", units)
+	}
+}
+
 func TestInferSuccess(t *testing.T) {
 	files, err := filepath.Glob("testdata/*.ml")
 	if err != nil {
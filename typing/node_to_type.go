@@ -10,12 +10,17 @@ type nodeTypeConv struct {
 }
 
 func newNodeTypeConv(decls []*ast.TypeDecl) (*nodeTypeConv, error) {
-	conv := &nodeTypeConv{make(map[string]Type, len(decls)+5 /*primitives*/)}
+	conv := &nodeTypeConv{make(map[string]Type, len(decls)+10 /*primitives*/)}
 	conv.aliases["unit"] = UnitType
 	conv.aliases["int"] = IntType
 	conv.aliases["bool"] = BoolType
 	conv.aliases["float"] = FloatType
 	conv.aliases["string"] = StringType
+	conv.aliases["char"] = CharType
+	conv.aliases["Buffer.t"] = BufferType
+	conv.aliases["in_channel"] = InChannelType
+	conv.aliases["out_channel"] = OutChannelType
+	conv.aliases["Random.State.t"] = RandomStateType
 
 	for _, decl := range decls {
 		if decl.Ident == "_" {
@@ -58,7 +63,7 @@ func (conv *nodeTypeConv) nodeToType(node ast.Expr) (Type, error) {
 			return nil, err
 		}
 
-		return &Fun{ret, params}, nil
+		return &Fun{ret, params, nil}, nil
 	case *ast.TupleType:
 		elems, err := conv.nodesToTypes(n.ElemTypes)
 		return &Tuple{elems}, err
@@ -89,8 +94,17 @@ func (conv *nodeTypeConv) nodeToType(node ast.Expr) (Type, error) {
 			}
 			elem, err := conv.nodeToType(n.ParamTypes[0])
 			return &Option{elem}, err
+		case "Hashtbl.t":
+			if len != 2 {
+				return nil, loc.ErrorAt(n.Pos(), "Invalid hash table type. 'Hashtbl.t' has 2 type parameters for key and value.")
+			}
+			ts, err := conv.nodesToTypes(n.ParamTypes)
+			if err != nil {
+				return nil, err
+			}
+			return &Hashtbl{ts[0], ts[1]}, nil
 		default:
-			return nil, loc.ErrorfAt(n.Pos(), "Unknown type constructor '%s'. Primitive types, aliased types, 'array', 'option' and '_' are supported", n.Ctor)
+			return nil, loc.ErrorfAt(n.Pos(), "Unknown type constructor '%s'. Primitive types, aliased types, 'array', 'option', 'Hashtbl.t' and '_' are supported", n.Ctor)
 		}
 	default:
 		panic("FATAL: Cannot convert non-type AST node into type values: " + node.Name())
@@ -51,6 +51,36 @@ func TestSuccess(t *testing.T) {
 			node: prim("int"),
 			want: IntType,
 		},
+		{
+			what: "char",
+			node: prim("char"),
+			want: CharType,
+		},
+		{
+			what: "Buffer.t",
+			node: prim("Buffer.t"),
+			want: BufferType,
+		},
+		{
+			what: "channels",
+			node: &ast.TupleType{[]ast.Expr{prim("in_channel"), prim("out_channel")}},
+			want: &Tuple{[]Type{InChannelType, OutChannelType}},
+		},
+		{
+			what: "Random.State.t",
+			node: prim("Random.State.t"),
+			want: RandomStateType,
+		},
+		{
+			what: "Hashtbl.t",
+			node: &ast.CtorType{
+				tok,
+				tok,
+				[]ast.Expr{prim("string"), ctor("array", prim("int"))},
+				"Hashtbl.t",
+			},
+			want: &Hashtbl{StringType, &Array{IntType}},
+		},
 		{
 			what: "_ (any)",
 			node: prim("_"),
@@ -85,7 +115,7 @@ func TestSuccess(t *testing.T) {
 				[]ast.Expr{prim("int"), prim("bool")},
 				prim("float"),
 			},
-			want: &Fun{FloatType, []Type{IntType, BoolType}},
+			want: &Fun{FloatType, []Type{IntType, BoolType}, nil},
 		},
 		{
 			what: "nested any",
@@ -98,7 +128,7 @@ func TestSuccess(t *testing.T) {
 				[]ast.Expr{prim("_"), prim("_")},
 				prim("_"),
 			},
-			want: &Fun{any, []Type{any, any}},
+			want: &Fun{any, []Type{any, any}, nil},
 		},
 		{
 			what: "nested any in tuple",
@@ -129,6 +159,7 @@ func TestSuccess(t *testing.T) {
 				&Fun{
 					UnitType,
 					[]Type{any},
+					nil,
 				},
 				[]Type{
 					&Tuple{[]Type{
@@ -148,6 +179,7 @@ func TestSuccess(t *testing.T) {
 						},
 					},
 				},
+				nil,
 			},
 		},
 		{
@@ -178,6 +210,7 @@ func TestSuccess(t *testing.T) {
 				&Fun{
 					IntType,
 					[]Type{IntType, IntType},
+					nil,
 				},
 			}},
 		},
@@ -246,6 +279,16 @@ func TestErrors(t *testing.T) {
 			},
 			msg: "'option' only has 1 type parameter",
 		},
+		{
+			what: "invalid Hashtbl.t type params",
+			node: &ast.CtorType{
+				nil,
+				tok,
+				[]ast.Expr{prim("int")},
+				"Hashtbl.t",
+			},
+			msg: "'Hashtbl.t' has 2 type parameters",
+		},
 		{
 			what: "unknown type (tuple elem)",
 			node: &ast.TupleType{[]ast.Expr{prim("foo")}},
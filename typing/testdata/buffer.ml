@@ -0,0 +1,11 @@
+let b: Buffer.t = Buffer.create 16 in
+Buffer.add_string b "hello";
+Buffer.add_char b ',';
+Buffer.add_int b 42;
+let len: int = Buffer.length b in
+let s: string = Buffer.contents b in
+Buffer.clear b;
+let maybe: Buffer.t option = Some b in
+let rec f (buf: Buffer.t) (n: int) = Buffer.add_int buf n in
+f b 1;
+()
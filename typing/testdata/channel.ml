@@ -0,0 +1,19 @@
+let ic: in_channel option = open_in "foo.txt" in
+let oc: out_channel option = open_out "bar.txt" in
+let ac: out_channel option = open_out_append "bar.txt" in
+let err: out_channel = stderr in
+let rec copy (i: in_channel) (o: out_channel) =
+    match input_line i with
+    | Some line -> output_string o line; output_string o "\n"; copy i o
+    | None -> flush o
+in
+(match ic with
+| Some i -> (match oc with Some o -> copy i o; close_in i; close_out o | None -> close_in i)
+| None -> prerrln_str "cannot open");
+(match ac with Some o -> close_out o | None -> ());
+copy stdin stdout;
+prerr_int 1; prerr_bool true; prerr_float 1.0; prerr_str "a"; prerr_char 'a';
+prerrln_int 1; prerrln_bool true; prerrln_float 1.0; prerrln_str "a"; prerrln_char 'a';
+let chans = Array.make 2 stdout in
+chans.(1) <- err;
+()
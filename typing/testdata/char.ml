@@ -0,0 +1,12 @@
+let c = 'a' in
+let s = "hello" in
+let h: char = String.get s 0 in
+let b: bool = h < c in
+let e: bool = h = 'h' in
+let code: int = to_char_code h in
+let d: char = from_char_code (code + 1) in
+let o: char option = Some 'x' in
+let str: string = char_to_str d in
+let rec is_digit (c: char) = '0' <= c && c <= '9' in
+print_char c;
+println_char (String.get s 4)
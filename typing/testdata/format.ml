@@ -0,0 +1,13 @@
+let n = 3 in
+let price = 1.25 in
+printf "%d items at %.2f\n" n price;
+printf "%5s|%-5b|%x|%c|100%%\n" "abc" true 255 'A';
+let s: string = sprintf "%03d" 7 in
+let t = sprintf "no conversion" in
+let rec show x = sprintf "<%d>" x in
+println_str (show 42);
+let p: int -> float -> unit = printf "%d %f\n" in
+p 1 2.0;
+let q: int -> string = sprintf "%s=%d" "x" in
+println_str (q 42);
+printf "%s %s\n" s t
@@ -0,0 +1,22 @@
+let t: (string, int) Hashtbl.t = Hashtbl.create 16 in
+Hashtbl.add t "foo" 1;
+Hashtbl.replace t "bar" 2;
+let found: int option = Hashtbl.find t "foo" in
+let b: bool = Hashtbl.mem t "bar" in
+Hashtbl.remove t "foo";
+let n: int = Hashtbl.length t in
+Hashtbl.iter (fun k v -> (println_str k; println_int v)) t;
+
+let pairs = Hashtbl.create 4 in
+Hashtbl.add pairs (1, "one", 'a') (Array.make 1 1.0);
+match Hashtbl.find pairs (2, "two", 'b') with
+| Some arr -> println_float arr.(0)
+| None -> ();
+
+let opts = Hashtbl.create 4 in
+Hashtbl.add opts () (Some 42);
+let rec f (tbl: (unit, int option) Hashtbl.t) = Hashtbl.length tbl in
+f opts;
+let nested: (bool, (int, float) Hashtbl.t) Hashtbl.t = Hashtbl.create 1 in
+Hashtbl.add nested true (Hashtbl.create 1);
+()
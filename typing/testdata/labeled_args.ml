@@ -0,0 +1,18 @@
+let rec f ~x ~(y: int) ?z ?(w = 42) ?(v: float = 3.14) u = x + y in
+let i: int = f ~x:1 ~y:2 () in
+let j: int = f ~y:(1 + 2) ~x:3 ~w:10 ?z:None () in
+let x = 1 in
+let y = 2 in
+let z: bool option = Some true in
+let k: int = f ~x ~y ?z () in
+let l: int = f 1 2 () in
+let g = f ~x:1 ~y:2 in
+let n: int = g () in
+let rec sub ~x ~y = x - y in
+let sub10: int -> int = sub ~x:10 in
+let m: int = sub10 3 in
+let rec greet ?(greeting = "Hello") name = str_concat greeting name in
+let s: string = greet "world" in
+let rec count ~x y = if y = 0 then x else count ~x:(x + 1) (y - 1) in
+let c: int = count ~x:1 3 in
+()
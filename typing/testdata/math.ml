@@ -0,0 +1,10 @@
+let x: float = sqrt 2.0 in
+let y: float = sin x +. cos x +. exp x +. log x in
+let z: float = pow x y in
+let w: float = floor z +. ceil z +. abs_float (-.z) in
+let inf: float = infinity in
+let b: bool = is_nan nan || is_nan w || inf > w in
+let fs = Array.make 3 sqrt in
+fs.(1) <- floor;
+println_float (fs.(0) x);
+println_bool b
@@ -0,0 +1,16 @@
+let rec add x y = x + y in
+let add3: int -> int = add 3 in
+let rec add4 a b c d = a + b + c + d in
+let f: int -> int -> int = add4 1 2 in
+let i: int = f 3 4 in
+let rec make_adder x = fun y -> x + y in
+let j: int = make_adder 1 2 in
+let rec apply f x = f x in
+let k: int = apply (add 1) 2 in
+let rec apply1 g = g 1 in
+let l: int = apply1 (fun x -> add x) 2 in
+let rec apply2 g = g 1 2 in
+let m: int = apply2 (fun x y -> make_adder x y) in
+let p = println_int in
+p 1;
+()
@@ -0,0 +1,13 @@
+Random.init 42;
+Random.self_init ();
+let i: int = Random.int 10 in
+let f: float = Random.float 1.0 in
+let b: bool = Random.bool () in
+let st: Random.State.t = Random.State.make 1 in
+let copied = Random.State.copy st in
+let j: int = Random.State.int copied 10 in
+let g: float = Random.State.float st 2.0 in
+let c: bool = Random.State.bool st in
+let rec roll (s: Random.State.t) = Random.State.int s 6 + 1 in
+let states = Array.make 2 st in
+println_int (roll states.(1))
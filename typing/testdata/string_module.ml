@@ -0,0 +1,19 @@
+let s = "  Hello, World  " in
+let bytes: int = String.length s in
+let chars: int = String.utf8_length "héllo" in
+let i: int = String.index_of s "World" in
+let c: bool = String.contains s "lo" in
+let words: string array = String.split_on_char ',' s in
+let t: string = String.trim s in
+let u: string = String.uppercase t in
+let l: string = String.lowercase t in
+let p: bool = String.starts_with ~prefix:"He" t in
+let q: bool = String.starts_with t ~prefix:"Wo" in
+let r: string = String.replace t "World" "GoCaml" in
+let j: string = String.concat ", " words in
+let f = String.trim in
+let v: string = f "  x  " in
+let n = Array.make 1 0 in
+String.iter (fun c -> if c = 'l' then n.(0) <- n.(0) + 1 else ()) t;
+let count: int = n.(0) in
+()
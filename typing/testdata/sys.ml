@@ -0,0 +1,9 @@
+let home: string option = getenv "HOME" in
+let status: int = Sys.command "ls" in
+let exists: bool = Sys.file_exists "foo.txt" in
+let entries: string array option = Sys.readdir "." in
+let rec safe_div x y = if y = 0 then exit 2 else x / y in
+let s: string = if exists then "yes" else exit 1 in
+let f: float = match home with Some _ -> 1.0 | None -> exit 3 in
+if status <> 0 then exit status else ();
+exit 0
@@ -47,18 +47,63 @@ func (t *String) String() string {
 	return "string"
 }
 
+type Char struct {
+}
+
+func (t *Char) String() string {
+	return "char"
+}
+
+// Returns string representation of the type as an operand of '*' or a type constructor like
+// 'array'. Function and tuple types are wrapped with parens to avoid ambiguity.
+//
+// e.g. '(int * int) array', '(int -> int) option'
+func operandString(t Type) string {
+	if v, ok := t.(*Var); ok && v.Ref != nil {
+		return operandString(v.Ref)
+	}
+	switch t.(type) {
+	case *Fun, *Tuple:
+		return "(" + t.String() + ")"
+	default:
+		return t.String()
+	}
+}
+
+// Label of function parameter. Name is empty when the parameter is not labeled.
+type Label struct {
+	Name     string
+	Optional bool
+}
+
+func (l Label) String() string {
+	if l.Optional {
+		return "?" + l.Name + ":"
+	}
+	if l.Name != "" {
+		return l.Name + ":"
+	}
+	return ""
+}
+
 type Fun struct {
 	Ret    Type
 	Params []Type
+	// Labels of parameters. nil when the function has no labeled parameter
+	Labels []Label
 }
 
 func (t *Fun) String() string {
 	ss := make([]string, 0, len(t.Params)+1)
-	for _, p := range t.Params {
+	for i, p := range t.Params {
+		l := ""
+		if t.Labels != nil {
+			l = t.Labels[i].String()
+		}
 		if f, ok := p.(*Fun); ok {
-			ss = append(ss, fmt.Sprintf("(%s)", f.String()))
+			ss = append(ss, fmt.Sprintf("%s(%s)", l, f.String()))
 		} else {
-			ss = append(ss, p.String())
+			ss = append(ss, l+p.String())
 		}
 	}
 	if f, ok := t.Ret.(*Fun); ok {
@@ -76,7 +121,7 @@ type Tuple struct {
 func (t *Tuple) String() string {
 	elems := make([]string, len(t.Elems))
 	for i, e := range t.Elems {
-		elems[i] = e.String()
+		elems[i] = operandString(e)
 	}
 	return strings.Join(elems, " * ")
 }
@@ -86,7 +131,7 @@ type Array struct {
 }
 
 func (t *Array) String() string {
-	return fmt.Sprintf("%s array", t.Elem.String())
+	return fmt.Sprintf("%s array", operandString(t.Elem))
 }
 
 type Option struct {
@@ -94,7 +139,27 @@ type Option struct {
 }
 
 func (t *Option) String() string {
-	return fmt.Sprintf("%s option", t.Elem.String())
+	return fmt.Sprintf("%s option", operandString(t.Elem))
+}
+
+// Hashtbl is a type of hash table which maps keys to values.
+type Hashtbl struct {
+	Key   Type
+	Value Type
+}
+
+func (t *Hashtbl) String() string {
+	return fmt.Sprintf("(%s, %s) Hashtbl.t", t.Key.String(), t.Value.String())
+}
+
+// Opaque is a type whose representation is hidden in runtime like 'Buffer.t'. Its value is
+// a pointer to the object allocated by runtime.
+type Opaque struct {
+	Name string
+}
+
+func (t *Opaque) String() string {
+	return t.Name
 }
 
 type Var struct {
@@ -110,9 +175,14 @@ func (t *Var) String() string {
 
 var (
 	// Make singleton type values because it doesn't have any contextual information
-	UnitType   = &Unit{}
-	BoolType   = &Bool{}
-	IntType    = &Int{}
-	FloatType  = &Float{}
-	StringType = &String{}
+	UnitType        = &Unit{}
+	BoolType        = &Bool{}
+	IntType         = &Int{}
+	FloatType       = &Float{}
+	StringType      = &String{}
+	CharType        = &Char{}
+	BufferType      = &Opaque{"Buffer.t"}
+	InChannelType   = &Opaque{"in_channel"}
+	OutChannelType  = &Opaque{"out_channel"}
+	RandomStateType = &Opaque{"Random.State.t"}
 )
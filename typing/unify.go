@@ -20,6 +20,8 @@ func occur(v *Var, rhs Type) bool {
 		return occur(v, t.Elem)
 	case *Option:
 		return occur(v, t.Elem)
+	case *Hashtbl:
+		return occur(v, t.Key) || occur(v, t.Value)
 	case *Fun:
 		if occur(v, t.Ret) {
 			return true
@@ -66,6 +68,16 @@ func unifyFun(left, right *Fun) error {
 		return loc.Errorf("Number of parameters of function does not match: %d vs %d (between '%s' and '%s')", len(left.Params), len(right.Params), left.String(), right.String())
 	}
 
+	// Function type without labels (e.g. type of function variable) can be unified with labeled one
+	// because labels don't change the representation of function.
+	if left.Labels != nil && right.Labels != nil {
+		for i, l := range left.Labels {
+			if r := right.Labels[i]; l != r {
+				return loc.Errorf("Label of %s parameter of function does not match: '%s' vs '%s' (between '%s' and '%s')", common.Ordinal(i+1), l.String(), r.String(), left.String(), right.String())
+			}
+		}
+	}
+
 	for i, l := range left.Params {
 		r := right.Params[i]
 		if err := Unify(l, r); err != nil {
@@ -87,8 +99,8 @@ func assignVar(v *Var, t Type) error {
 
 func Unify(left, right Type) error {
 	switch l := left.(type) {
-	case *Unit, *Bool, *Int, *Float, *String:
-		// Types for Unit, Bool, Int, Float and String are singleton instance.
+	case *Unit, *Bool, *Int, *Float, *String, *Char, *Opaque:
+		// Types for Unit, Bool, Int, Float, String, Char and opaque types are singleton instance.
 		// So comparing directly is OK.
 		if l == right {
 			return nil
@@ -105,6 +117,13 @@ func Unify(left, right Type) error {
 		if r, ok := right.(*Option); ok {
 			return Unify(l.Elem, r.Elem)
 		}
+	case *Hashtbl:
+		if r, ok := right.(*Hashtbl); ok {
+			if err := Unify(l.Key, r.Key); err != nil {
+				return err
+			}
+			return Unify(l.Value, r.Value)
+		}
 	case *Fun:
 		if r, ok := right.(*Fun); ok {
 			return unifyFun(l, r)